@@ -0,0 +1,161 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/roundtripper"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// grafanaAlertmanagerPath is the path of the API of the Alertmanager of
+// Grafana's alerting, relative to the url of Grafana.
+const grafanaAlertmanagerPath = "/api/alertmanager/grafana"
+
+// requestTimeout is the maximum duration of a request to the Alertmanager API,
+// so that a slow Alertmanager doesn't delay the graphs.
+const requestTimeout = 10 * time.Second
+
+// Client is the interface to get the alerts from the Alertmanager API.
+type Client interface {
+	GetAlerts(ctx context.Context) ([]Alert, error)
+	Close()
+}
+
+// Alert is a firing alert returned by the Alertmanager API.
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+}
+
+type client struct {
+	url        string
+	grafana    bool
+	httpClient *http.Client
+	transport  *roundtripper.Transport
+}
+
+// NewClient returns a new client for the Alertmanager API configured in the
+// given settings. If no Alertmanager url is set, but the alerts of Grafana are
+// enabled, a client for the Alertmanager of Grafana is returned. If neither is
+// set, nil is returned, so that the integration is disabled.
+func NewClient(settings *models.PluginSettings) (Client, error) {
+	if settings.AlertmanagerUrl == "" {
+		if settings.GrafanaAlerts {
+			return newGrafanaClient(), nil
+		}
+		return nil, nil
+	}
+
+	if _, err := url.Parse(settings.AlertmanagerUrl); err != nil {
+		return nil, fmt.Errorf("invalid alertmanager url: %w", err)
+	}
+
+	// The base transport is kept in the client, so that its idle connections
+	// can be closed when the datasource is disposed.
+	transport := roundtripper.New(roundtripper.Options{})
+	var roundTripper http.RoundTripper = transport
+
+	if settings.AlertmanagerAuthMethod == models.PrometheusAuthMethodBasic {
+		roundTripper = roundtripper.BasicAuthTransport{
+			Transport: roundTripper,
+			Username:  settings.AlertmanagerUsername,
+			Password:  settings.Secrets.AlertmanagerPassword,
+		}
+	}
+
+	if settings.AlertmanagerAuthMethod == models.PrometheusAuthMethodToken {
+		roundTripper = roundtripper.TokenAuthTransporter{
+			Transport: roundTripper,
+			Token:     settings.Secrets.AlertmanagerToken,
+		}
+	}
+
+	return &client{
+		url:        strings.TrimSuffix(settings.AlertmanagerUrl, "/"),
+		httpClient: &http.Client{Transport: roundTripper, Timeout: requestTimeout},
+		transport:  transport,
+	}, nil
+}
+
+// newGrafanaClient returns a new client for the Alertmanager of Grafana's
+// alerting. The url of Grafana and the token of the service account of the
+// plugin are taken from the context of each request, so that the requests do
+// not depend on the credentials of the user. The service account is created by
+// Grafana via the "iam" permissions in the "plugin.json" file.
+func newGrafanaClient() Client {
+	transport := roundtripper.New(roundtripper.Options{})
+
+	return &client{
+		grafana:    true,
+		httpClient: &http.Client{Transport: transport, Timeout: requestTimeout},
+		transport:  transport,
+	}
+}
+
+// GetAlerts returns all active alerts, which are neither silenced nor
+// inhibited.
+func (c *client) GetAlerts(ctx context.Context) ([]Alert, error) {
+	baseURL := c.url
+	var token string
+	if c.grafana {
+		cfg := backend.GrafanaConfigFromContext(ctx)
+		appURL, err := cfg.AppURL()
+		if err != nil {
+			return nil, fmt.Errorf("could not get grafana url: %w", err)
+		}
+		baseURL = strings.TrimSuffix(appURL, "/") + grafanaAlertmanagerPath
+
+		token, err = cfg.PluginAppClientSecret()
+		if err != nil {
+			return nil, fmt.Errorf("could not get service account token: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/v2/alerts?active=true&silenced=false&inhibited=false", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.grafana {
+		// The alerts are always read from the organization of the request,
+		// independent of the default organization of the service account.
+		req.Header.Set("Authorization", "Bearer "+token)
+		if orgID := backend.PluginConfigFromContext(ctx).OrgID; orgID > 0 {
+			req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(orgID, 10))
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("alertmanager returned HTTP status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var alerts []Alert
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		return nil, fmt.Errorf("could not decode alerts: %w", err)
+	}
+
+	return alerts, nil
+}
+
+// Close closes the idle connections of the client.
+func (c *client) Close() {
+	c.transport.CloseIdleConnections()
+}
@@ -0,0 +1,91 @@
+package alertmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/roundtripper"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAlerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/alertmanager/api/v2/alerts", r.URL.Path)
+		require.Equal(t, "active=true&silenced=false&inhibited=false", r.URL.RawQuery)
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"labels":{"alertname":"HighErrorRate","namespace":"bookinfo","workload":"reviews-v1"},"annotations":{"summary":"High error rate"},"startsAt":"2025-01-01T00:00:00Z"}]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{
+		AlertmanagerUrl:        server.URL + "/alertmanager/",
+		AlertmanagerAuthMethod: models.PrometheusAuthMethodToken,
+		Secrets:                &models.SecretPluginSettings{AlertmanagerToken: "token"},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	alerts, err := client.GetAlerts(context.Background())
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, map[string]string{"alertname": "HighErrorRate", "namespace": "bookinfo", "workload": "reviews-v1"}, alerts[0].Labels)
+	require.Equal(t, "High error rate", alerts[0].Annotations["summary"])
+}
+
+func TestGetAlertsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("unauthorized"))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{AlertmanagerUrl: server.URL, Secrets: &models.SecretPluginSettings{}})
+	require.NoError(t, err)
+
+	_, err = client.GetAlerts(context.Background())
+	require.EqualError(t, err, "alertmanager returned HTTP status 401 Unauthorized: unauthorized")
+}
+
+func TestGetGrafanaAlerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/grafana/api/alertmanager/grafana/api/v2/alerts", r.URL.Path)
+		require.Equal(t, "Bearer service-account-token", r.Header.Get("Authorization"))
+		require.Equal(t, "2", r.Header.Get("X-Grafana-Org-Id"))
+		require.Empty(t, r.Header.Get("Cookie"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"labels":{"alertname":"HighErrorRate","namespace":"bookinfo","service":"reviews"},"annotations":{},"startsAt":"2025-01-01T00:00:00Z"}]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{GrafanaAlerts: true, KeepCookies: []string{"grafana_session"}})
+	require.NoError(t, err)
+
+	// The credentials of the user are never forwarded to Grafana, instead the
+	// token of the service account of the plugin is used.
+	ctx := backend.WithGrafanaConfig(context.Background(), backend.NewGrafanaCfg(map[string]string{backend.AppURL: server.URL + "/grafana/", backend.AppClientSecret: "service-account-token"}))
+	ctx = backend.WithPluginContext(ctx, backend.PluginContext{OrgID: 2})
+	ctx = roundtripper.WithAuthorization(ctx, "Bearer user-token")
+	ctx = roundtripper.WithCookies(ctx, "grafana_session=session; other=value")
+
+	alerts, err := client.GetAlerts(ctx)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "reviews", alerts[0].Labels["service"])
+
+	_, err = client.GetAlerts(backend.WithGrafanaConfig(context.Background(), backend.NewGrafanaCfg(map[string]string{backend.AppURL: server.URL})))
+	require.ErrorContains(t, err, "could not get service account token")
+}
+
+func TestNewClientDisabled(t *testing.T) {
+	client, err := NewClient(&models.PluginSettings{})
+	require.NoError(t, err)
+	require.Nil(t, client)
+}
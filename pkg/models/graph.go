@@ -16,56 +16,69 @@ func (f *Fields) Add(name string, labels data.Labels, values any, config ...*dat
 }
 
 type Edge struct {
-	ID                   string
-	Source               string
-	SourceType           string
-	SourceName           string
-	SourceNamespace      string
-	Destination          string
-	DestinationType      string
-	DestinationName      string
-	DestinationNamespace string
-	DestinationService   string
-	GRPCResponseCodes    map[string]float64
-	GRPCRequestsSuccess  float64
-	GRPCRequestsError    float64
-	GRPCRequestDuration  float64
-	GRPCSentMessages     float64
-	GRPCReceivedMessages float64
-	HTTPResponseCodes    map[string]float64
-	HTTPRequestsSuccess  float64
-	HTTPRequestsError    float64
-	HTTPRequestDuration  float64
-	TCPSentBytes         float64
-	TCPReceivedBytes     float64
+	ID                    string
+	Source                string
+	SourceType            string
+	SourceName            string
+	SourceNamespace       string
+	SourceCluster         string
+	Destination           string
+	DestinationType       string
+	DestinationName       string
+	DestinationNamespace  string
+	DestinationCluster    string
+	DestinationService    string
+	GRPCResponseCodes     map[string]float64
+	GRPCRequestsSuccess   float64
+	GRPCRequestsError     float64
+	GRPCRequestsThrottled float64
+	GRPCRequestDuration   float64
+	GRPCSentMessages      float64
+	GRPCReceivedMessages  float64
+	HTTPResponseCodes     map[string]float64
+	HTTPRequestsSuccess   float64
+	HTTPRequestsError     float64
+	HTTPRequestsThrottled float64
+	HTTPRequestDuration   float64
+	TCPSentBytes          float64
+	TCPReceivedBytes      float64
+	Versions              map[string]float64
+	ResponseFlags         map[string]float64
+	Mirror                bool
+	Locality              string
+	CrossZoneBytes        float64
 }
 
 type Node struct {
-	ID                         string
-	Type                       string
-	Name                       string
-	Namespace                  string
-	Service                    string
-	ClientGRPCResponseCodes    map[string]float64
-	ClientGRPCRequestsSuccess  float64
-	ClientGRPCRequestsError    float64
-	ClientGRPCSentMessages     float64
-	ClientGRPCReceivedMessages float64
-	ClientHTTPResponseCodes    map[string]float64
-	ClientHTTPRequestsSuccess  float64
-	ClientHTTPRequestsError    float64
-	ClientTCPSentBytes         float64
-	ClientTCPReceivedBytes     float64
-	ServerGRPCResponseCodes    map[string]float64
-	ServerGRPCRequestsSuccess  float64
-	ServerGRPCRequestsError    float64
-	ServerGRPCSentMessages     float64
-	ServerGRPCReceivedMessages float64
-	ServerHTTPResponseCodes    map[string]float64
-	ServerHTTPRequestsSuccess  float64
-	ServerHTTPRequestsError    float64
-	ServerTCPSentBytes         float64
-	ServerTCPReceivedBytes     float64
+	ID                          string
+	Type                        string
+	Name                        string
+	Namespace                   string
+	Cluster                     string
+	Service                     string
+	ClientGRPCResponseCodes     map[string]float64
+	ClientGRPCRequestsSuccess   float64
+	ClientGRPCRequestsError     float64
+	ClientGRPCSentMessages      float64
+	ClientGRPCReceivedMessages  float64
+	ClientHTTPResponseCodes     map[string]float64
+	ClientHTTPRequestsSuccess   float64
+	ClientHTTPRequestsError     float64
+	ClientTCPSentBytes          float64
+	ClientTCPReceivedBytes      float64
+	ServerGRPCResponseCodes     map[string]float64
+	ServerGRPCRequestsSuccess   float64
+	ServerGRPCRequestsError     float64
+	ServerGRPCSentMessages      float64
+	ServerGRPCReceivedMessages  float64
+	ServerHTTPResponseCodes     map[string]float64
+	ServerHTTPRequestsSuccess   float64
+	ServerHTTPRequestsError     float64
+	ServerGRPCRequestsThrottled float64
+	ServerHTTPRequestsThrottled float64
+	ServerTCPSentBytes          float64
+	ServerTCPReceivedBytes      float64
+	Versions                    map[string]float64
 }
 
 type Field struct {
@@ -77,12 +90,18 @@ type Field struct {
 	Color                       string
 	DetailsGRPCRate             []string
 	DetailsGRPCErr              []string
+	DetailsGRPCThrottled        []string
 	DetailsGRPCDuration         []string
 	DetailsGRPCSentMessages     []string
 	DetailsGRPCReceivedMessages []string
 	DetailsHTTPRate             []string
 	DetailsHTTPErr              []string
+	DetailsHTTPThrottled        []string
 	DetailsHTTPDuration         []string
 	DetailsTCPSentBytes         []string
 	DetailsTCPReceivedBytes     []string
+	DetailsIssues               []string
+	DetailsRetries              []string
+	DetailsLocality             []string
+	DetailsCrossZoneBytes       []string
 }
@@ -10,6 +10,22 @@ const (
 	QueryTypeApplicationGraph = "applicationgraph"
 	QueryTypeWorkloadGraph    = "workloadgraph"
 	QueryTypeNamespaceGraph   = "namespacegraph"
+	QueryTypeServiceGraph     = "servicegraph"
+	QueryTypeCanary           = "canary"
+	QueryTypeNamespaceMatrix  = "namespacematrix"
+	QueryTypeUpstreams        = "upstreams"
+	QueryTypeDownstreams      = "downstreams"
+	QueryTypePath             = "path"
+	QueryTypeEgress           = "egress"
+	QueryTypeIngress          = "ingress"
+	QueryTypeHealthScore      = "healthscore"
+	QueryTypeMTLSCoverage     = "mtlscoverage"
+	QueryTypeLatencyHeatmap   = "latencyheatmap"
+	QueryTypeRollouts         = "rollouts"
+	QueryTypeErrorBudget      = "errorbudget"
+	QueryTypeLatencySLO       = "latencyslo"
+	QueryTypeNamespaceHealth  = "namespacehealth"
+	QueryTypeWorkloadTraffic  = "workloadtraffic"
 
 	MetricGRPCRequests         = "grpcRequests"
 	MetricGRPCRequestDuration  = "grpcRequestDuration"
@@ -19,45 +35,218 @@ const (
 	MetricHTTPRequestDuration  = "httpRequestDuration"
 	MetricTCPSentBytes         = "tcpSentBytes"
 	MetricTCPReceivedBytes     = "tcpReceivedBytes"
+	MetricResponseFlags        = "responseFlags"
 )
 
+// QueryTypes contains all supported query types. The list is returned to users
+// when they use an unknown query type, so that a new query type must also be
+// added here.
+var QueryTypes = []string{
+	QueryTypeNamespaces,
+	QueryTypeApplications,
+	QueryTypeWorkloads,
+	QueryTypeFilters,
+	QueryTypeApplicationGraph,
+	QueryTypeWorkloadGraph,
+	QueryTypeNamespaceGraph,
+	QueryTypeServiceGraph,
+	QueryTypeCanary,
+	QueryTypeNamespaceMatrix,
+	QueryTypeUpstreams,
+	QueryTypeDownstreams,
+	QueryTypePath,
+	QueryTypeEgress,
+	QueryTypeIngress,
+	QueryTypeHealthScore,
+	QueryTypeMTLSCoverage,
+	QueryTypeLatencyHeatmap,
+	QueryTypeRollouts,
+	QueryTypeErrorBudget,
+	QueryTypeLatencySLO,
+	QueryTypeNamespaceHealth,
+	QueryTypeWorkloadTraffic,
+}
+
+// Pagination can be embedded into the query models of the list query types, to
+// filter the returned values by a search string and to return only a subset of
+// the values.
+type Pagination struct {
+	Search string `json:"search"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// LinkContext is embedded into the options of the graph query types. It is set
+// by the frontend to the context of the panel, which sent the query, so that
+// the generated links to other dashboards use the same relative time range
+// (e.g. "now-1h"), time zone and "datasource" variable as the panel. The
+// relative time range is only set, when the panel uses one.
+type LinkContext struct {
+	RawFrom             string `json:"rawFrom"`
+	RawTo               string `json:"rawTo"`
+	Timezone            string `json:"timezone"`
+	DashboardDatasource string `json:"dashboardDatasource"`
+}
+
+type QueryModelNamespaces struct {
+	Pagination
+	SortByTraffic bool `json:"sortByTraffic"`
+}
+
 type QueryModelApplications struct {
-	Namespace string `json:"namespace"`
+	Pagination
+	Namespace     string `json:"namespace"`
+	SortByTraffic bool   `json:"sortByTraffic"`
 }
 
 type QueryModelWorkloads struct {
-	Namespace string `json:"namespace"`
+	Pagination
+	Namespace     string `json:"namespace"`
+	SortByTraffic bool   `json:"sortByTraffic"`
 }
 
 type QueryModelFilters struct {
+	Pagination
 	FilterType  string `json:"filterType"`
 	Namespace   string `json:"namespace"`
 	Application string `json:"application"`
 	Workload    string `json:"workload"`
 }
 
+// GraphQueryOptions are the options, which are shared by the query models of
+// all graph query types. The query models embed the options, so that a new
+// option is supported by all graphs and is converted in a single place.
+type GraphQueryOptions struct {
+	LinkContext
+	Metrics                []string `json:"metrics"`
+	IdleEdges              bool     `json:"idleEdges"`
+	HideServiceNodes       bool     `json:"hideServiceNodes"`
+	DetectIssues           bool     `json:"detectIssues"`
+	ExcludeMirrors         bool     `json:"excludeMirrors"`
+	Locality               bool     `json:"locality"`
+	GroupingLabels         []string `json:"groupingLabels"`
+	IncludeResponseClasses []string `json:"includeResponseClasses"`
+	AggregateByApp         bool     `json:"aggregateByApp"`
+	WorkloadDurations      bool     `json:"workloadDurations"`
+	SortByTraffic          bool     `json:"sortByTraffic"`
+	Revision               string   `json:"revision"`
+	ExpectedTopology       string   `json:"expectedTopology"`
+	CompareEvaluationTime  string   `json:"compareEvaluationTime"`
+	Job                    string   `json:"job"`
+	EdgeTable              bool     `json:"edgeTable"`
+	SparklineBuckets       int      `json:"sparklineBuckets"`
+	TimeSeries             bool     `json:"timeSeries"`
+	EvaluationTime         string   `json:"evaluationTime"`
+	Window                 string   `json:"window"`
+	IdleNodes              bool     `json:"idleNodes"`
+	SourceFilters          []string `json:"sourceFilters"`
+	DestinationFilters     []string `json:"destinationFilters"`
+	Preset                 string   `json:"preset"`
+}
+
 type QueryModelApplicationGraph struct {
-	Namespace          string   `json:"namespace"`
-	Application        string   `json:"application"`
-	Metrics            []string `json:"metrics"`
-	IdleEdges          bool     `json:"idleEdges"`
-	SourceFilters      []string `json:"sourceFilters"`
-	DestinationFilters []string `json:"destinationFilters"`
+	GraphQueryOptions
+	Namespace   string `json:"namespace"`
+	Application string `json:"application"`
+	Depth       int    `json:"depth"`
 }
 
 type QueryModelWorkloadGraph struct {
-	Namespace          string   `json:"namespace"`
-	Workload           string   `json:"workload"`
-	Metrics            []string `json:"metrics"`
-	IdleEdges          bool     `json:"idleEdges"`
-	SourceFilters      []string `json:"sourceFilters"`
-	DestinationFilters []string `json:"destinationFilters"`
+	GraphQueryOptions
+	Namespace             string `json:"namespace"`
+	Workload              string `json:"workload"`
+	MatchServiceNamespace bool   `json:"matchServiceNamespace"`
+	Depth                 int    `json:"depth"`
 }
 
 type QueryModelNamespaceGraph struct {
-	Namespace          string   `json:"namespace"`
-	Metrics            []string `json:"metrics"`
-	IdleEdges          bool     `json:"idleEdges"`
-	SourceFilters      []string `json:"sourceFilters"`
-	DestinationFilters []string `json:"destinationFilters"`
+	GraphQueryOptions
+	Namespace string `json:"namespace"`
+}
+
+type QueryModelServiceGraph struct {
+	GraphQueryOptions
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+}
+
+type QueryModelCanary struct {
+	Namespace       string `json:"namespace"`
+	Application     string `json:"application"`
+	BaselineVersion string `json:"baselineVersion"`
+	CanaryVersion   string `json:"canaryVersion"`
+}
+
+type QueryModelDependencies struct {
+	Namespace string `json:"namespace"`
+	Workload  string `json:"workload"`
+	Service   string `json:"service"`
+}
+
+type QueryModelPath struct {
+	GraphQueryOptions
+	SourceNamespace      string `json:"sourceNamespace"`
+	SourceWorkload       string `json:"sourceWorkload"`
+	DestinationNamespace string `json:"destinationNamespace"`
+	DestinationWorkload  string `json:"destinationWorkload"`
+	Depth                int    `json:"depth"`
+}
+
+type QueryModelEgress struct {
+	Namespace string `json:"namespace"`
+}
+
+type QueryModelIngress struct {
+	Namespace string `json:"namespace"`
+	Gateway   string `json:"gateway"`
+}
+
+type QueryModelHealthScore struct {
+	Namespace        string  `json:"namespace"`
+	LatencyThreshold float64 `json:"latencyThreshold"`
+	ErrorWeight      float64 `json:"errorWeight"`
+	LatencyWeight    float64 `json:"latencyWeight"`
+	MTLSWeight       float64 `json:"mtlsWeight"`
+}
+
+type QueryModelMTLSCoverage struct {
+	Namespace string `json:"namespace"`
+}
+
+type QueryModelLatencyHeatmap struct {
+	Namespace       string `json:"namespace"`
+	Service         string `json:"service"`
+	SourceNamespace string `json:"sourceNamespace"`
+	SourceWorkload  string `json:"sourceWorkload"`
+}
+
+type QueryModelRollouts struct {
+	Namespace   string  `json:"namespace"`
+	Application string  `json:"application"`
+	MinShare    float64 `json:"minShare"`
+}
+
+type QueryModelErrorBudget struct {
+	Namespace string  `json:"namespace"`
+	Service   string  `json:"service"`
+	Target    float64 `json:"target"`
+	Window    string  `json:"window"`
+}
+
+type QueryModelLatencySLO struct {
+	Namespace        string  `json:"namespace"`
+	Service          string  `json:"service"`
+	SourceNamespace  string  `json:"sourceNamespace"`
+	SourceWorkload   string  `json:"sourceWorkload"`
+	LatencyThreshold float64 `json:"latencyThreshold"`
+	Edges            bool    `json:"edges"`
+}
+
+type QueryModelNamespaceHealth struct {
+	Namespace string `json:"namespace"`
+}
+
+type QueryModelWorkloadTraffic struct {
+	Namespace string `json:"namespace"`
+	Workload  string `json:"workload"`
 }
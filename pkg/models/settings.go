@@ -3,6 +3,8 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -13,20 +15,168 @@ const (
 	PrometheusAuthMethodToken = "token"
 )
 
+const (
+	NodeHealthServer   = "server"
+	NodeHealthClient   = "client"
+	NodeHealthWorst    = "worst"
+	NodeHealthWeighted = "weighted"
+)
+
+const (
+	DurationUnitMilliseconds = "ms"
+	DurationUnitSeconds      = "s"
+)
+
+const (
+	IstioLinkTargetNode = "node"
+	IstioLinkTargetEdge = "edge"
+)
+
+const (
+	PrometheusFlavorPrometheus      = "prometheus"
+	PrometheusFlavorVictoriaMetrics = "victoriametrics"
+)
+
+const (
+	PrometheusDiscoveryModeLabelValues = "labelValues"
+	PrometheusDiscoveryModeSeries      = "series"
+)
+
 type PluginSettings struct {
-	PrometheusUrl          string                `json:"prometheusUrl"`
-	PrometheusAuthMethod   string                `json:"prometheusAuthMethod"`
-	PrometheusUsername     string                `json:"prometheusUsername"`
-	IstioWarningThreshold  float64               `json:"istioWarningThreshold"`
-	IstioErrorThreshold    float64               `json:"istioErrorThreshold"`
-	IstioWorkloadDashboard string                `json:"istioWorkloadDashboard"`
-	IstioServiceDashboard  string                `json:"istioServiceDashboard"`
-	Secrets                *SecretPluginSettings `json:"-"`
+	PrometheusUrl                string                 `json:"prometheusUrl"`
+	PrometheusAuthMethod         string                 `json:"prometheusAuthMethod"`
+	PrometheusUsername           string                 `json:"prometheusUsername"`
+	PrometheusTokenFile          string                 `json:"prometheusTokenFile"`
+	PrometheusClientCertFile     string                 `json:"prometheusClientCertFile"`
+	PrometheusClientKeyFile      string                 `json:"prometheusClientKeyFile"`
+	PrometheusProxyUrl           string                 `json:"prometheusProxyUrl"`
+	PrometheusFlavor             string                 `json:"prometheusFlavor"`
+	PrometheusDiscoveryMode      string                 `json:"prometheusDiscoveryMode"`
+	PrometheusQueryParams        string                 `json:"prometheusQueryParams"`
+	PrometheusMaxWindow          string                 `json:"prometheusMaxWindow"`
+	PrometheusRoundTo            string                 `json:"prometheusRoundTo"`
+	PrometheusQueryTimeout       string                 `json:"prometheusQueryTimeout"`
+	PrometheusQueryLimit         int                    `json:"prometheusQueryLimit"`
+	PrometheusDefaultRange       string                 `json:"prometheusDefaultRange"`
+	PrometheusListRefresh        string                 `json:"prometheusListRefresh"`
+	PrometheusRateLimit          float64                `json:"prometheusRateLimit"`
+	PrometheusBatchConcurrency   int                    `json:"prometheusBatchConcurrency"`
+	PrometheusForwardUserHeaders bool                   `json:"prometheusForwardUserHeaders"`
+	KeepCookies                  []string               `json:"keepCookies"`
+	PrometheusDemoMode           bool                   `json:"prometheusDemoMode"`
+	PrometheusTransport          PrometheusTransport    `json:"prometheusTransport"`
+	IstioWarningThreshold        float64                `json:"istioWarningThreshold"`
+	IstioErrorThreshold          float64                `json:"istioErrorThreshold"`
+	IstioHighlightThrottled      bool                   `json:"istioHighlightThrottled"`
+	IstioNodeHealth              string                 `json:"istioNodeHealth"`
+	IstioNodeHealthClientWeight  float64                `json:"istioNodeHealthClientWeight"`
+	IstioWorkloadDashboard       string                 `json:"istioWorkloadDashboard"`
+	IstioServiceDashboard        string                 `json:"istioServiceDashboard"`
+	IstioGatewayDashboard        string                 `json:"istioGatewayDashboard"`
+	IstioEdgeDashboard           string                 `json:"istioEdgeDashboard"`
+	IstioDashboardParams         string                 `json:"istioDashboardParams"`
+	IstioLinks                   []IstioLink            `json:"istioLinks"`
+	IstioAnnotations             []IstioAnnotation      `json:"istioAnnotations"`
+	IstioRevisionLabel           string                 `json:"istioRevisionLabel"`
+	IstioRevision                string                 `json:"istioRevision"`
+	IstioExcludedDestinations    []string               `json:"istioExcludedDestinations"`
+	IstioDurationMetric          string                 `json:"istioDurationMetric"`
+	IstioDurationUnit            string                 `json:"istioDurationUnit"`
+	IstioNativeHistograms        bool                   `json:"istioNativeHistograms"`
+	IstioMultiCluster            bool                   `json:"istioMultiCluster"`
+	IstioCardinalityLimit        int                    `json:"istioCardinalityLimit"`
+	AlertmanagerUrl              string                 `json:"alertmanagerUrl"`
+	AlertmanagerAuthMethod       string                 `json:"alertmanagerAuthMethod"`
+	AlertmanagerUsername         string                 `json:"alertmanagerUsername"`
+	GrafanaAlerts                bool                   `json:"grafanaAlerts"`
+	StorageDirectory             string                 `json:"storageDirectory"`
+	Overrides                    map[int64]OrgOverrides `json:"overrides"`
+	Secrets                      *SecretPluginSettings  `json:"-"`
+}
+
+type PrometheusTransport struct {
+	MaxIdleConnsPerHost   int    `json:"maxIdleConnsPerHost"`
+	IdleConnTimeout       string `json:"idleConnTimeout"`
+	ResponseHeaderTimeout string `json:"responseHeaderTimeout"`
+	KeepAlive             string `json:"keepAlive"`
+}
+
+// IstioLink is an additional link for the nodes or edges of the graphs, e.g.
+// to a logs, traces or runbook page. The placeholders in the url are replaced
+// with the values of the node or edge, see "IstioLinkPlaceholders".
+type IstioLink struct {
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Target string `json:"target"`
+}
+
+// IstioLinkPlaceholders are the placeholders, which can be used in the url of
+// a link for the given target.
+var IstioLinkPlaceholders = map[string][]string{
+	IstioLinkTargetNode: {"type", "name", "namespace", "cluster", "service", "from", "to", "timeRange"},
+	IstioLinkTargetEdge: {"source_type", "source_name", "source_namespace", "source_cluster", "destination_type", "destination_name", "destination_namespace", "destination_cluster", "destination_service", "from", "to", "timeRange"},
+}
+
+// linkPlaceholder matches the placeholders in the url of a link, e.g.
+// "{namespace}". Grafana variables like "${__url_time_range}" are matched too,
+// so that they can be skipped.
+var linkPlaceholder = regexp.MustCompile(`\$?\{([A-Za-z_]+)\}`)
+
+// LinkPlaceholders returns the names of all placeholders in the given url of a
+// link. Grafana variables like "${__url_time_range}" are not returned, because
+// they are replaced by Grafana.
+func LinkPlaceholders(url string) []string {
+	var placeholders []string
+	for _, match := range linkPlaceholder.FindAllStringSubmatch(url, -1) {
+		if !strings.HasPrefix(match[0], "$") {
+			placeholders = append(placeholders, match[1])
+		}
+	}
+	return placeholders
+}
+
+// ReplaceLinkPlaceholders replaces the placeholders in the given url of a link
+// with the query escaped values returned by the given function. Grafana
+// variables like "${__url_time_range}" are kept.
+func ReplaceLinkPlaceholders(url string, value func(placeholder string) string) string {
+	return linkPlaceholder.ReplaceAllStringFunc(url, func(match string) string {
+		if strings.HasPrefix(match, "$") {
+			return match
+		}
+		return value(match[1 : len(match)-1])
+	})
+}
+
+// IstioAnnotation is a Kubernetes annotation of the workloads and services,
+// e.g. the owning team or a runbook url, which is shown for the nodes of the
+// graphs. If link is set, the value of the annotation is shown as link with
+// the title instead of in the node details.
+type IstioAnnotation struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	Link  bool   `json:"link"`
+}
+
+// OrgOverrides are the settings, which can be overwritten for a single Grafana
+// organization, so that one datasource can serve multiple organizations with
+// different policies. Unset fields fall back to the settings of the datasource.
+// If namespaces are set, only these namespaces can be queried by the
+// organization.
+type OrgOverrides struct {
+	IstioWarningThreshold  *float64 `json:"istioWarningThreshold"`
+	IstioErrorThreshold    *float64 `json:"istioErrorThreshold"`
+	IstioWorkloadDashboard string   `json:"istioWorkloadDashboard"`
+	IstioServiceDashboard  string   `json:"istioServiceDashboard"`
+	IstioGatewayDashboard  string   `json:"istioGatewayDashboard"`
+	IstioEdgeDashboard     string   `json:"istioEdgeDashboard"`
+	IstioNamespaces        []string `json:"istioNamespaces"`
 }
 
 type SecretPluginSettings struct {
-	PrometheusPassword string `json:"prometheusPassword"`
-	PrometheusToken    string `json:"prometheusToken"`
+	PrometheusPassword   string `json:"prometheusPassword"`
+	PrometheusToken      string `json:"prometheusToken"`
+	AlertmanagerPassword string `json:"alertmanagerPassword"`
+	AlertmanagerToken    string `json:"alertmanagerToken"`
 }
 
 func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSettings, error) {
@@ -43,7 +193,9 @@ func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSetti
 
 func loadSecretPluginSettings(source map[string]string) *SecretPluginSettings {
 	return &SecretPluginSettings{
-		PrometheusPassword: source["prometheusPassword"],
-		PrometheusToken:    source["prometheusToken"],
+		PrometheusPassword:   source["prometheusPassword"],
+		PrometheusToken:      source["prometheusToken"],
+		AlertmanagerPassword: source["alertmanagerPassword"],
+		AlertmanagerToken:    source["alertmanagerToken"],
 	}
 }
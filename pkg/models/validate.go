@@ -0,0 +1,303 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// PluginID is the id of the plugin, which must be used as "type" of the
+// datasource in a provisioning file.
+const PluginID = "ricoberger-istio-datasource"
+
+// SecureJSONDataKeys are the keys, which can be set in the "secureJsonData" of
+// the datasource.
+var SecureJSONDataKeys = []string{"prometheusPassword", "prometheusToken", "alertmanagerPassword", "alertmanagerToken"}
+
+// envVariable matches the environment variables, which are replaced by Grafana
+// when a provisioning file is loaded, e.g. "${PROMETHEUS_URL}".
+var envVariable = regexp.MustCompile(`\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// ValidatePluginSettings validates the "jsonData" and the keys of the
+// "secureJsonData" of a datasource, like they are used in a provisioning
+// file, and returns all found errors. Values which contain an environment
+// variable are only validated after Grafana replaced the variable, so that
+// they are skipped here.
+func ValidatePluginSettings(jsonData []byte, secureJSONData map[string]any) []string {
+	var errors []string
+
+	if len(jsonData) == 0 {
+		jsonData = []byte("{}")
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		return []string{fmt.Sprintf("jsonData: %s", err.Error())}
+	}
+
+	known := jsonFieldNames(reflect.TypeFor[PluginSettings]())
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		if !slices.Contains(known, key) {
+			errors = append(errors, fmt.Sprintf("jsonData.%s: unknown setting", key))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(secureJSONData)) {
+		if !slices.Contains(SecureJSONDataKeys, key) {
+			errors = append(errors, fmt.Sprintf("secureJsonData.%s: unknown setting, supported: %s", key, strings.Join(SecureJSONDataKeys, ", ")))
+		}
+	}
+
+	var settings PluginSettings
+	if err := json.Unmarshal(jsonData, &settings); err != nil {
+		return append(errors, fmt.Sprintf("jsonData: %s", err.Error()))
+	}
+
+	if !settings.PrometheusDemoMode {
+		if settings.PrometheusUrl == "" {
+			errors = append(errors, "jsonData.prometheusUrl: is required")
+		} else if err := validateURL(settings.PrometheusUrl); err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData.prometheusUrl: %s", err.Error()))
+		}
+	}
+	if settings.PrometheusProxyUrl != "" {
+		if err := validateURL(settings.PrometheusProxyUrl); err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData.prometheusProxyUrl: %s", err.Error()))
+		}
+	}
+
+	switch settings.PrometheusAuthMethod {
+	case "", PrometheusAuthMethodNone:
+	case PrometheusAuthMethodBasic:
+		if settings.PrometheusUsername == "" {
+			errors = append(errors, "jsonData.prometheusUsername: is required for basic authentication")
+		}
+		if _, ok := secureJSONData["prometheusPassword"]; !ok {
+			errors = append(errors, "secureJsonData.prometheusPassword: is required for basic authentication")
+		}
+	case PrometheusAuthMethodToken:
+		if _, ok := secureJSONData["prometheusToken"]; !ok && settings.PrometheusTokenFile == "" {
+			errors = append(errors, "secureJsonData.prometheusToken: is required for token authentication")
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("jsonData.prometheusAuthMethod: must be one of %s, %s or %s", PrometheusAuthMethodNone, PrometheusAuthMethodBasic, PrometheusAuthMethodToken))
+	}
+	if settings.PrometheusTokenFile != "" {
+		if settings.PrometheusAuthMethod != PrometheusAuthMethodToken {
+			errors = append(errors, "jsonData.prometheusTokenFile: can only be used with token authentication")
+		} else if !filepath.IsAbs(settings.PrometheusTokenFile) && !envVariable.MatchString(settings.PrometheusTokenFile) {
+			errors = append(errors, "jsonData.prometheusTokenFile: must be an absolute path")
+		}
+	}
+	if (settings.PrometheusClientCertFile == "") != (settings.PrometheusClientKeyFile == "") {
+		errors = append(errors, "jsonData.prometheusClientCertFile: must be set together with jsonData.prometheusClientKeyFile")
+	}
+	for _, file := range []struct {
+		name  string
+		value string
+	}{
+		{name: "prometheusClientCertFile", value: settings.PrometheusClientCertFile},
+		{name: "prometheusClientKeyFile", value: settings.PrometheusClientKeyFile},
+	} {
+		if file.value != "" && !filepath.IsAbs(file.value) && !envVariable.MatchString(file.value) {
+			errors = append(errors, fmt.Sprintf("jsonData.%s: must be an absolute path", file.name))
+		}
+	}
+
+	if settings.AlertmanagerUrl != "" {
+		if err := validateURL(settings.AlertmanagerUrl); err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData.alertmanagerUrl: %s", err.Error()))
+		}
+	}
+
+	if settings.AlertmanagerUrl != "" && settings.GrafanaAlerts {
+		errors = append(errors, "jsonData.grafanaAlerts: can not be enabled together with an alertmanagerUrl")
+	}
+
+	switch settings.AlertmanagerAuthMethod {
+	case "", PrometheusAuthMethodNone:
+	case PrometheusAuthMethodBasic:
+		if settings.AlertmanagerUsername == "" {
+			errors = append(errors, "jsonData.alertmanagerUsername: is required for basic authentication")
+		}
+		if _, ok := secureJSONData["alertmanagerPassword"]; !ok {
+			errors = append(errors, "secureJsonData.alertmanagerPassword: is required for basic authentication")
+		}
+	case PrometheusAuthMethodToken:
+		if _, ok := secureJSONData["alertmanagerToken"]; !ok {
+			errors = append(errors, "secureJsonData.alertmanagerToken: is required for token authentication")
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("jsonData.alertmanagerAuthMethod: must be one of %s, %s or %s", PrometheusAuthMethodNone, PrometheusAuthMethodBasic, PrometheusAuthMethodToken))
+	}
+
+	if settings.StorageDirectory != "" && !filepath.IsAbs(settings.StorageDirectory) && !envVariable.MatchString(settings.StorageDirectory) {
+		errors = append(errors, "jsonData.storageDirectory: must be an absolute path")
+	}
+
+	if settings.PrometheusFlavor != "" && settings.PrometheusFlavor != PrometheusFlavorPrometheus && settings.PrometheusFlavor != PrometheusFlavorVictoriaMetrics {
+		errors = append(errors, fmt.Sprintf("jsonData.prometheusFlavor: must be one of %s or %s", PrometheusFlavorPrometheus, PrometheusFlavorVictoriaMetrics))
+	}
+
+	if settings.PrometheusDiscoveryMode != "" && settings.PrometheusDiscoveryMode != PrometheusDiscoveryModeLabelValues && settings.PrometheusDiscoveryMode != PrometheusDiscoveryModeSeries {
+		errors = append(errors, fmt.Sprintf("jsonData.prometheusDiscoveryMode: must be one of %s or %s", PrometheusDiscoveryModeLabelValues, PrometheusDiscoveryModeSeries))
+	}
+
+	if settings.PrometheusQueryParams != "" && !envVariable.MatchString(settings.PrometheusQueryParams) {
+		if _, err := url.ParseQuery(settings.PrometheusQueryParams); err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData.prometheusQueryParams: %s", err.Error()))
+		}
+	}
+
+	if settings.IstioDashboardParams != "" && !envVariable.MatchString(settings.IstioDashboardParams) {
+		if _, err := url.ParseQuery(strings.ReplaceAll(settings.IstioDashboardParams, "{cluster}", "cluster")); err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData.istioDashboardParams: %s", err.Error()))
+		}
+	}
+
+	for i, link := range settings.IstioLinks {
+		if link.Title == "" {
+			errors = append(errors, fmt.Sprintf("jsonData.istioLinks[%d].title: is required", i))
+		}
+		placeholders, ok := IstioLinkPlaceholders[link.Target]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("jsonData.istioLinks[%d].target: must be one of %s or %s", i, IstioLinkTargetNode, IstioLinkTargetEdge))
+		}
+		if link.URL == "" {
+			errors = append(errors, fmt.Sprintf("jsonData.istioLinks[%d].url: is required", i))
+		} else if ok {
+			for _, placeholder := range LinkPlaceholders(link.URL) {
+				if !slices.Contains(placeholders, placeholder) {
+					errors = append(errors, fmt.Sprintf("jsonData.istioLinks[%d].url: unknown placeholder {%s} for target %s", i, placeholder, link.Target))
+				}
+			}
+		}
+	}
+
+	for i, annotation := range settings.IstioAnnotations {
+		if annotation.Name == "" {
+			errors = append(errors, fmt.Sprintf("jsonData.istioAnnotations[%d].name: is required", i))
+		}
+	}
+
+	for _, duration := range []struct {
+		name  string
+		value string
+	}{
+		{name: "prometheusMaxWindow", value: settings.PrometheusMaxWindow},
+		{name: "prometheusRoundTo", value: settings.PrometheusRoundTo},
+		{name: "prometheusQueryTimeout", value: settings.PrometheusQueryTimeout},
+		{name: "prometheusDefaultRange", value: settings.PrometheusDefaultRange},
+		{name: "prometheusListRefresh", value: settings.PrometheusListRefresh},
+		{name: "prometheusTransport.idleConnTimeout", value: settings.PrometheusTransport.IdleConnTimeout},
+		{name: "prometheusTransport.responseHeaderTimeout", value: settings.PrometheusTransport.ResponseHeaderTimeout},
+		{name: "prometheusTransport.keepAlive", value: settings.PrometheusTransport.KeepAlive},
+	} {
+		if duration.value == "" || envVariable.MatchString(duration.value) {
+			continue
+		}
+		if _, err := model.ParseDuration(duration.value); err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData.%s: %s", duration.name, err.Error()))
+		}
+	}
+
+	if settings.PrometheusTransport.MaxIdleConnsPerHost < 0 {
+		errors = append(errors, "jsonData.prometheusTransport.maxIdleConnsPerHost: must not be negative")
+	}
+	if settings.PrometheusQueryLimit < 0 {
+		errors = append(errors, "jsonData.prometheusQueryLimit: must not be negative")
+	}
+	if settings.PrometheusRateLimit < 0 {
+		errors = append(errors, "jsonData.prometheusRateLimit: must not be negative")
+	}
+	if settings.PrometheusBatchConcurrency < 0 {
+		errors = append(errors, "jsonData.prometheusBatchConcurrency: must not be negative")
+	}
+	if settings.IstioCardinalityLimit < 0 {
+		errors = append(errors, "jsonData.istioCardinalityLimit: must not be negative")
+	}
+	if settings.IstioWarningThreshold < 0 {
+		errors = append(errors, "jsonData.istioWarningThreshold: must not be negative")
+	}
+	if settings.IstioErrorThreshold < 0 {
+		errors = append(errors, "jsonData.istioErrorThreshold: must not be negative")
+	}
+	if settings.IstioErrorThreshold > 0 && settings.IstioErrorThreshold < settings.IstioWarningThreshold {
+		errors = append(errors, "jsonData.istioErrorThreshold: must not be lower than the warning threshold")
+	}
+
+	switch settings.IstioNodeHealth {
+	case "", NodeHealthServer, NodeHealthClient, NodeHealthWorst, NodeHealthWeighted:
+	default:
+		errors = append(errors, fmt.Sprintf("jsonData.istioNodeHealth: must be one of %s, %s, %s or %s", NodeHealthServer, NodeHealthClient, NodeHealthWorst, NodeHealthWeighted))
+	}
+	if settings.IstioNodeHealthClientWeight < 0 || settings.IstioNodeHealthClientWeight > 1 {
+		errors = append(errors, "jsonData.istioNodeHealthClientWeight: must be between 0 and 1")
+	}
+
+	if settings.IstioDurationUnit != "" && settings.IstioDurationUnit != DurationUnitMilliseconds && settings.IstioDurationUnit != DurationUnitSeconds {
+		errors = append(errors, fmt.Sprintf("jsonData.istioDurationUnit: must be one of %s or %s", DurationUnitMilliseconds, DurationUnitSeconds))
+	}
+
+	for _, destination := range settings.IstioExcludedDestinations {
+		if destination == "" {
+			errors = append(errors, "jsonData.istioExcludedDestinations: must not contain empty destinations")
+		} else if strings.Contains(destination, ":") {
+			errors = append(errors, fmt.Sprintf("jsonData.istioExcludedDestinations: destination %q is ignored, because the destination_service label doesn't contain the port", destination))
+		}
+	}
+
+	for _, orgID := range slices.Sorted(maps.Keys(settings.Overrides)) {
+		overrides := settings.Overrides[orgID]
+		if orgID <= 0 {
+			errors = append(errors, fmt.Sprintf("jsonData.overrides.%d: must be a valid organization id", orgID))
+		}
+		if overrides.IstioWarningThreshold != nil && *overrides.IstioWarningThreshold < 0 {
+			errors = append(errors, fmt.Sprintf("jsonData.overrides.%d.istioWarningThreshold: must not be negative", orgID))
+		}
+		if overrides.IstioErrorThreshold != nil && *overrides.IstioErrorThreshold < 0 {
+			errors = append(errors, fmt.Sprintf("jsonData.overrides.%d.istioErrorThreshold: must not be negative", orgID))
+		}
+		if overrides.IstioNamespaces != nil && len(overrides.IstioNamespaces) == 0 {
+			errors = append(errors, fmt.Sprintf("jsonData.overrides.%d.istioNamespaces: must not be empty", orgID))
+		}
+	}
+
+	return errors
+}
+
+// validateURL returns an error if the given value is not an absolute http or
+// https url. Values with environment variables are not validated.
+func validateURL(value string) error {
+	if envVariable.MatchString(value) {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https url")
+	}
+	return nil
+}
+
+// jsonFieldNames returns the names of the json tags of the given struct.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
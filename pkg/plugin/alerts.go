@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// alertColor is the color of nodes with firing alerts, which replaces the
+// color of the health of the node.
+const alertColor = "#f2495c"
+
+var (
+	// alertWorkloadLabels are the labels of an alert, which can contain the
+	// name of the affected workload.
+	alertWorkloadLabels = []string{"workload", "deployment", "statefulset", "daemonset", "destination_workload"}
+	// alertServiceLabels are the labels of an alert, which can contain the
+	// name of the affected service.
+	alertServiceLabels = []string{"service", "destination_service_name"}
+)
+
+// getNodeAlerts returns the names of the firing alerts of the given nodes from
+// the Alertmanager API, where the key of the map is the id of the node. An
+// alert belongs to a workload or gateway node, if its "namespace" label
+// matches the namespace of the node and one of the "alertWorkloadLabels"
+// matches the name of the node. For service nodes the "alertServiceLabels" are
+// used. Alerts without a namespace are not matched, because the same workload
+// name can exist in multiple namespaces.
+func (d *Datasource) getNodeAlerts(ctx context.Context, nodes map[string]models.Node) (map[string][]string, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getNodeAlerts")
+	defer span.End()
+
+	alerts, err := d.alertmanagerClient.GetAlerts(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	nodeAlerts := make(map[string][]string)
+	for _, alert := range alerts {
+		namespace := cmp.Or(alert.Labels["namespace"], alert.Labels["destination_workload_namespace"], alert.Labels["destination_service_namespace"])
+		if namespace == "" {
+			continue
+		}
+
+		for id, node := range nodes {
+			if node.Namespace != namespace {
+				continue
+			}
+
+			var labels []string
+			switch node.Type {
+			case "Workload", "Gateway":
+				labels = alertWorkloadLabels
+			case "Service":
+				labels = alertServiceLabels
+			}
+
+			for _, label := range labels {
+				if alert.Labels[label] == node.Name {
+					name := cmp.Or(alert.Labels["alertname"], "unknown")
+					if !slices.Contains(nodeAlerts[id], name) {
+						nodeAlerts[id] = append(nodeAlerts[id], name)
+					}
+					break
+				}
+			}
+		}
+	}
+
+	for id := range nodeAlerts {
+		slices.Sort(nodeAlerts[id])
+	}
+
+	return nodeAlerts, nil
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/alertmanager"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+type testAlertmanagerClient struct {
+	alerts []alertmanager.Alert
+}
+
+func (c *testAlertmanagerClient) GetAlerts(ctx context.Context) ([]alertmanager.Alert, error) {
+	return c.alerts, nil
+}
+
+func (c *testAlertmanagerClient) Close() {}
+
+func TestGetNodeAlerts(t *testing.T) {
+	d := &Datasource{
+		alertmanagerClient: &testAlertmanagerClient{alerts: []alertmanager.Alert{
+			{Labels: map[string]string{"alertname": "HighErrorRate", "namespace": "bookinfo", "workload": "reviews-v1"}},
+			{Labels: map[string]string{"alertname": "PodCrashLooping", "namespace": "bookinfo", "deployment": "reviews-v1"}},
+			{Labels: map[string]string{"alertname": "HighLatency", "destination_service_namespace": "bookinfo", "destination_service_name": "reviews"}},
+			{Labels: map[string]string{"alertname": "HighErrorRate", "namespace": "other", "workload": "details-v1"}},
+			{Labels: map[string]string{"alertname": "Watchdog"}},
+		}},
+		logger: log.DefaultLogger,
+	}
+
+	alerts, err := d.getNodeAlerts(context.Background(), map[string]models.Node{
+		"reviews-v1": {Type: "Workload", Name: "reviews-v1", Namespace: "bookinfo"},
+		"reviews":    {Type: "Service", Name: "reviews", Namespace: "bookinfo"},
+		"details-v1": {Type: "Workload", Name: "details-v1", Namespace: "bookinfo"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"reviews-v1": {"HighErrorRate", "PodCrashLooping"},
+		"reviews":    {"HighLatency"},
+	}, alerts)
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// annotationLabelInvalidChars matches the characters of a Kubernetes
+// annotation, which are replaced with an underscore by kube-state-metrics, when
+// the annotation is exported as label.
+var annotationLabelInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// annotationLabel returns the name of the label of the "kube_*_annotations"
+// metrics of kube-state-metrics for the given Kubernetes annotation, e.g.
+// "annotation_example_com_team" for "example.com/team".
+func annotationLabel(annotation string) string {
+	return "annotation_" + annotationLabelInvalidChars.ReplaceAllString(annotation, "_")
+}
+
+// annotationKey returns the key of the annotations of a workload or service in
+// the map returned by "getNodeAnnotations".
+func annotationKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// getNodeAnnotations returns the configured annotations of the Deployments,
+// StatefulSets, DaemonSets and Services of the given nodes. Since the plugin
+// doesn't have access to the Kubernetes API, the annotations are retrieved from
+// the "kube_*_annotations" metrics of kube-state-metrics, which only contain
+// the annotations allowed via the "--metric-annotations-allowlist" flag. The
+// key of the returned map is generated via "annotationKey" and the value
+// contains the values of the configured annotations by their name.
+func (d *Datasource) getNodeAnnotations(ctx context.Context, nodes map[string]models.Node, timeRange backend.TimeRange) (map[string]map[string]string, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getNodeAnnotations")
+	defer span.End()
+
+	var namespaces []string
+	for _, node := range nodes {
+		if node.Namespace != "" && !slices.Contains(namespaces, node.Namespace) {
+			namespaces = append(namespaces, node.Namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil, nil
+	}
+	slices.Sort(namespaces)
+
+	matchers := prometheus.Matchers{prometheus.OneOf("namespace", namespaces...)}
+
+	var selectors []prometheus.Expression
+	for _, metric := range []string{"kube_deployment_annotations", "kube_statefulset_annotations", "kube_daemonset_annotations", "kube_service_annotations"} {
+		selectors = append(selectors, prometheus.Selector{Metric: metric, Matchers: matchers})
+	}
+	query := prometheus.Or(selectors...)
+
+	d.logger.Debug("Get node annotations", "query", query.String())
+	metrics, err := d.prometheusClient.GetMetrics(ctx, "annotations", query, timeRange)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	annotations := make(map[string]map[string]string)
+	for _, metric := range metrics {
+		var key string
+		if name := cmp.Or(metric.Labels["deployment"], metric.Labels["statefulset"], metric.Labels["daemonset"]); name != "" {
+			key = annotationKey("workload", metric.Labels["namespace"], name)
+		} else if name := metric.Labels["service"]; name != "" {
+			key = annotationKey("service", metric.Labels["namespace"], name)
+		} else {
+			continue
+		}
+
+		values := make(map[string]string)
+		for _, annotation := range d.istioAnnotations {
+			if value := metric.Labels[annotationLabel(annotation.Name)]; value != "" {
+				values[annotation.Name] = value
+			}
+		}
+		if len(values) > 0 {
+			if annotations[key] == nil {
+				annotations[key] = values
+			} else {
+				maps.Copy(annotations[key], values)
+			}
+		}
+	}
+
+	return annotations, nil
+}
+
+// nodeAnnotations returns the annotations of the Kubernetes resource of the
+// given node. Workloads and gateways use the annotations of their workload and
+// services the annotations of their service. Other nodes don't have
+// annotations.
+func nodeAnnotations(annotations map[string]map[string]string, node models.Node) map[string]string {
+	switch node.Type {
+	case "Workload", "Gateway":
+		return annotations[annotationKey("workload", node.Namespace, node.Name)]
+	case "Service":
+		return annotations[annotationKey("service", node.Namespace, node.Name)]
+	default:
+		return nil
+	}
+}
+
+// annotationField is the field of a configured annotation in the nodes frame
+// of a graph.
+type annotationField struct {
+	annotation models.IstioAnnotation
+	field      *data.Field
+}
+
+// addAnnotationFields adds a field for each configured annotation to the given
+// fields. Annotations which contain a link, e.g. to a runbook, are added as
+// link, all other annotations, e.g. the owning team, are shown in the details
+// of the nodes.
+func (d *Datasource) addAnnotationFields(fields *models.Fields) []annotationField {
+	var annotationFields []annotationField
+	for i, annotation := range d.istioAnnotations {
+		title := cmp.Or(annotation.Title, annotation.Name)
+
+		var field *data.Field
+		if annotation.Link {
+			name := fmt.Sprintf("annotation_%d", i+1)
+			field = fields.Add(name, nil, []string{}, &data.FieldConfig{
+				DisplayName: title,
+				Links: []data.DataLink{
+					{
+						Title: title,
+						URL:   fmt.Sprintf("${__data.fields[%q]}", name),
+					},
+				},
+			})
+		} else {
+			field = fields.Add(fmt.Sprintf("detail__annotation_%d", i+1), nil, []string{}, &data.FieldConfig{DisplayName: title})
+		}
+
+		annotationFields = append(annotationFields, annotationField{annotation: annotation, field: field})
+	}
+	return annotationFields
+}
+
+// appendValue appends the value of the annotation of the field for the given
+// annotations of a node. Missing annotations are shown as "-" in the details,
+// while missing links are empty, so that they are not clickable.
+func (f annotationField) appendValue(annotations map[string]string) {
+	value, ok := annotations[f.annotation.Name]
+	if !ok && !f.annotation.Link {
+		value = "-"
+	}
+	f.field.Append(value)
+}
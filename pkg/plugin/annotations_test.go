@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNodeAnnotations(t *testing.T) {
+	client := prometheustest.NewClient().AddMetrics("kube_deployment_annotations",
+		prometheus.Metric{Value: 1, Labels: map[string]string{"namespace": "bookinfo", "deployment": "reviews-v1", "annotation_example_com_team": "reviews", "annotation_example_com_runbook": "https://runbooks.example.com/reviews"}},
+		prometheus.Metric{Value: 1, Labels: map[string]string{"namespace": "bookinfo", "service": "reviews", "annotation_example_com_team": "reviews-svc"}},
+		prometheus.Metric{Value: 1, Labels: map[string]string{"namespace": "bookinfo", "deployment": "details-v1"}},
+	)
+	d := &Datasource{
+		prometheusClient: client,
+		istioAnnotations: []models.IstioAnnotation{{Name: "example.com/team", Title: "Team"}, {Name: "example.com/runbook", Title: "Runbook", Link: true}},
+		logger:           log.DefaultLogger,
+	}
+
+	nodes := map[string]models.Node{
+		"reviews-v1": {Type: "Workload", Name: "reviews-v1", Namespace: "bookinfo"},
+		"reviews":    {Type: "Service", Name: "reviews", Namespace: "bookinfo"},
+		"details-v1": {Type: "Workload", Name: "details-v1", Namespace: "bookinfo"},
+	}
+
+	annotations, err := d.getNodeAnnotations(context.Background(), nodes, backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()})
+	require.NoError(t, err)
+	require.Equal(t, []string{`kube_deployment_annotations{namespace="bookinfo"} or kube_statefulset_annotations{namespace="bookinfo"} or kube_daemonset_annotations{namespace="bookinfo"} or kube_service_annotations{namespace="bookinfo"}`}, client.Queries())
+
+	require.Equal(t, map[string]string{"example.com/team": "reviews", "example.com/runbook": "https://runbooks.example.com/reviews"}, nodeAnnotations(annotations, nodes["reviews-v1"]))
+	require.Equal(t, map[string]string{"example.com/team": "reviews-svc"}, nodeAnnotations(annotations, nodes["reviews"]))
+	require.Nil(t, nodeAnnotations(annotations, nodes["details-v1"]))
+
+	fields := models.Fields{}
+	annotationFields := d.addAnnotationFields(&fields)
+	for _, name := range []string{"reviews-v1", "details-v1"} {
+		for _, annotationField := range annotationFields {
+			annotationField.appendValue(nodeAnnotations(annotations, nodes[name]))
+		}
+	}
+
+	require.Equal(t, "detail__annotation_1", fields[0].Name)
+	require.Equal(t, "reviews", fields[0].At(0))
+	require.Equal(t, "-", fields[0].At(1))
+	require.Equal(t, "annotation_2", fields[1].Name)
+	require.Equal(t, "https://runbooks.example.com/reviews", fields[1].At(0))
+	require.Equal(t, "", fields[1].At(1))
+}
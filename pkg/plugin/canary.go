@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// canaryMetric defines a metric which is returned by the canary query. The
+// query must return one time series per "destination_version".
+type canaryMetric struct {
+	name        string
+	displayName string
+	unit        string
+	query       prometheus.Expression
+}
+
+// handleCanaryQueries handles the queries to compare two versions of an
+// application. It uses the concurrent package to handle multiple queries in
+// parallel. For both versions the request rate, error rate and the P50 and
+// P99 request duration are returned as time series.
+func (d *Datasource) handleCanaryQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleCanaryQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleCanary, 10)
+}
+
+func (d *Datasource) handleCanary(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleCanary")
+	defer span.End()
+
+	var qm models.QueryModelCanary
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// The step for the range queries is based on the interval of the query.
+	// The rate window should be at least one minute, so that we always have
+	// enough samples to calculate the rate.
+	step := query.DataQuery.Interval
+	if step <= 0 {
+		step = time.Minute
+	}
+	window := int64(max(step, time.Minute).Seconds())
+
+	matchers := prometheus.Matchers{
+		prometheus.Equal("destination_workload_namespace", qm.Namespace),
+		prometheus.Equal("destination_app", qm.Application),
+		prometheus.OneOf("destination_version", qm.BaselineVersion, qm.CanaryVersion),
+	}
+
+	metrics := []canaryMetric{{
+		name:        "rate",
+		displayName: "Rate",
+		unit:        "reqps",
+		query:       sumQuery("istio_requests_total", "rate", matchers, window, "destination_version"),
+	}, {
+		name:        "error",
+		displayName: "Error",
+		unit:        "percent",
+		query:       canaryErrorRateQuery(matchers, window),
+	}, {
+		name:        "p50",
+		displayName: "P50",
+		unit:        "ms",
+		query:       d.durationQuantile(0.5, "rate", matchers, window, "destination_version"),
+	}, {
+		name:        "p99",
+		displayName: "P99",
+		unit:        "ms",
+		query:       d.durationQuantile(0.99, "rate", matchers, window, "destination_version"),
+	}}
+
+	batch := make([]prometheus.TimeSeriesQuery, 0, len(metrics))
+	for _, metric := range metrics {
+		d.logger.Debug("Get time series", "query", metric.query.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To, "step", step)
+		batch = append(batch, prometheus.TimeSeriesQuery{Metric: metric.name, Query: metric.query, TimeRange: query.DataQuery.TimeRange, Step: step})
+	}
+
+	timeSeries, err := d.prometheusClient.GetTimeSeriesBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get time series", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// Create one frame per metric and version. The baseline version is always
+	// returned before the canary version, so that the series have a stable
+	// order in the panel.
+	var response backend.DataResponse
+
+	for i, metric := range metrics {
+		for _, version := range []string{qm.BaselineVersion, qm.CanaryVersion} {
+			for _, ts := range timeSeries[i] {
+				if ts.Labels["destination_version"] != version {
+					continue
+				}
+				ts = dropInvalidSamples(ts)
+
+				frame := data.NewFrame(
+					metric.name,
+					data.NewField("time", nil, ts.Timestamps),
+					data.NewField("value", data.Labels{"version": version}, ts.Values).SetConfig(&data.FieldConfig{
+						DisplayNameFromDS: fmt.Sprintf("%s (%s)", metric.displayName, version),
+						Unit:              metric.unit,
+					}),
+				)
+				frame.SetMeta(&data.FrameMeta{
+					PreferredVisualization: data.VisTypeGraph,
+					Type:                   data.FrameTypeTimeSeriesMulti,
+				})
+
+				response.Frames = append(response.Frames, frame)
+			}
+		}
+	}
+
+	return response
+}
+
+// canaryErrorRateQuery returns the query for the error rate per version. Without
+// the "or ... * 0" fallback a version without errors would have no series and
+// would be shown as missing instead of 0%.
+func canaryErrorRateQuery(matchers prometheus.Matchers, window int64) prometheus.Expression {
+	all := sumQuery("istio_requests_total", "rate", matchers, window, "destination_version")
+	errors := requestErrorsQuery("rate", matchers, window, "destination_version")
+
+	return prometheus.Binary{
+		LHS: prometheus.Binary{
+			LHS:      prometheus.Binary{LHS: errors, Operator: "or", RHS: prometheus.Binary{LHS: all, Operator: "*", RHS: prometheus.Number(0)}},
+			Operator: "/",
+			RHS:      all,
+		},
+		Operator: "*",
+		RHS:      prometheus.Number(100),
+	}
+}
+
+// dropInvalidSamples removes all NaN and Inf samples from the given time
+// series. The "histogram_quantile" function returns NaN when there were no
+// requests in a window, because all buckets are empty, and the error rate is
+// NaN for the same reason. The dropped samples are shown as gaps in the panel.
+func dropInvalidSamples(ts prometheus.TimeSeries) prometheus.TimeSeries {
+	result := prometheus.TimeSeries{Labels: ts.Labels}
+	for i, value := range ts.Values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		result.Timestamps = append(result.Timestamps, ts.Timestamps[i])
+		result.Values = append(result.Values, value)
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// cardinalityCheckInterval is the interval in which the number of series
+	// of the Istio metrics is checked again.
+	cardinalityCheckInterval = 30 * time.Minute
+	// cardinalityCheckTimeout is the maximum duration of a single check,
+	// because counting the series of all Istio metrics can be expensive.
+	cardinalityCheckTimeout = time.Minute
+)
+
+// cardinalityCheck contains the result of the last check of the number of
+// series per Istio metric and namespace. It is shared by the datasources of
+// all organizations, because they use the same Prometheus instance.
+type cardinalityCheck struct {
+	mu         sync.RWMutex
+	limit      int
+	violations []cardinalityViolation
+	err        error
+	time       time.Time
+}
+
+// cardinalityViolation is an Istio metric, which has more series in a
+// namespace than the configured limit.
+type cardinalityViolation struct {
+	Metric    string `json:"metric"`
+	Namespace string `json:"namespace"`
+	Series    int    `json:"series"`
+}
+
+// cardinalityCheckDetails is the result of the cardinality check, which is
+// returned in the details of the health check.
+type cardinalityCheckDetails struct {
+	Limit      int                    `json:"limit"`
+	Error      string                 `json:"error,omitempty"`
+	Time       time.Time              `json:"time,omitzero"`
+	Violations []cardinalityViolation `json:"violations"`
+}
+
+func newCardinalityCheck(limit int) *cardinalityCheck {
+	return &cardinalityCheck{limit: limit}
+}
+
+// runCardinalityCheck checks the number of series of the Istio metrics
+// immediately and then in the "cardinalityCheckInterval", until the given
+// context is canceled.
+func (d *Datasource) runCardinalityCheck(ctx context.Context, prometheusClient prometheus.Client) {
+	ticker := time.NewTicker(cardinalityCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		d.checkCardinality(ctx, prometheusClient, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkCardinality counts the series of each Istio metric per destination
+// namespace and stores all metrics and namespaces, which exceed the configured
+// limit. Only the violations are returned by Prometheus, so that the result
+// stays small even for large meshes. If the check fails, the previous
+// violations are kept.
+func (d *Datasource) checkCardinality(ctx context.Context, prometheusClient prometheus.Client, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, cardinalityCheckTimeout)
+	defer cancel()
+
+	query := prometheus.Binary{
+		LHS: prometheus.Query{
+			Aggregation: "count",
+			Selector:    prometheus.Selector{Matchers: prometheus.Matchers{prometheus.Regexp("__name__", "istio_.*"), prometheus.NotEqual("destination_workload_namespace", "")}},
+			GroupBy:     []string{"__name__", "destination_workload_namespace"},
+		},
+		Operator: ">",
+		RHS:      prometheus.Number(d.cardinalityCheck.limit),
+	}
+	metrics, err := prometheusClient.GetMetrics(ctx, "cardinality", query, backend.TimeRange{From: now, To: now})
+
+	d.cardinalityCheck.mu.Lock()
+	defer d.cardinalityCheck.mu.Unlock()
+
+	d.cardinalityCheck.err = err
+	d.cardinalityCheck.time = now
+
+	if err != nil {
+		d.logger.Warn("Failed to check cardinality of Istio metrics", "error", err.Error())
+		return
+	}
+
+	var violations []cardinalityViolation
+	for _, metric := range metrics {
+		violations = append(violations, cardinalityViolation{
+			Metric:    metric.Labels["__name__"],
+			Namespace: metric.Labels["destination_workload_namespace"],
+			Series:    int(metric.Value),
+		})
+	}
+	slices.SortFunc(violations, func(a, b cardinalityViolation) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Metric, b.Metric))
+	})
+
+	d.cardinalityCheck.violations = violations
+
+	if len(violations) > 0 {
+		d.logger.Warn("Istio metrics exceed the cardinality limit", "limit", d.cardinalityCheck.limit, "violations", violations)
+	}
+}
+
+// getCardinalityCheckDetails returns the result of the last cardinality check.
+// If the check is disabled, nil is returned. The check is shared by all
+// organizations, so that the violations in namespaces, which are not allowed
+// for the organization of the datasource, are dropped.
+func (d *Datasource) getCardinalityCheckDetails() *cardinalityCheckDetails {
+	if d.cardinalityCheck == nil {
+		return nil
+	}
+
+	d.cardinalityCheck.mu.RLock()
+	defer d.cardinalityCheck.mu.RUnlock()
+
+	details := &cardinalityCheckDetails{
+		Limit:      d.cardinalityCheck.limit,
+		Time:       d.cardinalityCheck.time,
+		Violations: []cardinalityViolation{},
+	}
+	for _, violation := range d.cardinalityCheck.violations {
+		if d.isNamespaceAllowed(violation.Namespace) {
+			details.Violations = append(details.Violations, violation)
+		}
+	}
+	if d.cardinalityCheck.err != nil {
+		details.Error = d.cardinalityCheck.err.Error()
+	}
+
+	return details
+}
+
+// cardinalityNotice returns a warning for the graphs of the given namespace,
+// when one of the Istio metrics exceeded the cardinality limit in the
+// namespace during the last check. If the limit wasn't exceeded, false is
+// returned.
+func (d *Datasource) cardinalityNotice(namespace string) (data.Notice, bool) {
+	if d.cardinalityCheck == nil || namespace == "" {
+		return data.Notice{}, false
+	}
+
+	d.cardinalityCheck.mu.RLock()
+	defer d.cardinalityCheck.mu.RUnlock()
+
+	var metrics []string
+	for _, violation := range d.cardinalityCheck.violations {
+		if violation.Namespace == namespace {
+			metrics = append(metrics, fmt.Sprintf("%s (%d series)", violation.Metric, violation.Series))
+		}
+	}
+	if len(metrics) == 0 {
+		return data.Notice{}, false
+	}
+
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("High cardinality: the following Istio metrics exceed the limit of %d series in the namespace %s: %s", d.cardinalityCheck.limit, namespace, strings.Join(metrics, ", ")),
+	}, true
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+)
+
+// The status of an edge, when the graph is compared with the graph of another
+// time range.
+const (
+	compareStatusAdded     = "added"
+	compareStatusRemoved   = "removed"
+	compareStatusChanged   = "changed"
+	compareStatusUnchanged = "unchanged"
+)
+
+// compareTrafficThreshold is the relative change of the traffic of an edge and
+// compareErrorThreshold is the change of the error rate in percentage points,
+// above which an edge is marked as changed.
+const (
+	compareTrafficThreshold = 0.2
+	compareErrorThreshold   = 1
+)
+
+// compareGraphs compares the edges of a graph with the edges of the baseline
+// graph of another time range and returns the status of the edges by their id.
+// Edges which only exist in the baseline are added to the graph without any
+// traffic, so that they can be shown as removed edges. The traffic is compared
+// per second, so that time ranges with different durations can be compared.
+func compareGraphs(edges map[string]models.Edge, nodes map[string]models.Node, baselineEdges map[string]models.Edge, baselineNodes map[string]models.Node, interval, baselineInterval float64) map[string]string {
+	status := make(map[string]string, len(edges))
+
+	for id, edge := range edges {
+		baseline, ok := baselineEdges[id]
+		if !ok {
+			status[id] = compareStatusAdded
+			continue
+		}
+
+		trafficChange, errorChange := compareEdges(edge, baseline, interval, baselineInterval)
+		if math.Abs(trafficChange) > compareTrafficThreshold || math.Abs(errorChange) > compareErrorThreshold {
+			status[id] = fmt.Sprintf("%s (traffic %+.1f%%, errors %+.1f%%)", compareStatusChanged, trafficChange*100, errorChange)
+		} else {
+			status[id] = compareStatusUnchanged
+		}
+	}
+
+	for id, baseline := range baselineEdges {
+		if _, ok := edges[id]; ok {
+			continue
+		}
+
+		edges[id] = models.Edge{
+			ID:                   baseline.ID,
+			Source:               baseline.Source,
+			SourceType:           baseline.SourceType,
+			SourceName:           baseline.SourceName,
+			SourceNamespace:      baseline.SourceNamespace,
+			SourceCluster:        baseline.SourceCluster,
+			Destination:          baseline.Destination,
+			DestinationType:      baseline.DestinationType,
+			DestinationName:      baseline.DestinationName,
+			DestinationNamespace: baseline.DestinationNamespace,
+			DestinationCluster:   baseline.DestinationCluster,
+			DestinationService:   baseline.DestinationService,
+			Mirror:               baseline.Mirror,
+		}
+		status[id] = compareStatusRemoved
+
+		for _, nodeID := range []string{baseline.Source, baseline.Destination} {
+			if _, ok := nodes[nodeID]; ok {
+				continue
+			}
+			if node, ok := baselineNodes[nodeID]; ok {
+				nodes[nodeID] = models.Node{
+					ID:        node.ID,
+					Type:      node.Type,
+					Name:      node.Name,
+					Namespace: node.Namespace,
+					Cluster:   node.Cluster,
+					Service:   node.Service,
+				}
+			}
+		}
+	}
+
+	return status
+}
+
+// compareEdges returns the relative change of the traffic and the change of
+// the error rate in percentage points between an edge and its baseline. The
+// traffic is the number of HTTP and gRPC requests or the number of TCP bytes
+// for edges without requests.
+func compareEdges(edge, baseline models.Edge, interval, baselineInterval float64) (float64, float64) {
+	requests := edge.HTTPRequestsSuccess + edge.HTTPRequestsError + edge.GRPCRequestsSuccess + edge.GRPCRequestsError
+	baselineRequests := baseline.HTTPRequestsSuccess + baseline.HTTPRequestsError + baseline.GRPCRequestsSuccess + baseline.GRPCRequestsError
+
+	traffic, baselineTraffic := requests, baselineRequests
+	if requests == 0 && baselineRequests == 0 {
+		traffic = edge.TCPSentBytes + edge.TCPReceivedBytes
+		baselineTraffic = baseline.TCPSentBytes + baseline.TCPReceivedBytes
+	}
+
+	var trafficChange float64
+	if rate, baselineRate := traffic/interval, baselineTraffic/baselineInterval; baselineRate > 0 {
+		trafficChange = (rate - baselineRate) / baselineRate
+	} else if rate > 0 {
+		trafficChange = 1
+	}
+
+	var errorRate, baselineErrorRate float64
+	if requests > 0 {
+		errorRate = (edge.HTTPRequestsError + edge.GRPCRequestsError) / requests * 100
+	}
+	if baselineRequests > 0 {
+		baselineErrorRate = (baseline.HTTPRequestsError + baseline.GRPCRequestsError) / baselineRequests * 100
+	}
+
+	return trafficChange, errorRate - baselineErrorRate
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareGraphs(t *testing.T) {
+	edges := map[string]models.Edge{
+		"a": {ID: "a", Source: "workload/bookinfo/productpage-v1", Destination: "service/bookinfo/reviews", HTTPRequestsSuccess: 100},
+		"b": {ID: "b", Source: "workload/bookinfo/productpage-v1", Destination: "service/bookinfo/details", HTTPRequestsSuccess: 200},
+		"c": {ID: "c", Source: "workload/bookinfo/reviews-v1", Destination: "service/bookinfo/ratings", HTTPRequestsSuccess: 90, HTTPRequestsError: 10},
+		"d": {ID: "d", Source: "workload/bookinfo/reviews-v1", Destination: "workload/data/mysql-v1", TCPSentBytes: 1000},
+	}
+	nodes := map[string]models.Node{
+		"workload/bookinfo/productpage-v1": {ID: "workload/bookinfo/productpage-v1", Type: "Workload", Name: "productpage-v1", Namespace: "bookinfo"},
+	}
+	baselineEdges := map[string]models.Edge{
+		// The baseline time range is twice as long, so that the traffic per
+		// second of edge "a" is the same.
+		"a": {ID: "a", Source: "workload/bookinfo/productpage-v1", Destination: "service/bookinfo/reviews", HTTPRequestsSuccess: 200},
+		"c": {ID: "c", Source: "workload/bookinfo/reviews-v1", Destination: "service/bookinfo/ratings", HTTPRequestsSuccess: 200},
+		"d": {ID: "d", Source: "workload/bookinfo/reviews-v1", Destination: "workload/data/mysql-v1", TCPSentBytes: 1000},
+		"e": {ID: "e", Source: "workload/bookinfo/productpage-v1", Destination: "service/bookinfo/reviews-legacy", DestinationService: "reviews-legacy.bookinfo.svc.cluster.local", HTTPRequestsSuccess: 50},
+	}
+	baselineNodes := map[string]models.Node{
+		"workload/bookinfo/productpage-v1": {ID: "workload/bookinfo/productpage-v1", Type: "Workload", Name: "productpage-v1", Namespace: "bookinfo"},
+		"service/bookinfo/reviews-legacy":  {ID: "service/bookinfo/reviews-legacy", Type: "Service", Name: "reviews-legacy", Namespace: "bookinfo", ServerHTTPRequestsSuccess: 50},
+	}
+
+	status := compareGraphs(edges, nodes, baselineEdges, baselineNodes, 60, 120)
+
+	require.Equal(t, map[string]string{
+		"a": compareStatusUnchanged,
+		"b": compareStatusAdded,
+		"c": "changed (traffic +0.0%, errors +10.0%)",
+		"d": "changed (traffic +100.0%, errors +0.0%)",
+		"e": compareStatusRemoved,
+	}, status)
+
+	// Removed edges and their nodes are added to the graph without traffic.
+	require.Equal(t, models.Edge{ID: "e", Source: "workload/bookinfo/productpage-v1", Destination: "service/bookinfo/reviews-legacy", DestinationService: "reviews-legacy.bookinfo.svc.cluster.local"}, edges["e"])
+	require.Equal(t, models.Node{ID: "service/bookinfo/reviews-legacy", Type: "Service", Name: "reviews-legacy", Namespace: "bookinfo"}, nodes["service/bookinfo/reviews-legacy"])
+}
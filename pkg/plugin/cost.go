@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// maxCostBodySize is the maximum size of the query model, which can be sent
+	// to the "graph/cost" resource.
+	maxCostBodySize = 1 << 16
+	// expensiveGraphSeries is the number of series, above which a graph is
+	// considered as expensive.
+	expensiveGraphSeries = 50000
+	// expensiveGraphSamples is the number of samples, above which a graph is
+	// considered as expensive. This is the default of the "--query.max-samples"
+	// flag of Prometheus, so that a single query of the graph could fail.
+	expensiveGraphSamples = 50000000
+	// costProbeWindow is the window of the samples probe. The samples of the
+	// whole time range are extrapolated from the samples of this window, so
+	// that the probe doesn't read as many samples as the graph itself.
+	costProbeWindow = 5 * time.Minute
+)
+
+// graphCostQuery contains the fields of the query models of the graphs, which
+// are used to estimate the cost of a graph. It embeds the same options as the
+// query models, so that the query editor can send its query as is.
+type graphCostQuery struct {
+	models.GraphQueryOptions
+	Namespace   string `json:"namespace"`
+	Application string `json:"application"`
+	Workload    string `json:"workload"`
+}
+
+// graphCost is the response of the "graph/cost" resource.
+type graphCost struct {
+	Namespace string            `json:"namespace"`
+	Series    int               `json:"series"`
+	Samples   int               `json:"samples"`
+	Metrics   []graphMetricCost `json:"metrics"`
+	Warning   string            `json:"warning,omitempty"`
+}
+
+// graphMetricCost is the estimated cost of the queries for a single metric of
+// a graph.
+type graphMetricCost struct {
+	Metric  string `json:"metric"`
+	Series  int    `json:"series"`
+	Samples int    `json:"samples"`
+}
+
+// handleGraphCostResource estimates the cost of the graph for the query model
+// in the body of the request, before the graph is generated. The time range is
+// selected via the "from" and "to" query parameters in milliseconds, like for
+// the other graph resources, where the "evaluationTime" and "window" of the
+// query model are applied. The query editor can use the response to warn users
+// about expensive selections.
+func (d *Datasource) handleGraphCostResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d = d.forOrg(backend.PluginConfigFromContext(r.Context()).OrgID)
+
+	var query graphCostQuery
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCostBodySize)).Decode(&query); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse query: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if query.Namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+	if !d.isNamespaceAllowed(query.Namespace) {
+		http.Error(w, fmt.Sprintf("namespace %q is not allowed for this organization", query.Namespace), http.StatusForbidden)
+		return
+	}
+	for _, metric := range query.Metrics {
+		if _, ok := d.graphQueryTemplate(metric); !ok {
+			http.Error(w, fmt.Sprintf("invalid metric %q", metric), http.StatusBadRequest)
+			return
+		}
+	}
+
+	options := newGraphOptions(query.GraphQueryOptions)
+	options.namespace = query.Namespace
+	options.application = query.Application
+	options.workload = query.Workload
+	if err := validateGraphOptions(options); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeRange, err := resourceTimeRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), time.Now())
+	if err == nil {
+		timeRange, err = graphTimeRange(timeRange, query.EvaluationTime, query.Window)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cost, err := d.estimateGraphCost(r.Context(), options, timeRange)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to estimate cost: %s", err.Error()), http.StatusBadGateway)
+		return
+	}
+
+	d.writeJSON(w, http.StatusOK, cost)
+}
+
+// estimateGraphCost returns the number of series and samples, which are read by
+// the queries of the first hop of the graph with the given options. For each
+// metric the series are counted via a "count" probe and the samples via a
+// "count_over_time" probe over the last five minutes of the time range, which
+// are extrapolated to the whole time range. The probes use the same selectors
+// as the destination and source queries of the graph. The selectors are
+// combined via "or", so that series which match both selectors are only counted
+// once. Additional hops can only be estimated after the first hop was queried,
+// so that the estimation is a lower bound for graphs with a depth.
+func (d *Datasource) estimateGraphCost(ctx context.Context, options graphOptions, timeRange backend.TimeRange) (graphCost, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "estimateGraphCost")
+	defer span.End()
+
+	var workloads []string
+	if options.workload != "" {
+		workloads = []string{options.workload}
+	}
+	window := min(costProbeWindow, timeRange.Duration())
+	if window < time.Second {
+		window = time.Second
+	}
+	scale := timeRange.Duration().Seconds() / window.Seconds()
+
+	// The series and samples probes of all metrics are sent as a single
+	// batch, where the probes of a metric are next to each other.
+	batch := make([]prometheus.MetricsQuery, 0, 2*len(options.metrics))
+	for _, metric := range options.metrics {
+		template, _ := d.graphQueryTemplate(metric)
+		destinations := template.selector(d.namespaceMatchers(options.namespace, "destination_workload_namespace"), append(graphFocusMatchers("destination", options.application, workloads), d.graphFilterMatchers(options)...))
+		sources := template.selector(d.namespaceMatchers(options.namespace, "source_workload_namespace"), append(graphFocusMatchers("source", options.application, workloads), d.graphFilterMatchers(options)...))
+
+		batch = append(batch, prometheus.MetricsQuery{
+			Metric:    metric,
+			Query:     prometheus.Aggregate{Operator: "count", Expr: prometheus.Or(destinations, sources)},
+			TimeRange: timeRange,
+		}, prometheus.MetricsQuery{
+			Metric: metric,
+			Query: prometheus.Aggregate{Operator: "sum", Expr: prometheus.Or(
+				prometheus.Query{Function: "count_over_time", Selector: destinations, Window: window},
+				prometheus.Query{Function: "count_over_time", Selector: sources, Window: window},
+			)},
+			TimeRange: timeRange,
+		})
+	}
+
+	d.logger.Debug("Get cost probes", "queries", len(batch))
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get cost probes", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return graphCost{}, err
+	}
+
+	costs := make([]graphMetricCost, len(options.metrics))
+	for i, metric := range options.metrics {
+		costs[i] = graphMetricCost{Metric: metric, Series: costProbeValue(results[2*i]), Samples: int(float64(costProbeValue(results[2*i+1])) * scale)}
+	}
+
+	cost := graphCost{Namespace: options.namespace, Metrics: costs}
+	for _, metricCost := range costs {
+		cost.Series += metricCost.Series
+		cost.Samples += metricCost.Samples
+	}
+	if cost.Series > expensiveGraphSeries || cost.Samples > expensiveGraphSamples {
+		cost.Warning = fmt.Sprintf("The graph reads %d series with %d samples, consider selecting an application or workload, fewer metrics or a shorter time range", cost.Series, cost.Samples)
+	}
+
+	return cost, nil
+}
+
+// costProbeValue returns the value of a probe. If the probe doesn't return a
+// value, because no series match the selectors, 0 is returned.
+func costProbeValue(metrics []prometheus.Metric) int {
+	if len(metrics) == 0 {
+		return 0
+	}
+	return int(metrics[0].Value)
+}
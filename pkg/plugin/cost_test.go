@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleGraphCostResource(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`^count\(istio_requests_total\{destination_workload_namespace="bookinfo", request_protocol="http", destination_app="reviews"\} or istio_requests_total\{source_workload_namespace="bookinfo", request_protocol="http", source_app="reviews"\}\)$`,
+			prometheus.Metric{Value: 40000},
+		).
+		AddMetrics(`^sum\(count_over_time\(istio_requests_total\{destination_workload_namespace="bookinfo".*\}\[300s\]\) or count_over_time\(istio_requests_total\{source_workload_namespace="bookinfo".*\}\[300s\]\)\)$`,
+			prometheus.Metric{Value: 800000},
+		).
+		AddMetrics(`^count\(istio_tcp_sent_bytes_total`,
+			prometheus.Metric{Value: 20000},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	request := func(method, body string) *httptest.ResponseRecorder {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 1})
+		w := httptest.NewRecorder()
+		d.handleGraphCostResource(w, httptest.NewRequestWithContext(ctx, method, "/graph/cost?from=0&to=3600000", strings.NewReader(body)))
+		return w
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "").Code)
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, `{"application": "reviews"}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, `{"namespace": "bookinfo", "metrics": ["unknown"]}`).Code)
+
+	t.Run("should return cost of graph", func(t *testing.T) {
+		w := request(http.MethodPost, `{"namespace": "bookinfo", "application": "reviews", "metrics": ["httpRequests"]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var cost graphCost
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&cost))
+		require.Equal(t, graphCost{
+			Namespace: "bookinfo",
+			Series:    40000,
+			Samples:   9600000,
+			Metrics:   []graphMetricCost{{Metric: "httpRequests", Series: 40000, Samples: 9600000}},
+		}, cost)
+	})
+
+	t.Run("should return warning for expensive graph", func(t *testing.T) {
+		w := request(http.MethodPost, `{"namespace": "bookinfo", "application": "reviews", "metrics": ["httpRequests", "tcpSentBytes"]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var cost graphCost
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&cost))
+		require.Equal(t, 60000, cost.Series)
+		require.Equal(t, []graphMetricCost{{Metric: "httpRequests", Series: 40000, Samples: 9600000}, {Metric: "tcpSentBytes", Series: 20000}}, cost.Metrics)
+		require.NotEmpty(t, cost.Warning)
+	})
+
+	t.Run("should probe the samples of short time ranges completely", func(t *testing.T) {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 1})
+		w := httptest.NewRecorder()
+		d.handleGraphCostResource(w, httptest.NewRequestWithContext(ctx, http.MethodPost, "/graph/cost?from=0&to=60000", strings.NewReader(`{"namespace": "bookinfo", "application": "reviews", "metrics": ["httpRequests"]}`)))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, client.Queries(), `sum(count_over_time(istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http", destination_app="reviews"}[60s]) or count_over_time(istio_requests_total{source_workload_namespace="bookinfo", request_protocol="http", source_app="reviews"}[60s]))`)
+	})
+}
@@ -0,0 +1,308 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// dashboardGraphMetrics are the metrics of the graphs in a generated
+// dashboard. These are the same metrics, which are selected by default in the
+// query editor.
+var dashboardGraphMetrics = []string{models.MetricGRPCRequests, models.MetricHTTPRequests, models.MetricTCPSentBytes, models.MetricTCPReceivedBytes}
+
+// dashboard is the model of a Grafana dashboard, which can be imported via the
+// Grafana UI or the "/api/dashboards/db" API. Only the fields required for the
+// generated dashboards are part of the model.
+type dashboard struct {
+	Title         string           `json:"title"`
+	Tags          []string         `json:"tags"`
+	Editable      bool             `json:"editable"`
+	Refresh       string           `json:"refresh"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Time          dashboardTime    `json:"time"`
+	Annotations   dashboardList    `json:"annotations"`
+	Templating    dashboardList    `json:"templating"`
+	Panels        []dashboardPanel `json:"panels"`
+}
+
+type dashboardTime struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type dashboardList struct {
+	List []map[string]any `json:"list"`
+}
+
+type dashboardDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type dashboardGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type dashboardPanel struct {
+	ID          int                 `json:"id"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	GridPos     dashboardGridPos    `json:"gridPos"`
+	Datasource  dashboardDatasource `json:"datasource"`
+	Targets     []map[string]any    `json:"targets"`
+}
+
+// handleDashboardResource returns a dashboard for the namespace of the
+// "namespace" query parameter, which can be imported into Grafana, so that a
+// new team can be onboarded with a single API call. The title of the
+// dashboard can be set via the optional "title" query parameter.
+func (d *Datasource) handleDashboardResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pluginContext := backend.PluginConfigFromContext(r.Context())
+	d = d.forOrg(pluginContext.OrgID)
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+	if !d.isNamespaceAllowed(namespace) {
+		http.Error(w, fmt.Sprintf("namespace %q is not allowed for this organization", namespace), http.StatusForbidden)
+		return
+	}
+
+	datasource := dashboardDatasource{Type: models.PluginID}
+	if pluginContext.DataSourceInstanceSettings != nil {
+		datasource.UID = pluginContext.DataSourceInstanceSettings.UID
+	}
+
+	d.writeJSON(w, http.StatusOK, generateDashboard(datasource, namespace, cmp.Or(r.URL.Query().Get("title"), fmt.Sprintf("Istio / %s", namespace))))
+}
+
+// generateDashboard returns a dashboard for the given namespace, which uses the
+// given datasource for all panels. The dashboard contains the graph of the
+// namespace, the health, mTLS coverage, error budget and latency SLO panels
+// of the namespace and drill-down panels for the workload selected via the
+// "workload" variable. The rollouts of the applications in the namespace are
+// shown as annotations.
+func generateDashboard(datasource dashboardDatasource, namespace, title string) dashboard {
+	panel := func(id int, panelType, title, description string, gridPos dashboardGridPos, target map[string]any) dashboardPanel {
+		target["refId"] = "A"
+		return dashboardPanel{
+			ID:          id,
+			Type:        panelType,
+			Title:       title,
+			Description: description,
+			GridPos:     gridPos,
+			Datasource:  datasource,
+			Targets:     []map[string]any{target},
+		}
+	}
+
+	return dashboard{
+		Title:         title,
+		Tags:          []string{"istio", namespace},
+		Editable:      true,
+		Refresh:       "1m",
+		SchemaVersion: 39,
+		Time:          dashboardTime{From: "now-1h", To: "now"},
+		Annotations: dashboardList{List: []map[string]any{
+			{
+				"name":       "Rollouts",
+				"datasource": datasource,
+				"enable":     true,
+				"iconColor":  "blue",
+				"target":     map[string]any{"queryType": models.QueryTypeRollouts, "namespace": namespace, "refId": "Anno"},
+			},
+		}},
+		Templating: dashboardList{List: []map[string]any{
+			{
+				"name":       "workload",
+				"label":      "Workload",
+				"type":       "query",
+				"datasource": datasource,
+				"query":      map[string]any{"queryType": models.QueryTypeWorkloads, "namespace": namespace, "refId": "workload"},
+				"refresh":    2,
+				"sort":       1,
+			},
+		}},
+		Panels: []dashboardPanel{
+			panel(1, "nodeGraph", "Graph", fmt.Sprintf("The traffic of all workloads and services in the namespace %s.", namespace), dashboardGridPos{H: 14, W: 24, X: 0, Y: 0}, map[string]any{
+				"queryType":    models.QueryTypeNamespaceGraph,
+				"namespace":    namespace,
+				"metrics":      dashboardGraphMetrics,
+				"detectIssues": true,
+			}),
+			panel(2, "gauge", "Health Score", "", dashboardGridPos{H: 8, W: 6, X: 0, Y: 14}, map[string]any{
+				"queryType": models.QueryTypeHealthScore,
+				"namespace": namespace,
+			}),
+			panel(3, "table", "Namespace Health", "", dashboardGridPos{H: 8, W: 10, X: 6, Y: 14}, map[string]any{
+				"queryType": models.QueryTypeNamespaceHealth,
+				"namespace": namespace,
+			}),
+			panel(4, "timeseries", "mTLS Coverage", "", dashboardGridPos{H: 8, W: 8, X: 16, Y: 14}, map[string]any{
+				"queryType": models.QueryTypeMTLSCoverage,
+				"namespace": namespace,
+			}),
+			panel(5, "table", "Error Budget", "The remaining error budget of the services for an SLO target of 99.9% over 30 days.", dashboardGridPos{H: 8, W: 12, X: 0, Y: 22}, map[string]any{
+				"queryType": models.QueryTypeErrorBudget,
+				"namespace": namespace,
+				"target":    99.9,
+				"window":    "30d",
+			}),
+			panel(6, "timeseries", "Latency SLO", "The share of requests of the services, which were faster than 500ms.", dashboardGridPos{H: 8, W: 12, X: 12, Y: 22}, map[string]any{
+				"queryType":        models.QueryTypeLatencySLO,
+				"namespace":        namespace,
+				"latencyThreshold": 500,
+			}),
+			panel(7, "nodeGraph", "Workload Graph ($workload)", "", dashboardGridPos{H: 12, W: 12, X: 0, Y: 30}, map[string]any{
+				"queryType": models.QueryTypeWorkloadGraph,
+				"namespace": namespace,
+				"workload":  "$workload",
+				"metrics":   dashboardGraphMetrics,
+			}),
+			panel(8, "table", "Workload Traffic ($workload)", "", dashboardGridPos{H: 12, W: 12, X: 12, Y: 30}, map[string]any{
+				"queryType": models.QueryTypeWorkloadTraffic,
+				"namespace": namespace,
+				"workload":  "$workload",
+			}),
+		},
+	}
+}
+
+// bundledDashboardDatasource is the placeholder for the uid of the datasource
+// in the bundled dashboards. It is the datasource input of the dashboards, so
+// that Grafana also replaces it when the dashboards are imported via the UI.
+const bundledDashboardDatasource = "${DS_ISTIO}"
+
+// bundledDashboard is an entry in the list of the bundled dashboards, which is
+// returned by the "dashboards" resource.
+type bundledDashboard struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+// bundledDashboardsFS returns the directory with the dashboards, which are
+// shipped with the plugin. The dashboards are copied into the "dashboards"
+// directory next to the executable of the plugin during the build.
+func bundledDashboardsFS() fs.FS {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+	return os.DirFS(filepath.Join(filepath.Dir(executable), "dashboards"))
+}
+
+// handleBundledDashboardsResource returns the dashboards, which are shipped
+// with the plugin. If the "name" query parameter is set, the dashboard with
+// this name is returned, where the datasource placeholders are replaced with
+// the uid of the datasource of the request, so that the dashboard can be used
+// for provisioning without any changes. Otherwise the names and titles of all
+// bundled dashboards are returned.
+func (d *Datasource) handleBundledDashboardsResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if d.bundledDashboards == nil {
+		http.Error(w, "bundled dashboards are not available", http.StatusNotFound)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		dashboards, err := listBundledDashboards(d.bundledDashboards)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to list dashboards: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		d.writeJSON(w, http.StatusOK, map[string][]bundledDashboard{"dashboards": dashboards})
+		return
+	}
+
+	var uid string
+	if settings := backend.PluginConfigFromContext(r.Context()).DataSourceInstanceSettings; settings != nil {
+		uid = settings.UID
+	}
+
+	dashboard, err := getBundledDashboard(d.bundledDashboards, name, uid)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, fmt.Sprintf("dashboard %q not found", name), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to read dashboard: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	d.writeJSON(w, http.StatusOK, dashboard)
+}
+
+// listBundledDashboards returns the names and titles of all dashboards in the
+// given directory. The name of a dashboard is the name of its file without the
+// ".json" extension.
+func listBundledDashboards(fsys fs.FS) ([]bundledDashboard, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	dashboards := []bundledDashboard{}
+	for _, file := range files {
+		raw, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		var dashboard bundledDashboard
+		if err := json.Unmarshal(raw, &dashboard); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		dashboards = append(dashboards, bundledDashboard{Name: strings.TrimSuffix(file, ".json"), Title: dashboard.Title})
+	}
+
+	return dashboards, nil
+}
+
+// getBundledDashboard returns the dashboard with the given name from the given
+// directory, where the datasource placeholders are replaced with the given uid.
+// The datasource inputs are removed, because they are not needed anymore when
+// the placeholders are replaced.
+func getBundledDashboard(fsys fs.FS, name, uid string) (map[string]any, error) {
+	if !fs.ValidPath(name) || strings.Contains(name, "/") {
+		return nil, fs.ErrNotExist
+	}
+
+	raw, err := fs.ReadFile(fsys, name+".json")
+	if err != nil {
+		return nil, err
+	}
+
+	var dashboard map[string]any
+	if err := json.Unmarshal(bytes.ReplaceAll(raw, []byte(bundledDashboardDatasource), []byte(uid)), &dashboard); err != nil {
+		return nil, err
+	}
+	delete(dashboard, "__inputs")
+
+	return dashboard, nil
+}
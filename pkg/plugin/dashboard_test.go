@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleDashboardResource(t *testing.T) {
+	d := &Datasource{logger: log.DefaultLogger, orgs: map[int64]*Datasource{2: {istioNamespaces: []string{"shop"}, logger: log.DefaultLogger}}}
+
+	request := func(orgID int64, method, target string) *httptest.ResponseRecorder {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID, DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "istio"}})
+		w := httptest.NewRecorder()
+		d.handleDashboardResource(w, httptest.NewRequestWithContext(ctx, method, target, nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, request(1, http.MethodPost, "/dashboard?namespace=bookinfo").Code)
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodGet, "/dashboard").Code)
+	require.Equal(t, http.StatusForbidden, request(2, http.MethodGet, "/dashboard?namespace=bookinfo").Code)
+
+	w := request(1, http.MethodGet, "/dashboard?namespace=bookinfo")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result struct {
+		Title      string `json:"title"`
+		Templating struct {
+			List []struct {
+				Name  string         `json:"name"`
+				Query map[string]any `json:"query"`
+			} `json:"list"`
+		} `json:"templating"`
+		Panels []struct {
+			Type       string              `json:"type"`
+			Datasource dashboardDatasource `json:"datasource"`
+			Targets    []map[string]any    `json:"targets"`
+		} `json:"panels"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	require.Equal(t, "Istio / bookinfo", result.Title)
+	require.Len(t, result.Templating.List, 1)
+	require.Equal(t, "workload", result.Templating.List[0].Name)
+	require.Equal(t, map[string]any{"queryType": "workloads", "namespace": "bookinfo", "refId": "workload"}, result.Templating.List[0].Query)
+
+	var queryTypes []string
+	for _, panel := range result.Panels {
+		require.Equal(t, dashboardDatasource{Type: "ricoberger-istio-datasource", UID: "istio"}, panel.Datasource)
+		require.Len(t, panel.Targets, 1)
+		require.Equal(t, "bookinfo", panel.Targets[0]["namespace"])
+		queryTypes = append(queryTypes, panel.Targets[0]["queryType"].(string))
+	}
+	require.Equal(t, []string{"namespacegraph", "healthscore", "namespacehealth", "mtlscoverage", "errorbudget", "latencyslo", "workloadgraph", "workloadtraffic"}, queryTypes)
+
+	w = request(1, http.MethodGet, "/dashboard?namespace=bookinfo&title=Team+A")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	require.Equal(t, "Team A", result.Title)
+}
+
+func TestHandleBundledDashboardsResource(t *testing.T) {
+	d := &Datasource{logger: log.DefaultLogger, bundledDashboards: os.DirFS("../../src/dashboards")}
+
+	request := func(method, target string) *httptest.ResponseRecorder {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 1, DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "istio-prod"}})
+		w := httptest.NewRecorder()
+		d.handleBundledDashboardsResource(w, httptest.NewRequestWithContext(ctx, method, target, nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPost, "/dashboards").Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/dashboards?name=unknown").Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/dashboards?name=../plugin").Code)
+
+	w := request(http.MethodGet, "/dashboards")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"dashboards":[{"name":"istio-overview","title":"Istio Overview"}]}`, w.Body.String())
+
+	w = request(http.MethodGet, "/dashboards?name=istio-overview")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), bundledDashboardDatasource)
+	require.NotContains(t, w.Body.String(), "__inputs")
+
+	var result struct {
+		Panels []struct {
+			Datasource dashboardDatasource `json:"datasource"`
+		} `json:"panels"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	require.NotEmpty(t, result.Panels)
+	for _, panel := range result.Panels {
+		if panel.Datasource.Type != "" {
+			require.Equal(t, dashboardDatasource{Type: "ricoberger-istio-datasource", UID: "istio-prod"}, panel.Datasource)
+		}
+	}
+}
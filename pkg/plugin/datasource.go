@@ -2,15 +2,30 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ricoberger/grafana-istio-plugin/pkg/alertmanager"
 	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
 	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/roundtripper"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/prometheus/common/model"
+	"golang.org/x/sync/singleflight"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -22,6 +37,7 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
+	_ backend.CallResourceHandler   = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
@@ -42,23 +58,237 @@ func NewDatasource(_ context.Context, pCtx backend.DataSourceInstanceSettings) (
 		return nil, err
 	}
 
+	alertmanagerClient, err := alertmanager.NewClient(settings)
+	if err != nil {
+		logger.Error("Failed to create Alertmanager client", "error", err.Error())
+		return nil, err
+	}
+
+	ds, err := newDatasource(settings, models.OrgOverrides{}, prometheusClient, logger)
+	if err != nil {
+		return nil, err
+	}
+	ds.alertmanagerClient = alertmanagerClient
+	ds.bundledDashboards = bundledDashboardsFS()
+
+	// For each organization with overrides we create a separate datasource,
+	// which shares the Prometheus client with the default datasource. The
+	// datasource for the organization of a request is selected in the
+	// "QueryData" function.
+	ds.orgs = make(map[int64]*Datasource, len(settings.Overrides))
+	for orgID, overrides := range settings.Overrides {
+		orgDs, err := newDatasource(settings, overrides, prometheusClient, logger.With("orgId", orgID))
+		if err != nil {
+			return nil, err
+		}
+		orgDs.alertmanagerClient = alertmanagerClient
+		ds.orgs[orgID] = orgDs
+	}
+
+	// The available Istio metrics are detected in the background, the cached
+	// lists are refreshed in the background and the cardinality of the Istio
+	// metrics is checked in the background. All of them are shared with the
+	// datasources of all organizations and stopped when the datasource is
+	// disposed. The expected topologies, filter presets and graph jobs are
+	// also shared, because they are already stored per organization. The
+	// goroutines of the graph jobs are added to the same background tasks, so
+	// that the datasource waits for the jobs of all organizations, when it is
+	// disposed.
+	//
+	// The expected topologies and filter presets are keyed by the uid of the
+	// datasource, so that they are not mixed up with the data of other
+	// datasources using the same storage directory.
+	var backgroundCtx context.Context
+	backgroundCtx, ds.stopBackground = context.WithCancel(context.Background())
+	ds.expectedTopologies = newOrgStore[expectedTopology](settings.StorageDirectory, pCtx.UID, "topologies")
+	ds.filterPresets = newOrgStore[filterPreset](settings.StorageDirectory, pCtx.UID, "presets")
+	for _, orgDs := range ds.orgs {
+		orgDs.expectedTopologies = ds.expectedTopologies
+		orgDs.filterPresets = ds.filterPresets
+		orgDs.graphJobs = ds.graphJobs
+		orgDs.background = ds.background
+		orgDs.metricDetection = ds.metricDetection
+		orgDs.listCache = ds.listCache
+		orgDs.cardinalityCheck = ds.cardinalityCheck
+	}
+	ds.background.Add(1)
+	go func() {
+		defer ds.background.Done()
+		ds.runMetricDetection(backgroundCtx, prometheusClient)
+	}()
+	if ds.listCache != nil {
+		ds.background.Add(1)
+		go func() {
+			defer ds.background.Done()
+			ds.runListRefresh(backgroundCtx, prometheusClient)
+		}()
+	}
+	if ds.cardinalityCheck != nil {
+		ds.background.Add(1)
+		go func() {
+			defer ds.background.Done()
+			ds.runCardinalityCheck(backgroundCtx, prometheusClient)
+		}()
+	}
+
+	resourceMux := http.NewServeMux()
+	resourceMux.HandleFunc("/healthz", ds.handleHealthzResource)
+	resourceMux.HandleFunc("/validate", ds.handleValidateResource)
+	resourceMux.HandleFunc("/graph/kiali", ds.handleKialiGraphResource)
+	resourceMux.HandleFunc("/topology", ds.handleTopologyResource)
+	resourceMux.HandleFunc("/graph/jobs", ds.handleGraphJobsResource)
+	resourceMux.HandleFunc("/graph/cost", ds.handleGraphCostResource)
+	resourceMux.HandleFunc("/presets", ds.handleFilterPresetsResource)
+	resourceMux.HandleFunc("/dashboard", ds.handleDashboardResource)
+	resourceMux.HandleFunc("/dashboards", ds.handleBundledDashboardsResource)
+	ds.resourceHandler = httpadapter.New(resourceMux)
+
+	return ds, nil
+}
+
+// newDatasource creates a datasource for the given settings, where the
+// thresholds, dashboards and namespaces are replaced by the given overrides of
+// an organization.
+func newDatasource(settings *models.PluginSettings, overrides models.OrgOverrides, prometheusClient prometheus.Client, logger log.Logger) (*Datasource, error) {
 	istioWarningThreshold := settings.IstioWarningThreshold
-	if istioWarningThreshold == 0 {
-		istioWarningThreshold = 0
+	if overrides.IstioWarningThreshold != nil {
+		istioWarningThreshold = *overrides.IstioWarningThreshold
 	}
 
 	istioErrorThreshold := settings.IstioErrorThreshold
+	if overrides.IstioErrorThreshold != nil {
+		istioErrorThreshold = *overrides.IstioErrorThreshold
+	}
 	if istioErrorThreshold == 0 {
 		istioErrorThreshold = 5
 	}
 
+	istioWorkloadDashboard := settings.IstioWorkloadDashboard
+	if overrides.IstioWorkloadDashboard != "" {
+		istioWorkloadDashboard = overrides.IstioWorkloadDashboard
+	}
+
+	istioServiceDashboard := settings.IstioServiceDashboard
+	if overrides.IstioServiceDashboard != "" {
+		istioServiceDashboard = overrides.IstioServiceDashboard
+	}
+
+	istioGatewayDashboard := settings.IstioGatewayDashboard
+	if overrides.IstioGatewayDashboard != "" {
+		istioGatewayDashboard = overrides.IstioGatewayDashboard
+	}
+
+	istioEdgeDashboard := settings.IstioEdgeDashboard
+	if overrides.IstioEdgeDashboard != "" {
+		istioEdgeDashboard = overrides.IstioEdgeDashboard
+	}
+
+	// The weight of the client error rate is only used for the "weighted" node
+	// health policy. By default the server and client error rates are weighted
+	// equally.
+	istioNodeHealthClientWeight := settings.IstioNodeHealthClientWeight
+	if istioNodeHealthClientWeight <= 0 || istioNodeHealthClientWeight > 1 {
+		istioNodeHealthClientWeight = 0.5
+	}
+
+	// The maximum window is used to split the "increase" queries for long time
+	// ranges into multiple queries. If it is not set, the queries are never
+	// split.
+	var prometheusMaxWindow time.Duration
+	if settings.PrometheusMaxWindow != "" {
+		maxWindow, err := model.ParseDuration(settings.PrometheusMaxWindow)
+		if err != nil {
+			logger.Error("Failed to parse maximum window", "error", err.Error())
+			return nil, err
+		}
+		prometheusMaxWindow = time.Duration(maxWindow)
+	}
+
+	// The default range is the time range, which is used by the dashboards. It
+	// is only used in the health check to verify that the retention of
+	// Prometheus covers this range.
+	var prometheusDefaultRange time.Duration
+	if settings.PrometheusDefaultRange != "" {
+		defaultRange, err := model.ParseDuration(settings.PrometheusDefaultRange)
+		if err != nil {
+			logger.Error("Failed to parse default range", "error", err.Error())
+			return nil, err
+		}
+		prometheusDefaultRange = time.Duration(defaultRange)
+	}
+
+	// If a refresh interval is set, the namespaces, applications and workloads
+	// lists are cached and refreshed in the background. The cache is disabled
+	// when the Grafana user or cookies are forwarded to Prometheus, because
+	// the lists can differ per user and the refresh runs without a user.
+	var listCache *listCache
+	if settings.PrometheusListRefresh != "" {
+		listRefresh, err := model.ParseDuration(settings.PrometheusListRefresh)
+		if err != nil {
+			logger.Error("Failed to parse list refresh interval", "error", err.Error())
+			return nil, err
+		}
+		if listRefresh > 0 && !settings.PrometheusForwardUserHeaders && len(settings.KeepCookies) == 0 {
+			listCache = newListCache(time.Duration(listRefresh))
+		}
+	}
+
+	// The excluded destinations are matched against the "destination_service"
+	// label, which contains the host of the service without the port, so that
+	// destinations with a port would never match. They are ignored instead of
+	// failing the datasource, so that datasources which were saved with a
+	// port are still working, and reported by the health check.
+	var istioExcludedDestinations, istioIgnoredDestinations []string
+	for _, destination := range settings.IstioExcludedDestinations {
+		if strings.Contains(destination, ":") {
+			logger.Warn("Ignore excluded destination, because the destination_service label doesn't contain the port", "destination", destination)
+			istioIgnoredDestinations = append(istioIgnoredDestinations, destination)
+			continue
+		}
+		istioExcludedDestinations = append(istioExcludedDestinations, destination)
+	}
+
+	// The cardinality of the Istio metrics is only checked, when a limit is
+	// configured, because counting the series can be expensive.
+	var cardinalityCheck *cardinalityCheck
+	if settings.IstioCardinalityLimit > 0 {
+		cardinalityCheck = newCardinalityCheck(settings.IstioCardinalityLimit)
+	}
+
 	ds := &Datasource{
-		prometheusClient:       prometheusClient,
-		istioWarningThreshold:  istioWarningThreshold,
-		istioErrorThreshold:    istioErrorThreshold,
-		istioWorkloadDashboard: settings.IstioWorkloadDashboard,
-		istioServiceDashboard:  settings.IstioServiceDashboard,
-		logger:                 logger,
+		prometheusClient:            prometheusClient,
+		prometheusMaxWindow:         prometheusMaxWindow,
+		prometheusDefaultRange:      prometheusDefaultRange,
+		prometheusPerUser:           settings.PrometheusForwardUserHeaders || len(settings.KeepCookies) > 0,
+		istioWarningThreshold:       istioWarningThreshold,
+		istioErrorThreshold:         istioErrorThreshold,
+		istioHighlightThrottled:     settings.IstioHighlightThrottled,
+		istioNodeHealth:             settings.IstioNodeHealth,
+		istioNodeHealthClientWeight: istioNodeHealthClientWeight,
+		istioWorkloadDashboard:      istioWorkloadDashboard,
+		istioServiceDashboard:       istioServiceDashboard,
+		istioGatewayDashboard:       istioGatewayDashboard,
+		istioEdgeDashboard:          istioEdgeDashboard,
+		istioDashboardParams:        settings.IstioDashboardParams,
+		istioLinks:                  settings.IstioLinks,
+		istioAnnotations:            settings.IstioAnnotations,
+		istioRevisionLabel:          settings.IstioRevisionLabel,
+		istioRevision:               settings.IstioRevision,
+		istioExcludedDestinations:   istioExcludedDestinations,
+		istioIgnoredDestinations:    istioIgnoredDestinations,
+		istioDurationMetric:         settings.IstioDurationMetric,
+		istioDurationUnit:           settings.IstioDurationUnit,
+		istioNativeHistograms:       settings.IstioNativeHistograms,
+		istioMultiCluster:           settings.IstioMultiCluster,
+		istioNamespaces:             overrides.IstioNamespaces,
+		expectedTopologies:          newOrgStore[expectedTopology]("", "", "topologies"),
+		graphJobs:                   newGraphJobStore(),
+		background:                  &sync.WaitGroup{},
+		filterPresets:               newOrgStore[filterPreset]("", "", "presets"),
+		metricDetection:             &metricDetection{},
+		cardinalityCheck:            cardinalityCheck,
+		listCache:                   listCache,
+		logger:                      logger,
 	}
 
 	queryTypeMux := datasource.NewQueryTypeMux()
@@ -69,6 +299,23 @@ func NewDatasource(_ context.Context, pCtx backend.DataSourceInstanceSettings) (
 	queryTypeMux.HandleFunc(models.QueryTypeApplicationGraph, ds.handleApplicationGraphQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeWorkloadGraph, ds.handleWorkloadGraphQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeNamespaceGraph, ds.handleNamespaceGraphQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeServiceGraph, ds.handleServiceGraphQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeCanary, ds.handleCanaryQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeNamespaceMatrix, ds.handleNamespaceMatrixQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeUpstreams, ds.handleUpstreamsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeDownstreams, ds.handleDownstreamsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypePath, ds.handlePathQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeEgress, ds.handleEgressQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeIngress, ds.handleIngressQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeHealthScore, ds.handleHealthScoreQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeMTLSCoverage, ds.handleMTLSCoverageQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeLatencyHeatmap, ds.handleLatencyHeatmapQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeRollouts, ds.handleRolloutsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeErrorBudget, ds.handleErrorBudgetQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeLatencySLO, ds.handleLatencySLOQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeNamespaceHealth, ds.handleNamespaceHealthQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeWorkloadTraffic, ds.handleWorkloadTrafficQueries)
+	queryTypeMux.HandleFunc("", ds.handleUnknownQueries)
 	ds.queryHandler = queryTypeMux
 
 	return ds, nil
@@ -77,13 +324,48 @@ func NewDatasource(_ context.Context, pCtx backend.DataSourceInstanceSettings) (
 // Datasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type Datasource struct {
-	queryHandler           backend.QueryDataHandler
-	prometheusClient       prometheus.Client
-	istioWarningThreshold  float64
-	istioErrorThreshold    float64
-	istioWorkloadDashboard string
-	istioServiceDashboard  string
-	logger                 log.Logger
+	queryHandler                backend.QueryDataHandler
+	resourceHandler             backend.CallResourceHandler
+	prometheusClient            prometheus.Client
+	alertmanagerClient          alertmanager.Client
+	bundledDashboards           fs.FS
+	prometheusMaxWindow         time.Duration
+	prometheusDefaultRange      time.Duration
+	prometheusPerUser           bool
+	istioWarningThreshold       float64
+	istioErrorThreshold         float64
+	istioHighlightThrottled     bool
+	istioNodeHealth             string
+	istioNodeHealthClientWeight float64
+	istioWorkloadDashboard      string
+	istioServiceDashboard       string
+	istioGatewayDashboard       string
+	istioEdgeDashboard          string
+	istioDashboardParams        string
+	istioLinks                  []models.IstioLink
+	istioAnnotations            []models.IstioAnnotation
+	istioRevisionLabel          string
+	istioRevision               string
+	istioExcludedDestinations   []string
+	istioIgnoredDestinations    []string
+	istioDurationMetric         string
+	istioDurationUnit           string
+	istioNativeHistograms       bool
+	istioMultiCluster           bool
+	istioNamespaces             []string
+	expectedTopologies          *orgStore[expectedTopology]
+	graphJobs                   *graphJobStore
+	filterPresets               *orgStore[filterPreset]
+	metricDetection             *metricDetection
+	cardinalityCheck            *cardinalityCheck
+	listCache                   *listCache
+	stopBackground              context.CancelFunc
+	background                  *sync.WaitGroup
+	disposed                    atomic.Bool
+	orgs                        map[int64]*Datasource
+	labelValuesGroup            singleflight.Group
+	graphGroup                  singleflight.Group
+	logger                      log.Logger
 }
 
 // QueryData handles multiple queries and returns multiple responses. The
@@ -94,15 +376,236 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	ctx, span := tracing.DefaultTracer().Start(ctx, "QueryData")
 	defer span.End()
 
-	return d.queryHandler.QueryData(ctx, req)
+	ctx = withRequestContext(ctx, req.PluginContext, req.GetHTTPHeader)
+	d = d.forOrg(req.PluginContext.OrgID)
+
+	allowedReq, deniedResponses := d.restrictNamespaces(skipHiddenQueries(req))
+	resp, err := d.queryHandler.QueryData(ctx, allowedReq)
+	if err != nil {
+		return nil, err
+	}
+	for refID, deniedResponse := range deniedResponses {
+		resp.Responses[refID] = deniedResponse
+	}
+	surfaceUnauthorizedErrors(resp)
+
+	return d.mergeGraphResponses(req, resp), nil
+}
+
+// withRequestContext returns a copy of the context with the user and the
+// forwarded cookies and "Authorization" header of the Grafana request, so that
+// all requests to Prometheus are sent on behalf of the user.
+// It is used for queries and resource calls, so that resources like the Kiali
+// graph also work behind oauth2-proxy or when the user is forwarded.
+func withRequestContext(ctx context.Context, pluginContext backend.PluginContext, header func(string) string) context.Context {
+	if pluginContext.User != nil && backend.UserFromContext(ctx) == nil {
+		ctx = backend.WithUser(ctx, pluginContext.User)
+	}
+	ctx = roundtripper.WithCookies(ctx, header(backend.CookiesHeaderName))
+	ctx = roundtripper.WithAuthorization(ctx, header(backend.OAuthIdentityTokenHeaderName))
+	return ctx
+}
+
+// surfaceUnauthorizedErrors replaces the errors of all responses, which failed
+// because Prometheus rejected the credentials of the datasource, with the
+// message of the UnauthorizedError. The messages of the wrapped errors contain
+// the url of the request, which hides the hint how the credentials can be
+// fixed. The responses are marked as downstream errors with the status "401
+// Unauthorized", so that they are not counted as errors of the plugin.
+func surfaceUnauthorizedErrors(resp *backend.QueryDataResponse) {
+	for refID, response := range resp.Responses {
+		var unauthorizedErr *prometheus.UnauthorizedError
+		if response.Error == nil || !errors.As(response.Error, &unauthorizedErr) {
+			continue
+		}
+
+		response.Error = errors.New(unauthorizedErr.Error())
+		response.ErrorSource = backend.ErrorSourceDownstream
+		response.Status = backend.StatusUnauthorized
+		resp.Responses[refID] = response
+	}
+}
+
+// forOrg returns the datasource with the overrides for the given organization.
+// If there are no overrides for the organization the datasource itself is
+// returned.
+func (d *Datasource) forOrg(orgID int64) *Datasource {
+	if orgDs, ok := d.orgs[orgID]; ok {
+		return orgDs
+	}
+	return d
+}
+
+// restrictNamespaces returns a copy of the request without the queries for
+// namespaces, which are not in the allowed namespaces of the organization, and
+// an error response for each of these queries. If no namespaces are configured
+// for the organization all queries are allowed.
+func (d *Datasource) restrictNamespaces(req *backend.QueryDataRequest) (*backend.QueryDataRequest, backend.Responses) {
+	if d.istioNamespaces == nil {
+		return req, nil
+	}
+
+	var queries []backend.DataQuery
+	deniedResponses := make(backend.Responses)
+
+	for _, query := range req.Queries {
+		var qm struct {
+			Namespace            string `json:"namespace"`
+			SourceNamespace      string `json:"sourceNamespace"`
+			DestinationNamespace string `json:"destinationNamespace"`
+		}
+		if err := json.Unmarshal(query.JSON, &qm); err == nil {
+			if namespace, ok := d.firstDeniedNamespace(qm.Namespace, qm.SourceNamespace, qm.DestinationNamespace); ok {
+				err := fmt.Errorf("namespace %q is not allowed for this organization", namespace)
+				d.logger.Error("Namespace is not allowed", "refId", query.RefID, "namespace", namespace)
+				deniedResponses[query.RefID] = backend.ErrorResponseWithErrorSource(err)
+				continue
+			}
+		}
+		queries = append(queries, query)
+	}
+
+	if len(deniedResponses) == 0 {
+		return req, nil
+	}
+
+	reqCopy := *req
+	reqCopy.Queries = queries
+	return &reqCopy, deniedResponses
+}
+
+// firstDeniedNamespace returns the first of the given namespaces, which is not
+// allowed for the organization. Empty namespaces are ignored, because the
+// handlers restrict mesh-wide queries via "namespaceMatchers".
+func (d *Datasource) firstDeniedNamespace(namespaces ...string) (string, bool) {
+	for _, namespace := range namespaces {
+		if namespace != "" && !d.isNamespaceAllowed(namespace) {
+			return namespace, true
+		}
+	}
+	return "", false
+}
+
+// isNamespaceAllowed returns true if the given namespace can be queried by the
+// organization of the datasource.
+func (d *Datasource) isNamespaceAllowed(namespace string) bool {
+	return d.istioNamespaces == nil || slices.Contains(d.istioNamespaces, namespace)
+}
+
+// namespaceMatchers returns the matchers, which select the series of the given
+// namespace via the given labels. If no namespace is set the query is mesh-wide
+// and for organizations with allowed namespaces the labels must match one of
+// these namespaces, so that the results do not contain other namespaces.
+func (d *Datasource) namespaceMatchers(namespace string, labels ...string) prometheus.Matchers {
+	var matchers prometheus.Matchers
+	for _, label := range labels {
+		if namespace != "" {
+			matchers = append(matchers, prometheus.Equal(label, namespace))
+		} else if d.istioNamespaces != nil {
+			matchers = append(matchers, prometheus.OneOf(label, d.istioNamespaces...))
+		}
+	}
+	return matchers
+}
+
+// skipHiddenQueries returns a copy of the request without the hidden queries,
+// so that we do not run the Prometheus queries for them. Hidden graph queries,
+// which are merged into another graph query via "mergeWithRefIds", are not
+// skipped, because their results are still needed.
+func skipHiddenQueries(req *backend.QueryDataRequest) *backend.QueryDataRequest {
+	merged := make(map[string]bool)
+	for _, query := range req.Queries {
+		var qm graphMergeModel
+		if err := json.Unmarshal(query.JSON, &qm); err == nil {
+			for _, refID := range qm.MergeWithRefIDs {
+				merged[refID] = true
+			}
+		}
+	}
+
+	var queries []backend.DataQuery
+	for _, query := range req.Queries {
+		var qm struct {
+			Hide bool `json:"hide"`
+		}
+		if err := json.Unmarshal(query.JSON, &qm); err == nil && qm.Hide && !merged[query.RefID] {
+			continue
+		}
+		queries = append(queries, query)
+	}
+
+	if len(queries) == len(req.Queries) {
+		return req
+	}
+
+	reqCopy := *req
+	reqCopy.Queries = queries
+	return &reqCopy
+}
+
+// handleUnknownQueries is the fallback handler of the query type multiplexer.
+// It returns an error for all queries, which contains the supported query
+// types, instead of the generic error of the multiplexer.
+func (d *Datasource) handleUnknownQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	resp := backend.NewQueryDataResponse()
+
+	for _, query := range req.Queries {
+		var err error
+		if query.QueryType == "" {
+			err = fmt.Errorf("missing query type, supported: %s", strings.Join(models.QueryTypes, ", "))
+		} else {
+			err = fmt.Errorf("unknown query type %q, supported: %s", query.QueryType, strings.Join(models.QueryTypes, ", "))
+		}
+
+		d.logger.Error("Unknown query type", "refId", query.RefID, "queryType", query.QueryType)
+		resp.Responses[query.RefID] = backend.ErrorResponseWithErrorSource(err)
+	}
+
+	return resp, nil
+}
+
+// CallResource handles the resource requests sent from Grafana to the plugin.
+// The requests are matched by their path against a handler function. See the
+// NewDatasource function where the handlers are registered.
+func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "CallResource")
+	defer span.End()
+
+	ctx = withRequestContext(ctx, req.PluginContext, req.GetHTTPHeader)
+
+	return d.resourceHandler.CallResource(ctx, req, sender)
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a
 // new instance created. As soon as datasource settings change detected by SDK
 // old datasource instance will be disposed and a new one will be created using
 // NewSampleDatasource factory function.
+//
+// The background tasks and the running graph jobs of all organizations are
+// stopped, before the idle connections of the Prometheus client are closed, so
+// that a settings change doesn't leak goroutines and connections of the old
+// instance.
 func (d *Datasource) Dispose() {
-	// Clean up datasource instance resources.
+	d.disposed.Store(true)
+	if d.stopBackground != nil {
+		d.stopBackground()
+	}
+	d.graphJobs.stop()
+	d.background.Wait()
+
+	if d.prometheusClient != nil {
+		d.prometheusClient.Close()
+	}
+	if d.alertmanagerClient != nil {
+		d.alertmanagerClient.Close()
+	}
+}
+
+// healthCheckDetails are the details of the health check, which contain the
+// result of the metric detection and of the cardinality check.
+type healthCheckDetails struct {
+	metricDetectionDetails
+	Cardinality *cardinalityCheckDetails `json:"cardinality,omitempty"`
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin. The main
@@ -112,15 +615,83 @@ func (d *Datasource) Dispose() {
 func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	res := &backend.CheckHealthResult{}
 
+	ctx = roundtripper.WithCookies(ctx, req.GetHTTPHeader(backend.CookiesHeaderName))
+
 	err := d.prometheusClient.CheckHealth(ctx)
 	if err != nil {
 		res.Status = backend.HealthStatusError
 		res.Message = "Prometheus health check failed: " + err.Error()
+
+		// When Prometheus rejected the credentials, we return only the hint
+		// how they can be fixed, e.g. after the token was rotated.
+		var unauthorizedErr *prometheus.UnauthorizedError
+		if errors.As(err, &unauthorizedErr) {
+			res.Message = unauthorizedErr.Error()
+		}
 		return res, nil
 	}
 
+	// The available Istio metrics are detected again, so that the result in
+	// the details of the health check is always up to date, e.g. after the
+	// telemetry configuration of Istio was changed.
+	d.detectMetrics(ctx, d.prometheusClient, time.Now())
+	details := healthCheckDetails{metricDetectionDetails: d.getMetricDetectionDetails()}
+
+	// The cardinality of the Istio metrics isn't checked again, because
+	// counting the series of all Istio metrics is expensive, especially when
+	// the limit is exceeded. Instead the result of the last check in the
+	// background is returned.
+	details.Cardinality = d.getCardinalityCheckDetails()
+
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		d.logger.Warn("Failed to marshal health check details", "error", err.Error())
+	}
+
+	// All warnings are collected and added to the message, so that a failing
+	// check doesn't hide the result of another check.
+	var warnings []string
+	if len(details.Unavailable) > 0 {
+		warnings = append(warnings, fmt.Sprintf("the following Istio metrics were not found: %s", strings.Join(details.Unavailable, ", ")))
+	}
+	if details.Cardinality != nil && len(details.Cardinality.Violations) > 0 {
+		var namespaces []string
+		for _, violation := range details.Cardinality.Violations {
+			if !slices.Contains(namespaces, violation.Namespace) {
+				namespaces = append(namespaces, violation.Namespace)
+			}
+		}
+		warnings = append(warnings, fmt.Sprintf("the Istio metrics exceed the cardinality limit of %d series in the following namespaces: %s", details.Cardinality.Limit, strings.Join(namespaces, ", ")))
+	}
+
+	// If a default range is configured, we verify that the retention of
+	// Prometheus covers this range, because otherwise the graphs are silently
+	// rendered empty or incomplete. A failing retention check is only logged,
+	// because the endpoints are not implemented by all Prometheus compatible
+	// backends.
+	if d.prometheusDefaultRange > 0 {
+		retention, err := d.prometheusClient.GetRetention(ctx)
+		if err != nil {
+			d.logger.Warn("Failed to get Prometheus retention", "error", err.Error())
+		} else if retention > 0 && d.prometheusDefaultRange > retention {
+			warnings = append(warnings, fmt.Sprintf("the default range of %s exceeds the Prometheus retention of %s, so that graphs for this range will be incomplete", model.Duration(d.prometheusDefaultRange), model.Duration(retention)))
+		}
+	}
+
+	// Excluded destinations with a port are ignored when the datasource is
+	// created, so that users know why these destinations are still shown.
+	if len(d.istioIgnoredDestinations) > 0 {
+		warnings = append(warnings, fmt.Sprintf("the following excluded destinations are ignored, because the destination_service label doesn't contain the port: %s", strings.Join(d.istioIgnoredDestinations, ", ")))
+	}
+
+	message := "Data source is working"
+	if len(warnings) > 0 {
+		message = fmt.Sprintf("%s, but %s", message, strings.Join(warnings, "; "))
+	}
+
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "Data source is working",
+		Status:      backend.HealthStatusOk,
+		Message:     message,
+		JSONDetails: jsonDetails,
 	}, nil
 }
@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryDataUnknownQueryType(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{}`)})
+	require.NoError(t, err)
+	ds := instance.(*Datasource)
+	ds.prometheusClient = prometheustest.NewClient()
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", QueryType: "topology", JSON: []byte(`{}`)},
+			{RefID: "B", JSON: []byte(`{}`)},
+		},
+	})
+	require.NoError(t, err)
+	require.ErrorContains(t, resp.Responses["A"].Error, `unknown query type "topology", supported: namespaces, applications`)
+	require.ErrorContains(t, resp.Responses["B"].Error, "missing query type")
+
+	for _, queryType := range models.QueryTypes {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", QueryType: queryType, JSON: []byte(`{}`)}},
+		})
+		require.NoError(t, err)
+		if resp.Responses["A"].Error != nil {
+			require.NotContains(t, resp.Responses["A"].Error.Error(), "query type", queryType)
+		}
+	}
+}
+
+func TestSkipHiddenQueries(t *testing.T) {
+	req := &backend.QueryDataRequest{Queries: []backend.DataQuery{
+		{RefID: "A", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"bookinfo","mergeWithRefIds":["C"]}`)},
+		{RefID: "B", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"shop","hide":true}`)},
+		{RefID: "C", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"data","hide":true}`)},
+	}}
+
+	var refIDs []string
+	for _, query := range skipHiddenQueries(req).Queries {
+		refIDs = append(refIDs, query.RefID)
+	}
+	require.Equal(t, []string{"A", "C"}, refIDs)
+	require.Len(t, req.Queries, 3)
+}
+
+func TestQueryDataOrgOverrides(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"istioErrorThreshold":2,"istioServiceDashboard":"/d/service?orgId=1","overrides":{"2":{"istioErrorThreshold":10,"istioNamespaces":["shop"]}}}`)})
+	require.NoError(t, err)
+	ds := instance.(*Datasource)
+
+	require.Same(t, ds, ds.forOrg(1))
+	require.Equal(t, 2.0, ds.istioErrorThreshold)
+	require.Equal(t, 10.0, ds.forOrg(2).istioErrorThreshold)
+	require.Equal(t, "/d/service?orgId=1", ds.forOrg(2).istioServiceDashboard)
+
+	client := prometheustest.NewClient().
+		AddLabelValues("destination_workload_namespace", "bookinfo", "shop").
+		AddLabelValues("source_workload_namespace", "data")
+	ds.forOrg(2).prometheusClient = client
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{OrgID: 2},
+		Queries: []backend.DataQuery{
+			{RefID: "A", QueryType: models.QueryTypeNamespaces, JSON: []byte(`{}`)},
+			{RefID: "B", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"bookinfo"}`)},
+			{RefID: "C", QueryType: models.QueryTypePath, JSON: []byte(`{"sourceNamespace":"shop","destinationNamespace":"data"}`)},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Equal(t, "shop", resp.Responses["A"].Frames[0].Fields[0].At(0))
+	require.Equal(t, 1, resp.Responses["A"].Frames[0].Fields[0].Len())
+	require.ErrorContains(t, resp.Responses["B"].Error, `namespace "bookinfo" is not allowed for this organization`)
+	require.ErrorContains(t, resp.Responses["C"].Error, `namespace "data" is not allowed for this organization`)
+}
+
+func TestQueryDataOrgOverridesMeshWide(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"overrides":{"2":{"istioNamespaces":["shop"]}}}`)})
+	require.NoError(t, err)
+	ds := instance.(*Datasource)
+
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total\{reporter="destination"\}`,
+			prometheus.Metric{Value: 100, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "response_code": "200"}},
+			prometheus.Metric{Value: 100, Labels: map[string]string{"destination_workload_namespace": "shop", "response_code": "200"}},
+		).
+		AddMetrics(`istio_requests_total\{reporter="destination", destination_workload_namespace="shop"\}`,
+			prometheus.Metric{Value: 100, Labels: map[string]string{"destination_workload_namespace": "shop", "response_code": "200"}},
+		)
+	ds.prometheusClient = client
+	ds.forOrg(2).prometheusClient = client
+
+	for orgID, namespaces := range map[int64][]string{1: {"bookinfo", "shop"}, 2: {"shop"}} {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{OrgID: orgID},
+			Queries: []backend.DataQuery{
+				{RefID: "A", QueryType: models.QueryTypeHealthScore, JSON: []byte(`{}`), TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()}},
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+
+		var fields []string
+		for _, field := range resp.Responses["A"].Frames[0].Fields {
+			fields = append(fields, field.Name)
+		}
+		require.Equal(t, namespaces, fields, "org %d", orgID)
+	}
+}
+
+func TestDetectMetrics(t *testing.T) {
+	t.Run("should hide unavailable metrics", func(t *testing.T) {
+		client := prometheustest.NewClient().AddLabelValues("__name__", "istio_requests_total", "istio_request_duration_milliseconds_bucket", "up")
+		ds, err := newDatasource(&models.PluginSettings{}, models.OrgOverrides{}, client, backend.Logger)
+		require.NoError(t, err)
+
+		require.True(t, ds.isMetricAvailable("istio_tcp_sent_bytes_total"))
+
+		ds.detectMetrics(context.Background(), client, time.Now())
+
+		require.True(t, ds.isMetricAvailable("istio_requests_total"))
+		require.False(t, ds.isMetricAvailable("istio_tcp_sent_bytes_total"))
+
+		details := ds.getMetricDetectionDetails()
+		require.True(t, details.Detected)
+		require.Equal(t, []string{"istio_requests_total", "istio_request_duration_milliseconds_bucket"}, details.Available)
+		require.Equal(t, []string{"istio_request_messages_total", "istio_response_messages_total", "istio_tcp_sent_bytes_total", "istio_tcp_received_bytes_total", "istio_tcp_connections_opened_total"}, details.Unavailable)
+	})
+
+	t.Run("should keep all metrics when the detection fails", func(t *testing.T) {
+		client := prometheustest.NewClient().AddError("__name__", errors.New("unsupported"))
+		ds, err := newDatasource(&models.PluginSettings{}, models.OrgOverrides{}, client, backend.Logger)
+		require.NoError(t, err)
+
+		ds.detectMetrics(context.Background(), client, time.Now())
+
+		require.True(t, ds.isMetricAvailable("istio_tcp_sent_bytes_total"))
+		require.Equal(t, "unsupported", ds.getMetricDetectionDetails().Error)
+	})
+
+	t.Run("should report unavailable metrics in the health check", func(t *testing.T) {
+		client := prometheustest.NewClient().AddLabelValues("__name__", "istio_requests_total")
+		ds, err := newDatasource(&models.PluginSettings{}, models.OrgOverrides{}, client, backend.Logger)
+		require.NoError(t, err)
+
+		res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+		require.NoError(t, err)
+		require.Equal(t, backend.HealthStatusOk, res.Status)
+		require.Contains(t, res.Message, "istio_tcp_sent_bytes_total")
+		require.Contains(t, string(res.JSONDetails), `"unavailable":["istio_request_duration_milliseconds_bucket"`)
+	})
+}
+
+func TestCheckCardinality(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddLabelValues("__name__", "istio_requests_total").
+		AddMetrics(`^count\(`, prometheus.Metric{Value: 12000, Labels: map[string]string{"__name__": "istio_requests_total", "destination_workload_namespace": "bookinfo"}})
+	ds, err := newDatasource(&models.PluginSettings{IstioCardinalityLimit: 10000}, models.OrgOverrides{}, client, backend.Logger)
+	require.NoError(t, err)
+
+	_, ok := ds.cardinalityNotice("bookinfo")
+	require.False(t, ok)
+
+	// The health check doesn't count the series itself, it returns the result
+	// of the last check in the background.
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.NotContains(t, res.Message, "cardinality limit")
+	require.NotContains(t, client.Queries(), `count({__name__=~"istio_.*", destination_workload_namespace!=""}) by (__name__, destination_workload_namespace) > 10000`)
+
+	ds.checkCardinality(context.Background(), client, time.Now())
+	res, err = ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusOk, res.Status)
+	require.Contains(t, res.Message, "exceed the cardinality limit of 10000 series in the following namespaces: bookinfo")
+	require.Contains(t, string(res.JSONDetails), `"cardinality":{"limit":10000,`)
+	require.Contains(t, string(res.JSONDetails), `"violations":[{"metric":"istio_requests_total","namespace":"bookinfo","series":12000}]`)
+	require.Contains(t, client.Queries(), `count({__name__=~"istio_.*", destination_workload_namespace!=""}) by (__name__, destination_workload_namespace) > 10000`)
+
+	notice, ok := ds.cardinalityNotice("bookinfo")
+	require.True(t, ok)
+	require.Equal(t, data.NoticeSeverityWarning, notice.Severity)
+	require.Equal(t, "High cardinality: the following Istio metrics exceed the limit of 10000 series in the namespace bookinfo: istio_requests_total (12000 series)", notice.Text)
+
+	_, ok = ds.cardinalityNotice("shop")
+	require.False(t, ok)
+
+	// The violations in namespaces, which are not allowed for an organization,
+	// are not returned in the health check of the organization.
+	restricted, err := newDatasource(&models.PluginSettings{IstioCardinalityLimit: 10000}, models.OrgOverrides{IstioNamespaces: []string{"shop"}}, client, backend.Logger)
+	require.NoError(t, err)
+	restricted.cardinalityCheck = ds.cardinalityCheck
+	res, err = restricted.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.NotContains(t, res.Message, "bookinfo")
+	require.Contains(t, string(res.JSONDetails), `"violations":[]`)
+}
+
+func TestCheckHealthWarnings(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddLabelValues("__name__", "istio_requests_total", "istio_request_duration_milliseconds_bucket", "istio_request_messages_total", "istio_response_messages_total", "istio_tcp_received_bytes_total", "istio_tcp_connections_opened_total").
+		AddMetrics(`^count\(`, prometheus.Metric{Value: 12000, Labels: map[string]string{"__name__": "istio_requests_total", "destination_workload_namespace": "bookinfo"}})
+	client.Retention = 24 * time.Hour
+	ds, err := newDatasource(&models.PluginSettings{PrometheusDefaultRange: "7d", IstioCardinalityLimit: 10000, IstioExcludedDestinations: []string{":15020"}}, models.OrgOverrides{}, client, backend.Logger)
+	require.NoError(t, err)
+	ds.checkCardinality(context.Background(), client, time.Now())
+
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusOk, res.Status)
+	require.Equal(t, "Data source is working, but the following Istio metrics were not found: istio_tcp_sent_bytes_total; the Istio metrics exceed the cardinality limit of 10000 series in the following namespaces: bookinfo; the default range of 1w exceeds the Prometheus retention of 1d, so that graphs for this range will be incomplete; the following excluded destinations are ignored, because the destination_service label doesn't contain the port: :15020", res.Message)
+}
+
+func TestDispose(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"prometheusListRefresh":"1m","overrides":{"2":{"istioErrorThreshold":10}}}`)})
+	require.NoError(t, err)
+	ds := instance.(*Datasource)
+
+	client := prometheustest.NewClient()
+	ds.prometheusClient = client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, ds.forOrg(2).graphJobs.start(&graphJob{ID: "job", Status: graphJobStatusRunning, cancel: cancel}, time.Now()))
+
+	ds.Dispose()
+
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	require.True(t, client.Closed())
+}
+
+func TestUnauthorizedErrors(t *testing.T) {
+	unauthorizedErr := &url.Error{Op: "Post", URL: "http://prometheus:9090/api/v1/query", Err: &prometheus.UnauthorizedError{AuthMethod: models.PrometheusAuthMethodToken}}
+	client := prometheustest.NewClient().AddError("", unauthorizedErr)
+	client.HealthError = unauthorizedErr
+	ds, err := newDatasource(&models.PluginSettings{}, models.OrgOverrides{}, client, backend.Logger)
+	require.NoError(t, err)
+
+	t.Run("should return credentials error in health check", func(t *testing.T) {
+		res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+		require.NoError(t, err)
+		require.Equal(t, backend.HealthStatusError, res.Status)
+		require.Equal(t, "Prometheus rejected the token (401 Unauthorized): credentials invalid, re-save datasource with a valid token", res.Message)
+	})
+
+	t.Run("should return credentials error in query response", func(t *testing.T) {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", QueryType: models.QueryTypeWorkloads, JSON: []byte(`{"namespace": "bookinfo"}`)}},
+		})
+		require.NoError(t, err)
+		require.EqualError(t, resp.Responses["A"].Error, "Prometheus rejected the token (401 Unauthorized): credentials invalid, re-save datasource with a valid token")
+		require.Equal(t, backend.ErrorSourceDownstream, resp.Responses["A"].ErrorSource)
+		require.Equal(t, backend.StatusUnauthorized, resp.Responses["A"].Status)
+	})
+}
+
+func TestCallResourceForwardsRequest(t *testing.T) {
+	var cookies, users []string
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/query" {
+			mu.Lock()
+			if cookie, err := r.Cookie("_oauth2_proxy"); err == nil {
+				cookies = append(cookies, cookie.Value)
+			}
+			users = append(users, r.Header.Get("X-Grafana-User"))
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(fmt.Sprintf(`{"prometheusUrl":%q,"keepCookies":["_oauth2_proxy"],"prometheusForwardUserHeaders":true}`, server.URL))})
+	require.NoError(t, err)
+	ds := instance.(*Datasource)
+	defer ds.Dispose()
+
+	var status int
+	err = ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "alice"}},
+		Path:          "graph/kiali",
+		Method:        http.MethodGet,
+		URL:           "graph/kiali?namespace=bookinfo&from=0&to=60000",
+		Headers:       map[string][]string{"Cookie": {"_oauth2_proxy=session; grafana_session=secret"}},
+	}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+		status = resp.Status
+		return nil
+	}))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, cookies, "session")
+	require.Contains(t, users, "alice")
+}
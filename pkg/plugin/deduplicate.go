@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+)
+
+// queryKeyIgnoredFields are the fields of a query, which are set by Grafana
+// and which do not have an effect on the result of a query. They are ignored
+// when we check if two queries are the same.
+var queryKeyIgnoredFields = []string{"refId", "datasource", "intervalMs", "maxDataPoints", "hide", "key"}
+
+// queryDataDeduplicated works like "concurrent.QueryData", but runs the given
+// function only once for all queries in the request, which are the same. This
+// is the case, when a dashboard contains multiple panels (e.g. a node graph and
+// a table panel) with the same query, so that we do not have to run all the
+// Prometheus queries multiple times.
+func (d *Datasource) queryDataDeduplicated(ctx context.Context, req *backend.QueryDataRequest, fn concurrent.QueryDataFunc) (*backend.QueryDataResponse, error) {
+	type result struct {
+		once     sync.Once
+		response backend.DataResponse
+	}
+
+	results := make(map[string]*result)
+	resultsMutex := &sync.Mutex{}
+
+	return concurrent.QueryData(ctx, req, func(ctx context.Context, query concurrent.Query) backend.DataResponse {
+		key, err := queryKey(query.DataQuery)
+		if err != nil {
+			return fn(ctx, query)
+		}
+
+		resultsMutex.Lock()
+		r, ok := results[key]
+		if !ok {
+			r = &result{}
+			results[key] = r
+		} else {
+			d.logger.Debug("Reuse result of duplicated query", "refId", query.DataQuery.RefID)
+		}
+		resultsMutex.Unlock()
+
+		r.once.Do(func() {
+			r.response = fn(ctx, query)
+		})
+
+		return r.response
+	}, 10)
+}
+
+// queryKey returns a key for the given query, which is the same for all
+// queries with the same query type, model and time range.
+func queryKey(query backend.DataQuery) (string, error) {
+	var model map[string]any
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		return "", err
+	}
+
+	for _, field := range queryKeyIgnoredFields {
+		delete(model, field)
+	}
+
+	// The keys of a map are sorted when it is marshaled, so that the same
+	// model always results in the same key.
+	data, err := json.Marshal(model)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%d/%d/%s", query.QueryType, query.TimeRange.From.UnixMilli(), query.TimeRange.To.UnixMilli(), data), nil
+}
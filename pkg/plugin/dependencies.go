@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handleUpstreamsQueries handles the queries to get all the upstreams of a
+// workload or service, which are all the services the workload or the
+// workloads of the service send requests to. It uses the concurrent package to
+// handle multiple queries in parallel.
+func (d *Datasource) handleUpstreamsQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleUpstreamsQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, func(ctx context.Context, query concurrent.Query) backend.DataResponse {
+		return d.handleDependencies(ctx, query, true)
+	}, 10)
+}
+
+// handleDownstreamsQueries handles the queries to get all the downstreams of a
+// workload or service, which are all the workloads which send requests to the
+// workload or service. It uses the concurrent package to handle multiple
+// queries in parallel.
+func (d *Datasource) handleDownstreamsQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleDownstreamsQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, func(ctx context.Context, query concurrent.Query) backend.DataResponse {
+		return d.handleDependencies(ctx, query, false)
+	}, 10)
+}
+
+// handleDependencies returns a table with all the direct dependencies of a
+// workload or service, including the request rate and the error rate for each
+// dependency. If "upstreams" is true the dependencies are the destination
+// services of the workload or of the workloads of the service, otherwise the
+// dependencies are the source workloads of the workload or service. The
+// upstreams are based on the metrics reported by the source and the
+// downstreams on the metrics reported by the destination, so that each request
+// is only counted once.
+func (d *Datasource) handleDependencies(ctx context.Context, query concurrent.Query, upstreams bool) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleDependencies")
+	defer span.End()
+
+	var qm models.QueryModelDependencies
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	var namespaceLabel string
+	var nameLabel string
+	var q prometheus.Query
+
+	if upstreams {
+		namespaceLabel = "destination_service_namespace"
+		nameLabel = "destination_service_name"
+		selector := prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Equal("source_workload_namespace", qm.Namespace), prometheus.Equal("source_workload", qm.Workload)}
+
+		// The Istio metrics do not contain the service of the source, so that
+		// we get the workloads of the service via the
+		// "destination_service_name" label first and use them as sources.
+		if qm.Service != "" {
+			workloads, err := d.getLabelValues(ctx, []prometheus.LabelValuesQuery{{
+				Label:     "destination_workload",
+				Selectors: []prometheus.Selector{{Metric: "istio_requests_total", Matchers: prometheus.Matchers{prometheus.Equal("destination_service_namespace", qm.Namespace), prometheus.Equal("destination_service_name", qm.Service)}}},
+			}}, query.DataQuery.TimeRange)
+			if err != nil {
+				d.logger.Error("Failed to get workloads", "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return backend.ErrorResponseWithErrorSource(err)
+			}
+			if len(workloads) == 0 {
+				return backend.DataResponse{Frames: data.Frames{dependenciesFrame(nil, nil, nil)}}
+			}
+
+			selector = prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Equal("source_workload_namespace", qm.Namespace), prometheus.OneOf("source_workload", workloads...)}
+		}
+
+		q = sumQuery("istio_requests_total", "increase", selector, interval, "destination_service_namespace", "destination_service_name", "request_protocol", "response_code", "grpc_response_status")
+	} else {
+		namespaceLabel = "source_workload_namespace"
+		nameLabel = "source_workload"
+
+		selector := prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_workload_namespace", qm.Namespace), prometheus.Equal("destination_workload", qm.Workload)}
+		if qm.Service != "" {
+			selector = prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_service_namespace", qm.Namespace), prometheus.Equal("destination_service_name", qm.Service)}
+		}
+
+		q = sumQuery("istio_requests_total", "increase", selector, interval, "source_workload_namespace", "source_workload", "request_protocol", "response_code", "grpc_response_status")
+	}
+
+	d.logger.Debug("Get metrics", "query", q.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+	metrics, err := d.prometheusClient.GetMetrics(ctx, "", q, query.DataQuery.TimeRange)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+	d.logger.Debug("Retrieved metrics", "query", q.String(), "metrics", metrics)
+
+	requests := make(map[string]float64)
+	errors := make(map[string]float64)
+
+	for _, m := range metrics {
+		dependency := fmt.Sprintf("%s/%s", m.Labels[namespaceLabel], m.Labels[nameLabel])
+
+		requests[dependency] += m.Value
+		if m.Labels["request_protocol"] == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+			errors[dependency] += m.Value
+		} else if m.Labels["request_protocol"] != "grpc" && isHTTPError(m.Labels["response_code"]) {
+			errors[dependency] += m.Value
+		}
+	}
+
+	dependencies := slices.Sorted(maps.Keys(requests))
+
+	var rps []float64
+	var errorRates []float64
+	for _, dependency := range dependencies {
+		rps = append(rps, requests[dependency]/float64(interval))
+		if requests[dependency] > 0 {
+			errorRates = append(errorRates, errors[dependency]/requests[dependency]*100)
+		} else {
+			errorRates = append(errorRates, 0)
+		}
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, dependenciesFrame(dependencies, rps, errorRates))
+
+	return response
+}
+
+// dependenciesFrame returns the table frame for the given dependencies and
+// their request and error rates.
+func dependenciesFrame(dependencies []string, rps, errorRates []float64) *data.Frame {
+	frame := data.NewFrame(
+		"Dependencies",
+		data.NewField("values", nil, dependencies).SetConfig(&data.FieldConfig{DisplayName: "Name"}),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
+		data.NewField("err", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error", Unit: "percent"}),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return frame
+}
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// defaultSparklineBuckets is the number of buckets of the sparklines in
+	// the edge table, when the query doesn't set the number of buckets.
+	defaultSparklineBuckets = 20
+	// maxSparklineBuckets is the maximum number of buckets of the sparklines,
+	// because the sparklines are only small charts in a table cell.
+	maxSparklineBuckets = 100
+)
+
+// getEdgeTableFrames returns the frames of the edge table for the given edges.
+// The "edge table" frame contains one row per edge with the request rate and
+// error rate over the whole time range. The "edge sparklines" frame contains
+// the request rate of each edge in the buckets of the time range as one field
+// per edge, which is labeled with the id of the edge. The "Time series to
+// table" transformation of Grafana turns the fields into sparklines, which can
+// be joined with the edge table via the "edge" field.
+//
+// The sparklines require a range query per request metric and direction, so
+// that they are only generated for the edges of the first hop of a graph.
+// Edges of further hops, which are not returned by these queries, don't have
+// values.
+func (d *Datasource) getEdgeTableFrames(ctx context.Context, edges map[string]models.Edge, options graphOptions, timeRange backend.TimeRange) (data.Frames, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getEdgeTableFrames")
+	defer span.End()
+
+	interval := timeRange.Duration().Seconds()
+	sorted := sortEdges(edges, options.sortByTraffic)
+
+	var ids, sources, destinations []string
+	var rps, errorRates []float64
+	for _, edge := range sorted {
+		requests := edge.GRPCRequestsSuccess + edge.GRPCRequestsError + edge.HTTPRequestsSuccess + edge.HTTPRequestsError
+		errors := edge.GRPCRequestsError + edge.HTTPRequestsError
+
+		ids = append(ids, edge.ID)
+		sources = append(sources, edge.SourceName+"."+edge.SourceNamespace)
+		destinations = append(destinations, edge.DestinationName+"."+edge.DestinationNamespace)
+		rps = append(rps, requests/interval)
+		if requests > 0 {
+			errorRates = append(errorRates, errors/requests*100)
+		} else {
+			errorRates = append(errorRates, 0)
+		}
+	}
+
+	tableFrame := data.NewFrame(
+		"edge table",
+		data.NewField("edge", nil, ids).SetConfig(&data.FieldConfig{DisplayName: "Edge"}),
+		data.NewField("from", nil, sources).SetConfig(&data.FieldConfig{DisplayName: "From"}),
+		data.NewField("to", nil, destinations).SetConfig(&data.FieldConfig{DisplayName: "To"}),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
+		data.NewField("err", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error", Unit: "percent"}),
+	)
+	tableFrame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	buckets := options.sparklineBuckets
+	if buckets <= 0 {
+		buckets = defaultSparklineBuckets
+	}
+	buckets = min(buckets, maxSparklineBuckets)
+
+	timestamps, timeSeries, err := d.getEdgeTimeSeries(ctx, options, timeRange, timeRange.Duration()/time.Duration(buckets))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	sparklineFrame := data.NewFrame("edge sparklines", data.NewField("time", nil, timestamps))
+	for _, edge := range sorted {
+		values := make([]*float64, len(timestamps))
+		if ts, ok := timeSeries[edge.ID]; ok {
+			for i := range timestamps {
+				value := ts.requests[i]
+				values[i] = &value
+			}
+		}
+		sparklineFrame.Fields = append(sparklineFrame.Fields, data.NewField("rps", data.Labels{"edge": edge.ID}, values).SetConfig(&data.FieldConfig{
+			DisplayNameFromDS: edge.ID,
+			Unit:              "reqps",
+		}))
+	}
+	sparklineFrame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeGraph,
+		Type:                   data.FrameTypeTimeSeriesWide,
+	})
+
+	return data.Frames{tableFrame, sparklineFrame}, nil
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEdgeTableFrames(t *testing.T) {
+	labels := map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}
+
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total`, prometheus.Metric{Value: 600, Labels: labels}).
+		AddTimeSeries(`istio_requests_total.*\[300s\]`, prometheus.TimeSeries{
+			Timestamps: []time.Time{time.Unix(600, 0), time.Unix(900, 0)},
+			Values:     []float64{300, 600},
+			Labels:     labels,
+		})
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	response := d.handleGraph(context.Background(), graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}, edgeTable: true, sparklineBuckets: 3}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(900, 0)})
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 4)
+
+	table := response.Frames[2]
+	require.Equal(t, "edge table", table.Name)
+	require.Equal(t, 2, table.Rows())
+	require.Equal(t, "service-reviews-bookinfo-workload-reviews-v1-bookinfo", table.Fields[0].At(0))
+	require.Equal(t, "workload-productpage-v1-bookinfo-service-reviews-bookinfo", table.Fields[0].At(1))
+	require.InDelta(t, 600.0/900, table.Fields[3].At(1), 0.0001)
+
+	sparklines := response.Frames[3]
+	require.Equal(t, "edge sparklines", sparklines.Name)
+	require.Len(t, sparklines.Fields, 3)
+	require.Equal(t, 2, sparklines.Rows())
+	require.Equal(t, "workload-productpage-v1-bookinfo-service-reviews-bookinfo", sparklines.Fields[2].Labels["edge"])
+	require.Equal(t, 1.0, *sparklines.Fields[2].At(0).(*float64))
+	require.Equal(t, 2.0, *sparklines.Fields[2].At(1).(*float64))
+}
+
+func TestGetEdgeTimeSeriesFrames(t *testing.T) {
+	labels := map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}
+	errorLabels := maps.Clone(labels)
+	errorLabels["response_code"] = "503"
+
+	client := prometheustest.NewClient().
+		AddTimeSeries(`istio_requests_total.*\[60s\]`,
+			prometheus.TimeSeries{Timestamps: []time.Time{time.Unix(60, 0), time.Unix(120, 0)}, Values: []float64{60, 0}, Labels: labels},
+			prometheus.TimeSeries{Timestamps: []time.Time{time.Unix(60, 0), time.Unix(120, 0)}, Values: []float64{60, 0}, Labels: errorLabels},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	edges := map[string]models.Edge{"workload-productpage-v1-bookinfo-service-reviews-bookinfo": {ID: "workload-productpage-v1-bookinfo-service-reviews-bookinfo", SourceName: "productpage-v1", SourceNamespace: "bookinfo", DestinationName: "reviews", DestinationNamespace: "bookinfo"}}
+
+	frames, err := d.getEdgeTimeSeriesFrames(context.Background(), edges, graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(120, 0)})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	require.Equal(t, "time series workload-productpage-v1-bookinfo-service-reviews-bookinfo", frames[0].Name)
+	require.Equal(t, "productpage-v1.bookinfo -> reviews.bookinfo (Rate)", frames[0].Fields[1].Config.DisplayNameFromDS)
+	require.Equal(t, 2.0, frames[0].Fields[1].At(0))
+	require.Equal(t, 50.0, *frames[0].Fields[2].At(0).(*float64))
+	require.Nil(t, frames[0].Fields[2].At(1))
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// edgeTimeSeriesPoints is the number of points of the time series of the
+// edges, which are returned when the "timeSeries" option of a graph is set.
+const edgeTimeSeriesPoints = 100
+
+// edgeTimeSeries contains the number of requests and failed requests per
+// second of an edge for each timestamp returned by "getEdgeTimeSeries".
+type edgeTimeSeries struct {
+	requests []float64
+	errors   []float64
+}
+
+// getEdgeTimeSeriesFrames returns one frame per edge with the request rate
+// and error rate of the edge over the time range of the graph. The frames are
+// named after the id of the edge and the fields are labeled with the id, the
+// source and the destination of the edge, so that a linked time series panel
+// can show the selected edge of the node graph.
+func (d *Datasource) getEdgeTimeSeriesFrames(ctx context.Context, edges map[string]models.Edge, options graphOptions, timeRange backend.TimeRange) (data.Frames, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getEdgeTimeSeriesFrames")
+	defer span.End()
+
+	timestamps, timeSeries, err := d.getEdgeTimeSeries(ctx, options, timeRange, timeRange.Duration()/edgeTimeSeriesPoints)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var frames data.Frames
+
+	for _, edge := range sortEdges(edges, options.sortByTraffic) {
+		ts, ok := timeSeries[edge.ID]
+		if !ok {
+			continue
+		}
+
+		// The error rate is only set for timestamps with requests, because it
+		// is not defined otherwise.
+		errorRates := make([]*float64, len(timestamps))
+		for i := range timestamps {
+			if ts.requests[i] > 0 {
+				errorRate := ts.errors[i] / ts.requests[i] * 100
+				errorRates[i] = &errorRate
+			}
+		}
+
+		labels := data.Labels{"edge": edge.ID, "source": edge.Source, "destination": edge.Destination}
+		displayName := fmt.Sprintf("%s.%s -> %s.%s", edge.SourceName, edge.SourceNamespace, edge.DestinationName, edge.DestinationNamespace)
+
+		frame := data.NewFrame(
+			fmt.Sprintf("time series %s", edge.ID),
+			data.NewField("time", nil, timestamps),
+			data.NewField("rps", labels, ts.requests).SetConfig(&data.FieldConfig{
+				DisplayNameFromDS: displayName + " (Rate)",
+				Unit:              "reqps",
+			}),
+			data.NewField("err", labels, errorRates).SetConfig((&data.FieldConfig{
+				DisplayNameFromDS: displayName + " (Error)",
+				Unit:              "percent",
+			}).SetMin(0).SetMax(100)),
+		)
+		frame.SetMeta(&data.FrameMeta{
+			PreferredVisualization: data.VisTypeGraph,
+			Type:                   data.FrameTypeTimeSeriesMulti,
+		})
+
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
+
+// getEdgeTimeSeries returns the timestamps and the request rate and error
+// rate of each edge at these timestamps, where the key of the map is the id of
+// the edge. The rates are based on range queries of the selected gRPC and HTTP
+// request metrics of the first hop, where the same queries as for the graph
+// are used, but with the step as window. The samples of each timestamp are
+// then converted into edges, like the samples of the instant queries for the
+// graph, so that the ids of the edges match the ids of the graph. The step is
+// never shorter than one minute, so that we always have enough samples to
+// calculate the increase.
+func (d *Datasource) getEdgeTimeSeries(ctx context.Context, options graphOptions, timeRange backend.TimeRange, step time.Duration) ([]time.Time, map[string]edgeTimeSeries, error) {
+	step = max(step.Truncate(time.Second), time.Minute)
+	stepTimeRange := backend.TimeRange{From: timeRange.From.Add(step), To: timeRange.To}
+
+	var workloads []string
+	if options.workload != "" {
+		workloads = []string{options.workload}
+	}
+
+	directions := []graphQueryBuilder{d.metricToPrometheusDestinationsQuery, d.metricToPrometheusSourcesQuery}
+	if options.matchServiceNamespace && len(workloads) > 0 {
+		directions = append(directions, d.metricToPrometheusServiceDestinationsQuery)
+	}
+	if options.service != "" {
+		directions = []graphQueryBuilder{d.metricToPrometheusServiceQuery}
+	}
+
+	var metrics []string
+	for _, metric := range options.metrics {
+		if metric == models.MetricGRPCRequests || metric == models.MetricHTTPRequests {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	var batch []prometheus.TimeSeriesQuery
+	for _, metric := range metrics {
+		for _, direction := range directions {
+			q := direction(options.namespace, options.application, workloads, metric, options, int64(step.Seconds()))
+			d.logger.Debug("Get time series", "query", q.String(), "timeRangeFrom", stepTimeRange.From, "timeRangeTo", stepTimeRange.To, "step", step)
+			batch = append(batch, prometheus.TimeSeriesQuery{Metric: metric, Query: q, TimeRange: stepTimeRange, Step: step})
+		}
+	}
+
+	results, err := d.prometheusClient.GetTimeSeriesBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get time series", "error", err.Error())
+		return nil, nil, err
+	}
+
+	samples := make(map[time.Time][]prometheus.Metric)
+	for _, timeSeries := range results {
+		for _, ts := range timeSeries {
+			for i, timestamp := range ts.Timestamps {
+				samples[timestamp] = append(samples[timestamp], prometheus.Metric{Value: ts.Values[i], Labels: ts.Labels})
+			}
+		}
+	}
+
+	timestamps := slices.SortedFunc(maps.Keys(samples), func(a, b time.Time) int { return a.Compare(b) })
+	timeSeries := make(map[string]edgeTimeSeries)
+
+	for i, timestamp := range timestamps {
+		metrics := d.deduplicateMetrics(samples[timestamp])
+		if options.aggregateByApp {
+			metrics = aggregateMetricsByApp(metrics)
+		}
+
+		for id, edge := range d.metricsToEdges(metrics, options) {
+			ts, ok := timeSeries[id]
+			if !ok {
+				ts = edgeTimeSeries{requests: make([]float64, len(timestamps)), errors: make([]float64, len(timestamps))}
+				timeSeries[id] = ts
+			}
+			ts.requests[i] = (edge.GRPCRequestsSuccess + edge.GRPCRequestsError + edge.HTTPRequestsSuccess + edge.HTTPRequestsError) / step.Seconds()
+			ts.errors[i] = (edge.GRPCRequestsError + edge.HTTPRequestsError) / step.Seconds()
+		}
+	}
+
+	return timestamps, timeSeries, nil
+}
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handleEgressQueries handles the queries to get all external dependencies of
+// the mesh. It uses the concurrent package to handle multiple queries in
+// parallel.
+func (d *Datasource) handleEgressQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleEgressQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleEgress, 10)
+}
+
+// handleEgress returns a table with all hosts outside of the mesh, which
+// received traffic in the selected time range. This includes all traffic sent
+// to the "PassthroughCluster" and to hosts defined via a ServiceEntry. Istio
+// sets the "destination_workload" label to "unknown" for this traffic, so that
+// we can use it to select the external traffic. The hosts are identified by
+// the "destination_service" label.
+func (d *Datasource) handleEgress(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleEgress")
+	defer span.End()
+
+	var qm models.QueryModelEgress
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Equal("destination_workload", "unknown")}
+	selector = append(selector, d.namespaceMatchers(qm.Namespace, "source_workload_namespace")...)
+
+	queries := map[string]prometheus.Query{
+		"requests":         sumQuery("istio_requests_total", "increase", selector, interval, "destination_service", "request_protocol", "response_code", "grpc_response_status"),
+		"tcpSentBytes":     sumQuery("istio_tcp_sent_bytes_total", "increase", selector, interval, "destination_service"),
+		"tcpReceivedBytes": sumQuery("istio_tcp_received_bytes_total", "increase", selector, interval, "destination_service"),
+	}
+
+	type hostStats struct {
+		requests      float64
+		errors        float64
+		sentBytes     float64
+		receivedBytes float64
+	}
+
+	stats := make(map[string]*hostStats)
+
+	// The queries are sorted by their metric, so that they are always sent in
+	// the same order.
+	batch := make([]prometheus.MetricsQuery, 0, len(queries))
+	for _, metric := range slices.Sorted(maps.Keys(queries)) {
+		d.logger.Debug("Get metrics", "query", queries[metric].String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+		batch = append(batch, prometheus.MetricsQuery{Metric: metric, Query: queries[metric], TimeRange: query.DataQuery.TimeRange})
+	}
+
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	for i, metrics := range results {
+		metric := batch[i].Metric
+		d.logger.Debug("Retrieved metrics", "query", batch[i].Query, "metrics", metrics)
+
+		for _, m := range metrics {
+			host := m.Labels["destination_service"]
+			if host == "" {
+				continue
+			}
+
+			if _, ok := stats[host]; !ok {
+				stats[host] = &hostStats{}
+			}
+
+			switch metric {
+			case "requests":
+				stats[host].requests += m.Value
+				if m.Labels["request_protocol"] == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+					stats[host].errors += m.Value
+				} else if m.Labels["request_protocol"] != "grpc" && isHTTPError(m.Labels["response_code"]) {
+					stats[host].errors += m.Value
+				}
+			case "tcpSentBytes":
+				stats[host].sentBytes += m.Value
+			case "tcpReceivedBytes":
+				stats[host].receivedBytes += m.Value
+			}
+		}
+	}
+
+	hosts := slices.Sorted(maps.Keys(stats))
+
+	var rps []float64
+	var errorRates []float64
+	var sentBps []float64
+	var receivedBps []float64
+	for _, host := range hosts {
+		rps = append(rps, stats[host].requests/float64(interval))
+		if stats[host].requests > 0 {
+			errorRates = append(errorRates, stats[host].errors/stats[host].requests*100)
+		} else {
+			errorRates = append(errorRates, 0)
+		}
+		sentBps = append(sentBps, stats[host].sentBytes/float64(interval))
+		receivedBps = append(receivedBps, stats[host].receivedBytes/float64(interval))
+	}
+
+	frame := data.NewFrame(
+		"Egress",
+		data.NewField("values", nil, hosts).SetConfig(&data.FieldConfig{DisplayName: "Host"}),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
+		data.NewField("err", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error", Unit: "percent"}),
+		data.NewField("sentbps", nil, sentBps).SetConfig(&data.FieldConfig{DisplayName: "TCP Sent", Unit: "Bps"}),
+		data.NewField("receivedbps", nil, receivedBps).SetConfig(&data.FieldConfig{DisplayName: "TCP Received", Unit: "Bps"}),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
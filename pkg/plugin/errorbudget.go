@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/errgroup"
+)
+
+// The default SLO target in percent and the default SLO window, which are used
+// when they are not set in the query.
+const (
+	defaultSLOTarget = 99.9
+	defaultSLOWindow = "30d"
+)
+
+// handleErrorBudgetQueries handles the queries to get the remaining error
+// budget of the services in a namespace. It uses the concurrent package to
+// handle multiple queries in parallel.
+func (d *Datasource) handleErrorBudgetQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleErrorBudgetQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleErrorBudget, 10)
+}
+
+// handleErrorBudget returns the remaining error budget of each service for the
+// SLO target and window of the query. The following frames are returned:
+//   - A table with the remaining error budget at the end of the selected time
+//     range, the burn rate within the selected time range and the projected
+//     time when the error budget is exhausted, if the burn rate stays the
+//     same.
+//   - One time series per service with the remaining error budget, where each
+//     sample is calculated over the SLO window before the sample.
+func (d *Datasource) handleErrorBudget(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleErrorBudget")
+	defer span.End()
+
+	var qm models.QueryModelErrorBudget
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	target := qm.Target
+	if target == 0 {
+		target = defaultSLOTarget
+	}
+	if target < 0 || target >= 100 {
+		err := fmt.Errorf("invalid target %g, must be between 0 and 100", target)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	sloWindow, err := model.ParseDuration(cmp.Or(qm.Window, defaultSLOWindow))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+	window := time.Duration(sloWindow)
+
+	// The step for the range queries is based on the interval of the query.
+	step := query.DataQuery.Interval
+	if step <= 0 {
+		step = time.Minute
+	}
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_service_namespace", qm.Namespace)}
+	if qm.Service != "" {
+		selector = append(selector, prometheus.Equal("destination_service_name", qm.Service))
+	}
+
+	errorsQuery := func(window int64) prometheus.Expression {
+		return requestErrorsQuery("increase", selector, window, "destination_service_namespace", "destination_service_name")
+	}
+	totalQuery := func(window int64) prometheus.Expression {
+		return sumQuery("istio_requests_total", "increase", selector, window, "destination_service_namespace", "destination_service_name")
+	}
+
+	// The error and total requests within the SLO window are returned as time
+	// series, the error and total requests within the selected time range are
+	// used to calculate the current burn rate. The time series and the metrics
+	// are retrieved as two batches, which are run in parallel, so that we only
+	// have to wait for the slowest query once.
+	timeSeriesBatch := []prometheus.TimeSeriesQuery{
+		{Metric: "errors", Query: errorsQuery(int64(window.Seconds())), TimeRange: query.DataQuery.TimeRange, Step: step},
+		{Metric: "total", Query: totalQuery(int64(window.Seconds())), TimeRange: query.DataQuery.TimeRange, Step: step},
+	}
+	metricsBatch := []prometheus.MetricsQuery{
+		{Metric: "errors", Query: errorsQuery(interval), TimeRange: query.DataQuery.TimeRange},
+		{Metric: "total", Query: totalQuery(interval), TimeRange: query.DataQuery.TimeRange},
+	}
+
+	var timeSeries [][]prometheus.TimeSeries
+	var metrics [][]prometheus.Metric
+
+	d.logger.Debug("Get error budget", "errorsQuery", errorsQuery(interval).String(), "totalQuery", totalQuery(interval).String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To, "step", step)
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		timeSeries, err = d.prometheusClient.GetTimeSeriesBatch(gCtx, timeSeriesBatch)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics, err = d.prometheusClient.GetMetricsBatch(gCtx, metricsBatch)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		d.logger.Error("Failed to get error budget", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	windowErrors, windowTotal := timeSeries[0], timeSeries[1]
+	rangeErrors, rangeTotal := metrics[0], metrics[1]
+
+	budget := 1 - target/100
+
+	serviceKey := func(labels map[string]string) string {
+		return labels["destination_service_name"] + "." + labels["destination_service_namespace"]
+	}
+
+	// Calculate the remaining error budget for each sample of the services.
+	// Samples where a service doesn't have any errors are not returned by
+	// Prometheus, so that we use a map of the errors by timestamp.
+	errorSamples := make(map[string]map[int64]float64)
+	for _, ts := range windowErrors {
+		key := serviceKey(ts.Labels)
+		errorSamples[key] = make(map[int64]float64)
+		for i, value := range ts.Values {
+			errorSamples[key][ts.Timestamps[i].UnixMilli()] = value
+		}
+	}
+
+	type serviceBudget struct {
+		namespace  string
+		service    string
+		timestamps []time.Time
+		remaining  []float64
+		burnRate   float64
+	}
+
+	budgets := make(map[string]*serviceBudget)
+	for _, ts := range windowTotal {
+		key := serviceKey(ts.Labels)
+		b := &serviceBudget{namespace: ts.Labels["destination_service_namespace"], service: ts.Labels["destination_service_name"]}
+		for i, value := range ts.Values {
+			b.timestamps = append(b.timestamps, ts.Timestamps[i])
+			b.remaining = append(b.remaining, getErrorBudget(errorSamples[key][ts.Timestamps[i].UnixMilli()], value, budget))
+		}
+		budgets[key] = b
+	}
+
+	rangeErrorsByService := make(map[string]float64)
+	for _, m := range rangeErrors {
+		rangeErrorsByService[serviceKey(m.Labels)] += m.Value
+	}
+	for _, m := range rangeTotal {
+		if b, ok := budgets[serviceKey(m.Labels)]; ok && m.Value > 0 {
+			b.burnRate = rangeErrorsByService[serviceKey(m.Labels)] / m.Value / budget
+		}
+	}
+
+	var response backend.DataResponse
+
+	var namespaces, services []string
+	var remaining, burnRates []float64
+	var exhaustions []*time.Time
+
+	for _, key := range slices.Sorted(maps.Keys(budgets)) {
+		b := budgets[key]
+		if len(b.remaining) == 0 {
+			continue
+		}
+
+		current := b.remaining[len(b.remaining)-1]
+
+		namespaces = append(namespaces, b.namespace)
+		services = append(services, b.service)
+		remaining = append(remaining, current)
+		burnRates = append(burnRates, b.burnRate)
+		exhaustions = append(exhaustions, getErrorBudgetExhaustion(current, b.burnRate, window, query.DataQuery.TimeRange.To))
+
+		frame := data.NewFrame(
+			"remaining",
+			data.NewField("time", nil, b.timestamps),
+			data.NewField("value", data.Labels{"namespace": b.namespace, "service": b.service}, b.remaining).SetConfig(&data.FieldConfig{
+				DisplayNameFromDS: fmt.Sprintf("%s.%s", b.service, b.namespace),
+				Unit:              "percent",
+			}),
+		)
+		frame.SetMeta(&data.FrameMeta{
+			PreferredVisualization: data.VisTypeGraph,
+			Type:                   data.FrameTypeTimeSeriesMulti,
+		})
+		response.Frames = append(response.Frames, frame)
+	}
+
+	table := data.NewFrame(
+		"Error Budget",
+		data.NewField("namespace", nil, namespaces).SetConfig(&data.FieldConfig{DisplayName: "Namespace"}),
+		data.NewField("service", nil, services).SetConfig(&data.FieldConfig{DisplayName: "Service"}),
+		data.NewField("remaining", nil, remaining).SetConfig(&data.FieldConfig{DisplayName: "Remaining", Unit: "percent"}),
+		data.NewField("burnRate", nil, burnRates).SetConfig(&data.FieldConfig{DisplayName: "Burn Rate"}),
+		data.NewField("exhaustion", nil, exhaustions).SetConfig(&data.FieldConfig{DisplayName: "Exhaustion"}),
+	)
+	table.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+	response.Frames = append([]*data.Frame{table}, response.Frames...)
+
+	return response
+}
+
+// getErrorBudget returns the remaining error budget in percent for the given
+// number of errors and total requests, where the budget is the allowed share
+// of errors (e.g. 0.001 for a target of 99.9%). The remaining error budget is
+// negative when the budget is exceeded. Without any requests the whole budget
+// is remaining.
+func getErrorBudget(errors, total, budget float64) float64 {
+	if total <= 0 || budget <= 0 {
+		return 100
+	}
+	return (1 - errors/total/budget) * 100
+}
+
+// getErrorBudgetExhaustion returns the projected time when the remaining error
+// budget (in percent) is exhausted. A burn rate of 1 consumes the whole budget
+// within the SLO window. If the budget is already exhausted the given time is
+// returned and if the burn rate is 0 the budget is never exhausted.
+func getErrorBudgetExhaustion(remaining, burnRate float64, window time.Duration, now time.Time) *time.Time {
+	if remaining <= 0 {
+		return &now
+	}
+	if burnRate <= 0 {
+		return nil
+	}
+
+	exhaustion := now.Add(time.Duration(remaining / 100 / burnRate * float64(window)))
+	return &exhaustion
+}
@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetErrorBudget(t *testing.T) {
+	require.InDelta(t, 50.0, getErrorBudget(5, 10000, 0.001), 0.0001)
+	require.InDelta(t, -100.0, getErrorBudget(20, 10000, 0.001), 0.0001)
+	require.Equal(t, 100.0, getErrorBudget(0, 0, 0.001))
+}
+
+func TestGetErrorBudgetExhaustion(t *testing.T) {
+	now := time.Unix(0, 0)
+	window := 30 * 24 * time.Hour
+
+	require.Equal(t, now, *getErrorBudgetExhaustion(0, 1, window, now))
+	require.Nil(t, getErrorBudgetExhaustion(50, 0, window, now))
+	require.Equal(t, now.Add(15*24*time.Hour), *getErrorBudgetExhaustion(50, 1, window, now))
+	require.Equal(t, now.Add(5*24*time.Hour), *getErrorBudgetExhaustion(50, 3, window, now))
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+)
+
+// staleDataThreshold is the age of the most recent sample, after which we
+// consider the telemetry of a namespace as stale. The default scrape interval
+// of Prometheus is 1 minute, so that a sample older than 2 minutes most likely
+// means that the sidecars are not scraped anymore.
+const staleDataThreshold = 2 * time.Minute
+
+// freshnessMetrics are the metrics, which are used to get the most recent
+// sample of a namespace. The TCP metrics are included, so that namespaces with
+// only TCP traffic are not reported as stale.
+var freshnessMetrics = []string{"istio_requests_total", "istio_tcp_sent_bytes_total"}
+
+// getDataFreshness returns the timestamp of the most recent sample of the Istio
+// metrics for the given namespace at the end of the time range and the time at
+// which Prometheus evaluated the query. The evaluation time can be before the
+// end of the time range, when the client rounds the time range, so that the age
+// of the sample must be calculated against it. If Prometheus didn't return a
+// sample within its lookback delta (5 minutes by default), the returned sample
+// time is zero.
+func (d *Datasource) getDataFreshness(ctx context.Context, namespace string, timeRange backend.TimeRange) (time.Time, time.Time, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getDataFreshness")
+	defer span.End()
+
+	var selectors []prometheus.Expression
+	for _, metric := range freshnessMetrics {
+		for _, label := range []string{"destination_workload_namespace", "source_workload_namespace"} {
+			selectors = append(selectors, prometheus.Selector{Metric: metric, Matchers: prometheus.Matchers{prometheus.Equal(label, namespace)}})
+		}
+	}
+	query := prometheus.Aggregate{Operator: "max", Expr: prometheus.Call{Function: "timestamp", Args: []prometheus.Expression{prometheus.Or(selectors...)}}}
+
+	metrics, err := d.prometheusClient.GetMetrics(ctx, "freshness", query, timeRange)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if len(metrics) == 0 {
+		return time.Time{}, timeRange.To, nil
+	}
+
+	evaluationTime := metrics[0].Timestamp
+	if evaluationTime.IsZero() {
+		evaluationTime = timeRange.To
+	}
+
+	return time.UnixMilli(int64(metrics[0].Value * 1000)), evaluationTime, nil
+}
+
+// dataFreshnessNotice returns the notice for a graph, which shows when the
+// data was last updated. If the most recent sample is older than the
+// "staleDataThreshold" or if there is no recent sample at all, a warning is
+// returned, so that users do not mistake missing data for zero traffic.
+func dataFreshnessNotice(latest, evaluationTime time.Time) data.Notice {
+	if latest.IsZero() {
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Telemetry is stale: no Istio metrics were received in the 5 minutes before %s", evaluationTime.UTC().Format(time.RFC3339)),
+		}
+	}
+
+	if age := evaluationTime.Sub(latest); age > staleDataThreshold {
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Telemetry is stale: data as of %s (%s old)", latest.UTC().Format(time.RFC3339), age.Round(time.Second)),
+		}
+	}
+
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("Data as of %s", latest.UTC().Format(time.RFC3339)),
+	}
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+var record = flag.String("record", "", "record the fixtures from the Prometheus instance with the given url")
+
+var goldenGraphMetrics = []string{
+	models.MetricGRPCRequests,
+	models.MetricGRPCRequestDuration,
+	models.MetricGRPCSentMessages,
+	models.MetricGRPCReceivedMessages,
+	models.MetricHTTPRequests,
+	models.MetricHTTPRequestDuration,
+	models.MetricTCPSentBytes,
+	models.MetricTCPReceivedBytes,
+}
+
+// TestGoldenResponses runs a query for each query type against the recorded
+// Prometheus fixtures in "testdata/fixtures" and compares the returned frames
+// with the golden files in "testdata/golden". The test fails, when a query
+// isn't part of the fixtures, so that fixtures which are out of date do not
+// silently result in empty frames.
+//
+// The fixtures are synthetic: They were generated from the demo mode of the
+// Prometheus client, which doesn't implement PromQL, so that they only test the
+// conversion of the results into frames. Run "go test ./pkg/plugin -run
+// TestGoldenResponses -record <prometheus-url>" to record the fixtures from a
+// real Prometheus instance and "-update" to update the golden files, e.g. after
+// adding a new test case. Refactorings of the graph generation should never
+// require an update.
+func TestGoldenResponses(t *testing.T) {
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: to.Add(-time.Hour), To: to}
+
+	for _, tc := range []struct {
+		name      string
+		queryType string
+		model     map[string]any
+		settings  string
+	}{
+		{name: "namespaces", queryType: models.QueryTypeNamespaces, model: map[string]any{}},
+		{name: "applications", queryType: models.QueryTypeApplications, model: map[string]any{"namespace": "bookinfo"}},
+		{name: "workloads", queryType: models.QueryTypeWorkloads, model: map[string]any{"namespace": "bookinfo"}},
+		{name: "filters", queryType: models.QueryTypeFilters, model: map[string]any{"namespace": "bookinfo", "filterType": "source"}},
+		{name: "applicationgraph", queryType: models.QueryTypeApplicationGraph, model: map[string]any{"namespace": "bookinfo", "application": "reviews", "metrics": goldenGraphMetrics, "depth": 2}},
+		{name: "workloadgraph", queryType: models.QueryTypeWorkloadGraph, model: map[string]any{"namespace": "shop", "workload": "frontend", "metrics": goldenGraphMetrics, "locality": true}},
+		{name: "multiclustergraph", queryType: models.QueryTypeWorkloadGraph, model: map[string]any{"namespace": "shop", "workload": "checkout", "metrics": []string{models.MetricGRPCRequests, models.MetricHTTPRequests, models.MetricHTTPRequestDuration, models.MetricTCPSentBytes, models.MetricTCPReceivedBytes}}, settings: `{"istioMultiCluster": true}`},
+		{name: "namespacegraph", queryType: models.QueryTypeNamespaceGraph, model: map[string]any{"namespace": "bookinfo", "metrics": goldenGraphMetrics, "idleNodes": true, "detectIssues": true}},
+		{name: "servicegraph", queryType: models.QueryTypeServiceGraph, model: map[string]any{"namespace": "bookinfo", "service": "reviews", "metrics": goldenGraphMetrics}},
+		{name: "canary", queryType: models.QueryTypeCanary, model: map[string]any{"namespace": "bookinfo", "application": "reviews", "baselineVersion": "v2", "canaryVersion": "v3"}},
+		{name: "namespacematrix", queryType: models.QueryTypeNamespaceMatrix, model: map[string]any{}},
+		{name: "upstreams", queryType: models.QueryTypeUpstreams, model: map[string]any{"namespace": "shop", "workload": "checkout"}},
+		{name: "downstreams", queryType: models.QueryTypeDownstreams, model: map[string]any{"namespace": "shop", "workload": "checkout"}},
+		{name: "path", queryType: models.QueryTypePath, model: map[string]any{"sourceNamespace": "istio-system", "sourceWorkload": "istio-ingressgateway", "destinationNamespace": "bookinfo", "destinationWorkload": "ratings-v1", "metrics": goldenGraphMetrics, "depth": 4}},
+		{name: "egress", queryType: models.QueryTypeEgress, model: map[string]any{"namespace": "shop"}},
+		{name: "ingress", queryType: models.QueryTypeIngress, model: map[string]any{"namespace": "istio-system"}},
+		{name: "healthscore", queryType: models.QueryTypeHealthScore, model: map[string]any{"namespace": "bookinfo"}},
+		{name: "mtlscoverage", queryType: models.QueryTypeMTLSCoverage, model: map[string]any{}},
+		{name: "latencyheatmap", queryType: models.QueryTypeLatencyHeatmap, model: map[string]any{"namespace": "bookinfo", "service": "reviews"}},
+		{name: "rollouts", queryType: models.QueryTypeRollouts, model: map[string]any{"namespace": "bookinfo", "application": "reviews"}},
+		{name: "errorbudget", queryType: models.QueryTypeErrorBudget, model: map[string]any{"namespace": "bookinfo", "service": "reviews"}},
+		{name: "latencyslo", queryType: models.QueryTypeLatencySLO, model: map[string]any{"namespace": "bookinfo", "service": "reviews"}},
+		{name: "namespacehealth", queryType: models.QueryTypeNamespaceHealth, model: map[string]any{}},
+		{name: "workloadtraffic", queryType: models.QueryTypeWorkloadTraffic, model: map[string]any{"namespace": "shop", "workload": "cart"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fixtures := filepath.Join("testdata", "fixtures", tc.name+".json")
+
+			var client prometheus.Client
+			var recorder *prometheustest.Recorder
+			var mockClient *prometheustest.Client
+			if *record != "" {
+				prometheusClient, err := prometheus.NewClient(&models.PluginSettings{PrometheusUrl: *record})
+				require.NoError(t, err)
+				recorder = prometheustest.NewRecorder(prometheusClient)
+				client = recorder
+			} else {
+				var err error
+				mockClient, err = prometheustest.LoadFixtures(fixtures)
+				require.NoError(t, err)
+				client = mockClient
+			}
+
+			instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(cmp.Or(tc.settings, `{}`))})
+			require.NoError(t, err)
+			ds := instance.(*Datasource)
+			ds.prometheusClient = client
+
+			model, err := json.Marshal(tc.model)
+			require.NoError(t, err)
+
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", QueryType: tc.queryType, JSON: model, TimeRange: timeRange, Interval: time.Minute}},
+			})
+			require.NoError(t, err)
+
+			if recorder != nil {
+				recorded := recorder.Fixtures()
+				recorded.Source = *record
+				require.NoError(t, prometheustest.SaveFixtures(fixtures, recorded))
+			} else {
+				require.Empty(t, mockClient.Unmatched(), "queries without fixtures")
+			}
+
+			prometheustest.CheckGoldenResponse(t, filepath.Join("testdata", "golden"), tc.name, resp.Responses["A"], *update || *record != "")
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// healthScoreWeights are the weights of the error rate, the latency and the
+// mTLS coverage, which are used to calculate the health score of a namespace.
+type healthScoreWeights struct {
+	errors  float64
+	latency float64
+	mtls    float64
+}
+
+// handleHealthScoreQueries handles the queries to get the health score of all
+// namespaces. It uses the concurrent package to handle multiple queries in
+// parallel.
+func (d *Datasource) handleHealthScoreQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleHealthScoreQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleHealthScore, 10)
+}
+
+// handleHealthScore returns a single health score between 0 and 100 for each
+// namespace, which received requests in the selected time range. The score is
+// weighted from the following values:
+//   - The error rate, where an error rate equal to or above the configured
+//     error threshold results in a score of 0.
+//   - The share of requests, which were faster than the latency threshold.
+//   - The share of requests, which used mTLS.
+func (d *Datasource) handleHealthScore(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleHealthScore")
+	defer span.End()
+
+	var qm models.QueryModelHealthScore
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// If no weights are set, we use the default weights. It is possible to
+	// ignore a value by setting its weight to 0, as long as one of the other
+	// weights is set.
+	weights := healthScoreWeights{errors: qm.ErrorWeight, latency: qm.LatencyWeight, mtls: qm.MTLSWeight}
+	if weights.errors == 0 && weights.latency == 0 && weights.mtls == 0 {
+		weights = healthScoreWeights{errors: 0.5, latency: 0.3, mtls: 0.2}
+	}
+
+	latencyThreshold := qm.LatencyThreshold
+	if latencyThreshold == 0 {
+		latencyThreshold = 500
+	}
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	matchers := prometheus.Matchers{prometheus.Equal("reporter", "destination")}
+	matchers = append(matchers, d.namespaceMatchers(qm.Namespace, "destination_workload_namespace")...)
+
+	queries := map[string]prometheus.Expression{
+		"requests":       sumQuery("istio_requests_total", "increase", matchers, interval, "destination_workload_namespace", "request_protocol", "response_code", "grpc_response_status", "connection_security_policy"),
+		"durationBucket": d.durationBelow("increase", matchers, latencyThreshold, interval, "destination_workload_namespace"),
+		"durationCount":  d.durationCount("increase", matchers, interval, "destination_workload_namespace"),
+	}
+
+	type namespaceStats struct {
+		requests       float64
+		errors         float64
+		mtls           float64
+		durationBucket float64
+		durationCount  float64
+	}
+
+	stats := make(map[string]*namespaceStats)
+
+	// The queries are sorted by their metric, so that they are always sent in
+	// the same order.
+	batch := make([]prometheus.MetricsQuery, 0, len(queries))
+	for _, metric := range slices.Sorted(maps.Keys(queries)) {
+		d.logger.Debug("Get metrics", "query", queries[metric].String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+		batch = append(batch, prometheus.MetricsQuery{Metric: metric, Query: queries[metric], TimeRange: query.DataQuery.TimeRange})
+	}
+
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	for i, metrics := range results {
+		metric := batch[i].Metric
+		d.logger.Debug("Retrieved metrics", "query", batch[i].Query.String(), "metrics", metrics)
+
+		for _, m := range metrics {
+			namespace := m.Labels["destination_workload_namespace"]
+			if namespace == "" {
+				continue
+			}
+
+			if _, ok := stats[namespace]; !ok {
+				stats[namespace] = &namespaceStats{}
+			}
+
+			switch metric {
+			case "requests":
+				stats[namespace].requests += m.Value
+				if m.Labels["request_protocol"] == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+					stats[namespace].errors += m.Value
+				} else if m.Labels["request_protocol"] != "grpc" && isHTTPError(m.Labels["response_code"]) {
+					stats[namespace].errors += m.Value
+				}
+				if m.Labels["connection_security_policy"] == "mutual_tls" {
+					stats[namespace].mtls += m.Value
+				}
+			case "durationBucket":
+				stats[namespace].durationBucket += m.Value
+			case "durationCount":
+				stats[namespace].durationCount += m.Value
+			}
+		}
+	}
+
+	frame := data.NewFrame("Health Score")
+
+	for _, namespace := range slices.Sorted(maps.Keys(stats)) {
+		s := stats[namespace]
+		if s.requests == 0 {
+			continue
+		}
+
+		// If the duration metrics are not available for a namespace (e.g.
+		// because it only receives TCP traffic), we assume that all requests
+		// were faster than the latency threshold.
+		latencyShare := 1.0
+		if s.durationCount > 0 {
+			latencyShare = s.durationBucket / s.durationCount
+		}
+
+		score := getHealthScore(s.errors/s.requests*100, d.istioErrorThreshold, latencyShare, s.mtls/s.requests, weights)
+
+		frame.Fields = append(frame.Fields, data.NewField(namespace, nil, []float64{score}).SetConfig((&data.FieldConfig{
+			DisplayName: namespace,
+			Thresholds: &data.ThresholdsConfig{
+				Mode: data.ThresholdsModeAbsolute,
+				Steps: []data.Threshold{
+					data.NewThreshold(math.Inf(-1), "red", ""),
+					data.NewThreshold(50, "yellow", ""),
+					data.NewThreshold(80, "green", ""),
+				},
+			},
+		}).SetMin(0).SetMax(100)))
+	}
+
+	frame.SetMeta(&data.FrameMeta{
+		Type: data.FrameTypeNumericWide,
+	})
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// getHealthScore returns the health score between 0 and 100 for the given
+// error rate (in percent), the share of requests faster than the latency
+// threshold and the share of requests using mTLS. An error rate equal to or
+// above the error threshold results in an error score of 0.
+func getHealthScore(errorRate, errorThreshold, latencyShare, mtlsShare float64, weights healthScoreWeights) float64 {
+	errorScore := 1.0
+	if errorThreshold > 0 {
+		errorScore = max(0, 1-errorRate/errorThreshold)
+	} else if errorRate > 0 {
+		errorScore = 0
+	}
+
+	totalWeight := weights.errors + weights.latency + weights.mtls
+	if totalWeight == 0 {
+		return 0
+	}
+
+	return (errorScore*weights.errors + min(latencyShare, 1)*weights.latency + min(mtlsShare, 1)*weights.mtls) / totalWeight * 100
+}
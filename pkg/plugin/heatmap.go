@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// frameTypeHeatmapRows is the frame type, which is used by Grafana for
+// heatmaps, where each numeric field is a bucket. The type is not defined in
+// the plugin SDK, so we have to define it on our own.
+const frameTypeHeatmapRows data.FrameType = "heatmap-rows"
+
+// handleLatencyHeatmapQueries handles the queries to get the latency
+// distribution of a service. It uses the concurrent package to handle multiple
+// queries in parallel.
+func (d *Datasource) handleLatencyHeatmapQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleLatencyHeatmapQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleLatencyHeatmap, 10)
+}
+
+// handleLatencyHeatmap returns the distribution of the request durations of a
+// service as heatmap. If a source workload is selected, only the requests from
+// this workload are used, so that the distribution of a single edge can be
+// shown.
+func (d *Datasource) handleLatencyHeatmap(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleLatencyHeatmap")
+	defer span.End()
+
+	var qm models.QueryModelLatencyHeatmap
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// The heatmap is generated from the "le" label of the buckets, which only
+	// exists for classic histograms.
+	if d.istioNativeHistograms {
+		err := fmt.Errorf("the latency heatmap is not supported for native histograms")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// The step for the range queries is based on the interval of the query.
+	// The rate window should be at least one minute, so that we always have
+	// enough samples to calculate the rate.
+	step := query.DataQuery.Interval
+	if step <= 0 {
+		step = time.Minute
+	}
+	window := int64(max(step, time.Minute).Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_service_namespace", qm.Namespace), prometheus.Equal("destination_service_name", qm.Service)}
+	if qm.SourceNamespace != "" && qm.SourceWorkload != "" {
+		selector = append(selector, prometheus.Equal("source_workload_namespace", qm.SourceNamespace), prometheus.Equal("source_workload", qm.SourceWorkload))
+	}
+
+	q := sumQuery(d.durationMetricName("bucket"), "rate", selector, window, "le")
+
+	d.logger.Debug("Get time series", "query", q.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To, "step", step)
+	timeSeries, err := d.prometheusClient.GetTimeSeries(ctx, "", q, query.DataQuery.TimeRange, step)
+	if err != nil {
+		d.logger.Error("Failed to get time series", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, bucketsToHeatmapFrame(timeSeries))
+
+	return response
+}
+
+// bucketsToHeatmapFrame converts the given cumulative histogram buckets into a
+// heatmap frame. The frame contains a time field and one field per bucket,
+// ordered by the upper bound of the bucket. Since the Prometheus buckets are
+// cumulative, we subtract the value of the previous bucket, so that each field
+// only contains the requests of its own bucket.
+func bucketsToHeatmapFrame(timeSeries []prometheus.TimeSeries) *data.Frame {
+	type bucket struct {
+		le     string
+		bound  float64
+		values map[time.Time]float64
+	}
+
+	var buckets []bucket
+	var timestamps []time.Time
+
+	for _, ts := range timeSeries {
+		bound, err := strconv.ParseFloat(ts.Labels["le"], 64)
+		if err != nil {
+			continue
+		}
+
+		b := bucket{le: ts.Labels["le"], bound: bound, values: make(map[time.Time]float64)}
+		for i, timestamp := range ts.Timestamps {
+			if math.IsNaN(ts.Values[i]) {
+				continue
+			}
+			b.values[timestamp] = ts.Values[i]
+			timestamps = append(timestamps, timestamp)
+		}
+		buckets = append(buckets, b)
+	}
+
+	slices.SortFunc(buckets, func(a, b bucket) int {
+		if a.bound < b.bound {
+			return -1
+		} else if a.bound > b.bound {
+			return 1
+		}
+		return 0
+	})
+	slices.SortFunc(timestamps, func(a, b time.Time) int { return a.Compare(b) })
+	timestamps = slices.Compact(timestamps)
+
+	frame := data.NewFrame("Heatmap", data.NewField("time", nil, timestamps))
+
+	for i, b := range buckets {
+		values := make([]float64, len(timestamps))
+		for j, timestamp := range timestamps {
+			values[j] = b.values[timestamp]
+			if i > 0 {
+				values[j] = max(0, values[j]-buckets[i-1].values[timestamp])
+			}
+		}
+
+		frame.Fields = append(frame.Fields, data.NewField(b.le, data.Labels{"le": b.le}, values).SetConfig(&data.FieldConfig{DisplayNameFromDS: b.le}))
+	}
+
+	frame.SetMeta(&data.FrameMeta{
+		Type: frameTypeHeatmapRows,
+	})
+
+	return frame
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// responseClasses are the response classes, which are returned as columns by
+// the ingress query.
+var responseClasses = []string{"2xx", "3xx", "4xx", "5xx"}
+
+// handleIngressQueries handles the queries to get the traffic, which enters
+// the mesh via the ingress gateways. It uses the concurrent package to handle
+// multiple queries in parallel.
+func (d *Datasource) handleIngressQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleIngressQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleIngress, 10)
+}
+
+// handleIngress returns a table with all services, which received traffic from
+// an ingress gateway in the selected time range. For each service the request
+// rate, the error rate and the request rate per response class (2xx, 3xx, 4xx
+// and 5xx) is returned. The metrics reported by the gateway are used, so that
+// requests which never reached a service (e.g. because of a missing route) are
+// also included.
+func (d *Datasource) handleIngress(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleIngress")
+	defer span.End()
+
+	var qm models.QueryModelIngress
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Regexp("source_workload", ".*ingressgateway.*")}
+	if qm.Gateway != "" {
+		selector = prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Equal("source_workload", qm.Gateway)}
+	}
+	selector = append(selector, d.namespaceMatchers(qm.Namespace, "source_workload_namespace")...)
+
+	q := sumQuery("istio_requests_total", "increase", selector, interval, "destination_service", "request_protocol", "response_code", "grpc_response_status")
+
+	d.logger.Debug("Get metrics", "query", q.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+	metrics, err := d.prometheusClient.GetMetrics(ctx, "", q, query.DataQuery.TimeRange)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+	d.logger.Debug("Retrieved metrics", "query", q.String(), "metrics", metrics)
+
+	requests := make(map[string]float64)
+	errors := make(map[string]float64)
+	classes := make(map[string]map[string]float64)
+
+	for _, m := range metrics {
+		service := m.Labels["destination_service"]
+
+		requests[service] += m.Value
+		if m.Labels["request_protocol"] == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+			errors[service] += m.Value
+		} else if m.Labels["request_protocol"] != "grpc" && isHTTPError(m.Labels["response_code"]) {
+			errors[service] += m.Value
+		}
+
+		if _, ok := classes[service]; !ok {
+			classes[service] = make(map[string]float64)
+		}
+		if class := httpResponseClass(m.Labels["response_code"]); class != "" {
+			classes[service][class] += m.Value
+		}
+	}
+
+	services := slices.Sorted(maps.Keys(requests))
+
+	var rps []float64
+	var errorRates []float64
+	for _, service := range services {
+		rps = append(rps, requests[service]/float64(interval))
+		if requests[service] > 0 {
+			errorRates = append(errorRates, errors[service]/requests[service]*100)
+		} else {
+			errorRates = append(errorRates, 0)
+		}
+	}
+
+	frame := data.NewFrame(
+		"Ingress",
+		data.NewField("values", nil, services).SetConfig(&data.FieldConfig{DisplayName: "Service"}),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
+		data.NewField("err", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error", Unit: "percent"}),
+	)
+
+	for _, class := range responseClasses {
+		var classRps []float64
+		for _, service := range services {
+			classRps = append(classRps, classes[service][class]/float64(interval))
+		}
+
+		frame.Fields = append(frame.Fields, data.NewField(class, nil, classRps).SetConfig(&data.FieldConfig{Unit: "reqps"}))
+	}
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
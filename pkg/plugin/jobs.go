@@ -0,0 +1,361 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	// graphJobTimeout is the maximum duration of a graph job. It is much
+	// higher than the timeout of a query, because the jobs are used for graphs
+	// which can not be generated within a query.
+	graphJobTimeout = 10 * time.Minute
+	// graphJobTTL is the duration for which the result of a finished graph job
+	// is cached.
+	graphJobTTL = time.Hour
+	// maxGraphJobs is the maximum number of graph jobs per organization.
+	maxGraphJobs = 50
+)
+
+// The status of a graph job.
+const (
+	graphJobStatusRunning = "running"
+	graphJobStatusDone    = "done"
+	graphJobStatusFailed  = "failed"
+)
+
+// graphJob is a graph, which is generated in the background. The "Progress"
+// contains the current stage of the graph generation, e.g. "hop 2 of 3".
+type graphJob struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	Progress   string    `json:"progress,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Namespace  string    `json:"namespace"`
+	App        string    `json:"app,omitempty"`
+	Workload   string    `json:"workload,omitempty"`
+	From       int64     `json:"from"`
+	To         int64     `json:"to"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+	Edges      int       `json:"edges"`
+	Nodes      int       `json:"nodes"`
+
+	owner     graphJobOwner
+	cancel    context.CancelFunc
+	timeRange backend.TimeRange
+	edges     map[string]models.Edge
+	nodes     map[string]models.Node
+}
+
+// graphJobOwner is the organization and the user, which started a graph job.
+// A job can only be accessed by its owner, because the graph can contain data,
+// which other users are not allowed to see, e.g. when the user is forwarded to
+// Prometheus.
+type graphJobOwner struct {
+	orgID int64
+	login string
+}
+
+// graphJobOwnerFromContext returns the owner for the organization and the user
+// of the given context.
+func graphJobOwnerFromContext(ctx context.Context) graphJobOwner {
+	owner := graphJobOwner{orgID: backend.PluginConfigFromContext(ctx).OrgID}
+	if user := backend.UserFromContext(ctx); user != nil {
+		owner.login = user.Login
+	}
+	return owner
+}
+
+// graphJobStore stores the graph jobs of all organizations by their id. The
+// store is shared by the datasources of all organizations, so that each job is
+// only returned to its owner. The jobs are only kept in memory.
+type graphJobStore struct {
+	mu      sync.RWMutex
+	jobs    map[string]*graphJob
+	stopped bool
+}
+
+func newGraphJobStore() *graphJobStore {
+	return &graphJobStore{jobs: make(map[string]*graphJob)}
+}
+
+// start adds a new job to the store and removes all expired jobs. If the
+// maximum number of jobs of the organization of the job is reached or if the
+// store was stopped, an error is returned.
+func (s *graphJobStore) start(job *graphJob, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return fmt.Errorf("datasource is disposed")
+	}
+
+	jobs := 0
+	for id, j := range s.jobs {
+		if j.Status != graphJobStatusRunning && now.Sub(j.FinishedAt) > graphJobTTL {
+			delete(s.jobs, id)
+		} else if j.owner.orgID == job.owner.orgID {
+			jobs++
+		}
+	}
+	if jobs >= maxGraphJobs {
+		return fmt.Errorf("maximum number of %d graph jobs reached", maxGraphJobs)
+	}
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// get returns a copy of the job with the given id, so that the job can be
+// used without holding the lock. Jobs of another owner are not returned.
+func (s *graphJobStore) get(owner graphJobOwner, id string) (graphJob, bool) {
+	if s == nil {
+		return graphJob{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.owner != owner {
+		return graphJob{}, false
+	}
+	return *job, true
+}
+
+func (s *graphJobStore) list(owner graphJobOwner) []graphJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]graphJob, 0, len(s.jobs))
+	for _, id := range slices.Sorted(maps.Keys(s.jobs)) {
+		if s.jobs[id].owner == owner {
+			jobs = append(jobs, *s.jobs[id])
+		}
+	}
+	return jobs
+}
+
+func (s *graphJobStore) update(id string, fn func(job *graphJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// delete removes the job with the given id and cancels it, if it is still
+// running. Jobs of another owner are not removed.
+func (s *graphJobStore) delete(owner graphJobOwner, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.owner != owner {
+		return false
+	}
+
+	job.cancel()
+	delete(s.jobs, id)
+	return true
+}
+
+// stop cancels all running jobs and rejects new jobs. The jobs are kept in the
+// store and are marked as failed by their goroutines.
+func (s *graphJobStore) stop() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+
+	for _, job := range s.jobs {
+		if job.Status == graphJobStatusRunning {
+			job.cancel()
+		}
+	}
+}
+
+// result returns a copy of the edges and nodes and the time range of a
+// finished job, because the graph handler modifies the edges and nodes, e.g.
+// when it is compared with an expected topology.
+func (s *graphJobStore) result(owner graphJobOwner, id string) (map[string]models.Edge, map[string]models.Node, backend.TimeRange, error) {
+	job, ok := s.get(owner, id)
+	if !ok {
+		return nil, nil, backend.TimeRange{}, fmt.Errorf("graph job %q not found", id)
+	}
+
+	switch job.Status {
+	case graphJobStatusRunning:
+		return nil, nil, backend.TimeRange{}, fmt.Errorf("graph job %q is still running (%s)", id, job.Progress)
+	case graphJobStatusFailed:
+		return nil, nil, backend.TimeRange{}, fmt.Errorf("graph job %q failed: %s", id, job.Error)
+	}
+
+	return maps.Clone(job.edges), maps.Clone(job.nodes), job.timeRange, nil
+}
+
+// graphProgressKey is the context key for the function, which is called by
+// "getGraph" to report the current stage of the graph generation.
+type graphProgressKey struct{}
+
+func withGraphProgress(ctx context.Context, fn func(stage string)) context.Context {
+	return context.WithValue(ctx, graphProgressKey{}, fn)
+}
+
+func reportGraphProgress(ctx context.Context, stage string) {
+	if fn, ok := ctx.Value(graphProgressKey{}).(func(stage string)); ok {
+		fn(stage)
+	}
+}
+
+// handleGraphJobsResource manages the graph jobs of the user of the request,
+// which are used to generate very large graphs in the background:
+// - POST starts a new job for the graph selected via the same query parameters
+// as for the "graph/kiali" resource and an optional comma separated list of
+// "metrics" (by default the metrics of the "graph/kiali" resource are used). In
+// contrast to the "graph/kiali" resource the namespace is optional, so that
+// mesh-wide graphs can be generated. It returns the job, where the "id" can be
+// used in the "job" option of a graph query, when the job is done.
+// - GET returns the job with the given "id" or all jobs if no id is set.
+// - DELETE cancels and removes the job with the given "id".
+func (d *Datasource) handleGraphJobsResource(w http.ResponseWriter, r *http.Request) {
+	owner := graphJobOwnerFromContext(r.Context())
+	d = d.forOrg(owner.orgID)
+	id := r.URL.Query().Get("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			d.writeJSON(w, http.StatusOK, d.graphJobs.list(owner))
+			return
+		}
+
+		job, ok := d.graphJobs.get(owner, id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("graph job %q not found", id), http.StatusNotFound)
+			return
+		}
+		d.writeJSON(w, http.StatusOK, job)
+
+	case http.MethodPost:
+		options, timeRange, status, err := d.resourceGraphOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		options.metrics = kialiGraphMetrics
+		if metrics := r.URL.Query().Get("metrics"); metrics != "" {
+			options.metrics = strings.Split(metrics, ",")
+		}
+		for _, metric := range options.metrics {
+			if _, ok := d.graphQueryTemplate(metric); !ok {
+				http.Error(w, fmt.Sprintf("invalid metric %q", metric), http.StatusBadRequest)
+				return
+			}
+		}
+
+		job, err := d.startGraphJob(r.Context(), options, timeRange)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		d.writeJSON(w, http.StatusAccepted, job)
+
+	case http.MethodDelete:
+		if !d.graphJobs.delete(owner, id) {
+			http.Error(w, fmt.Sprintf("graph job %q not found", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startGraphJob starts the generation of the graph in the background and
+// returns the created job. The job is owned by the organization and the user of
+// the given context. It uses the values of the context (e.g. the forwarded
+// cookies), but it is not canceled when the request is done. The goroutine of
+// the job is part of the background tasks of the datasource, so that "Dispose"
+// waits until the canceled job is done.
+func (d *Datasource) startGraphJob(ctx context.Context, options graphOptions, timeRange backend.TimeRange) (graphJob, error) {
+	owner := graphJobOwnerFromContext(ctx)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), graphJobTimeout)
+
+	job := &graphJob{
+		ID:        rand.Text(),
+		Status:    graphJobStatusRunning,
+		Namespace: options.namespace,
+		App:       options.application,
+		Workload:  options.workload,
+		From:      timeRange.From.UnixMilli(),
+		To:        timeRange.To.UnixMilli(),
+		StartedAt: time.Now(),
+		owner:     owner,
+		cancel:    cancel,
+		timeRange: timeRange,
+	}
+	d.background.Add(1)
+	if err := d.graphJobs.start(job, job.StartedAt); err != nil {
+		d.background.Done()
+		cancel()
+		return graphJob{}, err
+	}
+	d.logger.Info("Graph job started", "id", job.ID, "namespace", options.namespace, "app", options.application, "workload", options.workload)
+
+	// The job is copied before the graph generation is started, because the
+	// job is modified by the goroutine.
+	started := *job
+
+	go func() {
+		defer d.background.Done()
+		defer cancel()
+
+		ctx = withGraphProgress(ctx, func(stage string) {
+			d.graphJobs.update(started.ID, func(job *graphJob) { job.Progress = stage })
+		})
+
+		edges, nodes, _, err := d.getGraph(ctx, options, timeRange)
+
+		d.graphJobs.update(started.ID, func(job *graphJob) {
+			job.FinishedAt = time.Now()
+			job.Progress = ""
+			if err != nil {
+				job.Status = graphJobStatusFailed
+				job.Error = err.Error()
+				return
+			}
+			job.Status = graphJobStatusDone
+			job.Edges = len(edges)
+			job.Nodes = len(nodes)
+			job.edges = edges
+			job.nodes = nodes
+		})
+
+		if err != nil {
+			d.logger.Error("Graph job failed", "id", started.ID, "error", err.Error())
+		} else {
+			d.logger.Info("Graph job finished", "id", started.ID, "duration", time.Since(started.StartedAt).String(), "edges", len(edges), "nodes", len(nodes))
+		}
+	}()
+
+	return started, nil
+}
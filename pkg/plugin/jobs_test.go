@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleGraphJobsResource(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: client, graphJobs: newGraphJobStore(), background: &sync.WaitGroup{}, logger: log.DefaultLogger}
+
+	userContext := func(orgID int64, login string) context.Context {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID})
+		return backend.WithUser(ctx, &backend.User{Login: login})
+	}
+	requestAs := func(ctx context.Context, method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		d.handleGraphJobsResource(w, httptest.NewRequestWithContext(ctx, method, target, nil))
+		return w
+	}
+	request := func(method, target string) *httptest.ResponseRecorder {
+		return requestAs(userContext(1, "alice"), method, target)
+	}
+
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/graph/jobs?workload=reviews-v1").Code)
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/graph/jobs?namespace=bookinfo&metrics=httpRequests,unknown").Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/graph/jobs?id=unknown").Code)
+
+	w := request(http.MethodPost, "/graph/jobs?namespace=bookinfo&metrics=httpRequests&from=0&to=60000")
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var job graphJob
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+	require.NotEmpty(t, job.ID)
+	require.Equal(t, "bookinfo", job.Namespace)
+
+	require.Eventually(t, func() bool {
+		w := request(http.MethodGet, "/graph/jobs?id="+job.ID)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+		return job.Status != graphJobStatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, graphJobStatusDone, job.Status, job.Error)
+	require.Equal(t, 2, job.Edges)
+	require.Equal(t, 3, job.Nodes)
+
+	// The job can only be accessed by the user, which started the job, within
+	// the same organization.
+	for _, ctx := range []context.Context{userContext(1, "bob"), userContext(2, "alice")} {
+		require.Equal(t, http.StatusNotFound, requestAs(ctx, http.MethodGet, "/graph/jobs?id="+job.ID).Code)
+		require.JSONEq(t, `[]`, requestAs(ctx, http.MethodGet, "/graph/jobs").Body.String())
+		require.Equal(t, http.StatusNotFound, requestAs(ctx, http.MethodDelete, "/graph/jobs?id="+job.ID).Code)
+		require.ErrorContains(t, d.handleGraph(ctx, graphOptions{namespace: "bookinfo", job: job.ID}, backend.TimeRange{}).Error, "not found")
+	}
+
+	// The graph query uses the cached graph and the time range of the job
+	// instead of the time range of the query.
+	response := d.handleGraph(userContext(1, "alice"), graphOptions{namespace: "bookinfo", job: job.ID}, backend.TimeRange{From: time.Unix(1000, 0), To: time.Unix(2000, 0)})
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 2)
+	require.Equal(t, 2, response.Frames[0].Rows())
+	require.Equal(t, "1.00rps", response.Frames[0].Fields[3].At(1))
+
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/graph/jobs?id="+job.ID).Code)
+	require.ErrorContains(t, d.handleGraph(userContext(1, "alice"), graphOptions{namespace: "bookinfo", job: job.ID}, backend.TimeRange{}).Error, "not found")
+}
+
+func TestGraphJobStore(t *testing.T) {
+	now := time.Now()
+	store := newGraphJobStore()
+	owner := graphJobOwner{orgID: 1, login: "alice"}
+
+	require.NoError(t, store.start(&graphJob{ID: "running", Status: graphJobStatusRunning, owner: owner, cancel: func() {}}, now))
+	require.NoError(t, store.start(&graphJob{ID: "expired", Status: graphJobStatusDone, FinishedAt: now.Add(-2 * graphJobTTL), owner: owner, cancel: func() {}}, now))
+	require.NoError(t, store.start(&graphJob{ID: "failed", Status: graphJobStatusFailed, Error: "timeout", FinishedAt: now, owner: owner, cancel: func() {}}, now))
+
+	_, _, _, err := store.result(owner, "running")
+	require.ErrorContains(t, err, "is still running")
+	_, _, _, err = store.result(owner, "failed")
+	require.ErrorContains(t, err, "failed: timeout")
+
+	// Expired jobs are removed, when a new job is started.
+	require.NoError(t, store.start(&graphJob{ID: "done", Status: graphJobStatusDone, FinishedAt: now, edges: map[string]models.Edge{"a": {ID: "a"}}, owner: owner, cancel: func() {}}, now))
+	_, ok := store.get(owner, "expired")
+	require.False(t, ok)
+
+	edges, _, _, err := store.result(owner, "done")
+	require.NoError(t, err)
+	delete(edges, "a")
+	edges, _, _, err = store.result(owner, "done")
+	require.NoError(t, err)
+	require.Len(t, edges, 1)
+
+	// The jobs of other users are not returned and not removed.
+	other := graphJobOwner{orgID: 1, login: "bob"}
+	_, _, _, err = store.result(other, "done")
+	require.ErrorContains(t, err, "not found")
+	require.Empty(t, store.list(other))
+	require.False(t, store.delete(other, "done"))
+	require.Len(t, store.list(owner), 3)
+
+	// The maximum number of jobs is counted per organization.
+	for i := range maxGraphJobs - 3 {
+		require.NoError(t, store.start(&graphJob{ID: string(rune('a' + i)), Status: graphJobStatusRunning, owner: other, cancel: func() {}}, now))
+	}
+	require.ErrorContains(t, store.start(&graphJob{ID: "full", owner: owner, cancel: func() {}}, now), "maximum number")
+	require.NoError(t, store.start(&graphJob{ID: "org", Status: graphJobStatusRunning, owner: graphJobOwner{orgID: 2, login: "alice"}, cancel: func() {}}, now))
+
+	// After the store was stopped, all running jobs are canceled and no new
+	// jobs can be started.
+	canceled := false
+	require.NoError(t, store.start(&graphJob{ID: "canceled", Status: graphJobStatusRunning, owner: graphJobOwner{orgID: 3, login: "alice"}, cancel: func() { canceled = true }}, now))
+	store.stop()
+	require.True(t, canceled)
+	require.ErrorContains(t, store.start(&graphJob{ID: "stopped", owner: owner, cancel: func() {}}, now), "disposed")
+}
+
+func TestMeshWideGraphJob(t *testing.T) {
+	client := prometheustest.NewClient()
+	d := &Datasource{prometheusClient: client, graphJobs: newGraphJobStore(), background: &sync.WaitGroup{}, istioNamespaces: []string{"bookinfo", "shop"}, logger: log.DefaultLogger}
+
+	job, err := d.startGraphJob(context.Background(), graphOptions{metrics: []string{models.MetricHTTPRequests}}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)})
+	require.NoError(t, err)
+	require.Empty(t, job.Namespace)
+	d.background.Wait()
+
+	// The mesh-wide graph of an organization with allowed namespaces only
+	// contains the traffic of these namespaces.
+	require.Equal(t, []string{
+		`sum(increase(istio_requests_total{destination_workload_namespace=~"bookinfo|shop", request_protocol="http"}[60s])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, response_code) > 0`,
+		`sum(increase(istio_requests_total{source_workload_namespace=~"bookinfo|shop", request_protocol="http"}[60s])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, response_code) > 0`,
+	}, client.Queries())
+}
+
+// cancelClient blocks all metrics queries until their context is canceled.
+type cancelClient struct {
+	*prometheustest.Client
+	started chan struct{}
+}
+
+func (c cancelClient) GetMetricsBatch(ctx context.Context, queries []prometheus.MetricsQuery) ([][]prometheus.Metric, error) {
+	c.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDisposeWaitsForGraphJobs(t *testing.T) {
+	client := cancelClient{Client: prometheustest.NewClient(), started: make(chan struct{})}
+	d := &Datasource{prometheusClient: client, graphJobs: newGraphJobStore(), background: &sync.WaitGroup{}, logger: log.DefaultLogger}
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 1})
+
+	job, err := d.startGraphJob(ctx, graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)})
+	require.NoError(t, err)
+	<-client.started
+
+	d.Dispose()
+
+	job, ok := d.graphJobs.get(graphJobOwnerFromContext(ctx), job.ID)
+	require.True(t, ok)
+	require.Equal(t, graphJobStatusFailed, job.Status)
+	require.Contains(t, job.Error, "context canceled")
+}
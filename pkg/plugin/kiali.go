@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// kialiGraphMetrics are the metrics, which are used to generate a graph for the
+// "graph/kiali" resource.
+var kialiGraphMetrics = []string{
+	models.MetricGRPCRequests,
+	models.MetricGRPCRequestDuration,
+	models.MetricHTTPRequests,
+	models.MetricHTTPRequestDuration,
+	models.MetricTCPSentBytes,
+	models.MetricTCPReceivedBytes,
+}
+
+// kialiGraph is the graph in the JSON format of the Kiali graph API, so that
+// existing tooling, which consumes Kiali graphs, can also be used with the
+// plugin. Only the fields which can be derived from the Istio metrics are set.
+type kialiGraph struct {
+	Timestamp int64         `json:"timestamp"`
+	Duration  int64         `json:"duration"`
+	GraphType string        `json:"graphType"`
+	Elements  kialiElements `json:"elements"`
+}
+
+type kialiElements struct {
+	Nodes []kialiNodeWrapper `json:"nodes"`
+	Edges []kialiEdgeWrapper `json:"edges"`
+}
+
+type kialiNodeWrapper struct {
+	Data kialiNode `json:"data"`
+}
+
+type kialiNode struct {
+	ID        string                 `json:"id"`
+	NodeType  string                 `json:"nodeType"`
+	Cluster   string                 `json:"cluster"`
+	Namespace string                 `json:"namespace"`
+	Workload  string                 `json:"workload,omitempty"`
+	Service   string                 `json:"service,omitempty"`
+	Traffic   []kialiProtocolTraffic `json:"traffic,omitempty"`
+}
+
+type kialiEdgeWrapper struct {
+	Data kialiEdge `json:"data"`
+}
+
+type kialiEdge struct {
+	ID           string               `json:"id"`
+	Source       string               `json:"source"`
+	Target       string               `json:"target"`
+	ResponseTime string               `json:"responseTime,omitempty"`
+	Traffic      kialiProtocolTraffic `json:"traffic"`
+}
+
+type kialiProtocolTraffic struct {
+	Protocol  string                         `json:"protocol"`
+	Rates     map[string]string              `json:"rates"`
+	Responses map[string]kialiResponseDetail `json:"responses,omitempty"`
+}
+
+type kialiResponseDetail struct {
+	Flags map[string]string `json:"flags"`
+	Hosts map[string]string `json:"hosts"`
+}
+
+// handleKialiGraphResource returns the graph for a namespace, application or
+// workload in the JSON format of the Kiali graph API. The graph and the time
+// range are selected via the query parameters of the request, see
+// "resourceGraphOptions", where the namespace is required.
+func (d *Datasource) handleKialiGraphResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The resource handler is registered for the default datasource, so that
+	// we have to select the datasource for the organization of the request.
+	d = d.forOrg(backend.PluginConfigFromContext(r.Context()).OrgID)
+
+	options, timeRange, status, err := d.resourceGraphOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if options.namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+	options.metrics = kialiGraphMetrics
+
+	edges, nodes, _, err := d.getGraph(r.Context(), options, timeRange)
+	if err != nil {
+		d.logger.Error("Failed to get graph", "error", err.Error())
+		http.Error(w, fmt.Sprintf("failed to get graph: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(graphToKiali(edges, nodes, timeRange)); err != nil {
+		d.logger.Error("Failed to write Kiali graph", "error", err.Error())
+	}
+}
+
+// graphToKiali converts the edges and nodes of a graph into the format of the
+// Kiali graph API. The rates are requests (or bytes for TCP) per second and
+// the error rates and response shares are percentages, formatted like Kiali
+// does it.
+func graphToKiali(edges map[string]models.Edge, nodes map[string]models.Node, timeRange backend.TimeRange) kialiGraph {
+	interval := timeRange.Duration().Seconds()
+
+	graph := kialiGraph{
+		Timestamp: timeRange.To.Unix(),
+		Duration:  int64(interval),
+		GraphType: "workload",
+		Elements: kialiElements{
+			Nodes: []kialiNodeWrapper{},
+			Edges: []kialiEdgeWrapper{},
+		},
+	}
+
+	for _, node := range sortNodes(nodes, false) {
+		kn := kialiNode{
+			ID:        node.ID,
+			NodeType:  "workload",
+			Cluster:   cmp.Or(node.Cluster, "unknown"),
+			Namespace: node.Namespace,
+			Workload:  node.Name,
+		}
+		if node.Type == "Service" {
+			kn.NodeType = "service"
+			kn.Workload = ""
+			kn.Service = node.Name
+		}
+
+		httpIn := node.ServerHTTPRequestsSuccess + node.ServerHTTPRequestsError
+		httpOut := node.ClientHTTPRequestsSuccess + node.ClientHTTPRequestsError
+		if httpIn > 0 || httpOut > 0 {
+			rates := map[string]string{}
+			addKialiRate(rates, "httpIn", httpIn/interval)
+			addKialiRate(rates, "httpOut", httpOut/interval)
+
+			classes := make(map[string]float64)
+			for code, count := range node.ServerHTTPResponseCodes {
+				if class := httpResponseClass(code); class == "3xx" || class == "4xx" || class == "5xx" {
+					classes[class] += count
+				}
+			}
+			for class, count := range classes {
+				addKialiRate(rates, "httpIn"+class, count/interval)
+			}
+
+			kn.Traffic = append(kn.Traffic, kialiProtocolTraffic{Protocol: "http", Rates: rates})
+		}
+
+		grpcIn := node.ServerGRPCRequestsSuccess + node.ServerGRPCRequestsError
+		grpcOut := node.ClientGRPCRequestsSuccess + node.ClientGRPCRequestsError
+		if grpcIn > 0 || grpcOut > 0 {
+			rates := map[string]string{}
+			addKialiRate(rates, "grpcIn", grpcIn/interval)
+			addKialiRate(rates, "grpcInErr", node.ServerGRPCRequestsError/interval)
+			addKialiRate(rates, "grpcOut", grpcOut/interval)
+			kn.Traffic = append(kn.Traffic, kialiProtocolTraffic{Protocol: "grpc", Rates: rates})
+		}
+
+		if node.ServerTCPSentBytes > 0 || node.ClientTCPSentBytes > 0 {
+			rates := map[string]string{}
+			addKialiRate(rates, "tcpIn", node.ServerTCPSentBytes/interval)
+			addKialiRate(rates, "tcpOut", node.ClientTCPSentBytes/interval)
+			kn.Traffic = append(kn.Traffic, kialiProtocolTraffic{Protocol: "tcp", Rates: rates})
+		}
+
+		graph.Elements.Nodes = append(graph.Elements.Nodes, kialiNodeWrapper{Data: kn})
+	}
+
+	for _, edge := range sortEdges(edges, false) {
+		ke := kialiEdge{
+			ID:     edge.ID,
+			Source: edge.Source,
+			Target: edge.Destination,
+		}
+
+		// Kiali only supports one protocol per edge, so that we prefer HTTP
+		// over gRPC and gRPC over TCP, like the edge fields of the graph.
+		httpRequests := edge.HTTPRequestsSuccess + edge.HTTPRequestsError
+		grpcRequests := edge.GRPCRequestsSuccess + edge.GRPCRequestsError
+
+		switch {
+		case httpRequests > 0:
+			ke.Traffic = kialiProtocolTraffic{
+				Protocol: "http",
+				Rates: map[string]string{
+					"http":           kialiRate(httpRequests / interval),
+					"httpPercentErr": kialiPercent(edge.HTTPRequestsError / httpRequests),
+				},
+				Responses: kialiResponses(edge.HTTPResponseCodes, httpRequests, edge.DestinationService),
+			}
+			if isValidDuration(edge.HTTPRequestDuration) {
+				ke.ResponseTime = strconv.FormatFloat(edge.HTTPRequestDuration, 'f', 0, 64)
+			}
+		case grpcRequests > 0:
+			ke.Traffic = kialiProtocolTraffic{
+				Protocol: "grpc",
+				Rates: map[string]string{
+					"grpc":           kialiRate(grpcRequests / interval),
+					"grpcPercentErr": kialiPercent(edge.GRPCRequestsError / grpcRequests),
+				},
+				Responses: kialiResponses(edge.GRPCResponseCodes, grpcRequests, edge.DestinationService),
+			}
+			if isValidDuration(edge.GRPCRequestDuration) {
+				ke.ResponseTime = strconv.FormatFloat(edge.GRPCRequestDuration, 'f', 0, 64)
+			}
+		default:
+			ke.Traffic = kialiProtocolTraffic{
+				Protocol: "tcp",
+				Rates: map[string]string{
+					"tcp": kialiRate(edge.TCPSentBytes / interval),
+				},
+			}
+		}
+
+		graph.Elements.Edges = append(graph.Elements.Edges, kialiEdgeWrapper{Data: ke})
+	}
+
+	return graph
+}
+
+// kialiResponses returns the share of each response code of the requests of
+// an edge. The response flags are not available for all requests, so that they
+// are always set to "-".
+func kialiResponses(codes map[string]float64, requests float64, host string) map[string]kialiResponseDetail {
+	responses := make(map[string]kialiResponseDetail, len(codes))
+	for code, count := range codes {
+		if count <= 0 {
+			continue
+		}
+
+		detail := kialiResponseDetail{
+			Flags: map[string]string{"-": kialiPercent(count / requests)},
+			Hosts: map[string]string{},
+		}
+		if host != "" {
+			detail.Hosts[host] = kialiPercent(count / requests)
+		}
+		responses[code] = detail
+	}
+	return responses
+}
+
+// addKialiRate adds the given rate to the rates, if it is greater than zero,
+// because Kiali omits empty rates.
+func addKialiRate(rates map[string]string, name string, rate float64) {
+	if rate > 0 {
+		rates[name] = kialiRate(rate)
+	}
+}
+
+// kialiRate formats a rate with two decimals, like Kiali does it.
+func kialiRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', 2, 64)
+}
+
+// kialiPercent formats the given share (between 0 and 1) as percentage with
+// one decimal, like Kiali does it.
+func kialiPercent(share float64) string {
+	return strconv.FormatFloat(share*100, 'f', 1, 64)
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphToKiali(t *testing.T) {
+	edges := map[string]models.Edge{
+		"a": {ID: "a", Source: "workload/bookinfo/productpage-v1", Destination: "service/bookinfo/reviews", DestinationService: "reviews.bookinfo.svc.cluster.local", HTTPRequestsSuccess: 90, HTTPRequestsError: 10, HTTPResponseCodes: map[string]float64{"200": 90, "503": 10}, HTTPRequestDuration: 12.4},
+		"b": {ID: "b", Source: "workload/bookinfo/reviews-v1", Destination: "workload/data/mysql-v1", TCPSentBytes: 3600},
+	}
+	nodes := map[string]models.Node{
+		"workload/bookinfo/productpage-v1": {ID: "workload/bookinfo/productpage-v1", Type: "Workload", Name: "productpage-v1", Namespace: "bookinfo", ClientHTTPRequestsSuccess: 90, ClientHTTPRequestsError: 10},
+		"service/bookinfo/reviews":         {ID: "service/bookinfo/reviews", Type: "Service", Name: "reviews", Namespace: "bookinfo", Cluster: "east", ServerHTTPRequestsSuccess: 90, ServerHTTPRequestsError: 10, ServerHTTPResponseCodes: map[string]float64{"200": 90, "503": 10}},
+	}
+
+	graph := graphToKiali(edges, nodes, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(100, 0)})
+
+	require.Equal(t, int64(100), graph.Timestamp)
+	require.Equal(t, int64(100), graph.Duration)
+	require.Len(t, graph.Elements.Nodes, 2)
+	require.Equal(t, kialiNode{ID: "service/bookinfo/reviews", NodeType: "service", Cluster: "east", Namespace: "bookinfo", Service: "reviews", Traffic: []kialiProtocolTraffic{{Protocol: "http", Rates: map[string]string{"httpIn": "1.00", "httpIn5xx": "0.10"}}}}, graph.Elements.Nodes[0].Data)
+	require.Equal(t, kialiNode{ID: "workload/bookinfo/productpage-v1", NodeType: "workload", Cluster: "unknown", Namespace: "bookinfo", Workload: "productpage-v1", Traffic: []kialiProtocolTraffic{{Protocol: "http", Rates: map[string]string{"httpOut": "1.00"}}}}, graph.Elements.Nodes[1].Data)
+
+	require.Len(t, graph.Elements.Edges, 2)
+	require.Equal(t, kialiEdge{
+		ID:           "a",
+		Source:       "workload/bookinfo/productpage-v1",
+		Target:       "service/bookinfo/reviews",
+		ResponseTime: "12",
+		Traffic: kialiProtocolTraffic{
+			Protocol: "http",
+			Rates:    map[string]string{"http": "1.00", "httpPercentErr": "10.0"},
+			Responses: map[string]kialiResponseDetail{
+				"200": {Flags: map[string]string{"-": "90.0"}, Hosts: map[string]string{"reviews.bookinfo.svc.cluster.local": "90.0"}},
+				"503": {Flags: map[string]string{"-": "10.0"}, Hosts: map[string]string{"reviews.bookinfo.svc.cluster.local": "10.0"}},
+			},
+		},
+	}, graph.Elements.Edges[0].Data)
+	require.Equal(t, kialiProtocolTraffic{Protocol: "tcp", Rates: map[string]string{"tcp": "36.00"}}, graph.Elements.Edges[1].Data.Traffic)
+}
+
+func TestHandleKialiGraphResource(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger, orgs: map[int64]*Datasource{2: {logger: log.DefaultLogger, istioNamespaces: []string{"shop"}}}}
+
+	request := func(orgID int64, target string) *httptest.ResponseRecorder {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID})
+		w := httptest.NewRecorder()
+		d.handleKialiGraphResource(w, httptest.NewRequestWithContext(ctx, http.MethodGet, target, nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusBadRequest, request(1, "/graph/kiali").Code)
+	require.Equal(t, http.StatusBadRequest, request(1, "/graph/kiali?namespace=bookinfo&from=2000&to=1000").Code)
+	require.Equal(t, http.StatusForbidden, request(2, "/graph/kiali?namespace=bookinfo").Code)
+
+	w := request(1, "/graph/kiali?namespace=bookinfo&from=0&to=60000")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var graph kialiGraph
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&graph))
+	require.Equal(t, "workload", graph.GraphType)
+	require.Len(t, graph.Elements.Edges, 2)
+	require.Equal(t, "workload/bookinfo/productpage-v1", graph.Elements.Edges[1].Data.Source)
+	require.Equal(t, "service/bookinfo/reviews", graph.Elements.Edges[1].Data.Target)
+	require.Equal(t, map[string]string{"http": "1.00", "httpPercentErr": "0.0"}, graph.Elements.Edges[1].Data.Traffic.Rates)
+}
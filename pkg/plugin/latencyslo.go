@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// istioDurationBuckets are the bucket boundaries in milliseconds of the request
+// duration histogram of Istio, when no custom buckets are configured.
+var istioDurationBuckets = []float64{0.5, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 300000, 600000, 1800000, 3600000}
+
+// handleLatencySLOQueries handles the queries to get the share of requests,
+// which were faster than a latency threshold. It uses the concurrent package
+// to handle multiple queries in parallel.
+func (d *Datasource) handleLatencySLOQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleLatencySLOQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleLatencySLO, 10)
+}
+
+// handleLatencySLO returns the share of requests in percent, which were faster
+// than the latency threshold of the query, for each service as time series.
+// The share is calculated from the bucket of the request duration histogram,
+// which matches the threshold. If the "edges" option is set, one time series
+// per source workload and service is returned, so that the compliance of
+// single edges can be compared.
+func (d *Datasource) handleLatencySLO(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleLatencySLO")
+	defer span.End()
+
+	var qm models.QueryModelLatencySLO
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	latencyThreshold := qm.LatencyThreshold
+	if latencyThreshold == 0 {
+		latencyThreshold = 500
+	}
+
+	// The step for the range queries is based on the interval of the query.
+	// The rate window should be at least one minute, so that we always have
+	// enough samples to calculate the rate.
+	step := query.DataQuery.Interval
+	if step <= 0 {
+		step = time.Minute
+	}
+	window := int64(max(step, time.Minute).Seconds())
+
+	matchers := prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_service_namespace", qm.Namespace)}
+	if qm.Service != "" {
+		matchers = append(matchers, prometheus.Equal("destination_service_name", qm.Service))
+	}
+	if qm.SourceNamespace != "" && qm.SourceWorkload != "" {
+		matchers = append(matchers, prometheus.Equal("source_workload_namespace", qm.SourceNamespace), prometheus.Equal("source_workload", qm.SourceWorkload))
+	}
+
+	groupBy := []string{"destination_service_namespace", "destination_service_name"}
+	if qm.Edges {
+		groupBy = append(groupBy, "source_workload_namespace", "source_workload")
+	}
+
+	q := prometheus.Binary{LHS: d.durationFraction("rate", matchers, latencyThreshold, window, groupBy...), Operator: "*", RHS: prometheus.Number(100)}
+
+	d.logger.Debug("Get time series", "query", q.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To, "step", step)
+	timeSeries, err := d.prometheusClient.GetTimeSeries(ctx, "compliance", q, query.DataQuery.TimeRange, step)
+	if err != nil {
+		d.logger.Error("Failed to get time series", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// For classic histograms the threshold must be a bucket boundary, because
+	// otherwise no bucket matches the threshold and Prometheus doesn't return
+	// any time series. Instead of empty frames a notice is returned, because
+	// custom buckets can be configured in Istio, so that we can not reject
+	// other thresholds.
+	if len(timeSeries) == 0 && !d.istioNativeHistograms && !slices.Contains(istioDurationBuckets, latencyThreshold) {
+		frame := data.NewFrame("compliance")
+		frame.SetMeta(&data.FrameMeta{
+			Notices: []data.Notice{{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("No requests match the latency threshold of %sms, because it is not a bucket boundary of the default Istio request duration histogram: use one of %s", strconv.FormatFloat(latencyThreshold, 'f', -1, 64), formatBuckets(istioDurationBuckets)),
+			}},
+		})
+		return backend.DataResponse{Frames: data.Frames{frame}}
+	}
+
+	// Create one frame per service or edge. Windows without requests are
+	// dropped, because the share is NaN for them.
+	var response backend.DataResponse
+
+	for _, ts := range timeSeries {
+		ts = dropInvalidSamples(ts)
+
+		labels := data.Labels{"namespace": ts.Labels["destination_service_namespace"], "service": ts.Labels["destination_service_name"]}
+		displayName := fmt.Sprintf("%s.%s", ts.Labels["destination_service_name"], ts.Labels["destination_service_namespace"])
+		if qm.Edges {
+			labels["source_namespace"] = ts.Labels["source_workload_namespace"]
+			labels["source_workload"] = ts.Labels["source_workload"]
+			displayName = fmt.Sprintf("%s.%s -> %s", ts.Labels["source_workload"], ts.Labels["source_workload_namespace"], displayName)
+		}
+
+		frame := data.NewFrame(
+			"compliance",
+			data.NewField("time", nil, ts.Timestamps),
+			data.NewField("value", labels, ts.Values).SetConfig((&data.FieldConfig{
+				DisplayNameFromDS: displayName,
+				Unit:              "percent",
+			}).SetMin(0).SetMax(100)),
+		)
+		frame.SetMeta(&data.FrameMeta{
+			PreferredVisualization: data.VisTypeGraph,
+			Type:                   data.FrameTypeTimeSeriesMulti,
+		})
+
+		response.Frames = append(response.Frames, frame)
+	}
+
+	return response
+}
+
+// formatBuckets returns the given bucket boundaries as comma separated list.
+func formatBuckets(buckets []float64) string {
+	values := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		values = append(values, strconv.FormatFloat(bucket, 'f', -1, 64))
+	}
+	return strings.Join(values, ", ")
+}
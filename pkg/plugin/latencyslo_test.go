@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLatencySLO(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddTimeSeries(`le="250"`,
+			prometheus.TimeSeries{Timestamps: []time.Time{time.Unix(0, 0), time.Unix(60, 0)}, Values: []float64{99.5, math.NaN()}, Labels: map[string]string{"destination_service_namespace": "bookinfo", "destination_service_name": "reviews"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	response := d.handleLatencySLO(context.Background(), concurrent.Query{DataQuery: backend.DataQuery{
+		JSON:      []byte(`{"namespace": "bookinfo", "latencyThreshold": 250}`),
+		Interval:  time.Minute,
+		TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)},
+	}})
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 1)
+	require.Equal(t, 1, response.Frames[0].Fields[1].Len())
+	require.Equal(t, "reviews.bookinfo", response.Frames[0].Fields[1].Config.DisplayNameFromDS)
+	require.Equal(t, []string{`sum(rate(istio_request_duration_milliseconds_bucket{reporter="destination", destination_service_namespace="bookinfo", le="250"}[60s])) by (destination_service_namespace, destination_service_name) / sum(rate(istio_request_duration_milliseconds_count{reporter="destination", destination_service_namespace="bookinfo"}[60s])) by (destination_service_namespace, destination_service_name) * 100`}, client.Queries())
+
+	// A threshold, which is not a bucket boundary, returns a notice instead
+	// of an empty response.
+	response = d.handleLatencySLO(context.Background(), concurrent.Query{DataQuery: backend.DataQuery{
+		JSON:      []byte(`{"namespace": "bookinfo", "latencyThreshold": 300}`),
+		Interval:  time.Minute,
+		TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)},
+	}})
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 1)
+	require.Len(t, response.Frames[0].Meta.Notices, 1)
+	require.Contains(t, response.Frames[0].Meta.Notices[0].Text, "latency threshold of 300ms, because it is not a bucket boundary")
+}
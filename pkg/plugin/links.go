@@ -0,0 +1,265 @@
+package plugin
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// graphLinkContext returns the link context of a graph query. If an evaluation
+// time or a window is set, the graph isn't generated for the time range of the
+// panel, so that the relative time range of the panel is removed and the links
+// use the absolute time range of the graph instead.
+func graphLinkContext(links models.LinkContext, evaluationTime, window string) models.LinkContext {
+	if evaluationTime != "" || window != "" {
+		links.RawFrom = ""
+		links.RawTo = ""
+	}
+	return links
+}
+
+// dashboardLinkParams returns the query parameters for a link to a dashboard,
+// which should show the given time range. If the panel used a relative time
+// range, the relative time range is used instead of the absolute one, so that
+// the linked dashboard keeps refreshing like the panel. The time zone and the
+// "datasource" variable of the panel are added, when they are set.
+func dashboardLinkParams(links models.LinkContext, timeRange backend.TimeRange) string {
+	var params []string
+
+	if links.RawFrom != "" && links.RawTo != "" {
+		params = append(params, "from="+url.QueryEscape(links.RawFrom), "to="+url.QueryEscape(links.RawTo))
+	} else {
+		params = append(params, "from="+strconv.FormatInt(timeRange.From.UnixMilli(), 10), "to="+strconv.FormatInt(timeRange.To.UnixMilli(), 10))
+	}
+
+	if links.Timezone != "" {
+		params = append(params, "timezone="+url.QueryEscape(links.Timezone))
+	}
+	if links.DashboardDatasource != "" {
+		params = append(params, "var-datasource="+url.QueryEscape(links.DashboardDatasource))
+	}
+
+	return strings.Join(params, "&")
+}
+
+// getNodeLink returns the link to the Istio dashboard of the given node, where
+// the given query parameters for the time range and the configured additional
+// query parameters are appended. Depending on the
+// node type we link to the appropriate Istio dashboard with the correct
+// variables set.
+//   - Service dashboard: https://grafana.com/grafana/dashboards/7636-istio-service-dashboard/
+//   - Workload dashboard: https://grafana.com/grafana/dashboards/7630-istio-workload-dashboard/
+//
+// Gateways are linked to the gateway dashboard if it is configured and
+// otherwise to the workload dashboard. Nodes of other types are not linked.
+func (d *Datasource) getNodeLink(node models.Node, linkParams string) string {
+	linkParams = mergeDashboardParams(linkParams, dashboardExtraParams(d.istioDashboardParams, node.Cluster))
+
+	switch node.Type {
+	case "Service":
+		return fmt.Sprintf("%s&var-service=%s&%s", d.istioServiceDashboard, node.Service, linkParams)
+	case "Workload":
+		return fmt.Sprintf("%s&var-namespace=%s&var-workload=%s&%s", d.istioWorkloadDashboard, node.Namespace, node.Name, linkParams)
+	case "Gateway":
+		if d.istioGatewayDashboard != "" {
+			return fmt.Sprintf("%s&var-namespace=%s&var-gateway=%s&var-workload=%s&%s", d.istioGatewayDashboard, node.Namespace, strings.TrimSuffix(node.Name, gatewayWorkloadSuffix), node.Name, linkParams)
+		}
+		return fmt.Sprintf("%s&var-namespace=%s&var-workload=%s&%s", d.istioWorkloadDashboard, node.Namespace, node.Name, linkParams)
+	default:
+		return ""
+	}
+}
+
+// getEdgeLink returns the link to the configured edge dashboard for the given
+// edge, so that the dashboard can show the requests between the source and the
+// destination of the edge. The workload variables are only set for workloads
+// and gateways and the service variable only for edges with a destination
+// service. If no edge dashboard is configured, the edge is not linked. The
+// "{cluster}" placeholder of the additional query parameters is replaced with
+// the cluster of the destination.
+func (d *Datasource) getEdgeLink(edge models.Edge, linkParams string) string {
+	if d.istioEdgeDashboard == "" {
+		return ""
+	}
+
+	params := []string{"var-source_namespace=" + edge.SourceNamespace}
+	if edge.SourceType == "Workload" || edge.SourceType == "Gateway" {
+		params = append(params, "var-source_workload="+edge.SourceName)
+	}
+	params = append(params, "var-destination_namespace="+edge.DestinationNamespace)
+	if edge.DestinationType == "Workload" || edge.DestinationType == "Gateway" {
+		params = append(params, "var-destination_workload="+edge.DestinationName)
+	}
+	if edge.DestinationService != "" {
+		params = append(params, "var-destination_service="+edge.DestinationService)
+	}
+
+	linkParams = mergeDashboardParams(linkParams, dashboardExtraParams(d.istioDashboardParams, cmp.Or(edge.DestinationCluster, edge.SourceCluster)))
+
+	return fmt.Sprintf("%s&%s&%s", d.istioEdgeDashboard, strings.Join(params, "&"), linkParams)
+}
+
+// mergeDashboardParams appends the given additional query parameters to the
+// query parameters of the panel. The additional parameters take precedence
+// over the parameters of the panel, e.g. a "var-datasource" parameter for the
+// cluster of a node replaces the "datasource" variable of the panel.
+func mergeDashboardParams(linkParams, extraParams string) string {
+	if extraParams == "" {
+		return linkParams
+	}
+
+	var keys []string
+	for _, param := range strings.Split(extraParams, "&") {
+		key, _, _ := strings.Cut(param, "=")
+		keys = append(keys, key)
+	}
+
+	var params []string
+	for _, param := range strings.Split(linkParams, "&") {
+		if key, _, _ := strings.Cut(param, "="); !slices.Contains(keys, key) {
+			params = append(params, param)
+		}
+	}
+
+	return strings.Join(append(params, extraParams), "&")
+}
+
+// dashboardExtraParams returns the configured additional query parameters for
+// the links to the Istio dashboards, where the "{cluster}" placeholder is
+// replaced with the cluster of the node. Parameters with the placeholder are
+// skipped for nodes without a cluster, so that the dashboards fall back to the
+// default value of their variable.
+func dashboardExtraParams(params, cluster string) string {
+	if params == "" {
+		return ""
+	}
+
+	var result []string
+	for _, param := range strings.Split(params, "&") {
+		if param == "" {
+			continue
+		}
+		if strings.Contains(param, "{cluster}") {
+			if cluster == "" {
+				continue
+			}
+			param = strings.ReplaceAll(param, "{cluster}", url.QueryEscape(cluster))
+		}
+		result = append(result, param)
+	}
+
+	return strings.Join(result, "&")
+}
+
+// linkField is the field of a configured link in the nodes or edges frame of a
+// graph.
+type linkField struct {
+	link  models.IstioLink
+	field *data.Field
+}
+
+// addLinkFields adds a field for each configured link of the given target to
+// the given fields. The field contains the url of the link for each node or
+// edge and is referenced by the data link in its config, so that Grafana shows
+// all configured links in the context menu of a node or edge.
+func (d *Datasource) addLinkFields(fields *models.Fields, target string) []linkField {
+	var linkFields []linkField
+	for i, link := range d.istioLinks {
+		if link.Target != target {
+			continue
+		}
+
+		name := fmt.Sprintf("link_%d", i+1)
+		linkFields = append(linkFields, linkField{
+			link: link,
+			field: fields.Add(name, nil, []string{}, &data.FieldConfig{
+				DisplayName: link.Title,
+				Links: []data.DataLink{
+					{
+						Title: link.Title,
+						URL:   fmt.Sprintf("${__data.fields[%q]}", name),
+					},
+				},
+			}),
+		})
+	}
+	return linkFields
+}
+
+// getNodeLinkURL returns the url of the given configured link for the given
+// node, where the placeholders are replaced with the values of the node and
+// the time range of the graph.
+func getNodeLinkURL(link models.IstioLink, node models.Node, linkParams string, timeRange backend.TimeRange) string {
+	return models.ReplaceLinkPlaceholders(link.URL, func(placeholder string) string {
+		switch placeholder {
+		case "type":
+			return url.QueryEscape(node.Type)
+		case "name":
+			return url.QueryEscape(node.Name)
+		case "namespace":
+			return url.QueryEscape(node.Namespace)
+		case "cluster":
+			return url.QueryEscape(node.Cluster)
+		case "service":
+			return url.QueryEscape(node.Service)
+		default:
+			return timeRangeLinkPlaceholder(placeholder, linkParams, timeRange)
+		}
+	})
+}
+
+// getEdgeLinkURL returns the url of the given configured link for the given
+// edge, where the placeholders are replaced with the values of the source and
+// destination of the edge and the time range of the graph.
+func getEdgeLinkURL(link models.IstioLink, edge models.Edge, linkParams string, timeRange backend.TimeRange) string {
+	return models.ReplaceLinkPlaceholders(link.URL, func(placeholder string) string {
+		switch placeholder {
+		case "source_type":
+			return url.QueryEscape(edge.SourceType)
+		case "source_name":
+			return url.QueryEscape(edge.SourceName)
+		case "source_namespace":
+			return url.QueryEscape(edge.SourceNamespace)
+		case "source_cluster":
+			return url.QueryEscape(edge.SourceCluster)
+		case "destination_type":
+			return url.QueryEscape(edge.DestinationType)
+		case "destination_name":
+			return url.QueryEscape(edge.DestinationName)
+		case "destination_namespace":
+			return url.QueryEscape(edge.DestinationNamespace)
+		case "destination_cluster":
+			return url.QueryEscape(edge.DestinationCluster)
+		case "destination_service":
+			return url.QueryEscape(edge.DestinationService)
+		default:
+			return timeRangeLinkPlaceholder(placeholder, linkParams, timeRange)
+		}
+	})
+}
+
+// timeRangeLinkPlaceholder returns the value of the time range placeholders,
+// which can be used for nodes and edges. The "{from}" and "{to}" placeholders
+// are replaced with the timestamps in milliseconds and the "{timeRange}"
+// placeholder with the query parameters, which are also used for the links to
+// the Istio dashboards. Unknown placeholders are replaced with an empty string.
+func timeRangeLinkPlaceholder(placeholder, linkParams string, timeRange backend.TimeRange) string {
+	switch placeholder {
+	case "from":
+		return strconv.FormatInt(timeRange.From.UnixMilli(), 10)
+	case "to":
+		return strconv.FormatInt(timeRange.To.UnixMilli(), 10)
+	case "timeRange":
+		return linkParams
+	default:
+		return ""
+	}
+}
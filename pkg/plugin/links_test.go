@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboardLinkParams(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)}
+
+	require.Equal(t, "from=1000&to=2000", dashboardLinkParams(models.LinkContext{}, timeRange))
+	require.Equal(t, "from=now-1h&to=now&timezone=Europe%2FBerlin&var-datasource=prometheus", dashboardLinkParams(models.LinkContext{RawFrom: "now-1h", RawTo: "now", Timezone: "Europe/Berlin", DashboardDatasource: "prometheus"}, timeRange))
+	require.Equal(t, "from=1000&to=2000&timezone=utc", dashboardLinkParams(graphLinkContext(models.LinkContext{RawFrom: "now-1h", RawTo: "now", Timezone: "utc"}, "", "5m"), timeRange))
+}
+
+func TestGetNodeLink(t *testing.T) {
+	d := &Datasource{istioWorkloadDashboard: "/d/workload?orgId=1", istioDashboardParams: "var-cluster={cluster}&var-datasource=prometheus-{cluster}&var-qrep=destination"}
+
+	require.Equal(t, "/d/workload?orgId=1&var-namespace=bookinfo&var-workload=reviews-v1&from=now-1h&to=now&var-cluster=east&var-datasource=prometheus-east&var-qrep=destination", d.getNodeLink(models.Node{Type: "Workload", Namespace: "bookinfo", Name: "reviews-v1", Cluster: "east"}, "from=now-1h&to=now&var-datasource=panel"))
+	require.Equal(t, "/d/workload?orgId=1&var-namespace=bookinfo&var-workload=reviews-v1&from=now-1h&to=now&var-datasource=panel&var-qrep=destination", d.getNodeLink(models.Node{Type: "Workload", Namespace: "bookinfo", Name: "reviews-v1"}, "from=now-1h&to=now&var-datasource=panel"))
+	require.Empty(t, d.getNodeLink(models.Node{Type: "Unknown"}, "from=now-1h&to=now"))
+}
+
+func TestGetEdgeLink(t *testing.T) {
+	d := &Datasource{istioEdgeDashboard: "/d/edge?orgId=1", istioDashboardParams: "var-cluster={cluster}"}
+
+	require.Equal(t, "/d/edge?orgId=1&var-source_namespace=bookinfo&var-source_workload=productpage-v1&var-destination_namespace=bookinfo&var-destination_service=reviews.bookinfo.svc.cluster.local&from=now-1h&to=now&var-cluster=east", d.getEdgeLink(models.Edge{SourceType: "Workload", SourceName: "productpage-v1", SourceNamespace: "bookinfo", DestinationType: "Service", DestinationName: "reviews", DestinationNamespace: "bookinfo", DestinationService: "reviews.bookinfo.svc.cluster.local", DestinationCluster: "east"}, "from=now-1h&to=now"))
+	require.Equal(t, "/d/edge?orgId=1&var-source_namespace=bookinfo&var-destination_namespace=bookinfo&var-destination_workload=reviews-v1&var-destination_service=reviews.bookinfo.svc.cluster.local&from=now-1h&to=now", d.getEdgeLink(models.Edge{SourceType: "Service", SourceName: "reviews", SourceNamespace: "bookinfo", DestinationType: "Workload", DestinationName: "reviews-v1", DestinationNamespace: "bookinfo", DestinationService: "reviews.bookinfo.svc.cluster.local"}, "from=now-1h&to=now"))
+	require.Empty(t, (&Datasource{}).getEdgeLink(models.Edge{SourceType: "Workload"}, "from=now-1h&to=now"))
+}
+
+func TestGetLinkURL(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)}
+
+	nodeLink := models.IstioLink{Title: "Logs", URL: "https://logs.example.com/?query=namespace%3D{namespace}%20app%3D{name}&from={from}&to={to}&${__url_time_range}", Target: models.IstioLinkTargetNode}
+	require.Equal(t, "https://logs.example.com/?query=namespace%3Dbookinfo%20app%3Dreviews-v1&from=1000&to=2000&${__url_time_range}", getNodeLinkURL(nodeLink, models.Node{Type: "Workload", Namespace: "bookinfo", Name: "reviews-v1"}, "from=1000&to=2000", timeRange))
+
+	edgeLink := models.IstioLink{Title: "Traces", URL: "/explore?{timeRange}&source={source_name}.{source_namespace}&destination={destination_service}", Target: models.IstioLinkTargetEdge}
+	require.Equal(t, "/explore?from=now-1h&to=now&source=productpage-v1.bookinfo&destination=reviews.bookinfo.svc.cluster.local", getEdgeLinkURL(edgeLink, models.Edge{SourceName: "productpage-v1", SourceNamespace: "bookinfo", DestinationService: "reviews.bookinfo.svc.cluster.local"}, "from=now-1h&to=now", timeRange))
+}
+
+func TestAddLinkFields(t *testing.T) {
+	d := &Datasource{istioLinks: []models.IstioLink{
+		{Title: "Logs", URL: "/logs?namespace={namespace}", Target: models.IstioLinkTargetNode},
+		{Title: "Traces", URL: "/traces?source={source_name}", Target: models.IstioLinkTargetEdge},
+		{Title: "Runbook", URL: "/runbooks/{namespace}/{name}", Target: models.IstioLinkTargetNode},
+	}}
+
+	fields := models.Fields{}
+	linkFields := d.addLinkFields(&fields, models.IstioLinkTargetNode)
+
+	require.Len(t, fields, 2)
+	require.Len(t, linkFields, 2)
+	require.Equal(t, "link_1", fields[0].Name)
+	require.Equal(t, []data.DataLink{{Title: "Logs", URL: "${__data.fields[\"link_1\"]}"}}, fields[0].Config.Links)
+	require.Equal(t, "link_3", fields[1].Name)
+	require.Equal(t, "Runbook", linkFields[1].link.Title)
+}
@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// listCacheIdleTimeout is the duration after which a cached list, which wasn't
+// requested anymore, is removed from the cache and not refreshed anymore.
+const listCacheIdleTimeout = time.Hour
+
+// listCache contains the label values of the namespaces, applications and
+// workloads lists, which are refreshed in the background, so that the
+// dropdowns of the query editor open instantly, even when Prometheus is slow.
+// It is shared by the datasources of all organizations, because the namespaces
+// of an organization are filtered after the label values are returned.
+type listCache struct {
+	mu       sync.Mutex
+	interval time.Duration
+	entries  map[string]*listCacheEntry
+}
+
+// listCacheEntry are the cached label values for a label values query and the
+// duration of the time range of the query. The values are always refreshed for
+// a time range with this duration, which ends at the time of the refresh.
+type listCacheEntry struct {
+	query     prometheus.LabelValuesQuery
+	duration  time.Duration
+	values    []string
+	refreshed time.Time
+	accessed  time.Time
+}
+
+func newListCache(interval time.Duration) *listCache {
+	return &listCache{interval: interval, entries: make(map[string]*listCacheEntry)}
+}
+
+// listCacheKey returns the key of the cache entry for the given query and time
+// range. The duration is rounded to seconds, so that relative time ranges like
+// "now-1h" always use the same entry.
+func listCacheKey(query prometheus.LabelValuesQuery, timeRange backend.TimeRange) string {
+	return fmt.Sprintf("%s/%s/%d", query.Label, strings.Join(query.Matches(), ","), int64(timeRange.Duration().Seconds()))
+}
+
+// get returns the cached values for the given query and time range. The values
+// are only returned, if the time range ends within the refresh interval of the
+// last refresh, so that absolute time ranges in the past are never answered
+// from the cache.
+func (c *listCache) get(query prometheus.LabelValuesQuery, timeRange backend.TimeRange, now time.Time) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[listCacheKey(query, timeRange)]
+	if !ok || timeRange.To.Sub(entry.refreshed).Abs() > c.interval {
+		return nil, false
+	}
+
+	entry.accessed = now
+	return entry.values, true
+}
+
+// set adds the given values to the cache, if the time range of the query ends
+// within the refresh interval before now, i.e. if it is a relative time range.
+func (c *listCache) set(query prometheus.LabelValuesQuery, timeRange backend.TimeRange, values []string, now time.Time) {
+	if now.Sub(timeRange.To).Abs() > c.interval {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[listCacheKey(query, timeRange)] = &listCacheEntry{
+		query:     query,
+		duration:  timeRange.Duration(),
+		values:    values,
+		refreshed: timeRange.To,
+		accessed:  now,
+	}
+}
+
+// runListRefresh refreshes all cached lists in the refresh interval of the
+// cache, until the given context is canceled.
+func (d *Datasource) runListRefresh(ctx context.Context, prometheusClient prometheus.Client) {
+	ticker := time.NewTicker(d.listCache.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.refreshLists(ctx, prometheusClient, now)
+		}
+	}
+}
+
+// refreshLists gets the label values for all cached lists again, where the
+// time range ends at the given time. Lists which were not requested within the
+// "listCacheIdleTimeout" are removed. The lists are refreshed one after
+// another, so that the refresh doesn't cause a load spike on Prometheus. If a
+// refresh fails, the previous values are kept.
+func (d *Datasource) refreshLists(ctx context.Context, prometheusClient prometheus.Client, now time.Time) {
+	start := time.Now()
+
+	d.listCache.mu.Lock()
+	var entries []listCacheEntry
+	for key, entry := range d.listCache.entries {
+		if now.Sub(entry.accessed) > listCacheIdleTimeout {
+			delete(d.listCache.entries, key)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	d.listCache.mu.Unlock()
+
+	for _, entry := range entries {
+		timeRange := backend.TimeRange{From: now.Add(-entry.duration), To: now}
+
+		values, err := prometheusClient.GetLabelValues(ctx, entry.query, timeRange)
+		if err != nil {
+			d.logger.Warn("Failed to refresh list", "label", entry.query.Label, "matches", entry.query.Matches(), "error", err.Error())
+			continue
+		}
+
+		d.listCache.mu.Lock()
+		if cached, ok := d.listCache.entries[listCacheKey(entry.query, timeRange)]; ok {
+			cached.values = values
+			cached.refreshed = now
+		}
+		d.listCache.mu.Unlock()
+	}
+
+	d.logger.Debug("Refreshed lists", "lists", len(entries), "duration", time.Since(start))
+}
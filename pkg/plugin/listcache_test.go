@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCache(t *testing.T) {
+	now := time.Unix(3600, 0)
+	query := prometheus.LabelValuesQuery{Label: "destination_workload_namespace", Selectors: []prometheus.Selector{{Metric: "istio_requests_total"}}}
+
+	t.Run("should only cache relative time ranges", func(t *testing.T) {
+		cache := newListCache(time.Minute)
+
+		cache.set(query, backend.TimeRange{From: now.Add(-2 * time.Hour), To: now.Add(-time.Hour)}, []string{"bookinfo"}, now)
+		_, ok := cache.get(query, backend.TimeRange{From: now.Add(-2 * time.Hour), To: now.Add(-time.Hour)}, now)
+		require.False(t, ok)
+
+		cache.set(query, backend.TimeRange{From: now.Add(-time.Hour), To: now}, []string{"bookinfo"}, now)
+		values, ok := cache.get(query, backend.TimeRange{From: now.Add(-time.Hour + 30*time.Second), To: now.Add(30 * time.Second)}, now)
+		require.True(t, ok)
+		require.Equal(t, []string{"bookinfo"}, values)
+
+		_, ok = cache.get(query, backend.TimeRange{From: now.Add(-time.Hour + 2*time.Minute), To: now.Add(2 * time.Minute)}, now)
+		require.False(t, ok)
+	})
+
+	t.Run("should refresh and expire lists", func(t *testing.T) {
+		client := prometheustest.NewClient().AddLabelValues("destination_workload_namespace", "bookinfo", "shop")
+		d := &Datasource{prometheusClient: client, listCache: newListCache(time.Minute), logger: log.DefaultLogger}
+		d.listCache.set(query, backend.TimeRange{From: now.Add(-time.Hour), To: now}, []string{"bookinfo"}, now)
+
+		d.refreshLists(context.Background(), client, now.Add(time.Minute))
+		values, ok := d.listCache.get(query, backend.TimeRange{From: now.Add(time.Minute - time.Hour), To: now.Add(time.Minute)}, now.Add(time.Minute))
+		require.True(t, ok)
+		require.Equal(t, []string{"bookinfo", "shop"}, values)
+
+		d.refreshLists(context.Background(), client, now.Add(time.Minute+listCacheIdleTimeout+time.Second))
+		require.Empty(t, d.listCache.entries)
+	})
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"context"
+	"slices"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handleNamespaceMatrixQueries handles the queries to get the request rates
+// between all namespaces. It uses the concurrent package to handle multiple
+// queries in parallel.
+func (d *Datasource) handleNamespaceMatrixQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleNamespaceMatrixQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleNamespaceMatrix, 10)
+}
+
+// handleNamespaceMatrix returns a table, where each row is a source namespace
+// and each column is a destination namespace. The cells contain the request
+// rate from the source namespace to the destination namespace in the selected
+// time range.
+func (d *Datasource) handleNamespaceMatrix(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleNamespaceMatrix")
+	defer span.End()
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+	// For organizations with allowed namespaces only the requests between these
+	// namespaces are returned.
+	q := sumQuery("istio_requests_total", "increase", d.namespaceMatchers("", "source_workload_namespace", "destination_workload_namespace"), interval, "source_workload_namespace", "destination_workload_namespace")
+
+	d.logger.Debug("Get metrics", "query", q.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+	metrics, err := d.prometheusClient.GetMetrics(ctx, "", q, query.DataQuery.TimeRange)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+	d.logger.Debug("Retrieved metrics", "query", q.String(), "metrics", metrics)
+
+	var sources []string
+	var destinations []string
+	requests := make(map[string]map[string]float64)
+
+	for _, m := range metrics {
+		source := m.Labels["source_workload_namespace"]
+		destination := m.Labels["destination_workload_namespace"]
+
+		if _, ok := requests[source]; !ok {
+			requests[source] = make(map[string]float64)
+		}
+		requests[source][destination] += m.Value
+
+		sources = append(sources, source)
+		destinations = append(destinations, destination)
+	}
+
+	slices.Sort(sources)
+	sources = slices.Compact(sources)
+	slices.Sort(destinations)
+	destinations = slices.Compact(destinations)
+
+	frame := data.NewFrame(
+		"Matrix",
+		data.NewField("source", nil, sources).SetConfig(&data.FieldConfig{DisplayName: "Source"}),
+	)
+
+	for _, destination := range destinations {
+		var rps []float64
+		for _, source := range sources {
+			rps = append(rps, requests[source][destination]/float64(interval))
+		}
+
+		frame.Fields = append(frame.Fields, data.NewField(destination, nil, rps).SetConfig(&data.FieldConfig{Unit: "reqps"}))
+	}
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
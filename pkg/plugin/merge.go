@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// graphQueryTypes are the query types, which return a graph as "edges" and
+// "nodes" frames.
+var graphQueryTypes = []string{
+	models.QueryTypeApplicationGraph,
+	models.QueryTypeWorkloadGraph,
+	models.QueryTypeNamespaceGraph,
+	models.QueryTypeServiceGraph,
+	models.QueryTypePath,
+}
+
+// graphMergeModel contains the fields of a graph query, which are used to
+// merge the graphs of multiple queries.
+type graphMergeModel struct {
+	MergeWithRefIDs []string `json:"mergeWithRefIds"`
+}
+
+// mergeGraphResponses post-processes the responses of all graph queries in the
+// request:
+//   - The RefID is added to the names of the "edges" and "nodes" frames, so
+//     that the frames of multiple graph queries in a single panel do not
+//     collide.
+//   - If a query sets "mergeWithRefIds", the edges and nodes of the referenced
+//     graph queries are merged into its frames, so that a single topology is
+//     shown. Edges and nodes which are part of multiple graphs are only added
+//     once, with the values of the first graph. The frames of the referenced
+//     queries are removed from the response, so that they are not shown twice.
+func (d *Datasource) mergeGraphResponses(req *backend.QueryDataRequest, resp *backend.QueryDataResponse) *backend.QueryDataResponse {
+	if resp == nil {
+		return resp
+	}
+
+	merges := make(map[string][]string)
+	merged := make(map[string]bool)
+
+	for _, query := range req.Queries {
+		if !slices.Contains(graphQueryTypes, query.QueryType) {
+			continue
+		}
+
+		var qm graphMergeModel
+		if err := json.Unmarshal(query.JSON, &qm); err != nil || len(qm.MergeWithRefIDs) == 0 {
+			continue
+		}
+
+		for _, refID := range qm.MergeWithRefIDs {
+			if refID == query.RefID {
+				continue
+			}
+			if _, ok := resp.Responses[refID]; !ok {
+				d.logger.Warn("Failed to merge graph, query not found", "refId", query.RefID, "mergeWithRefId", refID)
+				continue
+			}
+			merges[query.RefID] = append(merges[query.RefID], refID)
+		}
+	}
+
+	responses := make(backend.Responses, len(resp.Responses))
+	for refID, response := range resp.Responses {
+		responses[refID] = response
+	}
+
+	for refID, refIDs := range merges {
+		response := resp.Responses[refID]
+		if response.Error != nil {
+			continue
+		}
+
+		frames := slices.Clone(response.Frames)
+		for _, mergeRefID := range refIDs {
+			mergeResponse := resp.Responses[mergeRefID]
+			if mergeResponse.Error != nil {
+				continue
+			}
+
+			for i, frame := range frames {
+				for _, mergeFrame := range mergeResponse.Frames {
+					if frame.Name == mergeFrame.Name {
+						frames[i] = mergeGraphFrames(frame, mergeFrame)
+					}
+				}
+			}
+			merged[mergeRefID] = true
+		}
+
+		response.Frames = frames
+		responses[refID] = response
+	}
+
+	// Remove the frames of all queries, which were merged into another query,
+	// except when they are merging other queries themselves.
+	for refID := range merged {
+		if _, ok := merges[refID]; ok {
+			continue
+		}
+		responses[refID] = backend.DataResponse{}
+	}
+
+	for _, query := range req.Queries {
+		response, ok := responses[query.RefID]
+		if !ok || !slices.Contains(graphQueryTypes, query.QueryType) {
+			continue
+		}
+		response.Frames = nameGraphFrames(response.Frames, query.RefID)
+		responses[query.RefID] = response
+	}
+
+	return &backend.QueryDataResponse{Responses: responses}
+}
+
+// nameGraphFrames returns copies of the given frames, where the RefID is added
+// to the name of the frames (e.g. "edges-A"). The frames are copied, because
+// the same frames might be returned for multiple deduplicated queries.
+func nameGraphFrames(frames data.Frames, refID string) data.Frames {
+	var named data.Frames
+	for _, frame := range frames {
+		frameCopy := *frame
+		frameCopy.Name = fmt.Sprintf("%s-%s", frame.Name, refID)
+		named = append(named, &frameCopy)
+	}
+	return named
+}
+
+// mergeGraphFrames returns a new frame with the rows of both frames. Rows of
+// the second frame with an id, which is already part of the first frame, are
+// skipped. The frames must have the same fields, which is always the case for
+// the frames of the graph queries, otherwise the first frame is returned.
+func mergeGraphFrames(a, b *data.Frame) *data.Frame {
+	if len(a.Fields) != len(b.Fields) || len(a.Fields) == 0 {
+		return a
+	}
+	for i := range a.Fields {
+		if a.Fields[i].Name != b.Fields[i].Name || a.Fields[i].Type() != b.Fields[i].Type() {
+			return a
+		}
+	}
+
+	merged := a.EmptyCopy()
+	merged.Meta = a.Meta
+	for i, field := range a.Fields {
+		merged.Fields[i].Config = field.Config
+	}
+
+	seen := make(map[string]bool)
+	for _, frame := range []*data.Frame{a, b} {
+		rows, _ := frame.RowLen()
+		for i := range rows {
+			id := fmt.Sprint(frame.Fields[0].CopyAt(i))
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			merged.AppendRow(frame.RowCopy(i)...)
+		}
+	}
+
+	return merged
+}
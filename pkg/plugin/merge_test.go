@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeGraphResponses(t *testing.T) {
+	graph := func(edges, nodes []string) backend.DataResponse {
+		var sources []string
+		for range edges {
+			sources = append(sources, "source")
+		}
+		return backend.DataResponse{Frames: data.Frames{
+			data.NewFrame("edges", data.NewField("id", nil, edges), data.NewField("source", nil, sources)),
+			data.NewFrame("nodes", data.NewField("id", nil, nodes)),
+		}}
+	}
+
+	d := &Datasource{logger: log.DefaultLogger}
+	req := &backend.QueryDataRequest{Queries: []backend.DataQuery{
+		{RefID: "A", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"bookinfo","mergeWithRefIds":["B","C"]}`)},
+		{RefID: "B", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"shop"}`)},
+		{RefID: "D", QueryType: models.QueryTypeNamespaceGraph, JSON: []byte(`{"namespace":"data"}`)},
+		{RefID: "E", QueryType: models.QueryTypeNamespaces, JSON: []byte(`{}`)},
+	}}
+	resp := &backend.QueryDataResponse{Responses: backend.Responses{
+		"A": graph([]string{"a-b"}, []string{"a", "b"}),
+		"B": graph([]string{"b-c", "a-b"}, []string{"b", "c"}),
+		"D": graph([]string{"d-e"}, []string{"d", "e"}),
+		"E": {Frames: data.Frames{data.NewFrame("namespaces", data.NewField("values", nil, []string{"bookinfo"}))}},
+	}}
+
+	result := d.mergeGraphResponses(req, resp)
+
+	require.Len(t, result.Responses["A"].Frames, 2)
+	require.Equal(t, "edges-A", result.Responses["A"].Frames[0].Name)
+	require.Equal(t, []string{"a-b", "b-c"}, []string{result.Responses["A"].Frames[0].Fields[0].At(0).(string), result.Responses["A"].Frames[0].Fields[0].At(1).(string)})
+	rows, _ := result.Responses["A"].Frames[1].RowLen()
+	require.Equal(t, 3, rows)
+	require.Equal(t, "nodes-A", result.Responses["A"].Frames[1].Name)
+
+	require.Empty(t, result.Responses["B"].Frames)
+
+	require.Equal(t, "edges-D", result.Responses["D"].Frames[0].Name)
+	require.Equal(t, "edges", resp.Responses["D"].Frames[0].Name)
+
+	require.Equal(t, "namespaces", result.Responses["E"].Frames[0].Name)
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	// metricDetectionInterval is the interval in which the available Istio
+	// metrics are detected again, so that metrics which are enabled or
+	// disabled in the telemetry configuration of Istio are picked up without
+	// recreating the datasource.
+	metricDetectionInterval = 10 * time.Minute
+	// metricDetectionRange is the time range, which is used to detect the
+	// available Istio metrics.
+	metricDetectionRange = time.Hour
+	// metricDetectionTimeout is the maximum duration of a single detection.
+	metricDetectionTimeout = 30 * time.Second
+)
+
+// metricDetection contains the result of the last detection of the available
+// Istio metrics. It is shared by the datasources of all organizations, because
+// they use the same Prometheus instance.
+type metricDetection struct {
+	mu       sync.RWMutex
+	detected bool
+	metrics  []string
+	err      error
+	time     time.Time
+}
+
+// metricDetectionDetails is the result of the metric detection, which is
+// returned in the details of the health check.
+type metricDetectionDetails struct {
+	Detected    bool      `json:"detected"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time,omitzero"`
+	Available   []string  `json:"available"`
+	Unavailable []string  `json:"unavailable"`
+}
+
+// istioMetricNames returns the names of all Istio metrics, which are used by
+// the datasource. For the request duration the configured histogram is used.
+func (d *Datasource) istioMetricNames() []string {
+	return []string{
+		"istio_requests_total",
+		d.durationHistogramMetric(),
+		"istio_request_messages_total",
+		"istio_response_messages_total",
+		"istio_tcp_sent_bytes_total",
+		"istio_tcp_received_bytes_total",
+		"istio_tcp_connections_opened_total",
+	}
+}
+
+// runMetricDetection detects the available Istio metrics immediately and then
+// in the "metricDetectionInterval", until the given context is canceled. The
+// Prometheus client is passed explicitly, so that the client of the datasource
+// is never read concurrently.
+func (d *Datasource) runMetricDetection(ctx context.Context, prometheusClient prometheus.Client) {
+	ticker := time.NewTicker(metricDetectionInterval)
+	defer ticker.Stop()
+
+	for {
+		d.detectMetrics(ctx, prometheusClient, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// detectMetrics gets the names of all Istio metrics from Prometheus, which
+// have samples in the "metricDetectionRange" before the given time, and stores
+// them in the metric detection of the datasource. If the detection fails, the
+// previously detected metrics are kept.
+func (d *Datasource) detectMetrics(ctx context.Context, prometheusClient prometheus.Client, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, metricDetectionTimeout)
+	defer cancel()
+
+	names, err := prometheusClient.GetLabelValues(ctx, prometheus.LabelValuesQuery{
+		Label:     "__name__",
+		Selectors: []prometheus.Selector{{Matchers: prometheus.Matchers{prometheus.Regexp("__name__", "istio_.*")}}},
+	}, backend.TimeRange{From: now.Add(-metricDetectionRange), To: now})
+
+	d.metricDetection.mu.Lock()
+	defer d.metricDetection.mu.Unlock()
+
+	d.metricDetection.err = err
+	d.metricDetection.time = now
+
+	if err != nil {
+		d.logger.Warn("Failed to detect available Istio metrics", "error", err.Error())
+		return
+	}
+
+	var metrics []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "istio_") {
+			metrics = append(metrics, name)
+		}
+	}
+	slices.Sort(metrics)
+
+	d.metricDetection.detected = true
+	d.metricDetection.metrics = metrics
+
+	var unavailable []string
+	for _, name := range d.istioMetricNames() {
+		if !slices.Contains(metrics, name) {
+			unavailable = append(unavailable, name)
+		}
+	}
+
+	if len(unavailable) > 0 {
+		d.logger.Info("Detected available Istio metrics", "metrics", metrics, "unavailable", unavailable)
+	} else {
+		d.logger.Debug("Detected available Istio metrics", "metrics", metrics)
+	}
+}
+
+// isMetricAvailable returns true if the given Istio metric was found by the
+// last successful metric detection. If the metrics were not detected yet or if
+// no Istio metric was found at all, all metrics are considered as available, so
+// that a failing detection never hides any data.
+func (d *Datasource) isMetricAvailable(name string) bool {
+	if d.metricDetection == nil {
+		return true
+	}
+
+	d.metricDetection.mu.RLock()
+	defer d.metricDetection.mu.RUnlock()
+
+	return !d.metricDetection.detected || len(d.metricDetection.metrics) == 0 || slices.Contains(d.metricDetection.metrics, name)
+}
+
+// getMetricDetectionDetails returns the result of the last metric detection
+// for the Istio metrics used by the datasource.
+func (d *Datasource) getMetricDetectionDetails() metricDetectionDetails {
+	details := metricDetectionDetails{Available: []string{}, Unavailable: []string{}}
+	if d.metricDetection == nil {
+		return details
+	}
+
+	d.metricDetection.mu.RLock()
+	defer d.metricDetection.mu.RUnlock()
+
+	details.Detected = d.metricDetection.detected
+	details.Time = d.metricDetection.time
+	if d.metricDetection.err != nil {
+		details.Error = d.metricDetection.err.Error()
+	}
+
+	if d.metricDetection.detected {
+		for _, name := range d.istioMetricNames() {
+			if slices.Contains(d.metricDetection.metrics, name) {
+				details.Available = append(details.Available, name)
+			} else {
+				details.Unavailable = append(details.Unavailable, name)
+			}
+		}
+	}
+
+	return details
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handleMTLSCoverageQueries handles the queries to get the mTLS coverage of
+// all namespaces. It uses the concurrent package to handle multiple queries in
+// parallel.
+func (d *Datasource) handleMTLSCoverageQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleMTLSCoverageQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleMTLSCoverage, 10)
+}
+
+// handleMTLSCoverage returns the share of requests and TCP connections, which
+// used mTLS, for each namespace as time series. The share is based on the
+// "connection_security_policy" label, which is only set by the destination
+// proxy. The mTLS traffic falls back to zero for the namespaces without any
+// mTLS traffic, so that a plaintext only namespace has a coverage of 0%
+// instead of no time series.
+func (d *Datasource) handleMTLSCoverage(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleMTLSCoverage")
+	defer span.End()
+
+	var qm models.QueryModelMTLSCoverage
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// The step for the range queries is based on the interval of the query.
+	// The rate window should be at least one minute, so that we always have
+	// enough samples to calculate the rate.
+	step := query.DataQuery.Interval
+	if step <= 0 {
+		step = time.Minute
+	}
+	window := int64(max(step, time.Minute).Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("reporter", "destination")}
+	selector = append(selector, d.namespaceMatchers(qm.Namespace, "destination_workload_namespace")...)
+
+	metrics := []canaryMetric{{
+		name:        "requests",
+		displayName: "Requests",
+		unit:        "percent",
+		query:       mtlsCoverageQuery("istio_requests_total", selector, window),
+	}, {
+		name:        "tcp",
+		displayName: "TCP Connections",
+		unit:        "percent",
+		query:       mtlsCoverageQuery("istio_tcp_connections_opened_total", selector, window),
+	}}
+
+	batch := make([]prometheus.TimeSeriesQuery, 0, len(metrics))
+	for _, metric := range metrics {
+		d.logger.Debug("Get time series", "query", metric.query.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To, "step", step)
+		batch = append(batch, prometheus.TimeSeriesQuery{Metric: metric.name, Query: metric.query, TimeRange: query.DataQuery.TimeRange, Step: step})
+	}
+
+	timeSeries, err := d.prometheusClient.GetTimeSeriesBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get time series", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// Create one frame per metric and namespace. If a namespace didn't receive
+	// any requests or TCP connections, Prometheus doesn't return a time series
+	// for it, so that we do not show a coverage of 0% for idle namespaces.
+	var response backend.DataResponse
+
+	for i, metric := range metrics {
+		for _, ts := range timeSeries[i] {
+			namespace := ts.Labels["destination_workload_namespace"]
+
+			frame := data.NewFrame(
+				metric.name,
+				data.NewField("time", nil, ts.Timestamps),
+				data.NewField("value", data.Labels{"namespace": namespace}, ts.Values).SetConfig((&data.FieldConfig{
+					DisplayNameFromDS: fmt.Sprintf("%s (%s)", metric.displayName, namespace),
+					Unit:              metric.unit,
+				}).SetMin(0).SetMax(100)),
+			)
+			frame.SetMeta(&data.FrameMeta{
+				PreferredVisualization: data.VisTypeGraph,
+				Type:                   data.FrameTypeTimeSeriesMulti,
+			})
+
+			response.Frames = append(response.Frames, frame)
+		}
+	}
+
+	return response
+}
+
+// mtlsCoverageQuery returns the query for the share of the given metric, which
+// used mTLS, per namespace. Without the "or ... * 0" fallback the division
+// would drop all namespaces without mTLS traffic.
+func mtlsCoverageQuery(metric string, selector prometheus.Matchers, window int64) prometheus.Expression {
+	all := sumQuery(metric, "rate", selector, window, "destination_workload_namespace")
+	mtls := sumQuery(metric, "rate", append(slices.Clip(selector), prometheus.Equal("connection_security_policy", "mutual_tls")), window, "destination_workload_namespace")
+	coverage := prometheus.Or(mtls, prometheus.Binary{LHS: all, Operator: "*", RHS: prometheus.Number(0)})
+	return prometheus.Binary{
+		LHS:      prometheus.Binary{LHS: coverage, Operator: "/", RHS: all},
+		Operator: "*",
+		RHS:      prometheus.Number(100),
+	}
+}
@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// namespaceHealth is the health of a namespace, which is aggregated from the
+// health of its workloads. The error rate is weighted by the requests of the
+// workloads, so that a failing workload with a low amount of traffic doesn't
+// dominate the health of the namespace.
+type namespaceHealth struct {
+	namespace      string
+	requests       float64
+	errors         float64
+	healthy        int64
+	warning        int64
+	error          int64
+	worstWorkload  string
+	worstErrorRate float64
+}
+
+// handleNamespaceHealthQueries handles the queries to get the health of all
+// namespaces. It uses the concurrent package to handle multiple queries in
+// parallel.
+func (d *Datasource) handleNamespaceHealthQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleNamespaceHealthQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleNamespaceHealth, 10)
+}
+
+// handleNamespaceHealth returns a table with the health of each namespace,
+// without generating the graph. The health of each workload is based on the
+// error rate of its server traffic and the configured warning and error
+// thresholds, like the color of the workload nodes in a graph. For each
+// namespace the number of healthy, warning and error workloads, the error rate
+// weighted by the requests of the workloads and the workload with the highest
+// error rate are returned.
+func (d *Datasource) handleNamespaceHealth(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleNamespaceHealth")
+	defer span.End()
+
+	var qm models.QueryModelNamespaceHealth
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("reporter", "destination")}
+	selector = append(selector, d.namespaceMatchers(qm.Namespace, "destination_workload_namespace")...)
+
+	q := sumQuery("istio_requests_total", "increase", selector, interval, "destination_workload_namespace", "destination_workload", "request_protocol", "response_code", "grpc_response_status")
+
+	d.logger.Debug("Get metrics", "query", q.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+	metrics, err := d.prometheusClient.GetMetrics(ctx, "requests", q, query.DataQuery.TimeRange)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	healths := d.getNamespaceHealths(metrics)
+
+	var namespaces, worstWorkloads []string
+	var errorRates, worstErrorRates []float64
+	var healthy, warning, errors []int64
+
+	for _, health := range healths {
+		namespaces = append(namespaces, health.namespace)
+		errorRates = append(errorRates, health.errors/health.requests*100)
+		healthy = append(healthy, health.healthy)
+		warning = append(warning, health.warning)
+		errors = append(errors, health.error)
+		worstWorkloads = append(worstWorkloads, health.worstWorkload)
+		worstErrorRates = append(worstErrorRates, health.worstErrorRate)
+	}
+
+	// The thresholds of Grafana are inclusive, but a workload is only marked
+	// as warning when its error rate is above the warning threshold, so that
+	// we use the next larger value for the warning step.
+	thresholds := &data.ThresholdsConfig{
+		Mode: data.ThresholdsModeAbsolute,
+		Steps: []data.Threshold{
+			data.NewThreshold(math.Inf(-1), "green", ""),
+			data.NewThreshold(math.Nextafter(d.istioWarningThreshold, math.Inf(1)), "yellow", ""),
+			data.NewThreshold(d.istioErrorThreshold, "red", ""),
+		},
+	}
+
+	frame := data.NewFrame(
+		"Namespace Health",
+		data.NewField("namespace", nil, namespaces).SetConfig(&data.FieldConfig{DisplayName: "Namespace"}),
+		data.NewField("errorRate", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error Rate", Unit: "percent", Thresholds: thresholds}),
+		data.NewField("healthy", nil, healthy).SetConfig(&data.FieldConfig{DisplayName: "Healthy"}),
+		data.NewField("warning", nil, warning).SetConfig(&data.FieldConfig{DisplayName: "Warning"}),
+		data.NewField("error", nil, errors).SetConfig(&data.FieldConfig{DisplayName: "Error"}),
+		data.NewField("worstWorkload", nil, worstWorkloads).SetConfig(&data.FieldConfig{DisplayName: "Worst Workload"}),
+		data.NewField("worstErrorRate", nil, worstErrorRates).SetConfig(&data.FieldConfig{DisplayName: "Worst Error Rate", Unit: "percent", Thresholds: thresholds}),
+	)
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// getNamespaceHealths aggregates the given request metrics, which must be
+// grouped by the destination workload, into the health of the namespaces. The
+// namespaces are sorted by name and namespaces without requests are skipped.
+func (d *Datasource) getNamespaceHealths(metrics []prometheus.Metric) []namespaceHealth {
+	type workloadStats struct {
+		requests float64
+		errors   float64
+	}
+
+	workloads := make(map[string]map[string]*workloadStats)
+
+	for _, m := range metrics {
+		namespace := m.Labels["destination_workload_namespace"]
+		workload := m.Labels["destination_workload"]
+		if namespace == "" || workload == "" {
+			continue
+		}
+
+		if _, ok := workloads[namespace]; !ok {
+			workloads[namespace] = make(map[string]*workloadStats)
+		}
+		if _, ok := workloads[namespace][workload]; !ok {
+			workloads[namespace][workload] = &workloadStats{}
+		}
+
+		workloads[namespace][workload].requests += m.Value
+		if m.Labels["request_protocol"] == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+			workloads[namespace][workload].errors += m.Value
+		} else if m.Labels["request_protocol"] != "grpc" && isHTTPError(m.Labels["response_code"]) {
+			workloads[namespace][workload].errors += m.Value
+		}
+	}
+
+	var healths []namespaceHealth
+
+	for _, namespace := range slices.Sorted(maps.Keys(workloads)) {
+		health := namespaceHealth{namespace: namespace, worstErrorRate: -1}
+
+		for _, workload := range slices.Sorted(maps.Keys(workloads[namespace])) {
+			stats := workloads[namespace][workload]
+			if stats.requests == 0 {
+				continue
+			}
+
+			health.requests += stats.requests
+			health.errors += stats.errors
+
+			errRate := stats.errors / stats.requests * 100
+			if errRate >= d.istioErrorThreshold {
+				health.error++
+			} else if errRate > d.istioWarningThreshold {
+				health.warning++
+			} else {
+				health.healthy++
+			}
+
+			if errRate > health.worstErrorRate {
+				health.worstWorkload = workload
+				health.worstErrorRate = errRate
+			}
+		}
+
+		if health.requests == 0 {
+			continue
+		}
+		healths = append(healths, health)
+	}
+
+	return healths
+}
@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNamespaceHealths(t *testing.T) {
+	d := &Datasource{istioWarningThreshold: 1, istioErrorThreshold: 5}
+
+	healths := d.getNamespaceHealths([]prometheus.Metric{
+		{Value: 980, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "destination_workload": "reviews-v1", "request_protocol": "http", "response_code": "200"}},
+		{Value: 20, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "destination_workload": "reviews-v1", "request_protocol": "http", "response_code": "503"}},
+		{Value: 90, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "destination_workload": "ratings-v1", "request_protocol": "grpc", "grpc_response_status": "0"}},
+		{Value: 10, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "destination_workload": "ratings-v1", "request_protocol": "grpc", "grpc_response_status": "14"}},
+		{Value: 100, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "destination_workload": "details-v1", "request_protocol": "http", "response_code": "200"}},
+		{Value: 0, Labels: map[string]string{"destination_workload_namespace": "idle", "destination_workload": "idle-v1", "request_protocol": "http", "response_code": "200"}},
+	})
+
+	require.Equal(t, []namespaceHealth{{namespace: "bookinfo", requests: 1200, errors: 30, healthy: 1, warning: 1, error: 1, worstWorkload: "ratings-v1", worstErrorRate: 10}}, healths)
+}
@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// orgStore stores named items, like the expected topologies and the filter
+// presets, per Grafana organization. The store is shared by the datasources of
+// all organizations, so that the items are always keyed by the organization of
+// the request, also for organizations without overrides.
+//
+// If a directory is set, the items of an organization are persisted as JSON
+// file in "<directory>/<datasourceUid>/<orgId>/<name>.json", so that they are
+// kept when the datasource is recreated or Grafana is restarted. The files are
+// keyed by the uid of the datasource, so that multiple datasources can use the
+// same directory. The file of an organization
+// is loaded on the first access. Without a directory the items are only kept in
+// memory.
+type orgStore[T any] struct {
+	name       string
+	directory  string
+	datasource string
+
+	mu    sync.RWMutex
+	items map[int64]map[string]T
+}
+
+func newOrgStore[T any](directory, datasource, name string) *orgStore[T] {
+	return &orgStore[T]{name: name, directory: directory, datasource: datasource, items: make(map[int64]map[string]T)}
+}
+
+func (s *orgStore[T]) get(orgID int64, name string) (T, bool, error) {
+	if s == nil {
+		var empty T
+		return empty, false, nil
+	}
+
+	items, err := s.load(orgID)
+	if err != nil {
+		var empty T
+		return empty, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := items[name]
+	return item, ok, nil
+}
+
+func (s *orgStore[T]) set(orgID int64, name string, item T) error {
+	if _, err := s.load(orgID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := maps.Clone(s.items[orgID])
+	items[name] = item
+	if err := s.save(orgID, items); err != nil {
+		return err
+	}
+	s.items[orgID] = items
+	return nil
+}
+
+func (s *orgStore[T]) delete(orgID int64, name string) (bool, error) {
+	if _, err := s.load(orgID); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[orgID][name]; !ok {
+		return false, nil
+	}
+
+	items := maps.Clone(s.items[orgID])
+	delete(items, name)
+	if err := s.save(orgID, items); err != nil {
+		return false, err
+	}
+	s.items[orgID] = items
+	return true, nil
+}
+
+func (s *orgStore[T]) names(orgID int64) ([]string, error) {
+	items, err := s.load(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The names are never nil, so that an organization without items returns
+	// an empty list instead of null in the responses of the resources.
+	names := slices.AppendSeq(make([]string, 0, len(items)), maps.Keys(items))
+	slices.Sort(names)
+	return names, nil
+}
+
+// load returns the items of the given organization. If the items of the
+// organization are not loaded yet, they are read from the file of the
+// organization. A missing file is not an error, because it is only created when
+// the first item is stored.
+func (s *orgStore[T]) load(orgID int64) (map[string]T, error) {
+	s.mu.RLock()
+	items, ok := s.items[orgID]
+	s.mu.RUnlock()
+	if ok {
+		return items, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if items, ok := s.items[orgID]; ok {
+		return items, nil
+	}
+
+	items = make(map[string]T)
+	if s.directory != "" {
+		data, err := os.ReadFile(s.file(orgID))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %w", s.name, err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &items); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", s.name, err)
+			}
+		}
+	}
+
+	s.items[orgID] = items
+	return items, nil
+}
+
+// save writes the given items to the file of the organization. The items are
+// written to a temporary file first, which is renamed afterwards, so that the
+// file is never left half written. It must be called with the lock held.
+func (s *orgStore[T]) save(orgID int64, items map[string]T) error {
+	if s.directory == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", s.name, err)
+	}
+
+	file := s.file(orgID)
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to save %s: %w", s.name, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+s.name+"-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", s.name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save %s: %w", s.name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save %s: %w", s.name, err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to save %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// file returns the path of the file, which contains the items of the given
+// organization.
+func (s *orgStore[T]) file(orgID int64) string {
+	return filepath.Join(s.directory, s.datasource, strconv.FormatInt(orgID, 10), s.name+".json")
+}
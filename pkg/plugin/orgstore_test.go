@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgStore(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		store := newOrgStore[string]("", "", "items")
+		require.NoError(t, store.set(1, "a", "org 1"))
+		require.NoError(t, store.set(2, "a", "org 2"))
+
+		item, ok, err := store.get(1, "a")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "org 1", item)
+
+		names, err := store.names(3)
+		require.NoError(t, err)
+		require.Empty(t, names)
+
+		ok, err = store.delete(2, "a")
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, ok, err = store.get(1, "a")
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("persistence", func(t *testing.T) {
+		directory := t.TempDir()
+		store := newOrgStore[string](directory, "istio", "items")
+		require.NoError(t, store.set(1, "b", "org 1"))
+		require.NoError(t, store.set(1, "a", "org 1"))
+		require.NoError(t, store.set(2, "a", "org 2"))
+		ok, err := store.delete(2, "a")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.FileExists(t, filepath.Join(directory, "istio", "1", "items.json"))
+
+		restored := newOrgStore[string](directory, "istio", "items")
+		names, err := restored.names(1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, names)
+		names, err = restored.names(2)
+		require.NoError(t, err)
+		require.Empty(t, names)
+
+		// The items of another datasource with the same directory are stored
+		// in a separate file.
+		other := newOrgStore[string](directory, "istio-staging", "items")
+		names, err = other.names(1)
+		require.NoError(t, err)
+		require.Empty(t, names)
+		require.NoError(t, other.set(1, "c", "org 1"))
+		names, err = newOrgStore[string](directory, "istio", "items").names(1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, names)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		directory := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(directory, "1"), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(directory, "1", "items.json"), []byte("{"), 0o600))
+
+		store := newOrgStore[string](directory, "", "items")
+		_, _, err := store.get(1, "a")
+		require.ErrorContains(t, err, "failed to parse items")
+		require.Error(t, store.set(1, "a", "org 1"))
+	})
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handlePathQueries handles the queries to get all paths between a source and
+// a destination workload. It uses the concurrent package to handle multiple
+// queries in parallel. Identical queries are only run once.
+func (d *Datasource) handlePathQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handlePathQueries")
+	defer span.End()
+
+	return d.queryDataDeduplicated(ctx, req, d.handlePath)
+}
+
+// handlePath generates the graph for the source workload with the given depth
+// and then only returns the edges and nodes which are part of a path from the
+// source workload to the destination workload. If no depth is set, the
+// maximum depth is used.
+func (d *Datasource) handlePath(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handlePath")
+	defer span.End()
+
+	var qm models.QueryModelPath
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	depth := qm.Depth
+	if depth <= 0 {
+		depth = maxGraphDepth
+	}
+
+	timeRange, err := graphTimeRange(query.DataQuery.TimeRange, qm.EvaluationTime, qm.Window)
+	if err != nil {
+		d.logger.Error("Failed to get time range", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	options := newGraphOptions(qm.GraphQueryOptions)
+	options.namespace = qm.SourceNamespace
+	options.workload = qm.SourceWorkload
+	options.depth = depth
+	options.pathDestination = nodeID("Workload", qm.DestinationNamespace, qm.DestinationWorkload, "")
+
+	return d.handleGraph(ctx, options, timeRange)
+}
+
+// filterPathEdges returns all edges which are part of a path from the source
+// node to the destination node. An edge is part of a path, when its source can
+// be reached from the source node and the destination node can be reached from
+// its destination. The source and destination node ids do not contain a
+// cluster, so that in a multi-cluster mesh the nodes of the workloads in all
+// clusters are used.
+func filterPathEdges(edges map[string]models.Edge, source, destination string) map[string]models.Edge {
+	outgoing := make(map[string][]string)
+	incoming := make(map[string][]string)
+
+	for _, edge := range edges {
+		outgoing[edge.Source] = append(outgoing[edge.Source], edge.Destination)
+		incoming[edge.Destination] = append(incoming[edge.Destination], edge.Source)
+	}
+
+	reachableFromSource := reachableNodes(outgoing, pathNodes(outgoing, source))
+	reachesDestination := reachableNodes(incoming, pathNodes(incoming, destination))
+
+	result := make(map[string]models.Edge)
+	for id, edge := range edges {
+		if reachableFromSource[edge.Source] && reachesDestination[edge.Destination] {
+			result[id] = edge
+		}
+	}
+
+	return result
+}
+
+// pathNodes returns the nodes of the given adjacency list, which are matching
+// the given node id, ignoring the cluster of the nodes. If no node is
+// matching, the node id itself is returned.
+func pathNodes(adjacency map[string][]string, id string) []string {
+	nodes := []string{id}
+	for node := range adjacency {
+		if strings.HasPrefix(node, id+"/") {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// reachableNodes returns all nodes which can be reached from the given start
+// nodes, including the start nodes themselves.
+func reachableNodes(adjacency map[string][]string, start []string) map[string]bool {
+	visited := make(map[string]bool)
+	for _, node := range start {
+		visited[node] = true
+	}
+	queue := slices.Clone(start)
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		for _, next := range adjacency[node] {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return visited
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterPathEdges(t *testing.T) {
+	edges := map[string]models.Edge{
+		"a-svc1":    {Source: "a", Destination: "svc1"},
+		"svc1-b":    {Source: "svc1", Destination: "b"},
+		"b-svc2":    {Source: "b", Destination: "svc2"},
+		"svc2-c":    {Source: "svc2", Destination: "c"},
+		"a-svc3":    {Source: "a", Destination: "svc3"},
+		"svc3-d":    {Source: "svc3", Destination: "d"},
+		"x-svc2":    {Source: "x", Destination: "svc2"},
+		"b-svcdead": {Source: "b", Destination: "svcdead"},
+	}
+
+	result := filterPathEdges(edges, "a", "c")
+	require.Equal(t, []string{"a-svc1", "b-svc2", "svc1-b", "svc2-c"}, slices.Sorted(maps.Keys(result)))
+
+	result = filterPathEdges(edges, "c", "a")
+	require.Empty(t, result)
+
+}
+
+func TestFilterPathEdgesMultiCluster(t *testing.T) {
+	edges := map[string]models.Edge{
+		"a-svc1-east": {Source: "workload/ns/a/east", Destination: "service/ns/svc1/west"},
+		"svc1-b-west": {Source: "service/ns/svc1/west", Destination: "workload/ns/b/west"},
+		"a-svc2-west": {Source: "workload/ns/a/west", Destination: "service/ns/svc2/west"},
+		"svc2-c-west": {Source: "service/ns/svc2/west", Destination: "workload/ns/c/west"},
+		"ab-svc1":     {Source: "workload/ns/ab/west", Destination: "service/ns/svc1/west"},
+	}
+
+	// The source and destination do not contain a cluster, so that the
+	// workloads in all clusters are used as start and end of a path.
+	result := filterPathEdges(edges, "workload/ns/a", "workload/ns/b")
+	require.Equal(t, []string{"a-svc1-east", "svc1-b-west"}, slices.Sorted(maps.Keys(result)))
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// maxFilterPresetBodySize is the maximum size of a filter preset, which can be
+// stored via the "presets" resource.
+const maxFilterPresetBodySize = 1 << 16
+
+// filterPreset is a named set of filters, which can be referenced by the graph
+// queries via the "preset" option, so that teams can share curated views of
+// the mesh across dashboards. The source and destination filters are workloads
+// in the "<namespace>/<name>" format, like the filters of the queries.
+type filterPreset struct {
+	SourceFilters      []string `json:"sourceFilters,omitempty"`
+	DestinationFilters []string `json:"destinationFilters,omitempty"`
+	HiddenNamespaces   []string `json:"hiddenNamespaces,omitempty"`
+	Metrics            []string `json:"metrics,omitempty"`
+}
+
+// handleFilterPresetsResource manages the filter presets of the organization
+// of the request. The preset is selected via the "name" query parameter:
+// - GET returns the preset or the names of all presets if no name is set.
+// - PUT and POST store the preset in the JSON format.
+// - DELETE removes the preset.
+//
+// Only editors and admins can store and remove presets.
+func (d *Datasource) handleFilterPresetsResource(w http.ResponseWriter, r *http.Request) {
+	orgID := backend.PluginConfigFromContext(r.Context()).OrgID
+	d = d.forOrg(orgID)
+	name := r.URL.Query().Get("name")
+
+	if r.Method != http.MethodGet && !canEdit(r) {
+		http.Error(w, "only editors and admins can change presets", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if name == "" {
+			names, err := d.filterPresets.names(orgID)
+			if err != nil {
+				d.logger.Error("Failed to get filter presets", "error", err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			d.writeJSON(w, http.StatusOK, map[string][]string{"names": names})
+			return
+		}
+
+		preset, ok, err := d.filterPresets.get(orgID, name)
+		if err != nil {
+			d.logger.Error("Failed to get filter preset", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("preset %q not found", name), http.StatusNotFound)
+			return
+		}
+		d.writeJSON(w, http.StatusOK, preset)
+
+	case http.MethodPut, http.MethodPost:
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		var preset filterPreset
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxFilterPresetBodySize)).Decode(&preset); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse preset: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if err := d.validateFilterPreset(preset); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := d.filterPresets.set(orgID, name, preset); err != nil {
+			d.logger.Error("Failed to store filter preset", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.logger.Info("Filter preset stored", "name", name)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		ok, err := d.filterPresets.delete(orgID, name)
+		if err != nil {
+			d.logger.Error("Failed to delete filter preset", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("preset %q not found", name), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateFilterPreset returns an error if a filter of the given preset is not
+// in the "<namespace>/<name>" format or if a metric can not be used in a graph.
+func (d *Datasource) validateFilterPreset(preset filterPreset) error {
+	for i, filter := range preset.SourceFilters {
+		if !isWorkloadFilter(filter) {
+			return fmt.Errorf("sourceFilters[%d]: %q must be in the format <namespace>/<name>", i, filter)
+		}
+	}
+	for i, filter := range preset.DestinationFilters {
+		if !isWorkloadFilter(filter) {
+			return fmt.Errorf("destinationFilters[%d]: %q must be in the format <namespace>/<name>", i, filter)
+		}
+	}
+	for i, namespace := range preset.HiddenNamespaces {
+		if namespace == "" {
+			return fmt.Errorf("hiddenNamespaces[%d]: must not be empty", i)
+		}
+	}
+	for i, metric := range preset.Metrics {
+		if _, ok := d.graphQueryTemplate(metric); !ok {
+			return fmt.Errorf("metrics[%d]: invalid metric %q", i, metric)
+		}
+	}
+	return nil
+}
+
+// isWorkloadFilter returns true if the given filter is a workload in the
+// "<namespace>/<name>" format.
+func isWorkloadFilter(filter string) bool {
+	namespace, name, ok := strings.Cut(filter, "/")
+	return ok && namespace != "" && name != ""
+}
+
+// applyFilterPreset adds the filters of the preset, which is referenced by the
+// given options, to the filters of the query. The preset is looked up in the
+// presets of the given organization. If the preset contains metrics,
+// they replace the metrics of the query, so that all dashboards using the
+// preset show the same metrics. If no preset is referenced, the options are
+// returned unchanged.
+func (d *Datasource) applyFilterPreset(orgID int64, options graphOptions) (graphOptions, error) {
+	if options.preset == "" {
+		return options, nil
+	}
+
+	preset, ok, err := d.filterPresets.get(orgID, options.preset)
+	if err != nil {
+		return options, err
+	}
+	if !ok {
+		return options, fmt.Errorf("preset %q not found", options.preset)
+	}
+
+	options.sourceFilters = append(slices.Clone(options.sourceFilters), preset.SourceFilters...)
+	options.destinationFilters = append(slices.Clone(options.destinationFilters), preset.DestinationFilters...)
+	options.hiddenNamespaces = append(slices.Clone(options.hiddenNamespaces), preset.HiddenNamespaces...)
+	if len(preset.Metrics) > 0 {
+		options.metrics = preset.Metrics
+	}
+
+	return options, nil
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleFilterPresetsResource(t *testing.T) {
+	presets := newOrgStore[filterPreset](t.TempDir(), "", "presets")
+	d := &Datasource{filterPresets: presets, logger: log.DefaultLogger, orgs: map[int64]*Datasource{2: {filterPresets: presets, logger: log.DefaultLogger}}}
+
+	requestAs := func(role string, orgID int64, method, target, body string) *httptest.ResponseRecorder {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID})
+		ctx = backend.WithUser(ctx, &backend.User{Login: "jane", Role: role})
+		w := httptest.NewRecorder()
+		d.handleFilterPresetsResource(w, httptest.NewRequestWithContext(ctx, method, target, strings.NewReader(body)))
+		return w
+	}
+	request := func(orgID int64, method, target, body string) *httptest.ResponseRecorder {
+		return requestAs("Editor", orgID, method, target, body)
+	}
+
+	// Viewers can only read the stored data.
+	require.Equal(t, http.StatusForbidden, requestAs("Viewer", 1, http.MethodPut, "/presets?name=team-a", `{}`).Code)
+	require.Equal(t, http.StatusForbidden, requestAs("Viewer", 1, http.MethodDelete, "/presets?name=team-a", "").Code)
+	require.Equal(t, http.StatusNotFound, requestAs("Viewer", 1, http.MethodGet, "/presets?name=team-a", "").Code)
+
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/presets", `{}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/presets?name=team-a", `{`).Code)
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/presets?name=team-a", `{"sourceFilters":["productpage-v1"]}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/presets?name=team-a", `{"metrics":["unknown"]}`).Code)
+	require.Equal(t, http.StatusNoContent, request(1, http.MethodPut, "/presets?name=team-a", `{"sourceFilters":["bookinfo/productpage-v1"],"hiddenNamespaces":["istio-system"],"metrics":["httpRequests"]}`).Code)
+
+	w := request(1, http.MethodGet, "/presets?name=team-a", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var preset filterPreset
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&preset))
+	require.Equal(t, filterPreset{SourceFilters: []string{"bookinfo/productpage-v1"}, HiddenNamespaces: []string{"istio-system"}, Metrics: []string{"httpRequests"}}, preset)
+
+	w = request(1, http.MethodGet, "/presets", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"names":["team-a"]}`, w.Body.String())
+
+	// The presets are stored per organization, so that another organization
+	// can store a preset with the same name without changing the preset of
+	// the first organization.
+	require.Equal(t, http.StatusNotFound, request(2, http.MethodGet, "/presets?name=team-a", "").Code)
+	require.Equal(t, http.StatusNoContent, request(2, http.MethodPut, "/presets?name=team-a", `{"destinationFilters":["shop/cart-v1"]}`).Code)
+	require.JSONEq(t, `{"destinationFilters":["shop/cart-v1"]}`, request(2, http.MethodGet, "/presets?name=team-a", "").Body.String())
+	require.JSONEq(t, `{"sourceFilters":["bookinfo/productpage-v1"],"hiddenNamespaces":["istio-system"],"metrics":["httpRequests"]}`, request(1, http.MethodGet, "/presets?name=team-a", "").Body.String())
+	require.JSONEq(t, `{"names":[]}`, request(3, http.MethodGet, "/presets", "").Body.String())
+
+	// The presets are persisted, so that they are still available after the
+	// datasource was recreated.
+	restored := newOrgStore[filterPreset](presets.directory, "", "presets")
+	preset, ok, err := restored.get(1, "team-a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, filterPreset{SourceFilters: []string{"bookinfo/productpage-v1"}, HiddenNamespaces: []string{"istio-system"}, Metrics: []string{"httpRequests"}}, preset)
+	preset, ok, err = restored.get(2, "team-a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, filterPreset{DestinationFilters: []string{"shop/cart-v1"}}, preset)
+
+	require.Equal(t, http.StatusNoContent, request(1, http.MethodDelete, "/presets?name=team-a", "").Code)
+	require.Equal(t, http.StatusNotFound, request(1, http.MethodGet, "/presets?name=team-a", "").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, request(1, http.MethodPatch, "/presets?name=team-a", "").Code)
+}
+
+func TestApplyFilterPreset(t *testing.T) {
+	d := &Datasource{filterPresets: newOrgStore[filterPreset]("", "", "presets"), logger: log.DefaultLogger}
+	require.NoError(t, d.filterPresets.set(1, "team-a", filterPreset{DestinationFilters: []string{"bookinfo/ratings-v1"}, HiddenNamespaces: []string{"istio-system"}, Metrics: []string{"tcpSentBytes"}}))
+
+	t.Run("should return options without preset", func(t *testing.T) {
+		options, err := d.applyFilterPreset(1, graphOptions{namespace: "bookinfo", metrics: []string{"httpRequests"}})
+		require.NoError(t, err)
+		require.Equal(t, graphOptions{namespace: "bookinfo", metrics: []string{"httpRequests"}}, options)
+	})
+
+	t.Run("should add filters of preset", func(t *testing.T) {
+		options, err := d.applyFilterPreset(1, graphOptions{namespace: "bookinfo", metrics: []string{"httpRequests"}, destinationFilters: []string{"bookinfo/details-v1"}, preset: "team-a"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"bookinfo/details-v1", "bookinfo/ratings-v1"}, options.destinationFilters)
+		require.Equal(t, []string{"istio-system"}, options.hiddenNamespaces)
+		require.Equal(t, []string{"tcpSentBytes"}, options.metrics)
+	})
+
+	t.Run("should return error for unknown preset", func(t *testing.T) {
+		_, err := d.applyFilterPreset(1, graphOptions{namespace: "bookinfo", preset: "unknown"})
+		require.Error(t, err)
+	})
+
+	t.Run("should return error for preset of another organization", func(t *testing.T) {
+		_, err := d.applyFilterPreset(2, graphOptions{namespace: "bookinfo", preset: "team-a"})
+		require.Error(t, err)
+	})
+
+	t.Run("should skip edges of hidden namespaces", func(t *testing.T) {
+		edges := d.metricsToEdges([]prometheus.Metric{
+			{Value: 10, Labels: map[string]string{"metric": "httpRequests", "source_workload": "istio-ingressgateway", "source_workload_namespace": "istio-system", "destination_service": "productpage.bookinfo.svc.cluster.local", "destination_service_name": "productpage", "destination_service_namespace": "bookinfo", "destination_workload": "productpage-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+			{Value: 10, Labels: map[string]string{"metric": "httpRequests", "source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		}, graphOptions{namespace: "bookinfo", hiddenNamespaces: []string{"istio-system"}})
+		require.Len(t, edges, 2)
+		for _, edge := range edges {
+			require.NotEqual(t, "istio-system", edge.SourceNamespace)
+		}
+	})
+}
@@ -1,13 +1,18 @@
 package plugin
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
 	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
@@ -16,7 +21,10 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/singleflight"
 )
 
 // handleNamespacesQueries handles the queries to get a list of namespaces. It
@@ -34,23 +42,38 @@ func (d *Datasource) handleNamespaces(ctx context.Context, query concurrent.Quer
 	ctx, span := tracing.DefaultTracer().Start(ctx, "handleNamespaces")
 	defer span.End()
 
+	var qm models.QueryModelNamespaces
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
 	queries := []prometheus.LabelValuesQuery{{
-		Label: "destination_workload_namespace",
-		Matches: []string{
-			"istio_requests_total",
-			"istio_tcp_sent_bytes_total",
-			"istio_tcp_received_bytes_total",
-		},
+		Label:     "destination_workload_namespace",
+		Selectors: trafficSelectors(),
 	}, {
-		Label: "source_workload_namespace",
-		Matches: []string{
-			"istio_requests_total",
-			"istio_tcp_sent_bytes_total",
-			"istio_tcp_received_bytes_total",
-		},
+		Label:     "source_workload_namespace",
+		Selectors: trafficSelectors(),
 	}}
 
-	return d.handelLabelValues(ctx, queries, query.DataQuery.TimeRange)
+	if qm.SortByTraffic {
+		window := query.DataQuery.TimeRange.Duration()
+		// The requests between two namespaces are reported by the source and
+		// the destination, so that we prefer the metrics of the destination
+		// and only use the metrics of the source for requests which were not
+		// reported by the destination, e.g. requests leaving the mesh.
+		trafficQueries := []prometheus.Expression{prometheus.Or(
+			trafficQuery(window, "destination", nil, "destination_workload_namespace", "source_workload_namespace"),
+			trafficQuery(window, "source", nil, "destination_workload_namespace", "source_workload_namespace"),
+		)}
+
+		return d.handleLabelValuesByTraffic(ctx, queries, trafficQueries, qm.Pagination, query.DataQuery.TimeRange)
+	}
+
+	return d.handelLabelValues(ctx, queries, qm.Pagination, query.DataQuery.TimeRange)
 }
 
 // handleApplicationQueries handles the queries to get a list of applications.
@@ -77,22 +100,24 @@ func (d *Datasource) handleApplications(ctx context.Context, query concurrent.Qu
 	}
 
 	queries := []prometheus.LabelValuesQuery{{
-		Label: "destination_app",
-		Matches: []string{
-			fmt.Sprintf("istio_requests_total{destination_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_sent_bytes_total{destination_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_received_bytes_total{destination_workload_namespace=\"%s\"}", qm.Namespace),
-		},
+		Label:     "destination_app",
+		Selectors: trafficSelectors(prometheus.Equal("destination_workload_namespace", qm.Namespace)),
 	}, {
-		Label: "source_app",
-		Matches: []string{
-			fmt.Sprintf("istio_requests_total{source_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_sent_bytes_total{source_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_received_bytes_total{source_workload_namespace=\"%s\"}", qm.Namespace),
-		},
+		Label:     "source_app",
+		Selectors: trafficSelectors(prometheus.Equal("source_workload_namespace", qm.Namespace)),
 	}}
 
-	return d.handelLabelValues(ctx, queries, query.DataQuery.TimeRange)
+	if qm.SortByTraffic {
+		window := query.DataQuery.TimeRange.Duration()
+		trafficQueries := []prometheus.Expression{
+			trafficQuery(window, "destination", prometheus.Matchers{prometheus.Equal("destination_workload_namespace", qm.Namespace)}, "destination_app"),
+			trafficQuery(window, "source", prometheus.Matchers{prometheus.Equal("source_workload_namespace", qm.Namespace)}, "source_app"),
+		}
+
+		return d.handleLabelValuesByTraffic(ctx, queries, trafficQueries, qm.Pagination, query.DataQuery.TimeRange)
+	}
+
+	return d.handelLabelValues(ctx, queries, qm.Pagination, query.DataQuery.TimeRange)
 }
 
 // handleWorkloadQueries handles the queries to get a list of workloads. It uses
@@ -119,22 +144,24 @@ func (d *Datasource) handleWorkloads(ctx context.Context, query concurrent.Query
 	}
 
 	queries := []prometheus.LabelValuesQuery{{
-		Label: "destination_workload",
-		Matches: []string{
-			fmt.Sprintf("istio_requests_total{destination_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_sent_bytes_total{destination_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_received_bytes_total{destination_workload_namespace=\"%s\"}", qm.Namespace),
-		},
+		Label:     "destination_workload",
+		Selectors: trafficSelectors(prometheus.Equal("destination_workload_namespace", qm.Namespace)),
 	}, {
-		Label: "source_workload",
-		Matches: []string{
-			fmt.Sprintf("istio_requests_total{source_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_sent_bytes_total{source_workload_namespace=\"%s\"}", qm.Namespace),
-			fmt.Sprintf("istio_tcp_received_bytes_total{source_workload_namespace=\"%s\"}", qm.Namespace),
-		},
+		Label:     "source_workload",
+		Selectors: trafficSelectors(prometheus.Equal("source_workload_namespace", qm.Namespace)),
 	}}
 
-	return d.handelLabelValues(ctx, queries, query.DataQuery.TimeRange)
+	if qm.SortByTraffic {
+		window := query.DataQuery.TimeRange.Duration()
+		trafficQueries := []prometheus.Expression{
+			trafficQuery(window, "destination", prometheus.Matchers{prometheus.Equal("destination_workload_namespace", qm.Namespace)}, "destination_workload"),
+			trafficQuery(window, "source", prometheus.Matchers{prometheus.Equal("source_workload_namespace", qm.Namespace)}, "source_workload"),
+		}
+
+		return d.handleLabelValuesByTraffic(ctx, queries, trafficQueries, qm.Pagination, query.DataQuery.TimeRange)
+	}
+
+	return d.handelLabelValues(ctx, queries, qm.Pagination, query.DataQuery.TimeRange)
 }
 
 // handleFilterQueries handles the queries to get a list of workloads for a
@@ -161,101 +188,123 @@ func (d *Datasource) handleFilters(ctx context.Context, query concurrent.Query)
 		return backend.ErrorResponseWithErrorSource(err)
 	}
 
+	window := query.DataQuery.TimeRange.Duration()
+	interval := int64(window.Seconds())
+
 	var namespaceLabel string
 	var workloadLabel string
-	var queries []string
+	var queries map[string]prometheus.Expression
 
 	switch qm.FilterType {
 	case "source":
 		namespaceLabel = "source_workload_namespace"
 		workloadLabel = "source_workload"
 
-		destinationLabel := ""
+		matchers := prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_workload_namespace", qm.Namespace)}
 		if qm.Application != "" {
-			destinationLabel = fmt.Sprintf(`, destination_app="%s"`, qm.Application)
+			matchers = append(matchers, prometheus.Equal("destination_app", qm.Application))
 		} else if qm.Workload != "" {
-			destinationLabel = fmt.Sprintf(`, destination_workload="%s"`, qm.Workload)
+			matchers = append(matchers, prometheus.Equal("destination_workload", qm.Workload))
 		}
 
-		queries = []string{
-			fmt.Sprintf("sum(istio_requests_total{destination_workload_namespace=\"%s\" %s}) by (source_workload_namespace, source_workload)", qm.Namespace, destinationLabel),
-			fmt.Sprintf("sum(istio_tcp_sent_bytes_total{destination_workload_namespace=\"%s\" %s}) by (source_workload_namespace, source_workload)", qm.Namespace, destinationLabel),
-			fmt.Sprintf("sum(istio_tcp_received_bytes_total{destination_workload_namespace=\"%s\" %s}) by (source_workload_namespace, source_workload)", qm.Namespace, destinationLabel),
-		}
+		queries = filterQueries(window, matchers, namespaceLabel, workloadLabel)
 	case "destination":
 		namespaceLabel = "destination_workload_namespace"
 		workloadLabel = "destination_workload"
 
-		sourceLabel := ""
+		matchers := prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Equal("source_workload_namespace", qm.Namespace)}
 		if qm.Application != "" {
-			sourceLabel = fmt.Sprintf(`, source_app="%s"`, qm.Application)
+			matchers = append(matchers, prometheus.Equal("source_app", qm.Application))
 		} else if qm.Workload != "" {
-			sourceLabel = fmt.Sprintf(`, source_workload="%s"`, qm.Workload)
+			matchers = append(matchers, prometheus.Equal("source_workload", qm.Workload))
 		}
 
-		queries = []string{
-			fmt.Sprintf("sum(istio_requests_total{source_workload_namespace=\"%s\" %s}) by (destination_workload_namespace, destination_workload)", qm.Namespace, sourceLabel),
-			fmt.Sprintf("sum(istio_tcp_sent_bytes_total{source_workload_namespace=\"%s\" %s}) by (destination_workload_namespace, destination_workload)", qm.Namespace, sourceLabel),
-			fmt.Sprintf("sum(istio_tcp_received_bytes_total{source_workload_namespace=\"%s\" %s}) by (destination_workload_namespace, destination_workload)", qm.Namespace, sourceLabel),
-		}
+		queries = filterQueries(window, matchers, namespaceLabel, workloadLabel)
 	}
 
-	var errors []error
-	errorsMutex := &sync.Mutex{}
+	// For each candidate filter we keep track of the number of requests, the
+	// number of failed requests and the number of sent and received bytes, so
+	// that users can see which traffic they hide from the graph.
+	type filterStats struct {
+		requests float64
+		errors   float64
+		bytes    float64
+	}
 
-	var values []string
-	valuesMutex := &sync.Mutex{}
+	var batch []prometheus.MetricsQuery
+	for _, metric := range slices.Sorted(maps.Keys(queries)) {
+		batch = append(batch, prometheus.MetricsQuery{Metric: metric, Query: queries[metric], TimeRange: query.DataQuery.TimeRange})
+	}
 
-	var queriesWG sync.WaitGroup
-	queriesWG.Add(len(queries))
+	d.logger.Debug("Get metrics", "queries", queries, "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
 
-	for _, q := range queries {
-		go func(q string) {
-			defer queriesWG.Done()
+	stats := make(map[string]*filterStats)
 
-			d.logger.Debug("Get metrics", "query", q, "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
-			metrics, err := d.prometheusClient.GetMetrics(ctx, "", q, query.DataQuery.TimeRange)
-			if err != nil {
-				d.logger.Error("Failed to get metrics", "error", err.Error())
-				span.RecordError(err)
-				span.SetStatus(codes.Error, err.Error())
+	for i, metrics := range results {
+		metric := batch[i].Metric
 
-				errorsMutex.Lock()
-				errors = append(errors, err)
-				errorsMutex.Unlock()
-				return
+		for _, m := range metrics {
+			namespace, ok := m.Labels[namespaceLabel]
+			if !ok {
+				continue
+			}
+			workload, ok := m.Labels[workloadLabel]
+			if !ok {
+				continue
 			}
-			d.logger.Debug("Retrieved metrics", "query", q, "metrics", metrics)
 
-			var vs []string
-			for _, metric := range metrics {
-				if namespace, ok := metric.Labels[namespaceLabel]; ok {
-					if workload, ok := metric.Labels[workloadLabel]; ok {
-						vs = append(vs, fmt.Sprintf("%s/%s", namespace, workload))
-					}
-				}
+			value := fmt.Sprintf("%s/%s", namespace, workload)
+			if _, ok := stats[value]; !ok {
+				stats[value] = &filterStats{}
 			}
 
-			valuesMutex.Lock()
-			values = append(values, vs...)
-			valuesMutex.Unlock()
-		}(q)
+			switch metric {
+			case "requests":
+				stats[value].requests += m.Value
+				if m.Labels["request_protocol"] == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+					stats[value].errors += m.Value
+				} else if m.Labels["request_protocol"] != "grpc" && isHTTPError(m.Labels["response_code"]) {
+					stats[value].errors += m.Value
+				}
+			default:
+				stats[value].bytes += m.Value
+			}
+		}
 	}
 
-	queriesWG.Wait()
-
-	if len(errors) > 0 {
-		span.RecordError(errors[0])
-		span.SetStatus(codes.Error, errors[0].Error())
-		return backend.ErrorResponseWithErrorSource(errors[0])
+	var values []string
+	for value := range stats {
+		values = append(values, value)
 	}
-
 	slices.Sort(values)
-	values = slices.Compact(values)
+	values = paginate(values, qm.Pagination)
+
+	var rps []float64
+	var errorRates []float64
+	var bps []float64
+	for _, value := range values {
+		rps = append(rps, stats[value].requests/float64(interval))
+		if stats[value].requests > 0 {
+			errorRates = append(errorRates, stats[value].errors/stats[value].requests*100)
+		} else {
+			errorRates = append(errorRates, 0)
+		}
+		bps = append(bps, stats[value].bytes/float64(interval))
+	}
 
 	frame := data.NewFrame(
 		"Values",
 		data.NewField("values", nil, values),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
+		data.NewField("err", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error", Unit: "percent"}),
+		data.NewField("bps", nil, bps).SetConfig(&data.FieldConfig{DisplayName: "Bytes", Unit: "Bps"}),
 	)
 
 	frame.SetMeta(&data.FrameMeta{
@@ -269,65 +318,151 @@ func (d *Datasource) handleFilters(ctx context.Context, query concurrent.Query)
 	return response
 }
 
+// trafficMetrics are the metrics, which are used to discover the namespaces,
+// applications and workloads with traffic.
+var trafficMetrics = []string{"istio_requests_total", "istio_tcp_sent_bytes_total", "istio_tcp_received_bytes_total"}
+
+// trafficSelectors returns a selector with the given matchers for each of the
+// traffic metrics.
+func trafficSelectors(matchers ...prometheus.Matcher) []prometheus.Selector {
+	var selectors []prometheus.Selector
+	for _, metric := range trafficMetrics {
+		selectors = append(selectors, prometheus.Selector{Metric: metric, Matchers: matchers})
+	}
+	return selectors
+}
+
+// trafficQuery returns the query for the number of requests within the window,
+// which were reported by the given reporter ("source" or "destination"), for
+// each value of the given labels. It is used to sort the label values by their
+// traffic. The reporter must always be set, because otherwise the requests
+// within the mesh are counted twice.
+func trafficQuery(window time.Duration, reporter string, matchers prometheus.Matchers, groupBy ...string) prometheus.Query {
+	return prometheus.Query{
+		Aggregation: "sum",
+		Function:    "increase",
+		Selector:    prometheus.Selector{Metric: "istio_requests_total", Matchers: append(prometheus.Matchers{prometheus.Equal("reporter", reporter)}, matchers...)},
+		Window:      window,
+		GroupBy:     groupBy,
+	}
+}
+
+// filterQueries returns the queries for the requests and the sent and received
+// bytes within the window, which are grouped by the namespace and workload
+// label of the candidate filters. The matchers must contain the reporter, so
+// that the requests within the mesh are not counted twice.
+func filterQueries(window time.Duration, matchers prometheus.Matchers, namespaceLabel, workloadLabel string) map[string]prometheus.Expression {
+	query := func(metric string, groupBy ...string) prometheus.Expression {
+		return prometheus.Query{
+			Aggregation: "sum",
+			Function:    "increase",
+			Selector:    prometheus.Selector{Metric: metric, Matchers: matchers},
+			Window:      window,
+			GroupBy:     append([]string{namespaceLabel, workloadLabel}, groupBy...),
+		}
+	}
+
+	return map[string]prometheus.Expression{
+		"requests":         query("istio_requests_total", "request_protocol", "response_code", "grpc_response_status"),
+		"tcpSentBytes":     query("istio_tcp_sent_bytes_total"),
+		"tcpReceivedBytes": query("istio_tcp_received_bytes_total"),
+	}
+}
+
 // handleLabelValues retrieves the values for the given labels and filter from
 // the "istio_requests_total", "istio_tcp_sent_bytes_total", and
 // "istio_tcp_received_bytes_total" metrics. It performs the retrieval in
 // parallel for each label and combines the results into a single response.
-func (d *Datasource) handelLabelValues(ctx context.Context, queries []prometheus.LabelValuesQuery, timeRange backend.TimeRange) backend.DataResponse {
+func (d *Datasource) handelLabelValues(ctx context.Context, queries []prometheus.LabelValuesQuery, pagination models.Pagination, timeRange backend.TimeRange) backend.DataResponse {
 	ctx, span := tracing.DefaultTracer().Start(ctx, "handleLabelValues")
 	defer span.End()
 
-	var errors []error
-	errorsMutex := &sync.Mutex{}
+	allValues, err := d.getLabelValues(ctx, queries, timeRange)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
 
-	var values [][]string
-	valuesMutex := &sync.Mutex{}
+	allValues = paginate(allValues, pagination)
 
-	var queriesWG sync.WaitGroup
-	queriesWG.Add(len(queries))
+	frame := data.NewFrame(
+		"Values",
+		data.NewField("values", nil, allValues),
+	)
 
-	for _, query := range queries {
-		go func(query prometheus.LabelValuesQuery) {
-			defer queriesWG.Done()
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
 
-			d.logger.Debug("Get label values", "label", query.Label, "matches", query.Matches, "timeRangeFrom", timeRange.From, "timeRangeTo", timeRange.To)
-			labelValues, err := d.prometheusClient.GetLabelValues(ctx, query, timeRange)
-			if err != nil {
-				d.logger.Error("Failed to get values", "error", err.Error())
-				span.RecordError(err)
-				span.SetStatus(codes.Error, err.Error())
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
 
-				errorsMutex.Lock()
-				errors = append(errors, err)
-				errorsMutex.Unlock()
-				return
-			}
-			d.logger.Debug("Retrieved label values", "label", query.Label, "matches", query.Matches, "values", labelValues)
+	return response
+}
 
-			valuesMutex.Lock()
-			values = append(values, labelValues)
-			valuesMutex.Unlock()
-		}(query)
+// handleLabelValuesByTraffic works like "handleLabelValues", but ranks the
+// returned values by their request rate in the selected time range. The
+// request rate for each value is the sum of all series of the given traffic
+// queries, where one of the labels of the label values queries contains the
+// value. A series is only counted once for a value, even if multiple labels
+// contain it, e.g. the requests within a namespace. Values without any requests
+// (e.g. TCP only workloads) are added at the end of the list with a rate of 0.
+func (d *Datasource) handleLabelValuesByTraffic(ctx context.Context, queries []prometheus.LabelValuesQuery, trafficQueries []prometheus.Expression, pagination models.Pagination, timeRange backend.TimeRange) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleLabelValuesByTraffic")
+	defer span.End()
+
+	interval := timeRange.Duration().Seconds()
+
+	allValues, err := d.getLabelValues(ctx, queries, timeRange)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
 	}
 
-	queriesWG.Wait()
+	batch := make([]prometheus.MetricsQuery, 0, len(trafficQueries))
+	for _, trafficQuery := range trafficQueries {
+		batch = append(batch, prometheus.MetricsQuery{Query: trafficQuery, TimeRange: timeRange})
+	}
 
-	if len(errors) > 0 {
-		span.RecordError(errors[0])
-		span.SetStatus(codes.Error, errors[0].Error())
-		return backend.ErrorResponseWithErrorSource(errors[0])
+	d.logger.Debug("Get metrics", "queries", trafficQueries, "timeRangeFrom", timeRange.From, "timeRangeTo", timeRange.To)
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
 	}
 
-	var allValues []string
-	for _, v := range values {
-		allValues = append(allValues, v...)
+	requests := make(map[string]float64)
+	for _, metrics := range results {
+		for _, metric := range metrics {
+			var values []string
+			for _, query := range queries {
+				if value, ok := metric.Labels[query.Label]; ok && !slices.Contains(values, value) {
+					values = append(values, value)
+					requests[value] += metric.Value
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(allValues, func(a, b string) int {
+		return cmp.Compare(requests[b], requests[a])
+	})
+	allValues = paginate(allValues, pagination)
+
+	var rps []float64
+	for _, value := range allValues {
+		rps = append(rps, requests[value]/interval)
 	}
-	slices.Sort(allValues)
-	allValues = slices.Compact(allValues)
 
 	frame := data.NewFrame(
 		"Values",
 		data.NewField("values", nil, allValues),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
 	)
 
 	frame.SetMeta(&data.FrameMeta{
@@ -341,15 +476,161 @@ func (d *Datasource) handelLabelValues(ctx context.Context, queries []prometheus
 	return response
 }
 
+// paginate applies the search, offset and limit of the given pagination to the
+// values. The search is a case-insensitive substring match. If the limit is 0
+// all values after the offset are returned.
+func paginate(values []string, pagination models.Pagination) []string {
+	if pagination.Search != "" {
+		search := strings.ToLower(pagination.Search)
+		values = slices.DeleteFunc(values, func(value string) bool {
+			return !strings.Contains(strings.ToLower(value), search)
+		})
+	}
+
+	if pagination.Offset > 0 {
+		if pagination.Offset >= len(values) {
+			return []string{}
+		}
+		values = values[pagination.Offset:]
+	}
+
+	if pagination.Limit > 0 && pagination.Limit < len(values) {
+		values = values[:pagination.Limit]
+	}
+
+	return values
+}
+
+// getSharedLabelValues returns the label values for the given queries, in the
+// order of the queries. The values of cached queries are returned from the
+// cache, all other queries are sent to Prometheus as a single batch. If the
+// same batch is already running, e.g. because multiple queries in a request
+// need the same values, we wait for the running batch and share its result
+// instead of sending the same queries to Prometheus again. The values are only
+// shared within the same organization and, when the Grafana user or cookies
+// are forwarded to Prometheus, with the same user, like the graphs in
+// "getGraph".
+func (d *Datasource) getSharedLabelValues(ctx context.Context, queries []prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	values := make([][]string, len(queries))
+
+	var missingQueries []prometheus.LabelValuesQuery
+	var missingIndexes []int
+	var keys []string
+
+	for i, query := range queries {
+		if d.listCache != nil {
+			if cachedValues, ok := d.listCache.get(query, timeRange, time.Now()); ok {
+				d.logger.Debug("Cached label values", "label", query.Label, "matches", query.Matches())
+				values[i] = cachedValues
+				continue
+			}
+		}
+
+		missingQueries = append(missingQueries, query)
+		missingIndexes = append(missingIndexes, i)
+		keys = append(keys, query.Label+"/"+strings.Join(query.Matches(), ","))
+	}
+
+	if len(missingQueries) == 0 {
+		return values, nil
+	}
+
+	key := d.sharedKey(ctx, fmt.Sprintf("%s/%d/%d", strings.Join(keys, ";"), timeRange.From.UnixMilli(), timeRange.To.UnixMilli()))
+
+	results, shared, err := doShared(ctx, &d.labelValuesGroup, key, func(ctx context.Context) (any, error) {
+		return d.prometheusClient.GetLabelValuesBatch(ctx, missingQueries, timeRange)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		d.logger.Debug("Shared label values", "queries", len(missingQueries))
+	}
+
+	for j, i := range missingIndexes {
+		values[i] = results.([][]string)[j]
+		if d.listCache != nil {
+			d.listCache.set(queries[i], timeRange, values[i], time.Now())
+		}
+	}
+
+	return values, nil
+}
+
+// sharedTimeout is the maximum duration of work, which is shared between
+// concurrent queries via a singleflight group.
+const sharedTimeout = 5 * time.Minute
+
+// doShared runs the given function only once for all concurrent callers with
+// the same key. The function isn't canceled when the caller which started it
+// is canceled, because other callers may still wait for the result, but it is
+// bounded by the "sharedTimeout" or by the deadline of the caller, when it is
+// later (e.g. for graph jobs). Each caller stops waiting as soon as its own
+// context is done.
+func doShared(ctx context.Context, group *singleflight.Group, key string, fn func(ctx context.Context) (any, error)) (any, bool, error) {
+	ch := group.DoChan(key, func() (any, error) {
+		timeout := sharedTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = max(timeout, time.Until(deadline))
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		return fn(ctx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case result := <-ch:
+		return result.Val, result.Shared, result.Err
+	}
+}
+
+// getLabelValues retrieves the values for all the given label values queries.
+// The returned values are sorted alphabetically and do not contain any
+// duplicates.
+func (d *Datasource) getLabelValues(ctx context.Context, queries []prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getLabelValues")
+	defer span.End()
+
+	d.logger.Debug("Get label values", "queries", len(queries), "timeRangeFrom", timeRange.From, "timeRangeTo", timeRange.To)
+	start := time.Now()
+	values, err := d.getSharedLabelValues(ctx, queries, timeRange)
+	if err != nil {
+		d.logger.Error("Failed to get values", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	d.logger.Debug("Retrieved label values", "queries", len(queries), "duration", time.Since(start))
+
+	var allValues []string
+	for i, query := range queries {
+		// If the namespaces are restricted for the organization, we remove all
+		// namespaces which are not allowed.
+		for _, value := range values[i] {
+			if d.istioNamespaces != nil && strings.HasSuffix(query.Label, "_namespace") && !d.isNamespaceAllowed(value) {
+				continue
+			}
+			allValues = append(allValues, value)
+		}
+	}
+	slices.Sort(allValues)
+	allValues = slices.Compact(allValues)
+
+	return allValues, nil
+}
+
 // handleApplicationGraphQueries handles the queries to get graph for an
 // application. It uses the concurrent package to handle multiple queries in
 // parallel. The graph is generated for a specific application in a namespace
-// and contains all the requested metrics.
+// and contains all the requested metrics. Identical queries are only run once.
 func (d *Datasource) handleApplicationGraphQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	ctx, span := tracing.DefaultTracer().Start(ctx, "handleApplicationGraphQueries")
 	defer span.End()
 
-	return concurrent.QueryData(ctx, req, d.handleApplicationGraph, 10)
+	return d.queryDataDeduplicated(ctx, req, d.handleApplicationGraph)
 }
 
 func (d *Datasource) handleApplicationGraph(ctx context.Context, query concurrent.Query) backend.DataResponse {
@@ -365,18 +646,31 @@ func (d *Datasource) handleApplicationGraph(ctx context.Context, query concurren
 		return backend.ErrorResponseWithErrorSource(err)
 	}
 
-	return d.handleGraph(ctx, qm.Namespace, qm.Application, "", qm.Metrics, qm.SourceFilters, qm.DestinationFilters, qm.IdleEdges, query.DataQuery.TimeRange)
+	timeRange, err := graphTimeRange(query.DataQuery.TimeRange, qm.EvaluationTime, qm.Window)
+	if err != nil {
+		d.logger.Error("Failed to get time range", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	options := newGraphOptions(qm.GraphQueryOptions)
+	options.namespace = qm.Namespace
+	options.application = qm.Application
+	options.depth = qm.Depth
+
+	return d.handleGraph(ctx, options, timeRange)
 }
 
 // handleWorkloadGraphQueries handles the queries to get graph for a workload.
 // It uses the concurrent package to handle multiple queries in parallel. The
 // graph is generated for a specific workload in a namespace and contains all
-// the requested metrics.
+// the requested metrics. Identical queries are only run once.
 func (d *Datasource) handleWorkloadGraphQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	ctx, span := tracing.DefaultTracer().Start(ctx, "handleWorkloadGraphQueries")
 	defer span.End()
 
-	return concurrent.QueryData(ctx, req, d.handleWorkloadGraph, 10)
+	return d.queryDataDeduplicated(ctx, req, d.handleWorkloadGraph)
 }
 
 func (d *Datasource) handleWorkloadGraph(ctx context.Context, query concurrent.Query) backend.DataResponse {
@@ -392,18 +686,32 @@ func (d *Datasource) handleWorkloadGraph(ctx context.Context, query concurrent.Q
 		return backend.ErrorResponseWithErrorSource(err)
 	}
 
-	return d.handleGraph(ctx, qm.Namespace, "", qm.Workload, qm.Metrics, qm.SourceFilters, qm.DestinationFilters, qm.IdleEdges, query.DataQuery.TimeRange)
+	timeRange, err := graphTimeRange(query.DataQuery.TimeRange, qm.EvaluationTime, qm.Window)
+	if err != nil {
+		d.logger.Error("Failed to get time range", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	options := newGraphOptions(qm.GraphQueryOptions)
+	options.namespace = qm.Namespace
+	options.workload = qm.Workload
+	options.matchServiceNamespace = qm.MatchServiceNamespace
+	options.depth = qm.Depth
+
+	return d.handleGraph(ctx, options, timeRange)
 }
 
 // handleNamespaceGraphQueries handles the queries to get graph for a namespace.
 // It uses the concurrent package to handle multiple queries in parallel. The
 // graph is generated for a specific namespace and contains all the requested
-// metrics.
+// metrics. Identical queries are only run once.
 func (d *Datasource) handleNamespaceGraphQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	ctx, span := tracing.DefaultTracer().Start(ctx, "handleNamespaceGraphQueries")
 	defer span.End()
 
-	return concurrent.QueryData(ctx, req, d.handleNamespaceGraph, 10)
+	return d.queryDataDeduplicated(ctx, req, d.handleNamespaceGraph)
 }
 
 func (d *Datasource) handleNamespaceGraph(ctx context.Context, query concurrent.Query) backend.DataResponse {
@@ -419,88 +727,244 @@ func (d *Datasource) handleNamespaceGraph(ctx context.Context, query concurrent.
 		return backend.ErrorResponseWithErrorSource(err)
 	}
 
-	return d.handleGraph(ctx, qm.Namespace, "", "", qm.Metrics, qm.SourceFilters, qm.DestinationFilters, qm.IdleEdges, query.DataQuery.TimeRange)
-}
+	timeRange, err := graphTimeRange(query.DataQuery.TimeRange, qm.EvaluationTime, qm.Window)
+	if err != nil {
+		d.logger.Error("Failed to get time range", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
 
-// handleGraph creates the graph for the given namespace, application or
-// workload. The function can be used for all the three graph types we support.
-// It retrieves all the requested metrics, generates the edges and nodes based
-// on the metrics and returns the graph as data frames.
-func (d *Datasource) handleGraph(ctx context.Context, namespace, application, workload string, metrics, sourceFilters, destinationFilters []string, idleEdges bool, timeRange backend.TimeRange) backend.DataResponse {
-	ctx, span := tracing.DefaultTracer().Start(ctx, "handleGraph")
-	defer span.End()
+	options := newGraphOptions(qm.GraphQueryOptions)
+	options.namespace = qm.Namespace
 
-	interval := int64(timeRange.Duration().Seconds())
+	return d.handleGraph(ctx, options, timeRange)
+}
 
-	var errors []error
-	errorsMutex := &sync.Mutex{}
+// graphTimeRange returns the time range which is used to generate a graph. By
+// default this is the time range of the dashboard. If an evaluation time is
+// set, the graph is generated as it looked at this time, e.g. during an
+// incident. If a window is set, it is used as the length of the time range
+// instead of the duration of the dashboard time range.
+func graphTimeRange(timeRange backend.TimeRange, evaluationTime, window string) (backend.TimeRange, error) {
+	to := timeRange.To
+	duration := timeRange.Duration()
+
+	if evaluationTime != "" {
+		t, err := time.Parse(time.RFC3339, evaluationTime)
+		if err != nil {
+			return backend.TimeRange{}, fmt.Errorf("invalid evaluation time: %w", err)
+		}
+		to = t
+	}
 
-	var prometheusMetrics []prometheus.Metric
-	prometheusMetricsMutex := &sync.Mutex{}
+	if window != "" {
+		w, err := model.ParseDuration(window)
+		if err != nil {
+			return backend.TimeRange{}, fmt.Errorf("invalid window: %w", err)
+		}
+		duration = time.Duration(w)
+	}
 
-	var metricsWG sync.WaitGroup
-	metricsWG.Add(len(metrics))
+	return backend.TimeRange{From: to.Add(-duration), To: to}, nil
+}
 
-	// Get all metrics in parallel for the given namespace, application or
-	// workload. We need to get the metrics where the namespace / application /
-	// workload is the detination orthe source to build the full graph.
-	for _, metric := range metrics {
-		go func(metric string) {
-			defer metricsWG.Done()
+// graphOptions contains all the options which can be set for a graph query.
+// If the "application", "workload" and "service" are empty, the graph is
+// generated for the whole namespace.
+type graphOptions struct {
+	namespace              string
+	application            string
+	workload               string
+	service                string
+	matchServiceNamespace  bool
+	metrics                []string
+	sourceFilters          []string
+	destinationFilters     []string
+	hiddenNamespaces       []string
+	preset                 string
+	idleEdges              bool
+	idleNodes              bool
+	hideServiceNodes       bool
+	depth                  int
+	pathDestination        string
+	detectIssues           bool
+	excludeMirrors         bool
+	locality               bool
+	groupingLabels         []string
+	includeResponseClasses []string
+	aggregateByApp         bool
+	workloadDurations      bool
+	sortByTraffic          bool
+	revision               string
+	expectedTopology       string
+	compareEvaluationTime  string
+	job                    string
+	edgeTable              bool
+	sparklineBuckets       int
+	timeSeries             bool
+	links                  models.LinkContext
+}
 
-			d.logger.Debug("Get metric", "metric", metric, "namespace", namespace, "application", application, "workload", workload, "timeRangeFrom", timeRange.From, "timeRangeTo", timeRange.To, "interval", interval)
+// newGraphOptions converts the options, which are shared by the query models of
+// all graph query types. The focal node of the graph and the depth depend on
+// the query type and must be set by the caller.
+func newGraphOptions(qm models.GraphQueryOptions) graphOptions {
+	return graphOptions{
+		metrics:                qm.Metrics,
+		sourceFilters:          qm.SourceFilters,
+		destinationFilters:     qm.DestinationFilters,
+		preset:                 qm.Preset,
+		idleEdges:              qm.IdleEdges,
+		idleNodes:              qm.IdleNodes,
+		hideServiceNodes:       qm.HideServiceNodes,
+		detectIssues:           qm.DetectIssues,
+		excludeMirrors:         qm.ExcludeMirrors,
+		locality:               qm.Locality,
+		groupingLabels:         qm.GroupingLabels,
+		includeResponseClasses: qm.IncludeResponseClasses,
+		aggregateByApp:         qm.AggregateByApp,
+		workloadDurations:      qm.WorkloadDurations,
+		sortByTraffic:          qm.SortByTraffic,
+		revision:               qm.Revision,
+		expectedTopology:       qm.ExpectedTopology,
+		compareEvaluationTime:  qm.CompareEvaluationTime,
+		job:                    qm.Job,
+		edgeTable:              qm.EdgeTable,
+		sparklineBuckets:       qm.SparklineBuckets,
+		timeSeries:             qm.TimeSeries,
+		links:                  graphLinkContext(qm.LinkContext, qm.EvaluationTime, qm.Window),
+	}
+}
 
-			destinationMetrics, err := d.prometheusClient.GetMetrics(ctx, metric, d.metricToPrometheusDestinationsQuery(namespace, application, workload, metric, idleEdges, interval), timeRange)
-			if err != nil {
-				d.logger.Error("Failed to get metric", "error", err.Error())
-				span.RecordError(err)
-				span.SetStatus(codes.Error, err.Error())
+// maxGraphDepth is the maximum number of hops we follow from the focal
+// application or workload, to limit the number of queries for a single graph.
+const maxGraphDepth = 5
+
+// gatewayWorkloadSuffix is the suffix of the workloads, which are deployed by
+// Istio for a Gateway API gateway.
+const gatewayWorkloadSuffix = "-istio"
+
+// edgeIssues are the response flags which are used to detect issues for an
+// edge. The threshold is the share of requests with the response flag, which
+// is required to flag an edge with the issue:
+//   - The majority of requests must fail with no route ("NR") or no healthy
+//     upstream ("UH"), otherwise the issue is most likely temporary.
+//   - A single request rejected because of an upstream overflow ("UO") means
+//     that a circuit breaker was tripped.
+var edgeIssues = []struct {
+	flag        string
+	description string
+	threshold   float64
+}{
+	{flag: "NR", description: "No route", threshold: 0.5},
+	{flag: "UH", description: "No healthy upstream", threshold: 0.5},
+	{flag: "UO", description: "Circuit breaker open", threshold: 0},
+}
 
-				errorsMutex.Lock()
-				errors = append(errors, err)
-				errorsMutex.Unlock()
-				return
-			}
-			d.logger.Debug("Retrieved metrics where application is destination", "metric", metric, "namespace", namespace, "application", application, "workload", workload, "metrics", destinationMetrics)
+// handleGraph creates the graph for the given namespace, application or
+// workload. The function can be used for all the three graph types we support.
+// It generates the edges and nodes via the "getGraph" function and returns the
+// graph as data frames.
+func (d *Datasource) handleGraph(ctx context.Context, options graphOptions, timeRange backend.TimeRange) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleGraph")
+	defer span.End()
 
-			sourceMetrics, err := d.prometheusClient.GetMetrics(ctx, metric, d.metricToPrometheusSourcesQuery(namespace, application, workload, metric, idleEdges, interval), timeRange)
-			if err != nil {
-				d.logger.Error("Failed to get metric", "error", err.Error())
-				span.RecordError(err)
-				span.SetStatus(codes.Error, err.Error())
+	if err := validateGraphOptions(options); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
 
-				errorsMutex.Lock()
-				errors = append(errors, err)
-				errorsMutex.Unlock()
-				return
-			}
-			d.logger.Debug("Retrieved metrics where application is source", "metric", metric, "namespace", namespace, "application", application, "workload", workload, "metrics", sourceMetrics)
+	// If a filter preset is selected, the filters of the preset are added to
+	// the filters of the query.
+	options, err := d.applyFilterPreset(backend.PluginConfigFromContext(ctx).OrgID, options)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
 
-			prometheusMetricsMutex.Lock()
-			prometheusMetrics = append(prometheusMetrics, destinationMetrics...)
-			prometheusMetrics = append(prometheusMetrics, sourceMetrics...)
-			prometheusMetricsMutex.Unlock()
-		}(metric)
+	// If a graph job is selected, we use the cached graph of the job instead
+	// of generating the graph. In this case the time range of the job is used,
+	// because the rates must be calculated for the time range of the job. The
+	// links then also use the absolute time range of the job.
+	var edges map[string]models.Edge
+	var nodes map[string]models.Node
+	var stats graphStats
+	if options.job != "" {
+		edges, nodes, timeRange, err = d.graphJobs.result(graphJobOwnerFromContext(ctx), options.job)
+		options.links.RawFrom, options.links.RawTo = "", ""
+	} else {
+		edges, nodes, stats, err = d.getGraph(ctx, options, timeRange)
 	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	interval := int64(timeRange.Duration().Seconds())
 
-	metricsWG.Wait()
+	// If a compare evaluation time is set, we also generate the graph for the
+	// time range at this time and compare both graphs, which adds the removed
+	// edges to the graph.
+	var compareStatus map[string]string
+	if options.compareEvaluationTime != "" {
+		baselineTimeRange, err := graphTimeRange(timeRange, options.compareEvaluationTime, "")
+		if err != nil {
+			d.logger.Error("Failed to get compare time range", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
 
-	if len(errors) > 0 {
-		span.RecordError(errors[0])
-		span.SetStatus(codes.Error, errors[0].Error())
-		return backend.ErrorResponseWithErrorSource(errors[0])
+		baselineEdges, baselineNodes, _, err := d.getGraph(ctx, options, baselineTimeRange)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+		compareStatus = compareGraphs(edges, nodes, baselineEdges, baselineNodes, timeRange.Duration().Seconds(), baselineTimeRange.Duration().Seconds())
 	}
 
-	// Deduplicate the metrics (metrics where all labels are the same), generate
-	// the edges based on the metrics and then generate the nodes based on the
-	// edges.
-	prometheusMetrics = d.deduplicateMetrics(prometheusMetrics)
-	edges := d.metricsToEdges(prometheusMetrics, sourceFilters, destinationFilters)
-	nodes := d.edgesToNodes(edges)
+	// If an expected topology is selected, we compare the graph with the
+	// topology, which adds the missing dependencies as edges to the graph.
+	var topologyStatus map[string]string
+	if options.expectedTopology != "" {
+		topology, ok, err := d.expectedTopologies.get(backend.PluginConfigFromContext(ctx).OrgID, options.expectedTopology)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+		if !ok {
+			err := fmt.Errorf("expected topology %q not found", options.expectedTopology)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+		topologyStatus = compareTopology(edges, nodes, topology, options)
+	}
+
+	// Record the number of series and the size of the graph, so that the load
+	// of a graph on the plugin and Prometheus can be seen in the traces and
+	// logs. The number of series is zero, when the graph of a job is used.
+	span.SetAttributes(
+		attribute.Int("graph.series", stats.series),
+		attribute.Int("graph.series_deduplicated", stats.deduplicatedSeries),
+		attribute.Int("graph.edges", len(edges)),
+		attribute.Int("graph.nodes", len(nodes)),
+	)
+	d.logger.Debug("Graph summary", "namespace", options.namespace, "app", options.application, "workload", options.workload, "series", stats.series, "deduplicatedSeries", stats.deduplicatedSeries, "edges", len(edges), "nodes", len(nodes))
 
 	// Generate the data frames for the edges and nodes, the data for the
 	// "details__*" fields is generated using the "getEdgeField" and
 	// "getNodeField" functions.
+	// The links to the Istio dashboards use the time range, time zone and
+	// datasource of the panel, so that the dashboards show the same context.
+	linkParams := dashboardLinkParams(options.links, timeRange)
+
 	edgeFields := models.Fields{}
 	edgeIds := edgeFields.Add("id", nil, []string{})
 	edgeSources := edgeFields.Add("source", nil, []string{})
@@ -508,18 +972,56 @@ func (d *Datasource) handleGraph(ctx context.Context, namespace, application, wo
 	edgeMainStat := edgeFields.Add("mainstat", nil, []string{}, &data.FieldConfig{DisplayName: "Main Stats"})
 	edgeSecondaryStat := edgeFields.Add("secondarystat", nil, []string{}, &data.FieldConfig{DisplayName: "Secondary Stats"})
 	edgeColors := edgeFields.Add("color", nil, []string{}, &data.FieldConfig{DisplayName: "Health"})
+	edgeStrokeDasharray := edgeFields.Add("strokeDasharray", nil, []string{})
+	edgeMirror := edgeFields.Add("detail__mirror", nil, []bool{}, &data.FieldConfig{DisplayName: "Mirrored Traffic"})
 	edgeDetailsGRPCRate := edgeFields.Add("detail__grpcrate", nil, []string{}, &data.FieldConfig{DisplayName: "gRPC Rate"})
 	edgeDetailsGRPCErr := edgeFields.Add("detail__grpcperr", nil, []string{}, &data.FieldConfig{DisplayName: "gRPC Error"})
+	edgeDetailsGRPCThrottled := edgeFields.Add("detail__grpcthrottled", nil, []string{}, &data.FieldConfig{DisplayName: "gRPC Throttled"})
 	edgeDetailsGRPCDuration := edgeFields.Add("detail__grpcduration", nil, []string{}, &data.FieldConfig{DisplayName: "gRPC Duration"})
 	edgeDetailsGRPCSentMessages := edgeFields.Add("detail__grpcsentmessages", nil, []string{}, &data.FieldConfig{DisplayName: "gRPC Sent Messages"})
 	edgeDetailsGRPCReceivedMessages := edgeFields.Add("detail__grpcreceivedmessages", nil, []string{}, &data.FieldConfig{DisplayName: "gRPC Received Messages"})
 	edgeDetailsHTTPRate := edgeFields.Add("detail__httprate", nil, []string{}, &data.FieldConfig{DisplayName: "HTTP Rate"})
 	edgeDetailsHTTPErr := edgeFields.Add("detail__httperr", nil, []string{}, &data.FieldConfig{DisplayName: "HTTP Error"})
+	edgeDetailsHTTPThrottled := edgeFields.Add("detail__httpthrottled", nil, []string{}, &data.FieldConfig{DisplayName: "HTTP Throttled"})
 	edgeDetailsHTTPDuration := edgeFields.Add("detail__httpduration", nil, []string{}, &data.FieldConfig{DisplayName: "HTTP Duration"})
 	edgeDetailsTCPSentBytes := edgeFields.Add("detail__tcpsentbytes", nil, []string{}, &data.FieldConfig{DisplayName: "TCP Sent"})
 	edgeDetailsTCPReceivedBytes := edgeFields.Add("detail__tcpreceivedbytes", nil, []string{}, &data.FieldConfig{DisplayName: "TCP Received"})
+	edgeDetailsTrafficSplit := edgeFields.Add("detail__trafficsplit", nil, []string{}, &data.FieldConfig{DisplayName: "Traffic Split"})
+	edgeDetailsIssues := edgeFields.Add("detail__issues", nil, []string{}, &data.FieldConfig{DisplayName: "Issues"})
+	edgeDetailsRetries := edgeFields.Add("detail__retries", nil, []string{}, &data.FieldConfig{DisplayName: "Retry Limit Exceeded"})
+	edgeDetailsLocality := edgeFields.Add("detail__locality", nil, []string{}, &data.FieldConfig{DisplayName: "Locality"})
+	edgeDetailsCrossZoneBytes := edgeFields.Add("detail__crosszonebytes", nil, []string{}, &data.FieldConfig{DisplayName: "Cross Zone"})
+
+	// The link to the edge dashboard is only added when an edge dashboard is
+	// configured, because the edges are not linked otherwise.
+	var edgeLink *data.Field
+	if d.istioEdgeDashboard != "" {
+		edgeLink = edgeFields.Add("link", nil, []string{}, &data.FieldConfig{
+			Links: []data.DataLink{
+				{
+					Title: "Istio Edge Dashboard",
+					URL:   "${__data.fields[\"link\"]}",
+				},
+			},
+		})
+	}
+	edgeLinks := d.addLinkFields(&edgeFields, models.IstioLinkTargetEdge)
 
-	for _, edge := range edges {
+	// The topology and compare fields are only added when the graph is
+	// compared with an expected topology or with another time range.
+	var edgeDetailsTopology *data.Field
+	if topologyStatus != nil {
+		edgeDetailsTopology = edgeFields.Add("detail__topology", nil, []string{}, &data.FieldConfig{DisplayName: "Topology"})
+	}
+	var edgeDetailsCompare *data.Field
+	if compareStatus != nil {
+		edgeDetailsCompare = edgeFields.Add("detail__compare", nil, []string{}, &data.FieldConfig{DisplayName: fmt.Sprintf("Compared to %s", options.compareEvaluationTime)})
+	}
+
+	// The edges and nodes are sorted before the fields are generated, because
+	// the order of the maps is random and the layout of the node graph would
+	// change on every refresh otherwise.
+	for _, edge := range sortEdges(edges, options.sortByTraffic) {
 		edgeField := d.getEdgeField(edge, float64(interval))
 
 		edgeIds.Append(edgeField.ID)
@@ -528,22 +1030,65 @@ func (d *Datasource) handleGraph(ctx context.Context, namespace, application, wo
 		edgeMainStat.Append(strings.Join(edgeField.MainStat, " | "))
 		edgeSecondaryStat.Append(strings.Join(edgeField.SecondaryStat, " | "))
 		edgeColors.Append(edgeField.Color)
+		edgeMirror.Append(edge.Mirror)
+		if topologyStatus[edge.ID] == topologyStatusMissing || compareStatus[edge.ID] == compareStatusRemoved {
+			edgeStrokeDasharray.Append("2 2")
+		} else if edge.Mirror {
+			edgeStrokeDasharray.Append("5 5")
+		} else {
+			edgeStrokeDasharray.Append("")
+		}
+		if edgeDetailsTopology != nil {
+			edgeDetailsTopology.Append(cmp.Or(topologyStatus[edge.ID], "-"))
+		}
+		if edgeDetailsCompare != nil {
+			edgeDetailsCompare.Append(cmp.Or(compareStatus[edge.ID], "-"))
+		}
 		edgeDetailsGRPCRate.Append(strings.Join(edgeField.DetailsGRPCRate, " | "))
 		edgeDetailsGRPCErr.Append(strings.Join(edgeField.DetailsGRPCErr, " | "))
+		edgeDetailsGRPCThrottled.Append(strings.Join(edgeField.DetailsGRPCThrottled, " | "))
 		edgeDetailsGRPCDuration.Append(strings.Join(edgeField.DetailsGRPCDuration, " | "))
 		edgeDetailsGRPCSentMessages.Append(strings.Join(edgeField.DetailsGRPCSentMessages, " | "))
 		edgeDetailsGRPCReceivedMessages.Append(strings.Join(edgeField.DetailsGRPCReceivedMessages, " | "))
 		edgeDetailsHTTPRate.Append(strings.Join(edgeField.DetailsHTTPRate, " | "))
 		edgeDetailsHTTPErr.Append(strings.Join(edgeField.DetailsHTTPErr, " | "))
+		edgeDetailsHTTPThrottled.Append(strings.Join(edgeField.DetailsHTTPThrottled, " | "))
 		edgeDetailsHTTPDuration.Append(strings.Join(edgeField.DetailsHTTPDuration, " | "))
 		edgeDetailsTCPSentBytes.Append(strings.Join(edgeField.DetailsTCPSentBytes, " | "))
 		edgeDetailsTCPReceivedBytes.Append(strings.Join(edgeField.DetailsTCPReceivedBytes, " | "))
+		edgeDetailsIssues.Append(strings.Join(edgeField.DetailsIssues, " | "))
+		edgeDetailsRetries.Append(strings.Join(edgeField.DetailsRetries, " | "))
+		edgeDetailsLocality.Append(strings.Join(edgeField.DetailsLocality, " | "))
+		edgeDetailsCrossZoneBytes.Append(strings.Join(edgeField.DetailsCrossZoneBytes, " | "))
+
+		// The traffic split is only shown for the edges from a service to its
+		// workloads. The share of each edge is calculated based on the total
+		// number of requests the service received.
+		if node, ok := nodes[edge.Source]; ok && edge.SourceType == "Service" {
+			edgeDetailsTrafficSplit.Append(getTrafficSplit(edge.Versions, node.Versions))
+		} else {
+			edgeDetailsTrafficSplit.Append("-")
+		}
+
+		if edgeLink != nil {
+			edgeLink.Append(d.getEdgeLink(edge, linkParams))
+		}
+		for _, link := range edgeLinks {
+			link.field.Append(getEdgeLinkURL(link.link, edge, linkParams, timeRange))
+		}
 	}
 
 	nodeFields := models.Fields{}
 	nodeIds := nodeFields.Add("id", nil, []string{})
 	nodeTitles := nodeFields.Add("title", nil, []string{}, &data.FieldConfig{DisplayName: "Type"})
-	nodeSubTitles := nodeFields.Add("subtitle", nil, []string{}, &data.FieldConfig{DisplayName: "Name (Namespace)"})
+	// In a multi-cluster mesh the same workload can run in multiple clusters,
+	// so that the subtitle is prefixed with the cluster of the node.
+	nodeSubTitlesDisplayName := "Name (Namespace)"
+	if d.istioMultiCluster {
+		nodeSubTitlesDisplayName = "Cluster: Name (Namespace)"
+	}
+	nodeSubTitles := nodeFields.Add("subtitle", nil, []string{}, &data.FieldConfig{DisplayName: nodeSubTitlesDisplayName})
+	nodeNamespaces := nodeFields.Add("namespace", nil, []string{}, &data.FieldConfig{DisplayName: "Namespace"})
 	nodeMainStat := nodeFields.Add("mainstat", nil, []string{}, &data.FieldConfig{DisplayName: "Main Stats"})
 	nodeSecondaryStat := nodeFields.Add("secondarystat", nil, []string{}, &data.FieldConfig{DisplayName: "Secondary Stats"})
 	nodeColors := nodeFields.Add("color", nil, []string{}, &data.FieldConfig{DisplayName: "Health"})
@@ -555,6 +1100,7 @@ func (d *Datasource) handleGraph(ctx context.Context, namespace, application, wo
 	nodeDetailsHTTPErr := nodeFields.Add("detail__httperr", nil, []string{}, &data.FieldConfig{DisplayName: "HTTP Error"})
 	nodeDetailsTCPSentBytes := nodeFields.Add("detail__tcpsentbytes", nil, []string{}, &data.FieldConfig{DisplayName: "TCP Sent"})
 	nodeDetailsTCPReceivedBytes := nodeFields.Add("detail__tcpreceivedbytes", nil, []string{}, &data.FieldConfig{DisplayName: "TCP Received"})
+	nodeDetailsTrafficSplit := nodeFields.Add("detail__trafficsplit", nil, []string{}, &data.FieldConfig{DisplayName: "Traffic Split"})
 	nodeLink := nodeFields.Add("link", nil, []string{}, &data.FieldConfig{
 		Links: []data.DataLink{
 			{
@@ -564,139 +1110,690 @@ func (d *Datasource) handleGraph(ctx context.Context, namespace, application, wo
 		},
 	})
 
-	for _, node := range nodes {
-		nodeField := d.getNodeField(node, float64(interval))
+	nodeLinks := d.addLinkFields(&nodeFields, models.IstioLinkTargetNode)
+	nodeAnnotationFields := d.addAnnotationFields(&nodeFields)
+
+	// The firing alerts of the nodes are only retrieved when an Alertmanager
+	// is configured. A failing request should not fail the graph, so that the
+	// error is only logged and the alerts are empty.
+	var nodeDetailsAlerts *data.Field
+	var alerts map[string][]string
+	if d.alertmanagerClient != nil {
+		nodeDetailsAlerts = nodeFields.Add("detail__alerts", nil, []string{}, &data.FieldConfig{DisplayName: "Active Alerts"})
+		alerts, err = d.getNodeAlerts(ctx, nodes)
+		if err != nil {
+			d.logger.Warn("Failed to get node alerts", "error", err.Error())
+		}
+	}
+
+	// The annotations of the workloads and services are only retrieved when
+	// annotations are configured. A failing query should not fail the graph,
+	// so that the error is only logged and the annotations are empty.
+	var annotations map[string]map[string]string
+	if len(d.istioAnnotations) > 0 {
+		annotations, err = d.getNodeAnnotations(ctx, nodes, timeRange)
+		if err != nil {
+			d.logger.Warn("Failed to get node annotations", "error", err.Error())
+		}
+	}
+
+	for _, node := range sortNodes(nodes, options.sortByTraffic) {
+		nodeField := d.getNodeField(node, float64(interval))
+
+		nodeIds.Append(nodeField.ID)
+		nodeTitles.Append(node.Type)
+		if d.istioMultiCluster && node.Cluster != "" {
+			nodeSubTitles.Append(fmt.Sprintf("%s: %s (%s)", node.Cluster, node.Name, node.Namespace))
+		} else {
+			nodeSubTitles.Append(fmt.Sprintf("%s (%s)", node.Name, node.Namespace))
+		}
+		nodeNamespaces.Append(node.Namespace)
+		nodeMainStat.Append(strings.Join(nodeField.MainStat, " | "))
+		nodeSecondaryStat.Append(strings.Join(nodeField.SecondaryStat, " | "))
+		if len(alerts[node.ID]) > 0 {
+			nodeColors.Append(alertColor)
+		} else {
+			nodeColors.Append(nodeField.Color)
+		}
+		nodeDetailsGRPCRate.Append(strings.Join(nodeField.DetailsGRPCRate, " | "))
+		nodeDetailsGRPCErr.Append(strings.Join(nodeField.DetailsGRPCErr, " | "))
+		nodeDetailsGRPCSentMessages.Append(strings.Join(nodeField.DetailsGRPCSentMessages, " | "))
+		nodeDetailsGRPCReceivedMessages.Append(strings.Join(nodeField.DetailsGRPCReceivedMessages, " | "))
+		nodeDetailsHTTPRate.Append(strings.Join(nodeField.DetailsHTTPRate, " | "))
+		nodeDetailsHTTPErr.Append(strings.Join(nodeField.DetailsHTTPErr, " | "))
+		nodeDetailsTCPSentBytes.Append(strings.Join(nodeField.DetailsTCPSentBytes, " | "))
+		nodeDetailsTCPReceivedBytes.Append(strings.Join(nodeField.DetailsTCPReceivedBytes, " | "))
+		if node.Type == "Service" {
+			nodeDetailsTrafficSplit.Append(getTrafficSplit(node.Versions, node.Versions))
+		} else {
+			nodeDetailsTrafficSplit.Append("-")
+		}
+
+		nodeLink.Append(d.getNodeLink(node, linkParams))
+		for _, link := range nodeLinks {
+			link.field.Append(getNodeLinkURL(link.link, node, linkParams, timeRange))
+		}
+		if nodeDetailsAlerts != nil {
+			nodeDetailsAlerts.Append(cmp.Or(strings.Join(alerts[node.ID], ", "), "-"))
+		}
+		for _, annotationField := range nodeAnnotationFields {
+			annotationField.appendValue(nodeAnnotations(annotations, node))
+		}
+	}
+
+	// Generate the backend data response with the edge and node data frames.
+	// Alos set the preferred visualization to "node graph" for both frames, so
+	// that Grafana knows how to visualize them.
+	edgeFrame := data.NewFrame("edges", edgeFields...).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+	nodeFrame := data.NewFrame("nodes", nodeFields...).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+
+	// Attach a notice with the timestamp of the most recent sample to the
+	// frames, so that users can see when the telemetry is stale, e.g. because
+	// of a scrape outage. A failing freshness query should not fail the graph,
+	// so that the error is only logged.
+	latest, evaluationTime, err := d.getDataFreshness(ctx, options.namespace, timeRange)
+	if err != nil {
+		d.logger.Warn("Failed to get data freshness", "error", err.Error())
+	} else {
+		notice := dataFreshnessNotice(latest, evaluationTime)
+		edgeFrame.AppendNotices(notice)
+		nodeFrame.AppendNotices(notice)
+	}
+
+	if notice, ok := d.cardinalityNotice(options.namespace); ok {
+		edgeFrame.AppendNotices(notice)
+		nodeFrame.AppendNotices(notice)
+	}
+
+	for _, degradation := range stats.degradations {
+		notice := data.Notice{Severity: data.NoticeSeverityWarning, Text: degradation}
+		edgeFrame.AppendNotices(notice)
+		nodeFrame.AppendNotices(notice)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, edgeFrame)
+	response.Frames = append(response.Frames, nodeFrame)
+
+	// If the "edgeTable" option is set, we also return the edges as table
+	// with sparklines of their request rate, which requires additional range
+	// queries.
+	if options.edgeTable {
+		edgeTableFrames, err := d.getEdgeTableFrames(ctx, edges, options, timeRange)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+		response.Frames = append(response.Frames, edgeTableFrames...)
+	}
+
+	// If the "timeSeries" option is set, we also return the request rate and
+	// error rate of each edge over time, which requires additional range
+	// queries.
+	if options.timeSeries {
+		edgeTimeSeriesFrames, err := d.getEdgeTimeSeriesFrames(ctx, edges, options, timeRange)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+		response.Frames = append(response.Frames, edgeTimeSeriesFrames...)
+	}
+
+	return response
+}
+
+// getGraph returns the edges and nodes of the graph for the given options and
+// time range. If the same graph is already generated, e.g. because multiple
+// users opened the same dashboard, we wait for the running generation and share
+// its result instead of sending the same queries to Prometheus again. The
+// result is only shared within the same organization and, when the Grafana user
+// or cookies are forwarded to Prometheus, with the same user, because
+// Prometheus may return different metrics per user.
+func (d *Datasource) getGraph(ctx context.Context, options graphOptions, timeRange backend.TimeRange) (map[string]models.Edge, map[string]models.Node, graphStats, error) {
+	key := d.sharedKey(ctx, fmt.Sprintf("%+v/%d/%d", options, timeRange.From.UnixMilli(), timeRange.To.UnixMilli()))
+
+	result, shared, err := doShared(ctx, &d.graphGroup, key, func(ctx context.Context) (any, error) {
+		edges, nodes, stats, err := d.generateGraph(ctx, options, timeRange)
+		return graphResult{edges: edges, nodes: nodes, stats: stats}, err
+	})
+	if err != nil {
+		return nil, nil, graphStats{}, err
+	}
+
+	// The edges and nodes are copied for all callers, because the graph
+	// handler modifies them, e.g. when the graph is compared with an expected
+	// topology.
+	graph := result.(graphResult)
+	if shared {
+		d.logger.Debug("Shared graph", "namespace", options.namespace, "app", options.application, "workload", options.workload)
+	}
+
+	return maps.Clone(graph.edges), maps.Clone(graph.nodes), graph.stats, nil
+}
+
+// sharedKey returns the key of the "graphGroup" and "labelValuesGroup" for the
+// given key. The key is prefixed with the organization, so that a result is
+// never shared with another organization, which may be restricted to other
+// namespaces. When Prometheus is queried per user, the key is also prefixed
+// with the login of the user.
+func (d *Datasource) sharedKey(ctx context.Context, key string) string {
+	if d.prometheusPerUser {
+		if user := backend.UserFromContext(ctx); user != nil {
+			key = user.Login + "/" + key
+		}
+	}
+	return fmt.Sprintf("%d/%s", backend.PluginConfigFromContext(ctx).OrgID, key)
+}
+
+// graphResult is the result of a graph generation, which is shared via the
+// "graphGroup" of the datasource.
+type graphResult struct {
+	edges map[string]models.Edge
+	nodes map[string]models.Node
+	stats graphStats
+}
+
+// graphStats contains the number of series, which were returned by Prometheus
+// for a graph, before and after the deduplication, and the degradations of the
+// graph, e.g. when a metric was omitted because of too many samples.
+type graphStats struct {
+	series             int
+	deduplicatedSeries int
+	degradations       []string
+}
+
+// generateGraph retrieves all the requested metrics for the given namespace,
+// application or workload and generates the edges and nodes of the graph based
+// on the metrics.
+func (d *Datasource) generateGraph(ctx context.Context, options graphOptions, timeRange backend.TimeRange) (map[string]models.Edge, map[string]models.Node, graphStats, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "generateGraph")
+	defer span.End()
+
+	interval := int64(timeRange.Duration().Seconds())
+
+	var workloads []string
+	if options.workload != "" {
+		workloads = []string{options.workload}
+	}
+
+	reportGraphProgress(ctx, "metrics")
+
+	ctx, degradations := withGraphDegradations(ctx)
+
+	prometheusMetrics, err := d.getGraphMetrics(ctx, []graphTarget{{namespace: options.namespace, application: options.application, workloads: workloads}}, options, interval, timeRange)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, nil, graphStats{}, err
+	}
+
+	// If a depth greater than 1 is set for an application or workload graph,
+	// we follow the edges to the discovered neighbors and also get the metrics
+	// for them. This is repeated until the depth is reached or until no new
+	// neighbors are discovered. The neighbors are grouped by namespace and the
+	// queries for all namespaces of a hop are part of the same batch.
+	//
+	// The workloads are marked as visited via the "<namespace>/<workload>"
+	// labels, which are part of the grouping labels of the graph queries. For
+	// an application graph the workloads of the application are retrieved
+	// first, because the app labels are not part of the grouping labels.
+	if (options.application != "" || options.workload != "") && min(options.depth, maxGraphDepth) > 1 {
+		focalWorkloads := workloads
+		if options.application != "" {
+			focalWorkloads, err = d.getApplicationWorkloads(ctx, options.namespace, options.application, timeRange)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return nil, nil, graphStats{}, err
+			}
+		}
+
+		visited := make(map[string]bool)
+		for _, workload := range focalWorkloads {
+			visited[fmt.Sprintf("%s/%s", options.namespace, workload)] = true
+		}
+		newMetrics := prometheusMetrics
+
+		for hop := 1; hop < min(options.depth, maxGraphDepth); hop++ {
+			reportGraphProgress(ctx, fmt.Sprintf("hop %d of %d", hop+1, min(options.depth, maxGraphDepth)))
+
+			neighbors := d.getNeighbors(newMetrics, visited, options)
+			if len(neighbors) == 0 {
+				break
+			}
+
+			targets := make([]graphTarget, 0, len(neighbors))
+			for _, namespace := range slices.Sorted(maps.Keys(neighbors)) {
+				d.logger.Debug("Get metrics for neighbors", "hop", hop, "namespace", namespace, "workloads", neighbors[namespace])
+				targets = append(targets, graphTarget{namespace: namespace, workloads: neighbors[namespace]})
+			}
+
+			newMetrics, err = d.getGraphMetrics(ctx, targets, options, interval, timeRange)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return nil, nil, graphStats{}, err
+			}
+
+			prometheusMetrics = append(prometheusMetrics, newMetrics...)
+		}
+	}
+
+	// Deduplicate the metrics (metrics where all labels are the same), generate
+	// the edges based on the metrics and then generate the nodes based on the
+	// edges.
+	reportGraphProgress(ctx, "edges")
+
+	stats := graphStats{series: len(prometheusMetrics), degradations: degradations.list()}
+	prometheusMetrics = d.deduplicateMetrics(prometheusMetrics)
+	stats.deduplicatedSeries = len(prometheusMetrics)
+	if options.aggregateByApp {
+		prometheusMetrics = aggregateMetricsByApp(prometheusMetrics)
+	}
+	edges := d.metricsToEdges(prometheusMetrics, options)
+
+	// If the "workloadDurations" option is set, we also set the request
+	// durations for the edges from services to workloads. This isn't needed
+	// when service nodes are hidden, because then the edges already have a
+	// duration.
+	if options.workloadDurations && !options.hideServiceNodes {
+		err := d.addWorkloadDurations(ctx, edges, options, timeRange)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, nil, graphStats{}, err
+		}
+	}
+
+	// If a path destination is set, we only keep the edges which are part of
+	// a path from the focal workload to the destination workload.
+	if options.pathDestination != "" {
+		edges = filterPathEdges(edges, nodeID("Workload", options.namespace, options.workload, ""), options.pathDestination)
+	}
+	reportGraphProgress(ctx, "nodes")
+
+	nodes := d.edgesToNodes(edges)
+
+	// If the "idleNodes" option is set, we also add all services and
+	// workloads of the namespace which do not have any traffic in the selected
+	// time range.
+	if options.idleNodes {
+		err := d.addIdleNodes(ctx, nodes, options, timeRange)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, nil, graphStats{}, err
+		}
+	}
+
+	// Workloads which are deployed by Istio for a Gateway API gateway are shown
+	// as "Gateway" nodes, so that they can be distinguished from the ordinary
+	// workloads.
+	markGatewayNodes(nodes)
+
+	return edges, nodes, stats, nil
+}
+
+// graphTarget is a namespace, application or a list of workloads in a
+// namespace, for which the metrics of a graph are retrieved.
+type graphTarget struct {
+	namespace   string
+	application string
+	workloads   []string
+}
+
+// getApplicationWorkloads returns the workloads of the given application, which
+// sent or received requests in the given time range.
+func (d *Datasource) getApplicationWorkloads(ctx context.Context, namespace, application string, timeRange backend.TimeRange) ([]string, error) {
+	return d.getLabelValues(ctx, []prometheus.LabelValuesQuery{{
+		Label:     "destination_workload",
+		Selectors: []prometheus.Selector{{Metric: "istio_requests_total", Matchers: prometheus.Matchers{prometheus.Equal("destination_workload_namespace", namespace), prometheus.Equal("destination_app", application)}}},
+	}, {
+		Label:     "source_workload",
+		Selectors: []prometheus.Selector{{Metric: "istio_requests_total", Matchers: prometheus.Matchers{prometheus.Equal("source_workload_namespace", namespace), prometheus.Equal("source_app", application)}}},
+	}}, timeRange)
+}
+
+// getGraphMetrics gets all the requested metrics for the given targets. The
+// metrics are retrieved with a single batch for all targets and metrics, where
+// the namespace / application / workloads of a target are the destination or
+// the source.
+func (d *Datasource) getGraphMetrics(ctx context.Context, targets []graphTarget, options graphOptions, interval int64, timeRange backend.TimeRange) ([]prometheus.Metric, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getGraphMetrics")
+	defer span.End()
+
+	// If issues should be detected, we also have to get the response flags
+	// for all requests, which are not part of the user selected metrics.
+	metrics := options.metrics
+	if options.detectIssues {
+		metrics = append(slices.Clone(metrics), models.MetricResponseFlags)
+	}
+
+	// The TCP metrics do not have a response code, so that they can not be
+	// filtered by the response classes and are skipped instead.
+	if len(options.includeResponseClasses) > 0 {
+		metrics = slices.DeleteFunc(slices.Clone(metrics), func(metric string) bool {
+			return metric == models.MetricTCPSentBytes || metric == models.MetricTCPReceivedBytes
+		})
+	}
+
+	// Metrics which were not found by the metric detection are skipped, so
+	// that a disabled metric in the telemetry configuration of Istio doesn't
+	// result in failing queries. Unknown metrics are skipped as well, because
+	// there is no query for them.
+	metrics = slices.DeleteFunc(slices.Clone(metrics), func(metric string) bool {
+		template, ok := d.graphQueryTemplate(metric)
+		return !ok || !d.isMetricAvailable(template.metric)
+	})
+
+	// Get all metrics for the given namespace, application or workload. We
+	// need to get the metrics where the namespace / application / workload is
+	// the detination or the source to build the full graph. The queries for
+	// all metrics and directions are part of the same batch, so that they
+	// share the concurrency limit of the Prometheus client and we have to wait
+	// for the slowest query only once.
+	type direction struct {
+		name  string
+		query graphQueryBuilder
+	}
+	directions := []direction{
+		{name: "destination", query: d.metricToPrometheusDestinationsQuery},
+		{name: "source", query: d.metricToPrometheusSourcesQuery},
+	}
+
+	// The graph of a service only contains the requests sent to the service, so
+	// that we only need the metrics where the service is the destination.
+	if options.service != "" {
+		directions = []direction{{name: "service", query: d.metricToPrometheusServiceQuery}}
+	}
+
+	queries := make([]increaseQuery, 0, len(targets)*len(metrics)*len(directions))
+	for _, target := range targets {
+		// If the "matchServiceNamespace" option is set, we also get the
+		// metrics where the workloads are the destination of a service in the
+		// namespace, so that traffic routed via services in the namespace to
+		// workloads in other namespaces is part of the graph. Metrics which
+		// are returned by both destination queries are removed by the
+		// deduplication.
+		targetDirections := directions
+		if options.matchServiceNamespace && options.service == "" && len(target.workloads) > 0 {
+			targetDirections = append(slices.Clip(directions), direction{name: "service destination", query: d.metricToPrometheusServiceDestinationsQuery})
+		}
+
+		for _, metric := range metrics {
+			for _, direction := range targetDirections {
+				queries = append(queries, increaseQuery{metric: metric, query: func(interval int64) prometheus.Expression {
+					return direction.query(target.namespace, target.application, target.workloads, metric, options, interval)
+				}})
+			}
+		}
+	}
+
+	d.logger.Debug("Get metrics", "metrics", metrics, "targets", len(targets), "timeRangeFrom", timeRange.From, "timeRangeTo", timeRange.To, "interval", interval)
+
+	prometheusMetrics, err := d.getIncreaseMetrics(ctx, queries, timeRange)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	d.logger.Debug("Retrieved metrics", "metrics", metrics, "targets", len(targets), "count", len(prometheusMetrics))
+
+	return prometheusMetrics, nil
+}
+
+// increaseQuery is a query of a graph metric, where the given interval is used
+// as window for the "increase" function.
+type increaseQuery struct {
+	metric string
+	query  func(interval int64) prometheus.Expression
+}
+
+// isDurationMetric returns true if the given graph metric is a request
+// duration, which is calculated via the quantile of a histogram.
+func isDurationMetric(metric string) bool {
+	return metric == models.MetricGRPCRequestDuration || metric == models.MetricHTTPRequestDuration
+}
+
+// increaseWindow returns the maximum window of the query for the given metric.
+// The request durations are never split, because the quantiles of the windows
+// can not be summed up.
+func increaseWindow(metric string, timeRange backend.TimeRange, maxWindow time.Duration) time.Duration {
+	if isDurationMetric(metric) || maxWindow <= 0 || maxWindow > timeRange.Duration() {
+		return timeRange.Duration()
+	}
+	return maxWindow
+}
+
+// getIncreaseMetrics returns the metrics for the given queries. If the time
+// range is longer than the configured maximum window, the time range is split
+// into multiple windows and the results are summed up. This allows us to stay
+// below the "query.max-samples" limit of Prometheus for long time ranges,
+// while preserving the totals. All queries and windows are executed as a
+// single batch.
+//
+// If Prometheus rejects a query, because it would load too many samples, the
+// queries are retried one after another, because we do not know which query
+// was rejected. A rejected query is retried once with a window, which is a
+// fraction of the previous window. If this also fails, or if the request
+// durations are rejected, the metric is omitted. In both cases a degradation
+// is reported, so that the graph is still rendered with a notice instead of
+// failing the panel.
+func (d *Datasource) getIncreaseMetrics(ctx context.Context, queries []increaseQuery, timeRange backend.TimeRange) ([]prometheus.Metric, error) {
+	metrics, err := d.getSplitIncreaseMetrics(ctx, queries, timeRange, d.prometheusMaxWindow)
+	if err == nil || !isQueryOverloadError(err) {
+		return metrics, err
+	}
+
+	metrics = nil
+	for _, query := range queries {
+		queryMetrics, err := d.getDegradedIncreaseMetrics(ctx, query, timeRange)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, queryMetrics...)
+	}
+
+	return metrics, nil
+}
+
+// getDegradedIncreaseMetrics returns the metrics for a single query, which is
+// retried with a smaller window or omitted, when Prometheus rejects it,
+// because it would load too many samples.
+func (d *Datasource) getDegradedIncreaseMetrics(ctx context.Context, query increaseQuery, timeRange backend.TimeRange) ([]prometheus.Metric, error) {
+	metrics, err := d.getSplitIncreaseMetrics(ctx, []increaseQuery{query}, timeRange, d.prometheusMaxWindow)
+	if err == nil || !isQueryOverloadError(err) {
+		return metrics, err
+	}
+
+	if !isDurationMetric(query.metric) {
+		retryWindow := (increaseWindow(query.metric, timeRange, d.prometheusMaxWindow) / overloadRetrySplit).Truncate(time.Second)
+		if retryWindow >= time.Minute {
+			d.logger.Warn("Query would load too many samples, retry with a smaller window", "metric", query.metric, "window", retryWindow)
+
+			metrics, err = d.getSplitIncreaseMetrics(ctx, []increaseQuery{query}, timeRange, retryWindow)
+			if err == nil {
+				reportGraphDegradation(ctx, fmt.Sprintf("The %s metric was queried in windows of %s, because the query would load too many samples", query.metric, model.Duration(retryWindow)))
+				return metrics, nil
+			}
+			if !isQueryOverloadError(err) {
+				return nil, err
+			}
+		}
+	}
+
+	d.logger.Warn("Query would load too many samples, omit metric", "metric", query.metric, "error", err.Error())
+	reportGraphDegradation(ctx, fmt.Sprintf("The %s metric is omitted, because the query would load too many samples", query.metric))
+	return nil, nil
+}
+
+// getSplitIncreaseMetrics returns the metrics for the given queries, where the
+// time range is split into windows, which are not longer than the given
+// maximum window. The windows of all queries are queried as a single batch, so
+// that they share the concurrency limit of the Prometheus client with all
+// other queries. The metrics of the windows of a query are summed up, while
+// the metrics of different queries are kept apart.
+func (d *Datasource) getSplitIncreaseMetrics(ctx context.Context, queries []increaseQuery, timeRange backend.TimeRange, maxWindow time.Duration) ([]prometheus.Metric, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "getSplitIncreaseMetrics")
+	defer span.End()
+
+	var batch []prometheus.MetricsQuery
+	var batchQueries []int
+	windowCounts := make([]int, len(queries))
+
+	for i, query := range queries {
+		windows := []backend.TimeRange{timeRange}
+		if window := increaseWindow(query.metric, timeRange, maxWindow); window < timeRange.Duration() {
+			windows = splitTimeRange(timeRange, window)
+			d.logger.Debug("Split time range", "metric", query.metric, "timeRangeFrom", timeRange.From, "timeRangeTo", timeRange.To, "windows", len(windows))
+		}
+
+		for _, window := range windows {
+			batch = append(batch, prometheus.MetricsQuery{Metric: query.metric, Query: query.query(int64(window.Duration().Seconds())), TimeRange: window})
+			batchQueries = append(batchQueries, i)
+		}
+		windowCounts[i] = len(windows)
+	}
+
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	sums := make(map[int]map[string]int)
+
+	for j, result := range results {
+		i := batchQueries[j]
+		if windowCounts[i] == 1 {
+			metrics = append(metrics, result...)
+			continue
+		}
+
+		if sums[i] == nil {
+			sums[i] = make(map[string]int)
+		}
+		for _, m := range result {
+			key := metricKey(m.Labels)
+			if index, ok := sums[i][key]; ok {
+				metrics[index].Value += m.Value
+			} else {
+				sums[i][key] = len(metrics)
+				metrics = append(metrics, m)
+			}
+		}
+	}
+
+	return metrics, nil
+}
 
-		nodeIds.Append(nodeField.ID)
-		nodeTitles.Append(node.Type)
-		nodeSubTitles.Append(fmt.Sprintf("%s (%s)", node.Name, node.Namespace))
-		nodeMainStat.Append(strings.Join(nodeField.MainStat, " | "))
-		nodeSecondaryStat.Append(strings.Join(nodeField.SecondaryStat, " | "))
-		nodeColors.Append(nodeField.Color)
-		nodeDetailsGRPCRate.Append(strings.Join(nodeField.DetailsGRPCRate, " | "))
-		nodeDetailsGRPCErr.Append(strings.Join(nodeField.DetailsGRPCErr, " | "))
-		nodeDetailsGRPCSentMessages.Append(strings.Join(nodeField.DetailsGRPCSentMessages, " | "))
-		nodeDetailsGRPCReceivedMessages.Append(strings.Join(nodeField.DetailsGRPCReceivedMessages, " | "))
-		nodeDetailsHTTPRate.Append(strings.Join(nodeField.DetailsHTTPRate, " | "))
-		nodeDetailsHTTPErr.Append(strings.Join(nodeField.DetailsHTTPErr, " | "))
-		nodeDetailsTCPSentBytes.Append(strings.Join(nodeField.DetailsTCPSentBytes, " | "))
-		nodeDetailsTCPReceivedBytes.Append(strings.Join(nodeField.DetailsTCPReceivedBytes, " | "))
+// overloadRetrySplit is the factor by which the window of a query is reduced,
+// when Prometheus rejects the query, because it would load too many samples.
+const overloadRetrySplit = 4
+
+// isQueryOverloadError returns true if Prometheus rejected a query, because it
+// would exceed the "query.max-samples" limit.
+func isQueryOverloadError(err error) bool {
+	return strings.Contains(err.Error(), "query processing would load too many samples")
+}
+
+// graphDegradationsKey is the context key for the degradations of a graph,
+// which are reported by "getIncreaseMetrics" when a metric can only be
+// retrieved with a reduced resolution or not at all.
+type graphDegradationsKey struct{}
+
+// graphDegradations contains the messages of all reported degradations of a
+// graph. The messages are deduplicated, because the same metric is queried for
+// multiple directions and hops.
+type graphDegradations struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func withGraphDegradations(ctx context.Context) (context.Context, *graphDegradations) {
+	degradations := &graphDegradations{}
+	return context.WithValue(ctx, graphDegradationsKey{}, degradations), degradations
+}
+
+func reportGraphDegradation(ctx context.Context, message string) {
+	if degradations, ok := ctx.Value(graphDegradationsKey{}).(*graphDegradations); ok {
+		degradations.mu.Lock()
+		defer degradations.mu.Unlock()
 
-		// Depending on the node type we link to the appropriate Istio dashboard
-		// with the correct variables set.
-		// - Service dashboard: https://grafana.com/grafana/dashboards/7636-istio-service-dashboard/
-		// - Workload dashboard: https://grafana.com/grafana/dashboards/7630-istio-workload-dashboard/
-		switch node.Type {
-		case "Service":
-			nodeLink.Append(fmt.Sprintf("%s&var-service=%s&from=%d&to=%d", d.istioServiceDashboard, node.Service, timeRange.From.UnixMilli(), timeRange.To.UnixMilli()))
-		case "Workload":
-			nodeLink.Append(fmt.Sprintf("%s&var-namespace=%s&var-workload=%s&from=%d&to=%d", d.istioWorkloadDashboard, node.Namespace, node.Name, timeRange.From.UnixMilli(), timeRange.To.UnixMilli()))
-		default:
-			nodeLink.Append("")
+		if !slices.Contains(degradations.messages, message) {
+			degradations.messages = append(degradations.messages, message)
 		}
 	}
+}
 
-	// Generate the backend data response with the edge and node data frames.
-	// Alos set the preferred visualization to "node graph" for both frames, so
-	// that Grafana knows how to visualize them.
-	edgeFrame := data.NewFrame("edges", edgeFields...).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
-	nodeFrame := data.NewFrame("nodes", nodeFields...).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+// list returns the sorted messages of the degradations.
+func (g *graphDegradations) list() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	var response backend.DataResponse
-	response.Frames = append(response.Frames, edgeFrame)
-	response.Frames = append(response.Frames, nodeFrame)
+	return slices.Sorted(slices.Values(g.messages))
+}
 
-	return response
+// splitTimeRange splits the given time range into multiple windows, which are
+// not longer than the given maximum window. The first window might be shorter
+// than the maximum window, so that all other windows are aligned to the end of
+// the time range.
+func splitTimeRange(timeRange backend.TimeRange, maxWindow time.Duration) []backend.TimeRange {
+	var windows []backend.TimeRange
+
+	for to := timeRange.To; to.After(timeRange.From); to = to.Add(-maxWindow) {
+		from := to.Add(-maxWindow)
+		if from.Before(timeRange.From) {
+			from = timeRange.From
+		}
+		windows = append(windows, backend.TimeRange{From: from, To: to})
+	}
+
+	return windows
 }
 
-// metricToPrometheusDestinationsQuery generates the Prometheus query for the
-// given metric where the application or workload is the destination.
-//
-// If the "idleEdges" parameter is set to true, the query will also include
-// edges with zero traffic. Otherwise, these edges will be filtered out using a
-// "> 0" operator.
-//
-// If the "application" parameter is set, the query will filter by the
-// "destination_app" label. If the "workload" parameter is set, the query will
-// filter by the "destination_workload" label.
-func (d *Datasource) metricToPrometheusDestinationsQuery(namespace, application, workload, metric string, idleEdges bool, interval int64) string {
-	operator := "> 0"
-	if idleEdges {
-		operator = ""
-	}
-
-	destinationLabel := ""
-	if application != "" {
-		destinationLabel = fmt.Sprintf(`, destination_app="%s"`, application)
-	} else if workload != "" {
-		destinationLabel = fmt.Sprintf(`, destination_workload="%s"`, workload)
-	}
-
-	switch metric {
-	case models.MetricGRPCRequests:
-		return fmt.Sprintf(`sum(increase(istio_requests_total{destination_workload_namespace="%s", request_protocol="grpc" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, grpc_response_status) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricGRPCRequestDuration:
-		return fmt.Sprintf(`histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds_bucket{destination_workload_namespace="%s", request_protocol="grpc" %s}[%ds])) by (le, destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload)) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricGRPCSentMessages:
-		return fmt.Sprintf(`sum(increase(istio_request_messages_total{destination_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricGRPCReceivedMessages:
-		return fmt.Sprintf(`sum(increase(istio_response_messages_total{destination_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricHTTPRequests:
-		return fmt.Sprintf(`sum(increase(istio_requests_total{destination_workload_namespace="%s", request_protocol="http" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, response_code) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricHTTPRequestDuration:
-		return fmt.Sprintf(`histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds_bucket{destination_workload_namespace="%s", request_protocol="http" %s}[%ds])) by (le, destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload)) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricTCPSentBytes:
-		return fmt.Sprintf(`sum(increase(istio_tcp_sent_bytes_total{destination_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, destinationLabel, interval, operator)
-	case models.MetricTCPReceivedBytes:
-		return fmt.Sprintf(`sum(increase(istio_tcp_received_bytes_total{destination_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, destinationLabel, interval, operator)
-	default:
-		return ""
+// metricKey returns a unique key for the given labels, which can be used to
+// identify a metric.
+func metricKey(labels map[string]string) string {
+	var key strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		key.WriteString(name)
+		key.WriteString("=")
+		key.WriteString(labels[name])
+		key.WriteString(",")
 	}
+	return key.String()
 }
 
-// metricToPrometheusSourcesQuery generates the Prometheus query for the given
-// metric where the application or workload is the source.
-//
-// If the "idleEdges" parameter is set to true, the query will also include
-// edges with zero traffic. Otherwise, these edges will be filtered out using a
-// "> 0" operator.
-//
-// If the "application" parameter is set, the query will filter by the
-// "source_app" label. If the "workload" parameter is set, the query will
-// filter by the "source_workload" label.
-func (d *Datasource) metricToPrometheusSourcesQuery(namespace, application, workload, metric string, idleEdges bool, interval int64) string {
-	operator := "> 0"
-	if idleEdges {
-		operator = ""
-	}
-
-	sourceLabel := ""
-	if application != "" {
-		sourceLabel = fmt.Sprintf(`, source_app="%s"`, application)
-	} else if workload != "" {
-		sourceLabel = fmt.Sprintf(`, source_workload="%s"`, workload)
-	}
-
-	switch metric {
-	case models.MetricGRPCRequests:
-		return fmt.Sprintf(`sum(increase(istio_requests_total{source_workload_namespace="%s", request_protocol="grpc" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, grpc_response_status) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricGRPCRequestDuration:
-		return fmt.Sprintf(`histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds_bucket{source_workload_namespace="%s", request_protocol="grpc" %s}[%ds])) by (le, destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload)) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricGRPCSentMessages:
-		return fmt.Sprintf(`sum(increase(istio_request_messages_total{source_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricGRPCReceivedMessages:
-		return fmt.Sprintf(`sum(increase(istio_response_messages_total{source_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricHTTPRequests:
-		return fmt.Sprintf(`sum(increase(istio_requests_total{source_workload_namespace="%s", request_protocol="http" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, response_code) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricHTTPRequestDuration:
-		return fmt.Sprintf(`histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds_bucket{source_workload_namespace="%s", request_protocol="http" %s}[%ds])) by (le, destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload)) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricTCPSentBytes:
-		return fmt.Sprintf(`sum(increase(istio_tcp_sent_bytes_total{source_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, sourceLabel, interval, operator)
-	case models.MetricTCPReceivedBytes:
-		return fmt.Sprintf(`sum(increase(istio_tcp_received_bytes_total{source_workload_namespace="%s" %s}[%ds])) by (destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload) %s`, namespace, sourceLabel, interval, operator)
-	default:
-		return ""
+// getNeighbors returns all the source and destination workloads from the given
+// metrics, which were not visited yet, grouped by their namespace. All
+// returned workloads are marked as visited. Workloads which match a source or
+// destination filter, workloads in a hidden namespace and unknown workloads are
+// ignored.
+func (d *Datasource) getNeighbors(metrics []prometheus.Metric, visited map[string]bool, options graphOptions) map[string][]string {
+	neighbors := make(map[string][]string)
+
+	for _, m := range metrics {
+		for _, prefix := range []string{"source", "destination"} {
+			namespace := m.Labels[prefix+"_workload_namespace"]
+			workload := m.Labels[prefix+"_workload"]
+			key := fmt.Sprintf("%s/%s", namespace, workload)
+
+			if namespace == "" || workload == "" || workload == "unknown" || visited[key] || slices.Contains(options.sourceFilters, key) || slices.Contains(options.destinationFilters, key) || slices.Contains(options.hiddenNamespaces, namespace) || !d.isNamespaceAllowed(namespace) {
+				continue
+			}
+
+			visited[key] = true
+			neighbors[namespace] = append(neighbors[namespace], workload)
+		}
 	}
+
+	return neighbors
 }
 
 // depuplicateMetrics removes duplicate metrics from the given slice of
@@ -722,32 +1819,85 @@ func (d *Datasource) deduplicateMetrics(metrics []prometheus.Metric) []prometheu
 
 // Generate the edges from the given Prometheus metrics. The edges are filtered
 // based on the given source and destination filters. If a source workload or
-// destination workload matches any of the filters, the edge is skipped.
-func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters, destinationFilters []string) map[string]models.Edge {
+// destination workload matches any of the filters, the edge is skipped. Edges
+// from or to a workload or service in a hidden namespace are also skipped.
+//
+// If "hideServiceNodes" is set, we create direct edges between the source and
+// destination workloads instead of going through the destination service.
+//
+// Mirrored (shadow) traffic is detected via the "-shadow" suffix, which Envoy
+// adds to the host of mirrored requests. If "excludeMirrors" is set,
+// the mirrored traffic is skipped, otherwise separate edges are created for
+// the mirrored traffic, so that it doesn't inflate the rates of the real
+// traffic.
+func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, options graphOptions) map[string]models.Edge {
 	edges := make(map[string]models.Edge)
+	hideServiceNodes := options.hideServiceNodes
 
 	for _, m := range metrics {
-		if slices.Contains(sourceFilters, fmt.Sprintf("%s/%s", m.Labels["source_workload_namespace"], m.Labels["source_workload"])) || slices.Contains(destinationFilters, fmt.Sprintf("%s/%s", m.Labels["destination_workload_namespace"], m.Labels["destination_workload"])) {
+		if slices.Contains(options.sourceFilters, fmt.Sprintf("%s/%s", m.Labels["source_workload_namespace"], m.Labels["source_workload"])) || slices.Contains(options.destinationFilters, fmt.Sprintf("%s/%s", m.Labels["destination_workload_namespace"], m.Labels["destination_workload"])) {
+			continue
+		}
+		if slices.Contains(options.hiddenNamespaces, m.Labels["source_workload_namespace"]) || slices.Contains(options.hiddenNamespaces, m.Labels["destination_workload_namespace"]) || slices.Contains(options.hiddenNamespaces, m.Labels["destination_service_namespace"]) {
+			continue
+		}
+
+		mirror := strings.HasSuffix(m.Labels["destination_service"], "-shadow") || strings.HasSuffix(m.Labels["destination_service_name"], "-shadow")
+		if mirror && options.excludeMirrors {
 			continue
 		}
 
 		var tmpEdges []models.Edge
 
 		// If the source or destination workload is a waypoint, create a direct
-		// edge between the source and destination workloads. Otherwise, create
-		// one edge from the source wrokload to the destination service and from
-		// the destination service to the destination workload.
+		// edge between the source and destination workloads. If service nodes
+		// should be hidden, we also create a direct edge between the source and
+		// destination workloads, but use the destination workload in the id,
+		// so that we get one edge per workload. Otherwise, create one edge from
+		// the source wrokload to the destination service and from the
+		// destination service to the destination workload.
 		if m.Labels["source_workload"] == "waypoint" || m.Labels["destination_workload"] == "waypoint" {
 			tmpEdges = []models.Edge{{
 				ID:                   fmt.Sprintf("workload-%s-%s-workload-%s-%s", m.Labels["source_workload"], m.Labels["source_workload_namespace"], m.Labels["destination_service_name"], m.Labels["destination_service_namespace"]),
-				Source:               fmt.Sprintf("Workload: %s (%s)", m.Labels["source_workload"], m.Labels["source_workload_namespace"]),
+				Source:               nodeID("Workload", m.Labels["source_workload_namespace"], m.Labels["source_workload"], m.Labels["source_cluster"]),
+				SourceType:           "Workload",
+				SourceName:           m.Labels["source_workload"],
+				SourceNamespace:      m.Labels["source_workload_namespace"],
+				SourceCluster:        m.Labels["source_cluster"],
+				Destination:          nodeID("Workload", m.Labels["destination_workload_namespace"], m.Labels["destination_workload"], m.Labels["destination_cluster"]),
+				DestinationType:      "Workload",
+				DestinationName:      m.Labels["destination_workload"],
+				DestinationNamespace: m.Labels["destination_workload_namespace"],
+				DestinationCluster:   m.Labels["destination_cluster"],
+				DestinationService:   m.Labels["destination_service"],
+				GRPCResponseCodes:    make(map[string]float64),
+				GRPCRequestsSuccess:  0,
+				GRPCRequestsError:    0,
+				GRPCRequestDuration:  0,
+				GRPCSentMessages:     0,
+				GRPCReceivedMessages: 0,
+				HTTPResponseCodes:    make(map[string]float64),
+				HTTPRequestsSuccess:  0,
+				HTTPRequestsError:    0,
+				HTTPRequestDuration:  0,
+				TCPSentBytes:         0,
+				TCPReceivedBytes:     0,
+				Versions:             make(map[string]float64),
+				ResponseFlags:        make(map[string]float64),
+			}}
+		} else if hideServiceNodes {
+			tmpEdges = []models.Edge{{
+				ID:                   fmt.Sprintf("workload-%s-%s-workload-%s-%s", m.Labels["source_workload"], m.Labels["source_workload_namespace"], m.Labels["destination_workload"], m.Labels["destination_workload_namespace"]),
+				Source:               nodeID("Workload", m.Labels["source_workload_namespace"], m.Labels["source_workload"], m.Labels["source_cluster"]),
 				SourceType:           "Workload",
 				SourceName:           m.Labels["source_workload"],
 				SourceNamespace:      m.Labels["source_workload_namespace"],
-				Destination:          fmt.Sprintf("Workload: %s (%s)", m.Labels["destination_workload"], m.Labels["destination_workload_namespace"]),
+				SourceCluster:        m.Labels["source_cluster"],
+				Destination:          nodeID("Workload", m.Labels["destination_workload_namespace"], m.Labels["destination_workload"], m.Labels["destination_cluster"]),
 				DestinationType:      "Workload",
 				DestinationName:      m.Labels["destination_workload"],
 				DestinationNamespace: m.Labels["destination_workload_namespace"],
+				DestinationCluster:   m.Labels["destination_cluster"],
 				DestinationService:   m.Labels["destination_service"],
 				GRPCResponseCodes:    make(map[string]float64),
 				GRPCRequestsSuccess:  0,
@@ -761,18 +1911,22 @@ func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters,
 				HTTPRequestDuration:  0,
 				TCPSentBytes:         0,
 				TCPReceivedBytes:     0,
+				Versions:             make(map[string]float64),
+				ResponseFlags:        make(map[string]float64),
 			}}
 		} else {
 			tmpEdges = []models.Edge{{
 				ID:                   fmt.Sprintf("workload-%s-%s-service-%s-%s", m.Labels["source_workload"], m.Labels["source_workload_namespace"], m.Labels["destination_service_name"], m.Labels["destination_service_namespace"]),
-				Source:               fmt.Sprintf("Workload: %s (%s)", m.Labels["source_workload"], m.Labels["source_workload_namespace"]),
+				Source:               nodeID("Workload", m.Labels["source_workload_namespace"], m.Labels["source_workload"], m.Labels["source_cluster"]),
 				SourceType:           "Workload",
 				SourceName:           m.Labels["source_workload"],
 				SourceNamespace:      m.Labels["source_workload_namespace"],
-				Destination:          fmt.Sprintf("Service: %s (%s)", m.Labels["destination_service_name"], m.Labels["destination_service_namespace"]),
+				SourceCluster:        m.Labels["source_cluster"],
+				Destination:          nodeID("Service", m.Labels["destination_service_namespace"], m.Labels["destination_service_name"], m.Labels["destination_cluster"]),
 				DestinationType:      "Service",
 				DestinationName:      m.Labels["destination_service_name"],
 				DestinationNamespace: m.Labels["destination_service_namespace"],
+				DestinationCluster:   m.Labels["destination_cluster"],
 				DestinationService:   m.Labels["destination_service"],
 				GRPCResponseCodes:    make(map[string]float64),
 				GRPCRequestsSuccess:  0,
@@ -786,16 +1940,20 @@ func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters,
 				HTTPRequestDuration:  0,
 				TCPSentBytes:         0,
 				TCPReceivedBytes:     0,
+				Versions:             make(map[string]float64),
+				ResponseFlags:        make(map[string]float64),
 			}, {
 				ID:                   fmt.Sprintf("service-%s-%s-workload-%s-%s", m.Labels["destination_service_name"], m.Labels["destination_service_namespace"], m.Labels["destination_workload"], m.Labels["destination_workload_namespace"]),
-				Source:               fmt.Sprintf("Service: %s (%s)", m.Labels["destination_service_name"], m.Labels["destination_service_namespace"]),
+				Source:               nodeID("Service", m.Labels["destination_service_namespace"], m.Labels["destination_service_name"], m.Labels["destination_cluster"]),
 				SourceType:           "Service",
 				SourceName:           m.Labels["destination_service_name"],
 				SourceNamespace:      m.Labels["destination_service_namespace"],
-				Destination:          fmt.Sprintf("Workload: %s (%s)", m.Labels["destination_workload"], m.Labels["destination_workload_namespace"]),
+				SourceCluster:        m.Labels["destination_cluster"],
+				Destination:          nodeID("Workload", m.Labels["destination_workload_namespace"], m.Labels["destination_workload"], m.Labels["destination_cluster"]),
 				DestinationType:      "Workload",
 				DestinationName:      m.Labels["destination_workload"],
 				DestinationNamespace: m.Labels["destination_workload_namespace"],
+				DestinationCluster:   m.Labels["destination_cluster"],
 				DestinationService:   m.Labels["destination_service"],
 				GRPCResponseCodes:    make(map[string]float64),
 				GRPCRequestsSuccess:  0,
@@ -809,6 +1967,8 @@ func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters,
 				HTTPRequestDuration:  0,
 				TCPSentBytes:         0,
 				TCPReceivedBytes:     0,
+				Versions:             make(map[string]float64),
+				ResponseFlags:        make(map[string]float64),
 			}}
 		}
 
@@ -827,29 +1987,66 @@ func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters,
 		//   https://gist.github.com/hamakn/708b9802ca845eb59f3975dbb3ae2a01).
 		// - A HTTP error is considered to be any response where the response
 		//   code starts with 5 (i.e., 5xx).
+		// - Throttled requests are tracked separately, these are all gRPC
+		//   requests with the "grpc_response_status" 8 (RESOURCE_EXHAUSTED) and
+		//   all HTTP requests with the response code 429. They are not counted
+		//   as errors.
+		// - For gRPC and HTTP requests we also keep track of the requests per
+		//   destination version, so that we can show the traffic split between
+		//   the versions of a service.
+		// - The response flags are split, because Envoy can set multiple flags
+		//   for a single request (e.g. "UF,URX").
 		// - For durations we take the latest value and only set it for edges
 		//   where the destination type is "Service", because for the edges from
 		//   services to workloads the duration depends on the source workload
-		//   and I think it doesn't make sens to aggregate them.
+		//   and I think it doesn't make sens to aggregate them. If service nodes
+		//   are hidden, the duration is set for the direct edges between the
+		//   source and destination workloads. The durations for the edges from
+		//   services to workloads can be added via the "workloadDurations"
+		//   option (see "addWorkloadDurations"). If the workloads are
+		//   aggregated by their app, we take the highest duration of all
+		//   workloads of the app.
 		for _, edge := range tmpEdges {
+			edge.ID = edge.ID + edgeClusterSuffix(edge.SourceCluster, edge.DestinationCluster)
+			if mirror {
+				edge.ID = edge.ID + "-mirror"
+				edge.Mirror = true
+			}
+
 			if _, ok := edges[edge.ID]; !ok {
 				edges[edge.ID] = edge
 			}
 
 			if existingEdge, ok := edges[edge.ID]; ok {
+				locality := ""
+				if options.locality {
+					locality = getLocality(m.Labels["source_locality"], m.Labels["destination_locality"])
+					if localityRank(locality) > localityRank(existingEdge.Locality) {
+						existingEdge.Locality = locality
+					}
+				}
+
 				switch m.Labels["metric"] {
 				case models.MetricGRPCRequests:
 					code := m.Labels["grpc_response_status"]
 					value := m.Value
 					existingEdge.GRPCResponseCodes[code] += value
-					if code == "2" || code == "4" || code == "12" || code == "13" || code == "14" || code == "15" {
+					existingEdge.Versions[m.Labels["destination_version"]] += value
+					if isGRPCError(code) {
 						existingEdge.GRPCRequestsError += value
 					} else {
 						existingEdge.GRPCRequestsSuccess += value
 					}
+					if code == "8" {
+						existingEdge.GRPCRequestsThrottled += value
+					}
 				case models.MetricGRPCRequestDuration:
-					if existingEdge.DestinationType == "Service" && m.Value > 0 {
-						existingEdge.GRPCRequestDuration = m.Value
+					if (existingEdge.DestinationType == "Service" || hideServiceNodes) && isValidDuration(m.Value) {
+						if options.aggregateByApp {
+							existingEdge.GRPCRequestDuration = max(existingEdge.GRPCRequestDuration, m.Value)
+						} else {
+							existingEdge.GRPCRequestDuration = m.Value
+						}
 					}
 				case models.MetricGRPCSentMessages:
 					existingEdge.GRPCSentMessages += m.Value
@@ -859,19 +2056,37 @@ func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters,
 					code := m.Labels["response_code"]
 					value := m.Value
 					existingEdge.HTTPResponseCodes[code] += value
-					if code[0] == '5' {
+					existingEdge.Versions[m.Labels["destination_version"]] += value
+					if isHTTPError(code) {
 						existingEdge.HTTPRequestsError += value
 					} else {
 						existingEdge.HTTPRequestsSuccess += value
 					}
+					if code == "429" {
+						existingEdge.HTTPRequestsThrottled += value
+					}
 				case models.MetricHTTPRequestDuration:
-					if existingEdge.DestinationType == "Service" && m.Value > 0 {
-						existingEdge.HTTPRequestDuration = m.Value
+					if (existingEdge.DestinationType == "Service" || hideServiceNodes) && isValidDuration(m.Value) {
+						if options.aggregateByApp {
+							existingEdge.HTTPRequestDuration = max(existingEdge.HTTPRequestDuration, m.Value)
+						} else {
+							existingEdge.HTTPRequestDuration = m.Value
+						}
 					}
 				case models.MetricTCPSentBytes:
 					existingEdge.TCPSentBytes += m.Value
+					if locality == localityCrossZone || locality == localityCrossRegion {
+						existingEdge.CrossZoneBytes += m.Value
+					}
 				case models.MetricTCPReceivedBytes:
 					existingEdge.TCPReceivedBytes += m.Value
+					if locality == localityCrossZone || locality == localityCrossRegion {
+						existingEdge.CrossZoneBytes += m.Value
+					}
+				case models.MetricResponseFlags:
+					for flag := range strings.SplitSeq(m.Labels["response_flags"], ",") {
+						existingEdge.ResponseFlags[flag] += m.Value
+					}
 				}
 
 				edges[edge.ID] = existingEdge
@@ -882,6 +2097,27 @@ func (d *Datasource) metricsToEdges(metrics []prometheus.Metric, sourceFilters,
 	return edges
 }
 
+// aggregateMetricsByApp replaces the source and destination workload of the
+// given metrics with their app, so that all workloads of the same app are
+// merged into one node when the edges and nodes are generated. Metrics without
+// an app keep their workload. The labels are copied, so that the original
+// metrics are not modified.
+func aggregateMetricsByApp(metrics []prometheus.Metric) []prometheus.Metric {
+	result := make([]prometheus.Metric, 0, len(metrics))
+
+	for _, m := range metrics {
+		labels := maps.Clone(m.Labels)
+		for _, prefix := range []string{"source", "destination"} {
+			if app := labels[prefix+"_app"]; app != "" && app != "unknown" {
+				labels[prefix+"_workload"] = app
+			}
+		}
+		result = append(result, prometheus.Metric{Labels: labels, Value: m.Value})
+	}
+
+	return result
+}
+
 // Generate the nodes from the given edges. The nodes are generated by going
 // through all the edges and aggregating the metrics for each node.
 func (d *Datasource) edgesToNodes(edges map[string]models.Edge) map[string]models.Node {
@@ -894,6 +2130,11 @@ func (d *Datasource) edgesToNodes(edges map[string]models.Edge) map[string]model
 	// - If the node is a source, the edge metrics are added as client metrics.
 	//   If the node is a destination, the edge metrics are added as server
 	//   metrics.
+	// - The requests per destination version are only added to the
+	//   destination node, so that we can show the observed traffic split for
+	//   services.
+	// - The response codes are cloned, because they are aggregated for the
+	//   nodes and the edges must not be modified.
 	// - We ignore the gRPC and HTTP request durations for the nodes, because
 	//   aggregating them doesn't make much sense.
 	for _, edge := range edges {
@@ -902,13 +2143,14 @@ func (d *Datasource) edgesToNodes(edges map[string]models.Edge) map[string]model
 			Type:                       edge.SourceType,
 			Name:                       edge.SourceName,
 			Namespace:                  edge.SourceNamespace,
+			Cluster:                    edge.SourceCluster,
 			Service:                    "",
-			ClientGRPCResponseCodes:    edge.GRPCResponseCodes,
+			ClientGRPCResponseCodes:    maps.Clone(edge.GRPCResponseCodes),
 			ClientGRPCRequestsSuccess:  edge.GRPCRequestsSuccess,
 			ClientGRPCRequestsError:    edge.GRPCRequestsError,
 			ClientGRPCSentMessages:     edge.GRPCSentMessages,
 			ClientGRPCReceivedMessages: edge.GRPCReceivedMessages,
-			ClientHTTPResponseCodes:    edge.HTTPResponseCodes,
+			ClientHTTPResponseCodes:    maps.Clone(edge.HTTPResponseCodes),
 			ClientHTTPRequestsSuccess:  edge.HTTPRequestsSuccess,
 			ClientHTTPRequestsError:    edge.HTTPRequestsError,
 			ClientTCPSentBytes:         edge.TCPSentBytes,
@@ -923,32 +2165,37 @@ func (d *Datasource) edgesToNodes(edges map[string]models.Edge) map[string]model
 			ServerHTTPRequestsError:    0,
 			ServerTCPSentBytes:         0,
 			ServerTCPReceivedBytes:     0,
+			Versions:                   make(map[string]float64),
 		}, {
-			ID:                         edge.Destination,
-			Type:                       edge.DestinationType,
-			Name:                       edge.DestinationName,
-			Namespace:                  edge.DestinationNamespace,
-			Service:                    edge.DestinationService,
-			ClientGRPCResponseCodes:    make(map[string]float64),
-			ClientGRPCRequestsSuccess:  0,
-			ClientGRPCRequestsError:    0,
-			ClientGRPCSentMessages:     0,
-			ClientGRPCReceivedMessages: 0,
-			ClientHTTPResponseCodes:    make(map[string]float64),
-			ClientHTTPRequestsSuccess:  0,
-			ClientHTTPRequestsError:    0,
-			ClientTCPSentBytes:         0,
-			ClientTCPReceivedBytes:     0,
-			ServerGRPCResponseCodes:    edge.GRPCResponseCodes,
-			ServerGRPCRequestsSuccess:  edge.GRPCRequestsSuccess,
-			ServerGRPCRequestsError:    edge.GRPCRequestsError,
-			ServerGRPCSentMessages:     edge.GRPCSentMessages,
-			ServerGRPCReceivedMessages: edge.GRPCReceivedMessages,
-			ServerHTTPResponseCodes:    edge.HTTPResponseCodes,
-			ServerHTTPRequestsSuccess:  edge.HTTPRequestsSuccess,
-			ServerHTTPRequestsError:    edge.HTTPRequestsError,
-			ServerTCPSentBytes:         edge.TCPSentBytes,
-			ServerTCPReceivedBytes:     edge.TCPReceivedBytes,
+			ID:                          edge.Destination,
+			Type:                        edge.DestinationType,
+			Name:                        edge.DestinationName,
+			Namespace:                   edge.DestinationNamespace,
+			Cluster:                     edge.DestinationCluster,
+			Service:                     edge.DestinationService,
+			ClientGRPCResponseCodes:     make(map[string]float64),
+			ClientGRPCRequestsSuccess:   0,
+			ClientGRPCRequestsError:     0,
+			ClientGRPCSentMessages:      0,
+			ClientGRPCReceivedMessages:  0,
+			ClientHTTPResponseCodes:     make(map[string]float64),
+			ClientHTTPRequestsSuccess:   0,
+			ClientHTTPRequestsError:     0,
+			ClientTCPSentBytes:          0,
+			ClientTCPReceivedBytes:      0,
+			ServerGRPCResponseCodes:     maps.Clone(edge.GRPCResponseCodes),
+			ServerGRPCRequestsSuccess:   edge.GRPCRequestsSuccess,
+			ServerGRPCRequestsError:     edge.GRPCRequestsError,
+			ServerGRPCSentMessages:      edge.GRPCSentMessages,
+			ServerGRPCReceivedMessages:  edge.GRPCReceivedMessages,
+			ServerHTTPResponseCodes:     maps.Clone(edge.HTTPResponseCodes),
+			ServerHTTPRequestsSuccess:   edge.HTTPRequestsSuccess,
+			ServerHTTPRequestsError:     edge.HTTPRequestsError,
+			ServerGRPCRequestsThrottled: edge.GRPCRequestsThrottled,
+			ServerHTTPRequestsThrottled: edge.HTTPRequestsThrottled,
+			ServerTCPSentBytes:          edge.TCPSentBytes,
+			ServerTCPReceivedBytes:      edge.TCPReceivedBytes,
+			Versions:                    maps.Clone(edge.Versions),
 		}}
 
 		for _, node := range tmpNodes {
@@ -988,9 +2235,22 @@ func (d *Datasource) edgesToNodes(edges map[string]models.Edge) map[string]model
 				}
 				existingNode.ServerHTTPRequestsSuccess += node.ServerHTTPRequestsSuccess
 				existingNode.ServerHTTPRequestsError += node.ServerHTTPRequestsError
+				existingNode.ServerGRPCRequestsThrottled += node.ServerGRPCRequestsThrottled
+				existingNode.ServerHTTPRequestsThrottled += node.ServerHTTPRequestsThrottled
 				existingNode.ServerTCPSentBytes += node.ServerTCPSentBytes
 				existingNode.ServerTCPReceivedBytes += node.ServerTCPReceivedBytes
 
+				for version, count := range node.Versions {
+					existingNode.Versions[version] += count
+				}
+
+				// The service is only known from edges where the node is the
+				// destination, so that we have to set it, when the node was
+				// added as source first.
+				if existingNode.Service == "" {
+					existingNode.Service = node.Service
+				}
+
 				nodes[node.ID] = existingNode
 			}
 		}
@@ -999,6 +2259,218 @@ func (d *Datasource) edgesToNodes(edges map[string]models.Edge) map[string]model
 	return nodes
 }
 
+// markGatewayNodes sets the type of all workload nodes, which are deployed by
+// Istio for a Gateway API gateway, to "Gateway". The id of the nodes is not
+// changed, so that the nodes are still connected to their edges.
+func markGatewayNodes(nodes map[string]models.Node) {
+	for id, node := range nodes {
+		if node.Type == "Workload" && isGatewayWorkload(node.Name) {
+			node.Type = "Gateway"
+			nodes[id] = node
+		}
+	}
+}
+
+// isGatewayWorkload returns true if the workload with the given name is
+// deployed by Istio for a Gateway API gateway. Istio names these workloads
+// "<gateway>-istio".
+func isGatewayWorkload(name string) bool {
+	return len(name) > len(gatewayWorkloadSuffix) && strings.HasSuffix(name, gatewayWorkloadSuffix)
+}
+
+// sortEdges returns the edges sorted by their id. If "byTraffic" is set, the
+// edges are sorted by the number of requests in descending order instead,
+// followed by the TCP bytes and the id for edges with the same traffic.
+func sortEdges(edges map[string]models.Edge, byTraffic bool) []models.Edge {
+	sorted := slices.Collect(maps.Values(edges))
+	slices.SortFunc(sorted, func(a, b models.Edge) int {
+		if byTraffic {
+			if c := cmp.Compare(b.GRPCRequestsSuccess+b.GRPCRequestsError+b.HTTPRequestsSuccess+b.HTTPRequestsError, a.GRPCRequestsSuccess+a.GRPCRequestsError+a.HTTPRequestsSuccess+a.HTTPRequestsError); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(b.TCPSentBytes+b.TCPReceivedBytes, a.TCPSentBytes+a.TCPReceivedBytes); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return sorted
+}
+
+// sortNodes returns the nodes sorted by their id. If "byTraffic" is set, the
+// nodes are sorted by the number of requests they received and sent in
+// descending order instead, followed by the TCP bytes and the id for nodes
+// with the same traffic.
+func sortNodes(nodes map[string]models.Node, byTraffic bool) []models.Node {
+	sorted := slices.Collect(maps.Values(nodes))
+	slices.SortFunc(sorted, func(a, b models.Node) int {
+		if byTraffic {
+			if c := cmp.Compare(nodeRequests(b), nodeRequests(a)); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(b.ServerTCPSentBytes+b.ServerTCPReceivedBytes+b.ClientTCPSentBytes+b.ClientTCPReceivedBytes, a.ServerTCPSentBytes+a.ServerTCPReceivedBytes+a.ClientTCPSentBytes+a.ClientTCPReceivedBytes); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return sorted
+}
+
+// nodeRequests returns the number of gRPC and HTTP requests, which were
+// received and sent by the given node.
+func nodeRequests(node models.Node) float64 {
+	return node.ServerGRPCRequestsSuccess + node.ServerGRPCRequestsError + node.ServerHTTPRequestsSuccess + node.ServerHTTPRequestsError + node.ClientGRPCRequestsSuccess + node.ClientGRPCRequestsError + node.ClientHTTPRequestsSuccess + node.ClientHTTPRequestsError
+}
+
+// addIdleNodes adds all services and workloads of the given namespace to the
+// nodes map, which are not already part of the graph. Since the plugin doesn't
+// have access to the Kubernetes API, the services and workloads are retrieved
+// from the kube-state-metrics in Prometheus. If kube-state-metrics isn't
+// available, no nodes are added. If service nodes should be hidden, only the
+// workloads are added.
+func (d *Datasource) addIdleNodes(ctx context.Context, nodes map[string]models.Node, options graphOptions, timeRange backend.TimeRange) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "addIdleNodes")
+	defer span.End()
+
+	namespace := options.namespace
+
+	namespaceMatchers := prometheus.Matchers{prometheus.Equal("namespace", namespace)}
+
+	services, err := d.getLabelValues(ctx, []prometheus.LabelValuesQuery{{
+		Label:     "service",
+		Selectors: []prometheus.Selector{{Metric: "kube_service_info", Matchers: namespaceMatchers}},
+	}}, timeRange)
+	if err != nil {
+		return err
+	}
+
+	workloads, err := d.getLabelValues(ctx, []prometheus.LabelValuesQuery{{
+		Label:     "deployment",
+		Selectors: []prometheus.Selector{{Metric: "kube_deployment_created", Matchers: namespaceMatchers}},
+	}, {
+		Label:     "statefulset",
+		Selectors: []prometheus.Selector{{Metric: "kube_statefulset_created", Matchers: namespaceMatchers}},
+	}, {
+		Label:     "daemonset",
+		Selectors: []prometheus.Selector{{Metric: "kube_daemonset_created", Matchers: namespaceMatchers}},
+	}}, timeRange)
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		if options.hideServiceNodes {
+			break
+		}
+
+		id := nodeID("Service", namespace, service, "")
+		if _, ok := nodes[id]; !ok {
+			nodes[id] = models.Node{
+				ID:        id,
+				Type:      "Service",
+				Name:      service,
+				Namespace: namespace,
+				Service:   fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+			}
+		}
+	}
+
+	// The app of an idle workload is unknown, because it isn't part of the
+	// kube-state-metrics, so that we can not add idle workloads when the
+	// workloads are aggregated by their app.
+	for _, workload := range workloads {
+		if options.aggregateByApp {
+			break
+		}
+
+		filter := fmt.Sprintf("%s/%s", namespace, workload)
+		if slices.Contains(options.sourceFilters, filter) || slices.Contains(options.destinationFilters, filter) {
+			continue
+		}
+
+		id := nodeID("Workload", namespace, workload, "")
+		if _, ok := nodes[id]; !ok {
+			nodes[id] = models.Node{
+				ID:        id,
+				Type:      "Workload",
+				Name:      workload,
+				Namespace: namespace,
+			}
+		}
+	}
+
+	return nil
+}
+
+// addWorkloadDurations sets the request durations for the edges from services
+// to workloads. The durations are retrieved grouped by the destination
+// workload, using only the metrics reported by the destination, so that the
+// P99 duration is calculated over the requests of all source workloads. This
+// makes latency differences between the workloads (e.g. versions) behind a
+// service visible. One query per namespace of the destination workloads and
+// selected duration metric is run. The queries are sent as a single batch.
+func (d *Datasource) addWorkloadDurations(ctx context.Context, edges map[string]models.Edge, options graphOptions, timeRange backend.TimeRange) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "addWorkloadDurations")
+	defer span.End()
+
+	namespaces := make(map[string]bool)
+	for _, edge := range edges {
+		if edge.SourceType == "Service" && edge.DestinationType == "Workload" {
+			namespaces[edge.DestinationNamespace] = true
+		}
+	}
+
+	interval := int64(timeRange.Duration().Seconds())
+
+	var batch []prometheus.MetricsQuery
+	for _, metric := range options.metrics {
+		if metric != models.MetricGRPCRequestDuration && metric != models.MetricHTTPRequestDuration {
+			continue
+		}
+
+		for _, namespace := range slices.Sorted(maps.Keys(namespaces)) {
+			batch = append(batch, prometheus.MetricsQuery{Metric: metric, Query: d.metricToPrometheusWorkloadDurationsQuery(namespace, metric, options, interval), TimeRange: timeRange})
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	for i, metrics := range results {
+		metric := batch[i].Metric
+		if options.aggregateByApp {
+			metrics = aggregateMetricsByApp(metrics)
+		}
+
+		for _, m := range metrics {
+			id := fmt.Sprintf("service-%s-%s-workload-%s-%s", m.Labels["destination_service_name"], m.Labels["destination_service_namespace"], m.Labels["destination_workload"], m.Labels["destination_workload_namespace"]) + edgeClusterSuffix(m.Labels["destination_cluster"], m.Labels["destination_cluster"])
+			if strings.HasSuffix(m.Labels["destination_service"], "-shadow") || strings.HasSuffix(m.Labels["destination_service_name"], "-shadow") {
+				id = id + "-mirror"
+			}
+
+			edge, ok := edges[id]
+			if !ok || !isValidDuration(m.Value) {
+				continue
+			}
+
+			if metric == models.MetricGRPCRequestDuration {
+				edge.GRPCRequestDuration = m.Value
+			} else {
+				edge.HTTPRequestDuration = m.Value
+			}
+			edges[id] = edge
+		}
+	}
+
+	return nil
+}
+
 // generateEdgeField generates the data frame fields for the give edge. This
 // also includes setting the color, main stat and secondary stat.
 func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Field {
@@ -1013,8 +2485,8 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 	// Set the details metrics for gRPC traffic and save the gRPC error rate
 	// for later to use them for setting the color. All metrics are set also
 	// when they are zero, except the gRPC request duration, where we use "-",
-	// because only edges from a source workload to a destination service have
-	// a duration.
+	// because by default only edges from a source workload to a destination
+	// service have a duration.
 	field.DetailsGRPCRate = []string{fmt.Sprintf("%.2frps", (edge.GRPCRequestsSuccess+edge.GRPCRequestsError)/interval)}
 	if edge.GRPCRequestsError > 0 {
 		grpcErrRate = (edge.GRPCRequestsError / (edge.GRPCRequestsSuccess + edge.GRPCRequestsError)) * 100
@@ -1023,19 +2495,15 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 		grpcErrRate = 0
 		field.DetailsGRPCErr = []string{fmt.Sprintf("%.2f%%", grpcErrRate)}
 	}
-	if edge.GRPCRequestDuration > 0 {
-		field.DetailsGRPCDuration = []string{fmt.Sprintf("%.2fms", edge.GRPCRequestDuration)}
-	} else {
-		field.DetailsGRPCDuration = []string{"-"}
-	}
+	field.DetailsGRPCDuration = []string{formatDuration(edge.GRPCRequestDuration)}
 	field.DetailsGRPCSentMessages = []string{fmt.Sprintf("%.2fmps", edge.GRPCSentMessages/interval)}
 	field.DetailsGRPCReceivedMessages = []string{fmt.Sprintf("%.2fmps", edge.GRPCReceivedMessages/interval)}
 
 	// Set the details metrics for HTTP traffic and save the HTTP error rate
 	// for later to use them for setting the color. All metrics are set also
 	// when they are zero, except the HTTP request duration, where we use "-",
-	// because only edges from a source workload to a destination service have
-	// a duration.
+	// because by default only edges from a source workload to a destination
+	// service have a duration.
 	field.DetailsHTTPRate = []string{fmt.Sprintf("%.2frps", (edge.HTTPRequestsSuccess+edge.HTTPRequestsError)/interval)}
 	if edge.HTTPRequestsError > 0 {
 		httpErrRate = (edge.HTTPRequestsError / (edge.HTTPRequestsSuccess + edge.HTTPRequestsError)) * 100
@@ -1044,11 +2512,20 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 		httpErrRate = 0
 		field.DetailsHTTPErr = []string{fmt.Sprintf("%.2f%%", httpErrRate)}
 	}
-	if edge.HTTPRequestDuration > 0 {
-		field.DetailsHTTPDuration = []string{fmt.Sprintf("%.2fms", edge.HTTPRequestDuration)}
-	} else {
-		field.DetailsHTTPDuration = []string{"-"}
+	field.DetailsHTTPDuration = []string{formatDuration(edge.HTTPRequestDuration)}
+
+	// Set the share of throttled requests for gRPC and HTTP traffic. These
+	// requests are not counted as errors, so that they are shown separately.
+	var grpcThrottledRate float64
+	var httpThrottledRate float64
+	if edge.GRPCRequestsThrottled > 0 {
+		grpcThrottledRate = (edge.GRPCRequestsThrottled / (edge.GRPCRequestsSuccess + edge.GRPCRequestsError)) * 100
 	}
+	if edge.HTTPRequestsThrottled > 0 {
+		httpThrottledRate = (edge.HTTPRequestsThrottled / (edge.HTTPRequestsSuccess + edge.HTTPRequestsError)) * 100
+	}
+	field.DetailsGRPCThrottled = []string{fmt.Sprintf("%.2f%%", grpcThrottledRate)}
+	field.DetailsHTTPThrottled = []string{fmt.Sprintf("%.2f%%", httpThrottledRate)}
 
 	// Set the details metrics for TCP traffic.
 	field.DetailsTCPSentBytes = []string{fmt.Sprintf("%.2fbps", edge.TCPSentBytes/interval)}
@@ -1066,7 +2543,9 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 	// The color is set as follows:
 	// - For HTTP and gRPC traffic, if the error rate is above the error
 	//   threshold, the color is red. If the error rate is above the warning
-	//   threshold, the color is yellow. Otherwise, the color is green.
+	//   threshold, the color is yellow. Otherwise, the color is green. If
+	//   throttled requests should be highlighted, the color is also yellow,
+	//   when there are any throttled requests.
 	// - For TCP traffic, the color is blue.
 	// - If there is no traffic, the color is gray.
 	if edge.HTTPRequestsSuccess+edge.HTTPRequestsError > edge.GRPCRequestsSuccess+edge.GRPCRequestsError {
@@ -1077,13 +2556,13 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 
 		if httpErrRate >= d.istioErrorThreshold {
 			field.Color = "#f2495c"
-		} else if httpErrRate > d.istioWarningThreshold {
+		} else if httpErrRate > d.istioWarningThreshold || d.istioHighlightThrottled && httpThrottledRate > 0 {
 			field.Color = "#fade2a"
 		} else {
 			field.Color = "#73bf69"
 		}
 
-		if edge.HTTPRequestDuration > 0 {
+		if isValidDuration(edge.HTTPRequestDuration) {
 			field.SecondaryStat = append(field.SecondaryStat, field.DetailsHTTPDuration[0])
 		}
 		if edge.TCPSentBytes+edge.TCPReceivedBytes > 0 {
@@ -1097,13 +2576,13 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 
 		if grpcErrRate >= d.istioErrorThreshold {
 			field.Color = "#f2495c"
-		} else if grpcErrRate > d.istioWarningThreshold {
+		} else if grpcErrRate > d.istioWarningThreshold || d.istioHighlightThrottled && grpcThrottledRate > 0 {
 			field.Color = "#fade2a"
 		} else {
 			field.Color = "#73bf69"
 		}
 
-		if edge.GRPCRequestDuration > 0 {
+		if isValidDuration(edge.GRPCRequestDuration) {
 			field.SecondaryStat = append(field.SecondaryStat, field.DetailsGRPCDuration[0])
 		}
 		if edge.TCPSentBytes+edge.TCPReceivedBytes > 0 {
@@ -1116,6 +2595,42 @@ func (d *Datasource) getEdgeField(edge models.Edge, interval float64) models.Fie
 		field.Color = "#ccccdc"
 	}
 
+	// Flag the edge if the share of requests with one of the response flags
+	// from "edgeIssues" is above the threshold of the issue. These edges are
+	// colored purple, because they often point to a misconfigured
+	// VirtualService or DestinationRule or to a tripped circuit breaker.
+	requests := edge.GRPCRequestsSuccess + edge.GRPCRequestsError + edge.HTTPRequestsSuccess + edge.HTTPRequestsError
+	if requests > 0 {
+		for _, issue := range edgeIssues {
+			if share := edge.ResponseFlags[issue.flag] / requests; share > 0 && share >= issue.threshold {
+				field.DetailsIssues = append(field.DetailsIssues, fmt.Sprintf("%s (%s): %.2f%%", issue.description, issue.flag, edge.ResponseFlags[issue.flag]/requests*100))
+			}
+		}
+	}
+	if len(field.DetailsIssues) > 0 {
+		field.Color = "#b877d9"
+	}
+
+	// Set the share of requests, which exceeded the retry limit ("URX"). This
+	// is only available, when the response flags were retrieved, which is the
+	// case when issues should be detected.
+	if requests > 0 && len(edge.ResponseFlags) > 0 {
+		field.DetailsRetries = []string{fmt.Sprintf("%.2f%%", edge.ResponseFlags["URX"]/requests*100)}
+	} else {
+		field.DetailsRetries = []string{"-"}
+	}
+
+	// Set the locality of the edge and the rate of bytes, which were sent
+	// across zones. This is only available, when the locality labels were
+	// retrieved.
+	if edge.Locality != "" {
+		field.DetailsLocality = []string{edge.Locality}
+		field.DetailsCrossZoneBytes = []string{fmt.Sprintf("%.2fbps", edge.CrossZoneBytes/interval)}
+	} else {
+		field.DetailsLocality = []string{"-"}
+		field.DetailsCrossZoneBytes = []string{"-"}
+	}
+
 	return field
 }
 
@@ -1129,17 +2644,19 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 	// edges, with the traffic were the node acting as a server.
 	if node.Type == "Service" {
 		return d.getEdgeField(models.Edge{
-			ID:                   node.ID,
-			Source:               node.ID,
-			Destination:          node.ID,
-			GRPCRequestsSuccess:  node.ServerGRPCRequestsSuccess,
-			GRPCRequestsError:    node.ServerGRPCRequestsError,
-			GRPCSentMessages:     node.ServerGRPCSentMessages,
-			GRPCReceivedMessages: node.ServerGRPCReceivedMessages,
-			HTTPRequestsSuccess:  node.ServerHTTPRequestsSuccess,
-			HTTPRequestsError:    node.ServerHTTPRequestsError,
-			TCPSentBytes:         node.ServerTCPSentBytes,
-			TCPReceivedBytes:     node.ServerTCPReceivedBytes,
+			ID:                    node.ID,
+			Source:                node.ID,
+			Destination:           node.ID,
+			GRPCRequestsSuccess:   node.ServerGRPCRequestsSuccess,
+			GRPCRequestsError:     node.ServerGRPCRequestsError,
+			GRPCRequestsThrottled: node.ServerGRPCRequestsThrottled,
+			GRPCSentMessages:      node.ServerGRPCSentMessages,
+			GRPCReceivedMessages:  node.ServerGRPCReceivedMessages,
+			HTTPRequestsSuccess:   node.ServerHTTPRequestsSuccess,
+			HTTPRequestsError:     node.ServerHTTPRequestsError,
+			HTTPRequestsThrottled: node.ServerHTTPRequestsThrottled,
+			TCPSentBytes:          node.ServerTCPSentBytes,
+			TCPReceivedBytes:      node.ServerTCPReceivedBytes,
 		}, interval)
 	}
 
@@ -1198,6 +2715,24 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 	field.DetailsTCPSentBytes = []string{fmt.Sprintf("%.2fbps", node.ServerTCPSentBytes/interval), fmt.Sprintf("%.2fbps", node.ClientTCPSentBytes/interval)}
 	field.DetailsTCPReceivedBytes = []string{fmt.Sprintf("%.2fbps", node.ServerTCPReceivedBytes/interval), fmt.Sprintf("%.2fbps", node.ClientTCPReceivedBytes/interval)}
 
+	// The error rate, which is used for the color of the node, depends on the
+	// configured node health policy. For each side we use the error rate of
+	// the traffic type with more requests.
+	serverErrRate := grpcServerErrRate
+	if node.ServerHTTPRequestsSuccess+node.ServerHTTPRequestsError > node.ServerGRPCRequestsSuccess+node.ServerGRPCRequestsError {
+		serverErrRate = httpServerErrRate
+	}
+	clientErrRate := grpcClientErrRate
+	if node.ClientHTTPRequestsSuccess+node.ClientHTTPRequestsError > node.ClientGRPCRequestsSuccess+node.ClientGRPCRequestsError {
+		clientErrRate = httpClientErrRate
+	}
+	errRate := d.getNodeErrorRate(
+		serverErrRate,
+		clientErrRate,
+		node.ServerHTTPRequestsSuccess+node.ServerHTTPRequestsError+node.ServerGRPCRequestsSuccess+node.ServerGRPCRequestsError > 0,
+		node.ClientHTTPRequestsSuccess+node.ClientHTTPRequestsError+node.ClientGRPCRequestsSuccess+node.ClientGRPCRequestsError > 0,
+	)
+
 	// Set the color, main stat and secondary stat based on the traffic type:
 	// - We always prefer server traffic over the client traffic.
 	// - We prefer the traffic type with more requests. This means if we have
@@ -1210,9 +2745,9 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 			field.MainStat = append(field.MainStat, field.DetailsHTTPErr[0])
 		}
 
-		if httpServerErrRate >= d.istioErrorThreshold {
+		if errRate >= d.istioErrorThreshold {
 			field.Color = "#f2495c"
-		} else if httpServerErrRate > d.istioWarningThreshold {
+		} else if errRate > d.istioWarningThreshold {
 			field.Color = "#fade2a"
 		} else {
 			field.Color = "#73bf69"
@@ -1227,9 +2762,9 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 			field.MainStat = append(field.MainStat, field.DetailsGRPCErr[0])
 		}
 
-		if grpcServerErrRate >= d.istioErrorThreshold {
+		if errRate >= d.istioErrorThreshold {
 			field.Color = "#f2495c"
-		} else if grpcServerErrRate > d.istioWarningThreshold {
+		} else if errRate > d.istioWarningThreshold {
 			field.Color = "#fade2a"
 		} else {
 			field.Color = "#73bf69"
@@ -1244,9 +2779,9 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 			field.MainStat = append(field.MainStat, field.DetailsHTTPErr[1])
 		}
 
-		if httpClientErrRate >= d.istioErrorThreshold {
+		if errRate >= d.istioErrorThreshold {
 			field.Color = "#f2495c"
-		} else if httpClientErrRate > d.istioWarningThreshold {
+		} else if errRate > d.istioWarningThreshold {
 			field.Color = "#fade2a"
 		} else {
 			field.Color = "#73bf69"
@@ -1261,9 +2796,9 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 			field.MainStat = append(field.MainStat, field.DetailsGRPCErr[1])
 		}
 
-		if grpcClientErrRate >= d.istioErrorThreshold {
+		if errRate >= d.istioErrorThreshold {
 			field.Color = "#f2495c"
-		} else if grpcClientErrRate > d.istioWarningThreshold {
+		} else if errRate > d.istioWarningThreshold {
 			field.Color = "#fade2a"
 		} else {
 			field.Color = "#73bf69"
@@ -1284,3 +2819,178 @@ func (d *Datasource) getNodeField(node models.Node, interval float64) models.Fie
 
 	return field
 }
+
+// getNodeErrorRate returns the error rate, which is used for the color of a
+// workload node, based on the configured node health policy:
+//   - "server": The error rate of the server traffic (default).
+//   - "client": The error rate of the client traffic.
+//   - "worst": The higher error rate of the server and client traffic.
+//   - "weighted": The weighted average of the server and client error rates,
+//     where the client error rate is weighted with the configured weight.
+//
+// If the node only has server or only has client traffic, the error rate of
+// this traffic is always used.
+func (d *Datasource) getNodeErrorRate(serverErrRate, clientErrRate float64, hasServer, hasClient bool) float64 {
+	if !hasServer {
+		return clientErrRate
+	}
+	if !hasClient {
+		return serverErrRate
+	}
+
+	switch d.istioNodeHealth {
+	case models.NodeHealthClient:
+		return clientErrRate
+	case models.NodeHealthWorst:
+		return max(serverErrRate, clientErrRate)
+	case models.NodeHealthWeighted:
+		return d.istioNodeHealthClientWeight*clientErrRate + (1-d.istioNodeHealthClientWeight)*serverErrRate
+	default:
+		return serverErrRate
+	}
+}
+
+// getTrafficSplit returns the observed traffic split for the given versions,
+// e.g. "v1: 90.00% / v2: 10.00%". The share of each version is calculated
+// based on the total number of requests of all versions in "total". If there
+// are no requests, "-" is returned.
+func getTrafficSplit(versions, total map[string]float64) string {
+	var totalRequests float64
+	for _, count := range total {
+		totalRequests += count
+	}
+
+	if totalRequests == 0 {
+		return "-"
+	}
+
+	var split []string
+	for _, version := range slices.Sorted(maps.Keys(versions)) {
+		if versions[version] > 0 {
+			split = append(split, fmt.Sprintf("%s: %.2f%%", version, versions[version]/totalRequests*100))
+		}
+	}
+
+	if len(split) == 0 {
+		return "-"
+	}
+
+	return strings.Join(split, " / ")
+}
+
+const (
+	localityUnknown     = "unknown"
+	localitySameZone    = "same zone"
+	localityCrossZone   = "cross zone"
+	localityCrossRegion = "cross region"
+)
+
+// getLocality classifies the traffic between the given source and destination
+// locality. The locality has the format "<region>/<zone>/<subzone>", like it
+// is used by Istio for locality load balancing.
+func getLocality(source, destination string) string {
+	if source == "" || destination == "" {
+		return localityUnknown
+	}
+
+	sourceParts := strings.Split(source, "/")
+	destinationParts := strings.Split(destination, "/")
+
+	if sourceParts[0] != destinationParts[0] {
+		return localityCrossRegion
+	}
+	if len(sourceParts) < 2 || len(destinationParts) < 2 {
+		return localityUnknown
+	}
+	if sourceParts[1] != destinationParts[1] {
+		return localityCrossZone
+	}
+	return localitySameZone
+}
+
+// isValidDuration returns true if the given request duration should be shown.
+// The "histogram_quantile" function returns NaN if all buckets are empty (e.g.
+// for idle edges) and +Inf if the quantile falls into the "+Inf" bucket, so
+// that we ignore these values, like we do for zero durations.
+func isValidDuration(duration float64) bool {
+	return duration > 0 && !math.IsNaN(duration) && !math.IsInf(duration, 0)
+}
+
+// formatDuration formats the given request duration in milliseconds. If the
+// duration is not valid, "-" is returned.
+func formatDuration(duration float64) string {
+	if !isValidDuration(duration) {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fms", duration)
+}
+
+// localityRank returns the rank of the given locality class, so that we can
+// keep the "worst" class for an edge, when it contains traffic with different
+// classes.
+func localityRank(locality string) int {
+	switch locality {
+	case localityUnknown:
+		return 1
+	case localitySameZone:
+		return 2
+	case localityCrossZone:
+		return 3
+	case localityCrossRegion:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// nodeID returns the canonical id of a node in the format
+// "<type>/<namespace>/<name>/<cluster>" (e.g. "workload/bookinfo/reviews-v1"),
+// which is used for the "id", "source" and "target" fields of the graph
+// frames. The cluster is omitted, when it isn't known. The id must not be shown
+// to users, the display strings are set in the "title" and "subtitle" fields,
+// so that they can be changed without breaking the graph.
+func nodeID(nodeType, namespace, name, cluster string) string {
+	id := strings.ToLower(nodeType) + "/" + namespace + "/" + name
+	if cluster != "" {
+		id = id + "/" + cluster
+	}
+	return id
+}
+
+// edgeClusterSuffix returns the suffix, which is added to the id of an edge
+// between the given clusters, so that the edges of workloads with the same
+// name in different clusters are not merged. The suffix is empty, when the
+// clusters aren't known, i.e. when the datasource isn't a multi-cluster
+// datasource.
+func edgeClusterSuffix(sourceCluster, destinationCluster string) string {
+	if sourceCluster == "" && destinationCluster == "" {
+		return ""
+	}
+	return "-" + sourceCluster + "-" + destinationCluster
+}
+
+// isGRPCError returns true if the given "grpc_response_status" is considered to
+// be an error. This is the case for the status codes 2, 4, 12, 13, 14 and 15,
+// which should correlate to the HTTP status codes 5xx.
+func isGRPCError(code string) bool {
+	return code == "2" || code == "4" || code == "12" || code == "13" || code == "14" || code == "15"
+}
+
+// isHTTPError returns true if the given "response_code" is considered to be an
+// error, which is the case for all 5xx status codes.
+func isHTTPError(code string) bool {
+	return httpResponseClass(code) == "5xx"
+}
+
+// httpResponseClass returns the class of the given "response_code" (e.g. "2xx"
+// or "5xx"). The label can be empty or "0" for requests without a response
+// (e.g. TCP traffic or requests reset by the client) and might contain any
+// value for malformed series, so that an empty string is returned for all
+// codes which are not a valid HTTP status code.
+func httpResponseClass(code string) string {
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 100 || status > 599 {
+		return ""
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,731 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus/prometheustest"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginate(t *testing.T) {
+	values := []string{"details", "productpage", "ratings", "reviews-v1", "reviews-v2", "reviews-v3"}
+
+	for _, tc := range []struct {
+		name       string
+		pagination models.Pagination
+		expected   []string
+	}{
+		{name: "no pagination", pagination: models.Pagination{}, expected: values},
+		{name: "search", pagination: models.Pagination{Search: "REVIEWS"}, expected: []string{"reviews-v1", "reviews-v2", "reviews-v3"}},
+		{name: "limit", pagination: models.Pagination{Limit: 2}, expected: []string{"details", "productpage"}},
+		{name: "offset and limit", pagination: models.Pagination{Offset: 2, Limit: 2}, expected: []string{"ratings", "reviews-v1"}},
+		{name: "search with offset", pagination: models.Pagination{Search: "reviews", Offset: 1}, expected: []string{"reviews-v2", "reviews-v3"}},
+		{name: "offset out of range", pagination: models.Pagination{Offset: 10}, expected: []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, paginate(append([]string{}, values...), tc.pagination))
+		})
+	}
+}
+
+func TestHandleNamespacesByTraffic(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddLabelValues("destination_workload_namespace", "bookinfo", "shop").
+		AddLabelValues("source_workload_namespace", "bookinfo").
+		AddMetrics(`by \(destination_workload_namespace, source_workload_namespace\)`,
+			prometheus.Metric{Value: 600, Labels: map[string]string{"destination_workload_namespace": "bookinfo", "source_workload_namespace": "bookinfo"}},
+			prometheus.Metric{Value: 1200, Labels: map[string]string{"destination_workload_namespace": "shop", "source_workload_namespace": "bookinfo"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	response := d.handleNamespaces(context.Background(), concurrent.Query{DataQuery: backend.DataQuery{
+		JSON:      []byte(`{"sortByTraffic": true}`),
+		TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)},
+	}})
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 1)
+
+	// The requests within the "bookinfo" namespace are only counted once,
+	// even if the namespace is the source and the destination.
+	require.Equal(t, "bookinfo", response.Frames[0].Fields[0].At(0))
+	require.Equal(t, 30.0, response.Frames[0].Fields[1].At(0))
+	require.Equal(t, "shop", response.Frames[0].Fields[0].At(1))
+	require.Equal(t, 20.0, response.Frames[0].Fields[1].At(1))
+	require.Contains(t, client.Queries(), `sum(increase(istio_requests_total{reporter="destination"}[60s])) by (destination_workload_namespace, source_workload_namespace) or sum(increase(istio_requests_total{reporter="source"}[60s])) by (destination_workload_namespace, source_workload_namespace)`)
+}
+
+func TestGetTrafficSplit(t *testing.T) {
+	versions := map[string]float64{"v1": 90, "v2": 10}
+
+	require.Equal(t, "v1: 90.00% / v2: 10.00%", getTrafficSplit(versions, versions))
+	require.Equal(t, "v2: 10.00%", getTrafficSplit(map[string]float64{"v2": 10}, versions))
+	require.Equal(t, "-", getTrafficSplit(map[string]float64{}, versions))
+	require.Equal(t, "-", getTrafficSplit(nil, nil))
+}
+
+func TestGetLocality(t *testing.T) {
+	require.Equal(t, localitySameZone, getLocality("us-east1/us-east1-a", "us-east1/us-east1-a"))
+	require.Equal(t, localityCrossZone, getLocality("us-east1/us-east1-a", "us-east1/us-east1-b"))
+	require.Equal(t, localityCrossRegion, getLocality("us-east1/us-east1-a", "eu-west1/eu-west1-a"))
+	require.Equal(t, localityUnknown, getLocality("", "us-east1/us-east1-a"))
+	require.Equal(t, localityUnknown, getLocality("us-east1", "us-east1"))
+}
+
+func TestGetHealthScore(t *testing.T) {
+	weights := healthScoreWeights{errors: 0.5, latency: 0.3, mtls: 0.2}
+
+	require.Equal(t, float64(100), getHealthScore(0, 5, 1, 1, weights))
+	require.Equal(t, float64(50), getHealthScore(5, 5, 1, 1, healthScoreWeights{errors: 0.5, mtls: 0.5}))
+	require.Equal(t, float64(50), getHealthScore(10, 5, 1, 1, weights))
+	require.Equal(t, float64(0), getHealthScore(0, 5, 1, 1, healthScoreWeights{}))
+}
+
+func TestBucketsToHeatmapFrame(t *testing.T) {
+	timestamp := time.Unix(0, 0)
+	frame := bucketsToHeatmapFrame([]prometheus.TimeSeries{
+		{Timestamps: []time.Time{timestamp}, Values: []float64{10}, Labels: map[string]string{"le": "+Inf"}},
+		{Timestamps: []time.Time{timestamp}, Values: []float64{4}, Labels: map[string]string{"le": "100"}},
+		{Timestamps: []time.Time{timestamp}, Values: []float64{1}, Labels: map[string]string{"le": "10"}},
+	})
+
+	require.Len(t, frame.Fields, 4)
+	require.Equal(t, "10", frame.Fields[1].Name)
+	require.Equal(t, float64(1), frame.Fields[1].At(0))
+	require.Equal(t, "100", frame.Fields[2].Name)
+	require.Equal(t, float64(3), frame.Fields[2].At(0))
+	require.Equal(t, "+Inf", frame.Fields[3].Name)
+	require.Equal(t, float64(6), frame.Fields[3].At(0))
+}
+
+func TestGraphTimeRange(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC), To: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	tr, err := graphTimeRange(timeRange, "", "")
+	require.NoError(t, err)
+	require.Equal(t, timeRange, tr)
+
+	tr, err = graphTimeRange(timeRange, "2025-01-01T03:00:00Z", "")
+	require.NoError(t, err)
+	require.Equal(t, backend.TimeRange{From: time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC), To: time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)}, tr)
+
+	tr, err = graphTimeRange(timeRange, "2025-01-01T03:00:00Z", "5m")
+	require.NoError(t, err)
+	require.Equal(t, backend.TimeRange{From: time.Date(2025, 1, 1, 2, 55, 0, 0, time.UTC), To: time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)}, tr)
+
+	_, err = graphTimeRange(timeRange, "03:00", "")
+	require.Error(t, err)
+	_, err = graphTimeRange(timeRange, "", "five minutes")
+	require.Error(t, err)
+}
+
+func TestNewGraphOptions(t *testing.T) {
+	query := []byte(`{"sourceNamespace": "bookinfo", "sourceWorkload": "productpage-v1", "idleNodes": true, "sourceFilters": ["bookinfo/ratings-v1"], "preset": "default", "window": "5m", "rawFrom": "now-1h", "rawTo": "now"}`)
+
+	// All graph query models must embed the shared options, so that an option
+	// can not be dropped by a single graph query type.
+	for _, qm := range []any{&models.QueryModelApplicationGraph{}, &models.QueryModelWorkloadGraph{}, &models.QueryModelNamespaceGraph{}, &models.QueryModelServiceGraph{}, &models.QueryModelPath{}, &graphCostQuery{}} {
+		require.NoError(t, json.Unmarshal(query, qm))
+
+		options := newGraphOptions(reflect.ValueOf(qm).Elem().FieldByName("GraphQueryOptions").Interface().(models.GraphQueryOptions))
+		require.True(t, options.idleNodes)
+		require.Equal(t, []string{"bookinfo/ratings-v1"}, options.sourceFilters)
+		require.Equal(t, "default", options.preset)
+		require.Equal(t, models.LinkContext{}, options.links)
+	}
+}
+
+func TestSplitTimeRange(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	windows := splitTimeRange(backend.TimeRange{From: from, To: from.Add(24 * time.Hour)}, 4*time.Hour)
+	require.Len(t, windows, 6)
+	require.Equal(t, from.Add(20*time.Hour), windows[0].From)
+	require.Equal(t, from, windows[5].From)
+
+	windows = splitTimeRange(backend.TimeRange{From: from, To: from.Add(5 * time.Hour)}, 4*time.Hour)
+	require.Len(t, windows, 2)
+	require.Equal(t, backend.TimeRange{From: from, To: from.Add(time.Hour)}, windows[1])
+}
+
+func TestGetNodeErrorRate(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		expected float64
+	}{
+		{policy: "", expected: 1},
+		{policy: models.NodeHealthServer, expected: 1},
+		{policy: models.NodeHealthClient, expected: 10},
+		{policy: models.NodeHealthWorst, expected: 10},
+		{policy: models.NodeHealthWeighted, expected: 7.75},
+	} {
+		d := &Datasource{istioNodeHealth: tc.policy, istioNodeHealthClientWeight: 0.75}
+		require.Equal(t, tc.expected, d.getNodeErrorRate(1, 10, true, true), tc.policy)
+	}
+
+	d := &Datasource{istioNodeHealth: models.NodeHealthWorst}
+	require.Equal(t, 10.0, d.getNodeErrorRate(0, 10, false, true))
+	require.Equal(t, 1.0, d.getNodeErrorRate(1, 0, true, false))
+}
+
+func TestDataFreshnessNotice(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	notice := dataFreshnessNotice(now.Add(-30*time.Second), now)
+	require.Equal(t, data.NoticeSeverityInfo, notice.Severity)
+	require.Equal(t, "Data as of 2024-12-31T23:59:30Z", notice.Text)
+
+	notice = dataFreshnessNotice(now.Add(-4*time.Minute), now)
+	require.Equal(t, data.NoticeSeverityWarning, notice.Severity)
+	require.Equal(t, "Telemetry is stale: data as of 2024-12-31T23:56:00Z (4m0s old)", notice.Text)
+
+	notice = dataFreshnessNotice(time.Time{}, now)
+	require.Equal(t, data.NoticeSeverityWarning, notice.Severity)
+}
+
+func TestGetDataFreshness(t *testing.T) {
+	// The client rounds the end of the time range down to the full minute, so
+	// that the age of the most recent sample must be calculated against the
+	// evaluation time and not against the end of the time range.
+	to := time.Date(2025, 1, 1, 0, 0, 50, 0, time.UTC)
+	evaluated := to.Truncate(time.Minute)
+	client := prometheustest.NewClient().
+		AddMetrics(`^max\(timestamp\(istio_requests_total\{destination_workload_namespace="data"\} or istio_requests_total\{source_workload_namespace="data"\} or istio_tcp_sent_bytes_total\{destination_workload_namespace="data"\} or istio_tcp_sent_bytes_total\{source_workload_namespace="data"\}\)\)$`,
+			prometheus.Metric{Value: float64(evaluated.Add(-30 * time.Second).Unix()), Timestamp: evaluated})
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	latest, evaluationTime, err := d.getDataFreshness(context.Background(), "data", backend.TimeRange{From: to.Add(-time.Hour), To: to})
+	require.NoError(t, err)
+	require.Equal(t, evaluated, evaluationTime)
+	require.Equal(t, evaluated.Add(-30*time.Second), latest.UTC())
+	require.Equal(t, "Data as of 2024-12-31T23:59:30Z", dataFreshnessNotice(latest, evaluationTime).Text)
+}
+
+func TestQueryKey(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+
+	key1, err := queryKey(backend.DataQuery{RefID: "A", QueryType: "namespacegraph", TimeRange: timeRange, JSON: []byte(`{"refId":"A","namespace":"default","metrics":["httpRequests"]}`)})
+	require.NoError(t, err)
+	key2, err := queryKey(backend.DataQuery{RefID: "B", QueryType: "namespacegraph", TimeRange: timeRange, JSON: []byte(`{"metrics":["httpRequests"],"namespace":"default","refId":"B"}`)})
+	require.NoError(t, err)
+	key3, err := queryKey(backend.DataQuery{RefID: "C", QueryType: "namespacegraph", TimeRange: timeRange, JSON: []byte(`{"refId":"C","namespace":"istio-system","metrics":["httpRequests"]}`)})
+	require.NoError(t, err)
+
+	require.Equal(t, key1, key2)
+	require.NotEqual(t, key1, key3)
+}
+
+func TestAddWorkloadDurations(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`reporter="destination"`,
+			prometheus.Metric{Value: 12, Labels: map[string]string{"destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo"}},
+			prometheus.Metric{Value: 250, Labels: map[string]string{"destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v2", "destination_workload_namespace": "bookinfo"}},
+		)
+	d := &Datasource{prometheusClient: client}
+
+	edges := map[string]models.Edge{
+		"workload-productpage-v1-bookinfo-service-reviews-bookinfo": {SourceType: "Workload", DestinationType: "Service", DestinationNamespace: "bookinfo", HTTPRequestDuration: 100},
+		"service-reviews-bookinfo-workload-reviews-v1-bookinfo":     {SourceType: "Service", DestinationType: "Workload", DestinationNamespace: "bookinfo"},
+		"service-reviews-bookinfo-workload-reviews-v2-bookinfo":     {SourceType: "Service", DestinationType: "Workload", DestinationNamespace: "bookinfo"},
+	}
+
+	err := d.addWorkloadDurations(context.Background(), edges, graphOptions{metrics: []string{models.MetricHTTPRequests, models.MetricHTTPRequestDuration}}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)})
+	require.NoError(t, err)
+	require.Equal(t, 100.0, edges["workload-productpage-v1-bookinfo-service-reviews-bookinfo"].HTTPRequestDuration)
+	require.Equal(t, 12.0, edges["service-reviews-bookinfo-workload-reviews-v1-bookinfo"].HTTPRequestDuration)
+	require.Equal(t, 250.0, edges["service-reviews-bookinfo-workload-reviews-v2-bookinfo"].HTTPRequestDuration)
+	require.Equal(t, []string{`histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds_bucket{destination_workload_namespace="bookinfo", request_protocol="http", reporter="destination"}[3600s])) by (le, destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload)) > 0`}, client.Queries())
+}
+
+func TestFormatDuration(t *testing.T) {
+	require.Equal(t, "12.35ms", formatDuration(12.345))
+	require.Equal(t, "-", formatDuration(0))
+	require.Equal(t, "-", formatDuration(math.NaN()))
+	require.Equal(t, "-", formatDuration(math.Inf(1)))
+}
+
+func TestMetricsToEdgesEmptyBuckets(t *testing.T) {
+	labels := map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo"}
+	withMetric := func(metric string) map[string]string {
+		l := maps.Clone(labels)
+		l["metric"] = metric
+		return l
+	}
+
+	d := &Datasource{}
+	edges := d.metricsToEdges([]prometheus.Metric{
+		{Value: 0, Labels: withMetric(models.MetricHTTPRequests)},
+		{Value: math.NaN(), Labels: withMetric(models.MetricHTTPRequestDuration)},
+		{Value: math.Inf(1), Labels: withMetric(models.MetricGRPCRequestDuration)},
+	}, graphOptions{idleEdges: true})
+
+	edge := edges["workload-productpage-v1-bookinfo-service-reviews-bookinfo"]
+	require.Equal(t, 0.0, edge.HTTPRequestDuration)
+	require.Equal(t, 0.0, edge.GRPCRequestDuration)
+
+	field := d.getEdgeField(edge, 60)
+	require.Equal(t, []string{"-"}, field.DetailsHTTPDuration)
+	require.Equal(t, []string{"-"}, field.DetailsGRPCDuration)
+}
+
+func TestMetricsToEdgesMultiCluster(t *testing.T) {
+	labels := map[string]string{"metric": models.MetricHTTPRequests, "response_code": "200", "source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo"}
+	withClusters := func(source, destination string) map[string]string {
+		l := maps.Clone(labels)
+		l["source_cluster"] = source
+		l["destination_cluster"] = destination
+		return l
+	}
+
+	d := &Datasource{}
+	edges := d.metricsToEdges([]prometheus.Metric{
+		{Value: 10, Labels: withClusters("east", "east")},
+		{Value: 20, Labels: withClusters("east", "west")},
+	}, graphOptions{})
+
+	require.Len(t, edges, 4)
+	require.Equal(t, 10.0, edges["workload-productpage-v1-bookinfo-service-reviews-bookinfo-east-east"].HTTPRequestsSuccess)
+	require.Equal(t, "service/bookinfo/reviews/west", edges["workload-productpage-v1-bookinfo-service-reviews-bookinfo-east-west"].Destination)
+	require.Equal(t, 20.0, edges["service-reviews-bookinfo-workload-reviews-v1-bookinfo-west-west"].HTTPRequestsSuccess)
+}
+
+func TestDropInvalidSamples(t *testing.T) {
+	timestamps := []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0), time.Unix(180, 0)}
+
+	ts := dropInvalidSamples(prometheus.TimeSeries{Timestamps: timestamps, Values: []float64{10, math.NaN(), math.Inf(1), 20}, Labels: map[string]string{"destination_version": "v1"}})
+	require.Equal(t, []time.Time{time.Unix(0, 0), time.Unix(180, 0)}, ts.Timestamps)
+	require.Equal(t, []float64{10, 20}, ts.Values)
+	require.Equal(t, "v1", ts.Labels["destination_version"])
+
+	ts = dropInvalidSamples(prometheus.TimeSeries{Timestamps: timestamps[:1], Values: []float64{math.NaN()}})
+	require.Empty(t, ts.Values)
+}
+
+func TestHTTPResponseClass(t *testing.T) {
+	for code, expected := range map[string]string{
+		"200":   "2xx",
+		"302":   "3xx",
+		"429":   "4xx",
+		"503":   "5xx",
+		"":      "",
+		"0":     "",
+		"-":     "",
+		"5xx":   "",
+		"abc":   "",
+		"600":   "",
+		" 500 ": "",
+	} {
+		require.Equal(t, expected, httpResponseClass(code), code)
+	}
+
+	require.True(t, isHTTPError("500"))
+	require.False(t, isHTTPError(""))
+	require.False(t, isHTTPError("0"))
+	require.False(t, isHTTPError("5xx"))
+	require.False(t, isHTTPError("429"))
+}
+
+func TestSortEdges(t *testing.T) {
+	edges := map[string]models.Edge{
+		"c": {ID: "c", HTTPRequestsSuccess: 10},
+		"a": {ID: "a", TCPSentBytes: 100},
+		"b": {ID: "b", GRPCRequestsSuccess: 5, GRPCRequestsError: 10},
+		"d": {ID: "d", TCPSentBytes: 100},
+	}
+
+	var ids []string
+	for _, edge := range sortEdges(edges, false) {
+		ids = append(ids, edge.ID)
+	}
+	require.Equal(t, []string{"a", "b", "c", "d"}, ids)
+
+	ids = nil
+	for _, edge := range sortEdges(edges, true) {
+		ids = append(ids, edge.ID)
+	}
+	require.Equal(t, []string{"b", "c", "a", "d"}, ids)
+}
+
+func TestSortNodes(t *testing.T) {
+	nodes := map[string]models.Node{
+		"b": {ID: "b", ServerHTTPRequestsSuccess: 10},
+		"a": {ID: "a"},
+		"c": {ID: "c", ClientGRPCRequestsSuccess: 20},
+	}
+
+	var ids []string
+	for _, node := range sortNodes(nodes, false) {
+		ids = append(ids, node.ID)
+	}
+	require.Equal(t, []string{"a", "b", "c"}, ids)
+
+	ids = nil
+	for _, node := range sortNodes(nodes, true) {
+		ids = append(ids, node.ID)
+	}
+	require.Equal(t, []string{"c", "b", "a"}, ids)
+}
+
+func TestNodeID(t *testing.T) {
+	require.Equal(t, "workload/bookinfo/reviews-v1", nodeID("Workload", "bookinfo", "reviews-v1", ""))
+	require.Equal(t, "service/bookinfo/reviews/cluster-1", nodeID("Service", "bookinfo", "reviews", "cluster-1"))
+}
+
+func TestMarkGatewayNodes(t *testing.T) {
+	nodes := map[string]models.Node{
+		"workload/bookinfo/bookinfo-gateway-istio": {Type: "Workload", Name: "bookinfo-gateway-istio"},
+		"workload/bookinfo/productpage-v1":         {Type: "Workload", Name: "productpage-v1"},
+		"workload/bookinfo/-istio":                 {Type: "Workload", Name: "-istio"},
+		"service/bookinfo/gateway-istio":           {Type: "Service", Name: "gateway-istio"},
+	}
+
+	markGatewayNodes(nodes)
+
+	require.Equal(t, "Gateway", nodes["workload/bookinfo/bookinfo-gateway-istio"].Type)
+	require.Equal(t, "Workload", nodes["workload/bookinfo/productpage-v1"].Type)
+	require.Equal(t, "Workload", nodes["workload/bookinfo/-istio"].Type)
+	require.Equal(t, "Service", nodes["service/bookinfo/gateway-istio"].Type)
+}
+
+// blockingClient blocks all metric and label values queries until "release" is
+// closed, so that concurrent graph generations and list queries overlap.
+type blockingClient struct {
+	*prometheustest.Client
+	release chan struct{}
+	started chan struct{}
+}
+
+func (c blockingClient) GetMetrics(ctx context.Context, metric string, query prometheus.Expression, timeRange backend.TimeRange) ([]prometheus.Metric, error) {
+	<-c.release
+	return c.Client.GetMetrics(ctx, metric, query, timeRange)
+}
+
+func (c blockingClient) GetMetricsBatch(ctx context.Context, queries []prometheus.MetricsQuery) ([][]prometheus.Metric, error) {
+	<-c.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Client.GetMetricsBatch(ctx, queries)
+}
+
+func (c blockingClient) GetLabelValues(ctx context.Context, query prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	<-c.release
+	return c.Client.GetLabelValues(ctx, query, timeRange)
+}
+
+func (c blockingClient) GetLabelValuesBatch(ctx context.Context, queries []prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	if c.started != nil {
+		c.started <- struct{}{}
+	}
+	<-c.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Client.GetLabelValuesBatch(ctx, queries, timeRange)
+}
+
+func TestGetSharedLabelValues(t *testing.T) {
+	query := prometheus.LabelValuesQuery{Label: "destination_workload_namespace", Selectors: []prometheus.Selector{{Metric: "istio_requests_total"}}}
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}
+
+	// Both callers must reach Prometheus before the first batch is released,
+	// otherwise the test blocks, because the values of separate callers are
+	// never shared.
+	t.Run("should not share values between organizations", func(t *testing.T) {
+		client := prometheustest.NewClient().AddLabelValues("destination_workload_namespace", "bookinfo")
+		blocking := blockingClient{Client: client, release: make(chan struct{}), started: make(chan struct{})}
+		d := &Datasource{prometheusClient: blocking, logger: log.DefaultLogger}
+
+		var wg sync.WaitGroup
+		for _, orgID := range []int64{1, 2} {
+			ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID})
+			wg.Go(func() {
+				values, err := d.getSharedLabelValues(ctx, []prometheus.LabelValuesQuery{query}, timeRange)
+				require.NoError(t, err)
+				require.Equal(t, [][]string{{"bookinfo"}}, values)
+			})
+		}
+
+		<-blocking.started
+		<-blocking.started
+		close(blocking.release)
+		wg.Wait()
+
+		require.Len(t, client.Queries(), 2)
+	})
+
+	t.Run("should not cancel shared values when the first caller is canceled", func(t *testing.T) {
+		client := prometheustest.NewClient().AddLabelValues("destination_workload_namespace", "bookinfo")
+		blocking := blockingClient{Client: client, release: make(chan struct{}), started: make(chan struct{})}
+		d := &Datasource{prometheusClient: blocking, logger: log.DefaultLogger}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error)
+		go func() {
+			_, err := d.getSharedLabelValues(ctx, []prometheus.LabelValuesQuery{query}, timeRange)
+			errs <- err
+		}()
+
+		<-blocking.started
+		cancel()
+		require.ErrorIs(t, <-errs, context.Canceled)
+
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			values, err := d.getSharedLabelValues(context.Background(), []prometheus.LabelValuesQuery{query}, timeRange)
+			require.NoError(t, err)
+			require.Equal(t, [][]string{{"bookinfo"}}, values)
+		})
+
+		time.Sleep(50 * time.Millisecond)
+		close(blocking.release)
+		wg.Wait()
+
+		require.Len(t, client.Queries(), 1)
+	})
+}
+
+func TestSharedKey(t *testing.T) {
+	withUser := func(orgID int64, login string) context.Context {
+		return backend.WithUser(backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID}), &backend.User{Login: login})
+	}
+
+	t.Run("should share results between users of an organization", func(t *testing.T) {
+		d := &Datasource{}
+		require.Equal(t, d.sharedKey(withUser(1, "alice"), "key"), d.sharedKey(withUser(1, "bob"), "key"))
+		require.NotEqual(t, d.sharedKey(withUser(1, "alice"), "key"), d.sharedKey(withUser(2, "alice"), "key"))
+	})
+
+	t.Run("should not share results between users when prometheus is queried per user", func(t *testing.T) {
+		d := &Datasource{prometheusPerUser: true}
+		require.NotEqual(t, d.sharedKey(withUser(1, "alice"), "key"), d.sharedKey(withUser(1, "bob"), "key"))
+	})
+}
+
+func TestGetGraphShared(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: blockingClient{Client: client, release: make(chan struct{})}, logger: log.DefaultLogger}
+
+	options := graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}}
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}
+
+	var wg sync.WaitGroup
+	results := make([]map[string]models.Edge, 2)
+	for i := range results {
+		wg.Go(func() {
+			edges, _, _, err := d.getGraph(context.Background(), options, timeRange)
+			require.NoError(t, err)
+			results[i] = edges
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(d.prometheusClient.(blockingClient).release)
+	wg.Wait()
+
+	// A single graph generation sends one query for the sources and one for the
+	// destinations of the namespace.
+	require.Len(t, client.Queries(), 2)
+	require.Len(t, results[0], 2)
+	require.Equal(t, results[0], results[1])
+
+	// Each caller gets its own copy of the edges, so that a handler can modify
+	// them without affecting the other callers.
+	clear(results[0])
+	require.Len(t, results[1], 2)
+}
+
+func TestGetGraphSharedCanceled(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		)
+	blocking := blockingClient{Client: client, release: make(chan struct{})}
+	d := &Datasource{prometheusClient: blocking, logger: log.DefaultLogger}
+
+	options := graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}}
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}
+
+	// The first caller is canceled while the graph is generated. This must not
+	// cancel the generation for the second caller, which joins afterwards.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, _, _, err := d.getGraph(ctx, options, timeRange)
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+
+	var edges map[string]models.Edge
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		var err error
+		edges, _, _, err = d.getGraph(context.Background(), options, timeRange)
+		require.NoError(t, err)
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	close(blocking.release)
+	wg.Wait()
+
+	require.Len(t, client.Queries(), 2)
+	require.Len(t, edges, 2)
+}
+
+func TestGetGraphStats(t *testing.T) {
+	// The metric is returned for the sources and the destinations of the
+	// namespace, so that it must be deduplicated.
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total\{(source|destination)_workload_namespace="bookinfo", request_protocol="http"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	edges, nodes, stats, err := d.getGraph(context.Background(), graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)})
+	require.NoError(t, err)
+	require.Equal(t, graphStats{series: 2, deduplicatedSeries: 1}, stats)
+	require.Len(t, edges, 2)
+	require.Len(t, nodes, 3)
+}
+
+func TestGetGraphAggregateByApp(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddMetrics(`istio_requests_total\{destination_workload_namespace="bookinfo", request_protocol="http"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "source_app": "productpage", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "destination_app": "reviews", "response_code": "200"}},
+			prometheus.Metric{Value: 30, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "source_app": "productpage", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v2", "destination_workload_namespace": "bookinfo", "destination_app": "reviews", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	edges, nodes, _, err := d.getGraph(context.Background(), graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests}, aggregateByApp: true}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)})
+	require.NoError(t, err)
+	require.Len(t, edges, 2)
+	require.Equal(t, 90.0, edges["service-reviews-bookinfo-workload-reviews-bookinfo"].HTTPRequestsSuccess)
+	require.Equal(t, 90.0, edges["workload-productpage-bookinfo-service-reviews-bookinfo"].HTTPRequestsSuccess)
+	require.Len(t, nodes, 3)
+	require.Contains(t, client.Queries()[0], "source_workload, source_app, destination_app, response_code) > 0")
+}
+
+// batchCountingClient records the size of each batch of metrics queries.
+type batchCountingClient struct {
+	*prometheustest.Client
+	batches *[]int
+}
+
+func (c batchCountingClient) GetMetricsBatch(ctx context.Context, queries []prometheus.MetricsQuery) ([][]prometheus.Metric, error) {
+	*c.batches = append(*c.batches, len(queries))
+	return c.Client.GetMetricsBatch(ctx, queries)
+}
+
+func TestGetGraphMetricsBatch(t *testing.T) {
+	var batches []int
+	d := &Datasource{prometheusClient: batchCountingClient{Client: prometheustest.NewClient(), batches: &batches}, logger: log.DefaultLogger}
+
+	_, err := d.getGraphMetrics(context.Background(), []graphTarget{{namespace: "bookinfo"}}, graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests, models.MetricGRPCRequests, models.MetricTCPSentBytes}}, 60, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)})
+	require.NoError(t, err)
+	require.Equal(t, []int{6}, batches)
+}
+
+func TestGetGraphOverloadRetry(t *testing.T) {
+	overloadErr := errors.New("execution: query processing would load too many samples into memory in query execution")
+	client := prometheustest.NewClient().
+		AddError(`\[3600s\]`, overloadErr).
+		AddMetrics(`istio_requests_total\{destination_workload_namespace="bookinfo", request_protocol="http".*\[900s\]`,
+			prometheus.Metric{Value: 15, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	edges, _, stats, err := d.getGraph(context.Background(), graphOptions{namespace: "bookinfo", metrics: []string{models.MetricHTTPRequests, models.MetricHTTPRequestDuration}}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)})
+	require.NoError(t, err)
+	require.Equal(t, 60.0, edges["service-reviews-bookinfo-workload-reviews-v1-bookinfo"].HTTPRequestsSuccess)
+	require.Equal(t, []string{
+		"The httpRequestDuration metric is omitted, because the query would load too many samples",
+		"The httpRequests metric was queried in windows of 15m, because the query would load too many samples",
+	}, stats.degradations)
+}
+
+func TestGenerateGraphDepth(t *testing.T) {
+	newClient := func(ratingsNamespace string) *prometheustest.Client {
+		return prometheustest.NewClient().
+			AddLabelValues("destination_workload", "reviews-v1").
+			AddMetrics(`destination_app="reviews"`,
+				prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "productpage-v1", "source_workload_namespace": "bookinfo", "destination_service": "reviews.bookinfo.svc.cluster.local", "destination_service_name": "reviews", "destination_service_namespace": "bookinfo", "destination_workload": "reviews-v1", "destination_workload_namespace": "bookinfo", "response_code": "200"}},
+			).
+			AddMetrics(`source_app="reviews"`,
+				prometheus.Metric{Value: 60, Labels: map[string]string{"source_workload": "reviews-v1", "source_workload_namespace": "bookinfo", "destination_service": "ratings." + ratingsNamespace + ".svc.cluster.local", "destination_service_name": "ratings", "destination_service_namespace": ratingsNamespace, "destination_workload": "ratings-v1", "destination_workload_namespace": ratingsNamespace, "response_code": "200"}},
+			)
+	}
+	options := graphOptions{namespace: "bookinfo", application: "reviews", metrics: []string{models.MetricHTTPRequests}, depth: 2}
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}
+
+	t.Run("should only get the metrics for the neighbors", func(t *testing.T) {
+		client := newClient("bookinfo")
+		var batches []int
+		d := &Datasource{prometheusClient: batchCountingClient{Client: client, batches: &batches}, logger: log.DefaultLogger}
+
+		_, _, _, err := d.generateGraph(context.Background(), options, timeRange)
+		require.NoError(t, err)
+
+		// The second hop only gets the metrics for the neighbors of the
+		// application in a single batch, the workloads of the application
+		// itself are already part of the graph.
+		require.Equal(t, []int{2, 2}, batches)
+		require.Len(t, client.Queries(), 6)
+		for _, query := range client.Queries()[4:] {
+			require.Contains(t, query, `_workload=~"productpage-v1|ratings-v1"`)
+		}
+	})
+
+	t.Run("should skip neighbors in namespaces which are not allowed", func(t *testing.T) {
+		client := newClient("ratings")
+		var batches []int
+		d := &Datasource{prometheusClient: batchCountingClient{Client: client, batches: &batches}, logger: log.DefaultLogger, istioNamespaces: []string{"bookinfo"}}
+
+		_, _, _, err := d.generateGraph(context.Background(), options, timeRange)
+		require.NoError(t, err)
+
+		require.Equal(t, []int{2, 2}, batches)
+		require.Len(t, client.Queries(), 6)
+		for _, query := range client.Queries()[4:] {
+			require.Contains(t, query, `_workload="productpage-v1"`)
+			require.NotContains(t, query, `ratings`)
+		}
+	})
+}
+
+func TestHandleUpstreamsOfService(t *testing.T) {
+	client := prometheustest.NewClient().
+		AddLabelValues("destination_workload", "reviews-v1", "reviews-v2").
+		AddMetrics(`source_workload=~"reviews-v1\|reviews-v2"`,
+			prometheus.Metric{Value: 60, Labels: map[string]string{"destination_service_namespace": "bookinfo", "destination_service_name": "ratings", "request_protocol": "http", "response_code": "200"}},
+		)
+	d := &Datasource{prometheusClient: client, logger: log.DefaultLogger}
+
+	response := d.handleDependencies(context.Background(), concurrent.Query{DataQuery: backend.DataQuery{
+		JSON:      []byte(`{"namespace": "bookinfo", "service": "reviews"}`),
+		TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)},
+	}}, true)
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 1)
+	require.Equal(t, "bookinfo/ratings", response.Frames[0].Fields[0].At(0))
+	require.Contains(t, client.Queries(), `sum(increase(istio_requests_total{reporter="source", source_workload_namespace="bookinfo", source_workload=~"reviews-v1|reviews-v2"}[60s])) by (destination_service_namespace, destination_service_name, request_protocol, response_code, grpc_response_status)`)
+}
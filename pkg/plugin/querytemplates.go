@@ -0,0 +1,500 @@
+package plugin
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+)
+
+// graphQueryBuilder returns the PromQL query for the given metric, where the
+// namespace / application / workloads are the destination or the source.
+type graphQueryBuilder func(namespace, application string, workloads []string, metric string, options graphOptions, interval int64) prometheus.Expression
+
+// graphQueryTemplate is the template for the PromQL query of a metric, which
+// is used to generate a graph. The query always sums up the "increase" of the
+// metric and groups it by the graph grouping labels.
+type graphQueryTemplate struct {
+	// metric is the name of the Prometheus metric.
+	metric string
+	// matchers are additional label matchers for the metric.
+	matchers prometheus.Matchers
+	// groupBy are additional labels to group the metric by, which must start
+	// with a comma.
+	groupBy string
+	// quantile wraps the query with a "histogram_quantile" function to get the
+	// P99 value of the histogram.
+	quantile bool
+	// nonZero filters out all zero values, even if idle edges should be shown.
+	nonZero bool
+	// scale is the factor for the "histogram_quantile" function to convert
+	// the P99 value into milliseconds, e.g. 1000. It is ignored when it is
+	// zero or one.
+	scale float64
+	// native is set for native histograms, which do not have a "le" label, so
+	// that the query is not grouped by the buckets.
+	native bool
+}
+
+// graphQueryTemplates contains the templates for all metrics, which can be
+// used in a graph. The templates are created once, so that we only have to
+// fill in the dynamic parts of the query for each request.
+var graphQueryTemplates = map[string]graphQueryTemplate{
+	models.MetricGRPCRequests:         {metric: "istio_requests_total", matchers: prometheus.Matchers{prometheus.Equal("request_protocol", "grpc")}, groupBy: ", grpc_response_status"},
+	models.MetricGRPCRequestDuration:  {metric: "istio_request_duration_milliseconds_bucket", matchers: prometheus.Matchers{prometheus.Equal("request_protocol", "grpc")}, quantile: true},
+	models.MetricGRPCSentMessages:     {metric: "istio_request_messages_total"},
+	models.MetricGRPCReceivedMessages: {metric: "istio_response_messages_total"},
+	models.MetricHTTPRequests:         {metric: "istio_requests_total", matchers: prometheus.Matchers{prometheus.Equal("request_protocol", "http")}, groupBy: ", response_code"},
+	models.MetricHTTPRequestDuration:  {metric: "istio_request_duration_milliseconds_bucket", matchers: prometheus.Matchers{prometheus.Equal("request_protocol", "http")}, quantile: true},
+	models.MetricTCPSentBytes:         {metric: "istio_tcp_sent_bytes_total"},
+	models.MetricTCPReceivedBytes:     {metric: "istio_tcp_received_bytes_total"},
+	models.MetricResponseFlags:        {metric: "istio_requests_total", matchers: prometheus.Matchers{prometheus.NotEqual("response_flags", "-")}, groupBy: ", response_flags", nonZero: true},
+}
+
+// The labels which are used to group the metrics for a graph. If the locality
+// of the edges should be shown, we also group by the "source_locality" and
+// "destination_locality" labels. These labels are only available if they were
+// added to the Istio metrics via the telemetry API.
+const (
+	graphGroupBy         = "destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload"
+	graphGroupByLocality = graphGroupBy + ", source_locality, destination_locality"
+)
+
+// graphGroupByCluster are the labels which are added to the graph grouping
+// labels for multi-cluster datasources, so that the nodes and edges of
+// workloads with the same name in different clusters are not merged.
+const graphGroupByCluster = "source_cluster, destination_cluster"
+
+// graphGroupByApp are the labels which are added to the graph grouping labels,
+// when the workloads should be aggregated by their app.
+const graphGroupByApp = "source_app, destination_app"
+
+// graphOptionalGroupingLabels are the graph grouping labels, which are only
+// needed for some details of a graph: The "destination_service" label contains
+// the host of a service, which is used for the service dashboard links and to
+// detect mirrored traffic, and the "destination_version" label is used for the
+// traffic split. A query can omit these labels to reduce the size of the
+// results.
+var graphOptionalGroupingLabels = []string{"destination_service", "destination_version"}
+
+// graphGroupByWorkload are the labels which are used to group the request
+// durations by the destination workload, independent of the source workload.
+const graphGroupByWorkload = "destination_service, destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload"
+
+// defaultRevisionLabel is the label which contains the Istio revision of the
+// reporting proxy, when no label is configured. This is the "istio.io/rev" pod
+// label, when it is added to the Istio metrics via relabeling.
+const defaultRevisionLabel = "istio_io_rev"
+
+// build generates the PromQL query from the template. The "namespaceMatchers"
+// select the namespace via the "destination_workload_namespace",
+// "destination_service_namespace" or "source_workload_namespace" label, see
+// "namespaceMatchers", and the "matchers" are optional matchers for the
+// application, workloads or service and the filters of the graph.
+func (t graphQueryTemplate) build(namespaceMatchers, matchers prometheus.Matchers, groupBy string, idleEdges bool, interval int64) prometheus.Expression {
+	labels := strings.Split(groupBy+t.groupBy, ", ")
+	if t.quantile && !t.native {
+		labels = append([]string{"le"}, labels...)
+	}
+
+	var query prometheus.Expression = prometheus.Query{
+		Aggregation: "sum",
+		Function:    "increase",
+		Selector:    t.selector(namespaceMatchers, matchers),
+		Window:      time.Duration(interval) * time.Second,
+		GroupBy:     labels,
+	}
+	if t.quantile {
+		query = prometheus.Call{Function: "histogram_quantile", Args: []prometheus.Expression{prometheus.Number(0.99), query}}
+		if t.scale != 0 && t.scale != 1 {
+			query = prometheus.Binary{LHS: query, Operator: "*", RHS: prometheus.Number(t.scale)}
+		}
+	}
+	if t.nonZero || !idleEdges {
+		query = prometheus.Binary{LHS: query, Operator: ">", RHS: prometheus.Number(0)}
+	}
+
+	return query
+}
+
+// selector returns the series selector of the template, which is used in the
+// query generated via "build", e.g. to count the series read by the query.
+func (t graphQueryTemplate) selector(namespaceMatchers, matchers prometheus.Matchers) prometheus.Selector {
+	selectorMatchers := make(prometheus.Matchers, 0, len(namespaceMatchers)+len(t.matchers)+len(matchers))
+	selectorMatchers = append(selectorMatchers, namespaceMatchers...)
+	selectorMatchers = append(selectorMatchers, t.matchers...)
+	selectorMatchers = append(selectorMatchers, matchers...)
+	return prometheus.Selector{Metric: t.metric, Matchers: selectorMatchers}
+}
+
+// graphQueryTemplate returns the template for the given metric. For the
+// request duration metrics the histogram metric and the scaling of the
+// datasource are used, so that the durations are always in milliseconds.
+func (d *Datasource) graphQueryTemplate(metric string) (graphQueryTemplate, bool) {
+	template, ok := graphQueryTemplates[metric]
+	if ok && template.quantile {
+		template.metric = d.durationHistogramMetric()
+		template.scale = d.durationScale()
+		template.native = d.istioNativeHistograms
+	}
+	return template, ok
+}
+
+// defaultDurationMetric is the name of the request duration histogram of
+// Istio, without the "_bucket", "_count" and "_sum" suffix.
+const defaultDurationMetric = "istio_request_duration_milliseconds"
+
+// durationMetricName returns the name of the request duration histogram of
+// the datasource with the given suffix, e.g. "bucket" or "count".
+func (d *Datasource) durationMetricName(suffix string) string {
+	return cmp.Or(d.istioDurationMetric, defaultDurationMetric) + "_" + suffix
+}
+
+// durationHistogramMetric returns the name of the series, which contain the
+// buckets of the request duration histogram. For native histograms this is the
+// name of the histogram itself, because the buckets are stored in a single
+// series.
+func (d *Datasource) durationHistogramMetric() string {
+	if d.istioNativeHistograms {
+		return cmp.Or(d.istioDurationMetric, defaultDurationMetric)
+	}
+	return d.durationMetricName("bucket")
+}
+
+// durationQuantile returns the query for the given quantile of the request
+// duration histogram in milliseconds. The histogram is aggregated with the
+// given range function (e.g. "rate") over the window and grouped by the given
+// labels, where the "le" label is added for classic histograms.
+func (d *Datasource) durationQuantile(quantile float64, function string, matchers prometheus.Matchers, window int64, groupBy ...string) prometheus.Expression {
+	if !d.istioNativeHistograms {
+		groupBy = append([]string{"le"}, groupBy...)
+	}
+
+	var query prometheus.Expression = prometheus.Call{Function: "histogram_quantile", Args: []prometheus.Expression{
+		prometheus.Number(quantile),
+		sumQuery(d.durationHistogramMetric(), function, matchers, window, groupBy...),
+	}}
+	if scale := d.durationScale(); scale != 1 {
+		query = prometheus.Binary{LHS: query, Operator: "*", RHS: prometheus.Number(scale)}
+	}
+	return query
+}
+
+// durationFraction returns the query for the share (between 0 and 1) of the
+// requests, which were faster than the given threshold in milliseconds. For
+// classic histograms the bucket matching the threshold is divided by the count
+// of the histogram, for native histograms the "histogram_fraction" function is
+// used, which doesn't require a bucket for the threshold.
+func (d *Datasource) durationFraction(function string, matchers prometheus.Matchers, threshold float64, window int64, groupBy ...string) prometheus.Expression {
+	if d.istioNativeHistograms {
+		return prometheus.Call{Function: "histogram_fraction", Args: []prometheus.Expression{
+			prometheus.Number(0),
+			prometheus.Number(threshold / d.durationScale()),
+			sumQuery(d.durationHistogramMetric(), function, matchers, window, groupBy...),
+		}}
+	}
+	return prometheus.Binary{LHS: d.durationBelow(function, matchers, threshold, window, groupBy...), Operator: "/", RHS: d.durationCount(function, matchers, window, groupBy...)}
+}
+
+// durationBelow returns the query for the number of requests, which were
+// faster than the given threshold in milliseconds.
+func (d *Datasource) durationBelow(function string, matchers prometheus.Matchers, threshold float64, window int64, groupBy ...string) prometheus.Expression {
+	if d.istioNativeHistograms {
+		return prometheus.Binary{LHS: d.durationFraction(function, matchers, threshold, window, groupBy...), Operator: "*", RHS: d.durationCount(function, matchers, window, groupBy...)}
+	}
+	return sumQuery(d.durationMetricName("bucket"), function, append(slices.Clip(matchers), prometheus.Equal("le", d.durationBucket(threshold))), window, groupBy...)
+}
+
+// durationCount returns the query for the number of requests of the request
+// duration histogram. For native histograms the count is extracted via the
+// "histogram_count" function.
+func (d *Datasource) durationCount(function string, matchers prometheus.Matchers, window int64, groupBy ...string) prometheus.Expression {
+	if d.istioNativeHistograms {
+		return prometheus.Call{Function: "histogram_count", Args: []prometheus.Expression{sumQuery(d.durationHistogramMetric(), function, matchers, window, groupBy...)}}
+	}
+	return sumQuery(d.durationMetricName("count"), function, matchers, window, groupBy...)
+}
+
+// sumQuery returns the sum of the given range function (e.g. "increase") over
+// the series of the metric with the given matchers, which is grouped by the
+// given labels.
+func sumQuery(metric, function string, matchers prometheus.Matchers, window int64, groupBy ...string) prometheus.Query {
+	return prometheus.Query{
+		Aggregation: "sum",
+		Function:    function,
+		Selector:    prometheus.Selector{Metric: metric, Matchers: matchers},
+		Window:      time.Duration(window) * time.Second,
+		GroupBy:     groupBy,
+	}
+}
+
+// requestErrorsQuery returns the sum of the given range function over the
+// failed requests with the given matchers, which is grouped by the given
+// labels. A gRPC request failed, when it has one of the server error status
+// codes, an HTTP request failed, when it has a 5xx response code.
+func requestErrorsQuery(function string, matchers prometheus.Matchers, window int64, groupBy ...string) prometheus.Expression {
+	requests := func(errorMatchers ...prometheus.Matcher) prometheus.Query {
+		return prometheus.Query{
+			Function: function,
+			Selector: prometheus.Selector{Metric: "istio_requests_total", Matchers: append(slices.Clip(matchers), errorMatchers...)},
+			Window:   time.Duration(window) * time.Second,
+		}
+	}
+
+	return prometheus.Aggregate{
+		Operator: "sum",
+		Expr: prometheus.Binary{
+			LHS:      requests(prometheus.Equal("request_protocol", "grpc"), prometheus.Regexp("grpc_response_status", "2|4|12|13|14|15")),
+			Operator: "or",
+			RHS:      requests(prometheus.NotEqual("request_protocol", "grpc"), prometheus.Regexp("response_code", "5.*")),
+		},
+		GroupBy: groupBy,
+	}
+}
+
+// durationScale returns the factor to convert the values of the request
+// duration histogram into milliseconds. If no unit is configured, the unit is
+// detected from the name of the histogram, so that the old
+// "istio_request_duration_seconds" histogram works without a unit.
+func (d *Datasource) durationScale() float64 {
+	if d.istioDurationUnit == models.DurationUnitSeconds || d.istioDurationUnit == "" && strings.HasSuffix(d.istioDurationMetric, "_seconds") {
+		return 1000
+	}
+	return 1
+}
+
+// durationBucket returns the value of the "le" label of the request duration
+// histogram for the given threshold in milliseconds.
+func (d *Datasource) durationBucket(threshold float64) string {
+	return strconv.FormatFloat(threshold/d.durationScale(), 'g', -1, 64)
+}
+
+// metricToPrometheusDestinationsQuery generates the Prometheus query for the
+// given metric where the application or workload is the destination.
+//
+// If the "idleEdges" parameter is set to true, the query will also include
+// edges with zero traffic. Otherwise, these edges will be filtered out using a
+// "> 0" operator.
+//
+// If the "application" parameter is set, the query will filter by the
+// "destination_app" label. If the "workloads" parameter is set, the query will
+// filter by the "destination_workload" label.
+func (d *Datasource) metricToPrometheusDestinationsQuery(namespace, application string, workloads []string, metric string, options graphOptions, interval int64) prometheus.Expression {
+	template, ok := d.graphQueryTemplate(metric)
+	if !ok {
+		return nil
+	}
+
+	return template.build(d.namespaceMatchers(namespace, "destination_workload_namespace"), append(graphFocusMatchers("destination", application, workloads), d.graphFilterMatchers(options)...), d.graphGroupingLabels(options), options.idleEdges, interval)
+}
+
+// metricToPrometheusServiceDestinationsQuery generates the Prometheus query for
+// the given metric where the workloads are the destination of a service in the
+// namespace. In contrast to the "metricToPrometheusDestinationsQuery" function
+// the namespace is matched via the "destination_service_namespace" label, so
+// that the workloads can run in another namespace than the service.
+func (d *Datasource) metricToPrometheusServiceDestinationsQuery(namespace, application string, workloads []string, metric string, options graphOptions, interval int64) prometheus.Expression {
+	template, ok := d.graphQueryTemplate(metric)
+	if !ok {
+		return nil
+	}
+
+	return template.build(d.namespaceMatchers(namespace, "destination_service_namespace"), append(graphFocusMatchers("destination", application, workloads), d.graphFilterMatchers(options)...), d.graphGroupingLabels(options), options.idleEdges, interval)
+}
+
+// metricToPrometheusServiceQuery generates the Prometheus query for the given
+// metric where the service of the options is the destination. The namespace
+// is the namespace of the service.
+func (d *Datasource) metricToPrometheusServiceQuery(namespace, application string, workloads []string, metric string, options graphOptions, interval int64) prometheus.Expression {
+	template, ok := d.graphQueryTemplate(metric)
+	if !ok {
+		return nil
+	}
+
+	return template.build(d.namespaceMatchers(namespace, "destination_service_namespace"), append(prometheus.Matchers{prometheus.Equal("destination_service_name", options.service)}, d.graphFilterMatchers(options)...), d.graphGroupingLabels(options), options.idleEdges, interval)
+}
+
+// metricToPrometheusSourcesQuery generates the Prometheus query for the given
+// metric where the application or workload is the source.
+//
+// If the "idleEdges" parameter is set to true, the query will also include
+// edges with zero traffic. Otherwise, these edges will be filtered out using a
+// "> 0" operator.
+//
+// If the "application" parameter is set, the query will filter by the
+// "source_app" label. If the "workloads" parameter is set, the query will
+// filter by the "source_workload" label.
+func (d *Datasource) metricToPrometheusSourcesQuery(namespace, application string, workloads []string, metric string, options graphOptions, interval int64) prometheus.Expression {
+	template, ok := d.graphQueryTemplate(metric)
+	if !ok {
+		return nil
+	}
+
+	return template.build(d.namespaceMatchers(namespace, "source_workload_namespace"), append(graphFocusMatchers("source", application, workloads), d.graphFilterMatchers(options)...), d.graphGroupingLabels(options), options.idleEdges, interval)
+}
+
+// metricToPrometheusWorkloadDurationsQuery generates the Prometheus query for
+// the P99 request duration of all workloads in the given namespace, grouped by
+// the destination service and workload. Only the metrics reported by the
+// destination are used, so that each request is counted once, independent of
+// the source workload.
+func (d *Datasource) metricToPrometheusWorkloadDurationsQuery(namespace, metric string, options graphOptions, interval int64) prometheus.Expression {
+	template, ok := d.graphQueryTemplate(metric)
+	if !ok || !template.quantile {
+		return nil
+	}
+
+	// If the workloads are aggregated by their app, the durations are grouped
+	// by the destination app instead of the workload, so that the P99 duration
+	// is calculated over the requests of all workloads of the app.
+	groupBy := graphGroupByWorkload
+	if options.aggregateByApp {
+		groupBy = strings.TrimSuffix(graphGroupByWorkload, "destination_workload") + "destination_app"
+	}
+	if d.istioMultiCluster {
+		groupBy += ", destination_cluster"
+	}
+
+	return template.build(d.namespaceMatchers(namespace, "destination_workload_namespace"), append(prometheus.Matchers{prometheus.Equal("reporter", "destination")}, d.graphFilterMatchers(options)...), groupBy, false, interval)
+}
+
+// graphFocusMatchers returns the label matchers for the given application or
+// workloads, where the prefix is either "destination" or "source". If multiple
+// workloads are given, a regular expression is used to match all of them.
+func graphFocusMatchers(prefix, application string, workloads []string) prometheus.Matchers {
+	if application != "" {
+		return prometheus.Matchers{prometheus.Equal(prefix+"_app", application)}
+	} else if len(workloads) > 0 {
+		return prometheus.Matchers{prometheus.OneOf(prefix+"_workload", workloads...)}
+	}
+	return nil
+}
+
+// graphFilterMatchers returns the label matchers, which are added to all graph
+// queries to filter the metrics by the revision, the response classes and the
+// excluded destinations.
+func (d *Datasource) graphFilterMatchers(options graphOptions) prometheus.Matchers {
+	var matchers prometheus.Matchers
+	matchers = append(matchers, d.revisionMatchers(options)...)
+	matchers = append(matchers, responseClassMatchers(options)...)
+	matchers = append(matchers, d.excludedDestinationsMatchers()...)
+	return matchers
+}
+
+// revisionMatchers returns the label matcher for the Istio revision, so that a
+// graph only contains the metrics reported by the proxies of this revision. If
+// no revision is set in the query, the default revision of the datasource is
+// used. If both are empty, no matcher is returned.
+func (d *Datasource) revisionMatchers(options graphOptions) prometheus.Matchers {
+	revision := cmp.Or(options.revision, d.istioRevision)
+	if revision == "" {
+		return nil
+	}
+	return prometheus.Matchers{prometheus.Equal(cmp.Or(d.istioRevisionLabel, defaultRevisionLabel), revision)}
+}
+
+// graphResponseClasses are the response code classes, which can be used to
+// filter the requests of a graph, e.g. to only show the failing requests.
+var graphResponseClasses = []string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// responseClassMatchers returns the label matcher for the response code
+// classes of the options, e.g. `response_code=~"4..|5.."` for the classes
+// "4xx" and "5xx". If no classes are set, no matcher is returned.
+func responseClassMatchers(options graphOptions) prometheus.Matchers {
+	if len(options.includeResponseClasses) == 0 {
+		return nil
+	}
+
+	var codes []string
+	for _, class := range options.includeResponseClasses {
+		codes = append(codes, class[:1]+"..")
+	}
+	return prometheus.Matchers{prometheus.Regexp("response_code", strings.Join(codes, "|"))}
+}
+
+// excludedDestinationsMatchers returns the label matcher to drop the requests
+// to the excluded destinations of the datasource, e.g. the health checks and
+// metric scrapes of the Istio proxies. If no destinations are excluded, no
+// matcher is returned.
+func (d *Datasource) excludedDestinationsMatchers() prometheus.Matchers {
+	if len(d.istioExcludedDestinations) == 0 {
+		return nil
+	}
+
+	var patterns []string
+	for _, destination := range d.istioExcludedDestinations {
+		patterns = append(patterns, excludedDestinationRegex(destination))
+	}
+	return prometheus.Matchers{prometheus.NotRegexp("destination_service", strings.Join(patterns, "|"))}
+}
+
+// excludedDestinationRegex returns the regular expression for the
+// "destination_service" label of an excluded destination. A destination is the
+// host of a service, which can contain "*" as wildcard, e.g. "*.istio-system".
+// The host also matches the fully qualified name of a service, e.g.
+// "istiod.istio-system.svc.cluster.local".
+func excludedDestinationRegex(destination string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(destination), `\*`, ".*") + `(\..*)?`
+}
+
+// graphGroupingLabels returns the labels which are used to group the metrics
+// for a graph. If the grouping labels of the options are set, only these
+// optional labels are added to the required labels. If the workloads should be
+// aggregated by their app, the "source_app" and "destination_app" labels are
+// also added. For multi-cluster datasources the "source_cluster" and
+// "destination_cluster" labels are always added.
+func (d *Datasource) graphGroupingLabels(options graphOptions) string {
+	if options.groupingLabels == nil {
+		groupBy := graphGroupBy
+		if options.locality {
+			groupBy = graphGroupByLocality
+		}
+		if options.aggregateByApp {
+			groupBy += ", " + graphGroupByApp
+		}
+		if d.istioMultiCluster {
+			groupBy += ", " + graphGroupByCluster
+		}
+		return groupBy
+	}
+
+	var labels []string
+	for label := range strings.SplitSeq(graphGroupBy, ", ") {
+		if slices.Contains(graphOptionalGroupingLabels, label) && !slices.Contains(options.groupingLabels, label) {
+			continue
+		}
+		labels = append(labels, label)
+	}
+	if options.locality {
+		labels = append(labels, "source_locality", "destination_locality")
+	}
+	if options.aggregateByApp {
+		labels = append(labels, graphGroupByApp)
+	}
+	if d.istioMultiCluster {
+		labels = append(labels, graphGroupByCluster)
+	}
+	return strings.Join(labels, ", ")
+}
+
+// validateGraphOptions returns an error if one of the grouping labels isn't an
+// optional graph grouping label or one of the response classes isn't valid.
+func validateGraphOptions(options graphOptions) error {
+	for _, label := range options.groupingLabels {
+		if !slices.Contains(graphOptionalGroupingLabels, label) {
+			return fmt.Errorf("invalid grouping label %q, must be one of %s", label, strings.Join(graphOptionalGroupingLabels, ", "))
+		}
+	}
+	for _, class := range options.includeResponseClasses {
+		if !slices.Contains(graphResponseClasses, class) {
+			return fmt.Errorf("invalid response class %q, must be one of %s", class, strings.Join(graphResponseClasses, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var graphQueryMetrics = []string{
+	models.MetricGRPCRequests,
+	models.MetricGRPCRequestDuration,
+	models.MetricGRPCSentMessages,
+	models.MetricGRPCReceivedMessages,
+	models.MetricHTTPRequests,
+	models.MetricHTTPRequestDuration,
+	models.MetricTCPSentBytes,
+	models.MetricTCPReceivedBytes,
+	models.MetricResponseFlags,
+}
+
+func TestGraphQueries(t *testing.T) {
+	d := &Datasource{}
+
+	focuses := []struct {
+		name        string
+		application string
+		workloads   []string
+	}{
+		{name: "namespace"},
+		{name: "application", application: "reviews"},
+		{name: "workload", workloads: []string{"reviews-v1"}},
+		{name: "workloads", workloads: []string{"reviews-v1", "reviews.v2"}},
+	}
+
+	for _, direction := range []struct {
+		name  string
+		query graphQueryBuilder
+	}{
+		{name: "destinations", query: d.metricToPrometheusDestinationsQuery},
+		{name: "sources", query: d.metricToPrometheusSourcesQuery},
+	} {
+		t.Run(direction.name, func(t *testing.T) {
+			var queries strings.Builder
+
+			for _, focus := range focuses {
+				for _, idleEdges := range []bool{false, true} {
+					for _, locality := range []bool{false, true} {
+						for _, metric := range graphQueryMetrics {
+							query := direction.query("bookinfo", focus.application, focus.workloads, metric, graphOptions{idleEdges: idleEdges, locality: locality}, 3600)
+							fmt.Fprintf(&queries, "%s idleEdges=%t locality=%t %s: %s\n", focus.name, idleEdges, locality, metric, query)
+						}
+					}
+				}
+			}
+
+			golden := filepath.Join("testdata", fmt.Sprintf("graphqueries_%s.golden", direction.name))
+			if *update {
+				require.NoError(t, os.MkdirAll("testdata", 0o755))
+				require.NoError(t, os.WriteFile(golden, []byte(queries.String()), 0o644))
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), queries.String())
+		})
+	}
+}
+
+func TestGraphFocusMatchers(t *testing.T) {
+	require.Empty(t, graphFocusMatchers("destination", "", nil))
+	require.Equal(t, `destination_app="reviews"`, graphFocusMatchers("destination", "reviews", []string{"reviews-v1"}).String())
+	require.Equal(t, `source_workload="reviews-v1"`, graphFocusMatchers("source", "", []string{"reviews-v1"}).String())
+	require.Equal(t, `source_workload=~"reviews-v1|my\\.workload"`, graphFocusMatchers("source", "", []string{"reviews-v1", "my.workload"}).String())
+	require.Equal(t, `destination_app="reviews\"} or vector(1) or up{a=\"b"`, graphFocusMatchers("destination", `reviews"} or vector(1) or up{a="b`, nil).String())
+}
+
+func BenchmarkGraphQueries(b *testing.B) {
+	d := &Datasource{}
+	options := graphOptions{locality: true}
+	workloads := []string{"reviews-v1", "reviews-v2"}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, metric := range graphQueryMetrics {
+			d.metricToPrometheusDestinationsQuery("bookinfo", "", workloads, metric, options, 3600)
+			d.metricToPrometheusSourcesQuery("bookinfo", "", workloads, metric, options, 3600)
+		}
+	}
+}
+
+func TestRevisionMatchers(t *testing.T) {
+	require.Empty(t, (&Datasource{}).revisionMatchers(graphOptions{}))
+	require.Equal(t, `istio_io_rev="canary"`, (&Datasource{}).revisionMatchers(graphOptions{revision: "canary"}).String())
+	require.Equal(t, `istio_io_rev="stable"`, (&Datasource{istioRevision: "stable"}).revisionMatchers(graphOptions{}).String())
+	require.Equal(t, `revision="canary"`, (&Datasource{istioRevisionLabel: "revision", istioRevision: "stable"}).revisionMatchers(graphOptions{revision: "canary"}).String())
+
+	d := &Datasource{}
+	require.Equal(t, `sum(increase(istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http", destination_app="reviews", istio_io_rev="canary"}[3600s])) by (`+graphGroupBy+`, response_code) > 0`, d.metricToPrometheusDestinationsQuery("bookinfo", "reviews", nil, models.MetricHTTPRequests, graphOptions{revision: "canary"}, 3600).String())
+}
+
+func TestGraphGroupingLabels(t *testing.T) {
+	d := &Datasource{}
+	require.Equal(t, graphGroupBy, d.graphGroupingLabels(graphOptions{}))
+	require.Equal(t, graphGroupByLocality, d.graphGroupingLabels(graphOptions{locality: true}))
+	require.Equal(t, "destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, source_workload_namespace, source_workload", d.graphGroupingLabels(graphOptions{groupingLabels: []string{}}))
+	require.Equal(t, "destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, destination_version, source_workload_namespace, source_workload, source_locality, destination_locality", d.graphGroupingLabels(graphOptions{groupingLabels: []string{"destination_version"}, locality: true}))
+
+	d = &Datasource{istioMultiCluster: true}
+	require.Equal(t, graphGroupBy+", source_cluster, destination_cluster", d.graphGroupingLabels(graphOptions{}))
+	require.Equal(t, "destination_service_namespace, destination_service_name, destination_workload_namespace, destination_workload, source_workload_namespace, source_workload, source_cluster, destination_cluster", d.graphGroupingLabels(graphOptions{groupingLabels: []string{}}))
+	require.Equal(t, `histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds_bucket{destination_workload_namespace="bookinfo", request_protocol="http", reporter="destination"}[3600s])) by (le, `+graphGroupByWorkload+`, destination_cluster)) > 0`, d.metricToPrometheusWorkloadDurationsQuery("bookinfo", models.MetricHTTPRequestDuration, graphOptions{}, 3600).String())
+}
+
+func TestValidateGraphOptions(t *testing.T) {
+	require.NoError(t, validateGraphOptions(graphOptions{groupingLabels: []string{"destination_service", "destination_version"}, includeResponseClasses: []string{"4xx", "5xx"}}))
+	require.ErrorContains(t, validateGraphOptions(graphOptions{groupingLabels: []string{"source_version"}}), `invalid grouping label "source_version"`)
+	require.ErrorContains(t, validateGraphOptions(graphOptions{includeResponseClasses: []string{"5"}}), `invalid response class "5"`)
+}
+
+func TestResponseClassMatchers(t *testing.T) {
+	require.Empty(t, responseClassMatchers(graphOptions{}))
+	require.Equal(t, `response_code=~"4..|5.."`, responseClassMatchers(graphOptions{includeResponseClasses: []string{"4xx", "5xx"}}).String())
+
+	d := &Datasource{}
+	require.Equal(t, `sum(increase(istio_requests_total{source_workload_namespace="bookinfo", request_protocol="http", source_workload="productpage-v1", response_code=~"5.."}[3600s])) by (`+graphGroupBy+`, response_code) > 0`, d.metricToPrometheusSourcesQuery("bookinfo", "", []string{"productpage-v1"}, models.MetricHTTPRequests, graphOptions{includeResponseClasses: []string{"5xx"}}, 3600).String())
+}
+
+func TestExcludedDestinationsMatchers(t *testing.T) {
+	require.Empty(t, (&Datasource{}).excludedDestinationsMatchers())
+	require.Equal(t, `destination_service!~".*\\.istio-system(\\..*)?|prometheus(\\..*)?"`, (&Datasource{istioExcludedDestinations: []string{"*.istio-system", "prometheus"}}).excludedDestinationsMatchers().String())
+
+	require.Regexp(t, "^"+excludedDestinationRegex("*.istio-system")+"$", `istiod.istio-system.svc.cluster.local`)
+	require.Regexp(t, "^"+excludedDestinationRegex("prometheus")+"$", `prometheus.monitoring.svc.cluster.local`)
+	require.NotRegexp(t, "^"+excludedDestinationRegex("prometheus")+"$", `prometheus-operator.monitoring.svc.cluster.local`)
+
+	d := &Datasource{istioExcludedDestinations: []string{"*.istio-system"}}
+	require.Equal(t, `sum(increase(istio_requests_total{destination_workload_namespace="bookinfo", request_protocol="http", destination_app="reviews", destination_service!~".*\\.istio-system(\\..*)?"}[3600s])) by (`+graphGroupBy+`, response_code) > 0`, d.metricToPrometheusDestinationsQuery("bookinfo", "reviews", nil, models.MetricHTTPRequests, graphOptions{}, 3600).String())
+
+	d, err := newDatasource(&models.PluginSettings{IstioExcludedDestinations: []string{"*.istio-system", ":15020"}}, models.OrgOverrides{}, nil, backend.Logger)
+	require.NoError(t, err)
+	require.Equal(t, []string{"*.istio-system"}, d.istioExcludedDestinations)
+	require.Equal(t, []string{":15020"}, d.istioIgnoredDestinations)
+}
+
+func TestMetricToPrometheusServiceDestinationsQuery(t *testing.T) {
+	d := &Datasource{}
+	require.Equal(t, `sum(increase(istio_requests_total{destination_service_namespace="bookinfo", request_protocol="http", destination_workload="reviews-v1"}[3600s])) by (`+graphGroupBy+`, response_code) > 0`, d.metricToPrometheusServiceDestinationsQuery("bookinfo", "", []string{"reviews-v1"}, models.MetricHTTPRequests, graphOptions{}, 3600).String())
+	require.Nil(t, d.metricToPrometheusServiceDestinationsQuery("bookinfo", "", []string{"reviews-v1"}, "unknown", graphOptions{}, 3600))
+}
+
+func TestMetricToPrometheusServiceQuery(t *testing.T) {
+	d := &Datasource{}
+	require.Equal(t, `sum(increase(istio_requests_total{destination_service_namespace="bookinfo", request_protocol="http", destination_service_name="reviews"}[3600s])) by (`+graphGroupBy+`, response_code) > 0`, d.metricToPrometheusServiceQuery("bookinfo", "", nil, models.MetricHTTPRequests, graphOptions{service: "reviews"}, 3600).String())
+}
+
+func TestDurationMetric(t *testing.T) {
+	d := &Datasource{}
+	require.Equal(t, "istio_request_duration_milliseconds_bucket", d.durationMetricName("bucket"))
+	require.Equal(t, 1.0, d.durationScale())
+	require.Equal(t, "500", d.durationBucket(500))
+
+	d = &Datasource{istioDurationMetric: "istio_request_duration_seconds"}
+	require.Equal(t, "istio_request_duration_seconds_count", d.durationMetricName("count"))
+	require.Equal(t, 1000.0, d.durationScale())
+	require.Equal(t, "0.5", d.durationBucket(500))
+	require.Equal(t, `histogram_quantile(0.99, sum(increase(istio_request_duration_seconds_bucket{destination_workload_namespace="bookinfo", request_protocol="http", reporter="destination"}[3600s])) by (le, `+graphGroupByWorkload+`)) * 1000 > 0`, d.metricToPrometheusWorkloadDurationsQuery("bookinfo", models.MetricHTTPRequestDuration, graphOptions{}, 3600).String())
+
+	d = &Datasource{istioDurationMetric: "custom_duration", istioDurationUnit: models.DurationUnitSeconds}
+	require.Equal(t, 1000.0, d.durationScale())
+}
+
+func TestDurationNativeHistograms(t *testing.T) {
+	d := &Datasource{}
+	require.Equal(t, `histogram_quantile(0.99, sum(rate(istio_request_duration_milliseconds_bucket{reporter="destination"}[60s])) by (le, destination_app))`, d.durationQuantile(0.99, "rate", prometheus.Matchers{prometheus.Equal("reporter", "destination")}, 60, "destination_app").String())
+	require.Equal(t, `sum(rate(istio_request_duration_milliseconds_bucket{reporter="destination", le="500"}[60s])) by (destination_app) / sum(rate(istio_request_duration_milliseconds_count{reporter="destination"}[60s])) by (destination_app)`, d.durationFraction("rate", prometheus.Matchers{prometheus.Equal("reporter", "destination")}, 500, 60, "destination_app").String())
+
+	d = &Datasource{istioNativeHistograms: true}
+	require.Equal(t, `histogram_quantile(0.99, sum(rate(istio_request_duration_milliseconds{reporter="destination"}[60s])) by (destination_app))`, d.durationQuantile(0.99, "rate", prometheus.Matchers{prometheus.Equal("reporter", "destination")}, 60, "destination_app").String())
+	require.Equal(t, `histogram_fraction(0, 500, sum(rate(istio_request_duration_milliseconds{reporter="destination"}[60s])) by (destination_app))`, d.durationFraction("rate", prometheus.Matchers{prometheus.Equal("reporter", "destination")}, 500, 60, "destination_app").String())
+	require.Equal(t, `histogram_count(sum(increase(istio_request_duration_milliseconds{reporter="destination"}[3600s])) by (destination_app))`, d.durationCount("increase", prometheus.Matchers{prometheus.Equal("reporter", "destination")}, 3600, "destination_app").String())
+	require.Equal(t, `histogram_quantile(0.99, sum(increase(istio_request_duration_milliseconds{destination_workload_namespace="bookinfo", request_protocol="http", reporter="destination"}[3600s])) by (`+graphGroupByWorkload+`)) > 0`, d.metricToPrometheusWorkloadDurationsQuery("bookinfo", models.MetricHTTPRequestDuration, graphOptions{}, 3600).String())
+
+	d = &Datasource{istioNativeHistograms: true, istioDurationMetric: "istio_request_duration_seconds"}
+	require.Equal(t, `histogram_fraction(0, 0.5, sum(rate(istio_request_duration_seconds{reporter="destination"}[60s])) by (destination_app))`, d.durationFraction("rate", prometheus.Matchers{prometheus.Equal("reporter", "destination")}, 500, 60, "destination_app").String())
+}
@@ -0,0 +1,226 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"gopkg.in/yaml.v3"
+)
+
+// maxValidateBodySize is the maximum size of a provisioning file, which can be
+// validated via the "validate" resource.
+const maxValidateBodySize = 1 << 20
+
+// provisioningFile is the format of a Grafana provisioning file for
+// datasources. A single datasource can also be validated, in this case the
+// "datasources" field is empty and the datasource fields are set.
+type provisioningFile struct {
+	APIVersion             any                      `yaml:"apiVersion"`
+	Datasources            []provisioningDatasource `yaml:"datasources"`
+	provisioningDatasource `yaml:",inline"`
+}
+
+type provisioningDatasource struct {
+	Name           string         `yaml:"name"`
+	Type           string         `yaml:"type"`
+	JSONData       map[string]any `yaml:"jsonData"`
+	SecureJSONData map[string]any `yaml:"secureJsonData"`
+}
+
+// validateResult is the response of the "validate" resource.
+type validateResult struct {
+	Valid       bool                       `json:"valid"`
+	Datasources []validateDatasourceResult `json:"datasources"`
+}
+
+type validateDatasourceResult struct {
+	Name   string   `json:"name"`
+	Errors []string `json:"errors"`
+}
+
+// handleValidateResource validates a provisioning file or a single datasource
+// in the YAML or JSON format, so that a configuration can be verified before it
+// is applied, e.g. in a GitOps pipeline. Datasources of other types in a
+// provisioning file are ignored. The response contains all errors per
+// datasource.
+func (d *Datasource) handleValidateResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxValidateBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// YAML is a superset of JSON, so that we can parse both formats with the
+	// YAML parser.
+	var file provisioningFile
+	if err := yaml.Unmarshal(body, &file); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse payload: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	result := validateProvisioningFile(file)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		d.logger.Error("Failed to write validation result", "error", err.Error())
+	}
+}
+
+// validateProvisioningFile validates all datasources of the plugin in the
+// given provisioning file.
+func validateProvisioningFile(file provisioningFile) validateResult {
+	datasources := file.Datasources
+	single := len(datasources) == 0
+	if single {
+		datasources = []provisioningDatasource{file.provisioningDatasource}
+	}
+
+	result := validateResult{Valid: true, Datasources: []validateDatasourceResult{}}
+
+	for _, datasource := range datasources {
+		if !single && datasource.Type != models.PluginID {
+			continue
+		}
+
+		var errors []string
+		if datasource.Type != models.PluginID {
+			errors = append(errors, fmt.Sprintf("type: must be %s", models.PluginID))
+		}
+
+		jsonData, err := json.Marshal(datasource.JSONData)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("jsonData: %s", err.Error()))
+		} else {
+			errors = append(errors, models.ValidatePluginSettings(jsonData, datasource.SecureJSONData)...)
+		}
+
+		if len(errors) > 0 {
+			result.Valid = false
+		} else {
+			errors = []string{}
+		}
+		result.Datasources = append(result.Datasources, validateDatasourceResult{Name: datasource.Name, Errors: errors})
+	}
+
+	if len(result.Datasources) == 0 {
+		result.Valid = false
+	}
+
+	return result
+}
+
+// canEdit returns true if the user of the request has the "Editor" or "Admin"
+// role in the organization, which is required to change the data stored by the
+// resources, e.g. the expected topologies and the filter presets.
+func canEdit(r *http.Request) bool {
+	user := backend.UserFromContext(r.Context())
+	return user != nil && (user.Role == "Editor" || user.Role == "Admin")
+}
+
+// writeJSON writes the given value as JSON response with the given status code.
+func (d *Datasource) writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		d.logger.Error("Failed to write response", "error", err.Error())
+	}
+}
+
+// handleHealthzResource returns the health of the plugin process, without
+// sending any requests to Prometheus, so that it can be used for the liveness
+// and readiness probes of the plugin, when it is run as standalone backend.
+// In contrast to the "CheckHealth" function it doesn't verify the
+// connection to Prometheus, because an unavailable Prometheus should not
+// restart the plugin. After the datasource was disposed, the status "disposed"
+// is returned with a 503 status code.
+func (d *Datasource) handleHealthzResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if d.disposed.Load() {
+		d.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "disposed"})
+		return
+	}
+	d.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// resourceGraphOptions returns the graph options and the time range for a
+// resource request. The graph is selected via the "namespace", "app",
+// "workload", "depth" and "revision" query parameters and the time range via
+// the "from" and "to" query parameters in milliseconds, like in Grafana. If no
+// namespace is set, the graph is mesh-wide, so that an application or workload
+// can only be selected together with a namespace. If the request is invalid,
+// the returned status code should be used for the response.
+func (d *Datasource) resourceGraphOptions(r *http.Request) (graphOptions, backend.TimeRange, int, error) {
+	params := r.URL.Query()
+
+	namespace := params.Get("namespace")
+	if namespace == "" && (params.Get("app") != "" || params.Get("workload") != "") {
+		return graphOptions{}, backend.TimeRange{}, http.StatusBadRequest, fmt.Errorf("namespace is required for an application or workload graph")
+	}
+	if namespace != "" && !d.isNamespaceAllowed(namespace) {
+		return graphOptions{}, backend.TimeRange{}, http.StatusForbidden, fmt.Errorf("namespace %q is not allowed for this organization", namespace)
+	}
+
+	timeRange, err := resourceTimeRange(params.Get("from"), params.Get("to"), time.Now())
+	if err != nil {
+		return graphOptions{}, backend.TimeRange{}, http.StatusBadRequest, err
+	}
+
+	var depth int
+	if params.Get("depth") != "" {
+		depth, err = strconv.Atoi(params.Get("depth"))
+		if err != nil {
+			return graphOptions{}, backend.TimeRange{}, http.StatusBadRequest, fmt.Errorf("invalid depth: %w", err)
+		}
+	}
+
+	return graphOptions{
+		namespace:   namespace,
+		application: params.Get("app"),
+		workload:    params.Get("workload"),
+		depth:       depth,
+		revision:    params.Get("revision"),
+	}, timeRange, http.StatusOK, nil
+}
+
+// resourceTimeRange returns the time range for the given "from" and "to" values
+// in milliseconds. If a value is empty, the last hour before now is used.
+func resourceTimeRange(from, to string, now time.Time) (backend.TimeRange, error) {
+	timeRange := backend.TimeRange{From: now.Add(-time.Hour), To: now}
+
+	if to != "" {
+		ms, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return backend.TimeRange{}, fmt.Errorf("invalid to: %w", err)
+		}
+		timeRange.To = time.UnixMilli(ms)
+		timeRange.From = timeRange.To.Add(-time.Hour)
+	}
+	if from != "" {
+		ms, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return backend.TimeRange{}, fmt.Errorf("invalid from: %w", err)
+		}
+		timeRange.From = time.UnixMilli(ms)
+	}
+
+	if !timeRange.From.Before(timeRange.To) {
+		return backend.TimeRange{}, fmt.Errorf("from must be before to")
+	}
+	return timeRange, nil
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleValidateResource(t *testing.T) {
+	d := &Datasource{logger: log.DefaultLogger}
+
+	validate := func(t *testing.T, method, body string) (int, validateResult) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		d.handleValidateResource(w, httptest.NewRequest(method, "/validate", strings.NewReader(body)))
+
+		var result validateResult
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		}
+		return w.Code, result
+	}
+
+	t.Run("provisioning file", func(t *testing.T) {
+		// The provisioning file of the development environment must always be
+		// valid.
+		body, err := os.ReadFile(filepath.Join("..", "..", "provisioning", "datasources", "datasources.yml"))
+		require.NoError(t, err)
+
+		code, result := validate(t, http.MethodPost, string(body))
+		require.Equal(t, http.StatusOK, code)
+		require.True(t, result.Valid, result)
+		require.Len(t, result.Datasources, 1)
+		require.Equal(t, "Istio", result.Datasources[0].Name)
+	})
+
+	t.Run("invalid datasource", func(t *testing.T) {
+		code, result := validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"localhost:9090","prometheusAuthMethod":"token","prometheusMaxWindow":"4 hours","istioNodeHealth":"best","unknownSetting":true},"secureJsonData":{"prometheusTokn":"secret"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.False(t, result.Valid)
+		require.Equal(t, []string{
+			"jsonData.unknownSetting: unknown setting",
+			"secureJsonData.prometheusTokn: unknown setting, supported: prometheusPassword, prometheusToken, alertmanagerPassword, alertmanagerToken",
+			"jsonData.prometheusUrl: must be an absolute http or https url",
+			"secureJsonData.prometheusToken: is required for token authentication",
+			`jsonData.prometheusMaxWindow: unknown unit " hours" in duration "4 hours"`,
+			"jsonData.istioNodeHealth: must be one of server, client, worst or weighted",
+		}, result.Datasources[0].Errors)
+	})
+
+	t.Run("token file", func(t *testing.T) {
+		code, result := validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","prometheusAuthMethod":"token","prometheusTokenFile":"/var/run/secrets/kubernetes.io/serviceaccount/token"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.True(t, result.Valid)
+
+		code, result = validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","prometheusAuthMethod":"token","prometheusTokenFile":"token"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"jsonData.prometheusTokenFile: must be an absolute path"}, result.Datasources[0].Errors)
+
+		code, result = validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","prometheusTokenFile":"/token"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"jsonData.prometheusTokenFile: can only be used with token authentication"}, result.Datasources[0].Errors)
+	})
+
+	t.Run("excluded destinations", func(t *testing.T) {
+		code, result := validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","istioExcludedDestinations":["*.istio-system"]}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.True(t, result.Valid)
+
+		code, result = validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","istioExcludedDestinations":[":15020"]}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{`jsonData.istioExcludedDestinations: destination ":15020" is ignored, because the destination_service label doesn't contain the port`}, result.Datasources[0].Errors)
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		code, result := validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"https://localhost:9090","prometheusClientCertFile":"/etc/prometheus/tls.crt","prometheusClientKeyFile":"/etc/prometheus/tls.key"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.True(t, result.Valid)
+
+		code, result = validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"https://localhost:9090","prometheusClientCertFile":"tls.crt"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{
+			"jsonData.prometheusClientCertFile: must be set together with jsonData.prometheusClientKeyFile",
+			"jsonData.prometheusClientCertFile: must be an absolute path",
+		}, result.Datasources[0].Errors)
+	})
+
+	t.Run("storage directory", func(t *testing.T) {
+		code, result := validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","storageDirectory":"/var/lib/grafana/istio"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.True(t, result.Valid)
+
+		code, result = validate(t, http.MethodPost, `{"name":"Istio","type":"ricoberger-istio-datasource","jsonData":{"prometheusUrl":"http://localhost:9090","storageDirectory":"istio"}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"jsonData.storageDirectory: must be an absolute path"}, result.Datasources[0].Errors)
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		code, result := validate(t, http.MethodPost, "name: Istio\ntype: prometheus\njsonData:\n  prometheusDemoMode: true\n")
+		require.Equal(t, http.StatusOK, code)
+		require.False(t, result.Valid)
+		require.Equal(t, []string{"type: must be ricoberger-istio-datasource"}, result.Datasources[0].Errors)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		code, _ := validate(t, http.MethodPost, "datasources: [")
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = validate(t, http.MethodGet, "")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+	})
+}
+
+func TestHandleHealthzResource(t *testing.T) {
+	d := &Datasource{background: &sync.WaitGroup{}, logger: log.DefaultLogger}
+
+	request := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		d.handleHealthzResource(w, httptest.NewRequest(method, "/healthz", nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPost).Code)
+
+	w := request(http.MethodGet)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+
+	d.Dispose()
+
+	w = request(http.MethodGet)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.JSONEq(t, `{"status":"disposed"}`, w.Body.String())
+}
+
+func TestResourceTimeRange(t *testing.T) {
+	now := time.UnixMilli(10_000_000)
+
+	timeRange, err := resourceTimeRange("", "", now)
+	require.NoError(t, err)
+	require.Equal(t, backend.TimeRange{From: now.Add(-time.Hour), To: now}, timeRange)
+
+	timeRange, err = resourceTimeRange("1000", "2000", now)
+	require.NoError(t, err)
+	require.Equal(t, backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)}, timeRange)
+
+	_, err = resourceTimeRange("abc", "", now)
+	require.ErrorContains(t, err, "invalid from")
+}
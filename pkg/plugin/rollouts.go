@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// defaultRolloutMinShare is the share of requests in percent, which a version
+// must receive to be considered as deployed, when no share is set in the query.
+// This avoids markers for single requests to an old version.
+const defaultRolloutMinShare = 5
+
+// rollout is a change of the deployed versions of an application, where new
+// versions appeared and / or old versions disappeared.
+type rollout struct {
+	time        time.Time
+	application string
+	added       []string
+	removed     []string
+}
+
+// handleRolloutsQueries handles the queries to detect the rollouts of the
+// applications in a namespace. It uses the concurrent package to handle
+// multiple queries in parallel.
+func (d *Datasource) handleRolloutsQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleRolloutsQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleRollouts, 10)
+}
+
+// handleRollouts returns the rollouts of the applications in a namespace as
+// annotations. A rollout is detected when the mix of the "destination_version"
+// label changes, so that Grafana can overlay the rollouts on related panels.
+func (d *Datasource) handleRollouts(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleRollouts")
+	defer span.End()
+
+	var qm models.QueryModelRollouts
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// The step for the range queries is based on the interval of the query.
+	// The rate window should be at least one minute, so that we always have
+	// enough samples to calculate the rate.
+	step := query.DataQuery.Interval
+	if step <= 0 {
+		step = time.Minute
+	}
+	window := int64(max(step, time.Minute).Seconds())
+
+	selector := prometheus.Matchers{prometheus.Equal("destination_workload_namespace", qm.Namespace)}
+	if qm.Application != "" {
+		selector = append(selector, prometheus.Equal("destination_app", qm.Application))
+	}
+	promQuery := sumQuery("istio_requests_total", "rate", selector, window, "destination_app", "destination_version")
+
+	d.logger.Debug("Get time series", "query", promQuery.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To, "step", step)
+	timeSeries, err := d.prometheusClient.GetTimeSeries(ctx, "rollouts", promQuery, query.DataQuery.TimeRange, step)
+	if err != nil {
+		d.logger.Error("Failed to get time series", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	minShare := qm.MinShare
+	if minShare <= 0 {
+		minShare = defaultRolloutMinShare
+	}
+
+	var times []time.Time
+	var titles, texts, tags []string
+
+	for _, r := range detectRollouts(timeSeries, minShare) {
+		var changes []string
+		if len(r.added) > 0 {
+			changes = append(changes, fmt.Sprintf("Added %s", strings.Join(r.added, ", ")))
+		}
+		if len(r.removed) > 0 {
+			changes = append(changes, fmt.Sprintf("Removed %s", strings.Join(r.removed, ", ")))
+		}
+
+		times = append(times, r.time)
+		titles = append(titles, fmt.Sprintf("Rollout of %s", r.application))
+		texts = append(texts, strings.Join(changes, ", "))
+		tags = append(tags, strings.Join(append([]string{"rollout", r.application}, r.added...), ","))
+	}
+
+	frame := data.NewFrame(
+		"rollouts",
+		data.NewField("time", nil, times),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// detectRollouts returns the rollouts for the given time series, which must
+// contain the request rate per "destination_app" and "destination_version".
+// For each application and timestamp the versions are determined, which receive
+// at least the given share of requests (in percent). A rollout is returned for
+// each timestamp where these versions differ from the previous timestamp with
+// traffic. Timestamps without traffic are skipped, so that an idle application
+// doesn't result in rollouts.
+func detectRollouts(timeSeries []prometheus.TimeSeries, minShare float64) []rollout {
+	rates := make(map[string]map[int64]map[string]float64)
+
+	for _, ts := range timeSeries {
+		application := ts.Labels["destination_app"]
+		version := ts.Labels["destination_version"]
+		if application == "" || version == "" || version == "unknown" {
+			continue
+		}
+
+		if _, ok := rates[application]; !ok {
+			rates[application] = make(map[int64]map[string]float64)
+		}
+		for i, value := range ts.Values {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			timestamp := ts.Timestamps[i].UnixMilli()
+			if _, ok := rates[application][timestamp]; !ok {
+				rates[application][timestamp] = make(map[string]float64)
+			}
+			rates[application][timestamp][version] += value
+		}
+	}
+
+	var rollouts []rollout
+
+	for _, application := range slices.Sorted(maps.Keys(rates)) {
+		var previous []string
+		hasPrevious := false
+
+		for _, timestamp := range slices.Sorted(maps.Keys(rates[application])) {
+			versionRates := rates[application][timestamp]
+
+			var total float64
+			for _, value := range versionRates {
+				total += value
+			}
+			if total <= 0 {
+				continue
+			}
+
+			var current []string
+			for _, version := range slices.Sorted(maps.Keys(versionRates)) {
+				if versionRates[version]/total*100 >= minShare {
+					current = append(current, version)
+				}
+			}
+
+			if hasPrevious {
+				var added, removed []string
+				for _, version := range current {
+					if !slices.Contains(previous, version) {
+						added = append(added, version)
+					}
+				}
+				for _, version := range previous {
+					if !slices.Contains(current, version) {
+						removed = append(removed, version)
+					}
+				}
+
+				if len(added) > 0 || len(removed) > 0 {
+					rollouts = append(rollouts, rollout{
+						time:        time.UnixMilli(timestamp),
+						application: application,
+						added:       added,
+						removed:     removed,
+					})
+				}
+			}
+
+			previous = current
+			hasPrevious = true
+		}
+	}
+
+	slices.SortStableFunc(rollouts, func(a, b rollout) int {
+		return a.time.Compare(b.time)
+	})
+
+	return rollouts
+}
@@ -0,0 +1,25 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectRollouts(t *testing.T) {
+	timestamps := []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0), time.Unix(180, 0)}
+
+	rollouts := detectRollouts([]prometheus.TimeSeries{
+		{Timestamps: timestamps, Values: []float64{10, 10, 1, 0}, Labels: map[string]string{"destination_app": "reviews", "destination_version": "v1"}},
+		{Timestamps: timestamps, Values: []float64{0, 0.1, 9, 10}, Labels: map[string]string{"destination_app": "reviews", "destination_version": "v2"}},
+		{Timestamps: timestamps, Values: []float64{5, 0, 0, 5}, Labels: map[string]string{"destination_app": "ratings", "destination_version": "v1"}},
+	}, defaultRolloutMinShare)
+
+	require.Equal(t, []rollout{
+		{time: time.Unix(120, 0), application: "reviews", added: []string{"v2"}},
+		{time: time.Unix(180, 0), application: "reviews", removed: []string{"v1"}},
+	}, rollouts)
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handleServiceGraphQueries handles the queries to get the graph for a service.
+// It uses the concurrent package to handle multiple queries in parallel.
+// Identical queries are only run once.
+func (d *Datasource) handleServiceGraphQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleServiceGraphQueries")
+	defer span.End()
+
+	return d.queryDataDeduplicated(ctx, req, d.handleServiceGraph)
+}
+
+// handleServiceGraph generates the graph for a service, which contains all
+// workloads sending requests to the service and all workloads backing the
+// service. The metrics are selected via the "destination_service_namespace"
+// and "destination_service_name" labels, so that the backing workloads can also
+// run in other namespaces.
+func (d *Datasource) handleServiceGraph(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleServiceGraph")
+	defer span.End()
+
+	var qm models.QueryModelServiceGraph
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	timeRange, err := graphTimeRange(query.DataQuery.TimeRange, qm.EvaluationTime, qm.Window)
+	if err != nil {
+		d.logger.Error("Failed to get time range", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	options := newGraphOptions(qm.GraphQueryOptions)
+	options.namespace = qm.Namespace
+	options.service = qm.Service
+
+	return d.handleGraph(ctx, options, timeRange)
+}
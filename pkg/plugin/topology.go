@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"gopkg.in/yaml.v3"
+)
+
+// maxTopologyBodySize is the maximum size of an expected topology, which can be
+// uploaded via the "topology" resource.
+const maxTopologyBodySize = 1 << 20
+
+// The status of an edge, when the graph is compared with an expected topology.
+const (
+	topologyStatusExpected   = "expected"
+	topologyStatusUnexpected = "unexpected"
+	topologyStatusMissing    = "missing"
+)
+
+// expectedTopology is the format of an expected topology, e.g. an export of a
+// service catalog. Each dependency is a call from a source workload to a
+// destination service, both in the "<namespace>/<name>" format.
+type expectedTopology struct {
+	Dependencies []expectedDependency `json:"dependencies" yaml:"dependencies"`
+}
+
+type expectedDependency struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+}
+
+// handleTopologyResource manages the expected topologies of the organization
+// of the request. The topology is selected via the "name" query parameter:
+// - GET returns the topology or the names of all topologies if no name is set.
+// - PUT and POST upload the topology in the YAML or JSON format.
+// - DELETE removes the topology.
+//
+// Only editors and admins can upload and remove topologies.
+func (d *Datasource) handleTopologyResource(w http.ResponseWriter, r *http.Request) {
+	orgID := backend.PluginConfigFromContext(r.Context()).OrgID
+	d = d.forOrg(orgID)
+	name := r.URL.Query().Get("name")
+
+	if r.Method != http.MethodGet && !canEdit(r) {
+		http.Error(w, "only editors and admins can change topologies", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if name == "" {
+			names, err := d.expectedTopologies.names(orgID)
+			if err != nil {
+				d.logger.Error("Failed to get expected topologies", "error", err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			d.writeJSON(w, http.StatusOK, map[string][]string{"names": names})
+			return
+		}
+
+		topology, ok, err := d.expectedTopologies.get(orgID, name)
+		if err != nil {
+			d.logger.Error("Failed to get expected topology", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("topology %q not found", name), http.StatusNotFound)
+			return
+		}
+		d.writeJSON(w, http.StatusOK, topology)
+
+	case http.MethodPut, http.MethodPost:
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxTopologyBodySize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		// YAML is a superset of JSON, so that we can parse both formats with
+		// the YAML parser.
+		var topology expectedTopology
+		if err := yaml.Unmarshal(body, &topology); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse topology: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if err := validateExpectedTopology(topology); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := d.expectedTopologies.set(orgID, name, topology); err != nil {
+			d.logger.Error("Failed to store expected topology", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.logger.Info("Expected topology uploaded", "name", name, "dependencies", len(topology.Dependencies))
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		ok, err := d.expectedTopologies.delete(orgID, name)
+		if err != nil {
+			d.logger.Error("Failed to delete expected topology", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("topology %q not found", name), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateExpectedTopology returns an error if a source or destination of a
+// dependency is not in the "<namespace>/<name>" format.
+func validateExpectedTopology(topology expectedTopology) error {
+	for i, dependency := range topology.Dependencies {
+		for _, value := range []string{dependency.Source, dependency.Destination} {
+			if namespace, name, ok := strings.Cut(value, "/"); !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+				return fmt.Errorf("dependencies[%d]: %q must be in the format <namespace>/<name>", i, value)
+			}
+		}
+	}
+	return nil
+}
+
+// compareTopology compares the edges of a graph with the given expected
+// topology and returns the status of the edges by their id. Edges from a
+// workload to a service (or to a workload, when service nodes are hidden)
+// are "expected" when they are part of the topology and "unexpected"
+// otherwise. Dependencies without traffic are added as "missing" edges, when
+// their source or destination is part of the graph or when their source is in
+// the namespace of a namespace graph.
+func compareTopology(edges map[string]models.Edge, nodes map[string]models.Node, topology expectedTopology, options graphOptions) map[string]string {
+	status := make(map[string]string)
+
+	expected := make(map[expectedDependency]bool, len(topology.Dependencies))
+	for _, dependency := range topology.Dependencies {
+		expected[dependency] = false
+	}
+
+	for id, edge := range edges {
+		dependency, ok := edgeDependency(edge)
+		if !ok {
+			continue
+		}
+
+		if _, ok := expected[dependency]; ok {
+			expected[dependency] = true
+			status[id] = topologyStatusExpected
+		} else {
+			status[id] = topologyStatusUnexpected
+		}
+	}
+
+	for dependency, observed := range expected {
+		if observed {
+			continue
+		}
+
+		sourceNamespace, sourceName, _ := strings.Cut(dependency.Source, "/")
+		destinationNamespace, destinationName, _ := strings.Cut(dependency.Destination, "/")
+
+		source, hasSource := findNode(nodes, "Workload", sourceNamespace, sourceName)
+		destination, hasDestination := findNode(nodes, "Service", destinationNamespace, destinationName)
+		isNamespaceGraph := options.application == "" && options.workload == "" && options.service == "" && sourceNamespace == options.namespace
+		if !hasSource && !hasDestination && !isNamespaceGraph {
+			continue
+		}
+
+		if !hasSource {
+			nodes[source] = models.Node{ID: source, Type: "Workload", Name: sourceName, Namespace: sourceNamespace}
+		}
+		if !hasDestination {
+			nodes[destination] = models.Node{ID: destination, Type: "Service", Name: destinationName, Namespace: destinationNamespace, Service: fmt.Sprintf("%s.%s.svc.cluster.local", destinationName, destinationNamespace)}
+		}
+
+		id := fmt.Sprintf("workload-%s-%s-service-%s-%s", sourceName, sourceNamespace, destinationName, destinationNamespace)
+		edges[id] = models.Edge{
+			ID:                   id,
+			Source:               source,
+			SourceType:           "Workload",
+			SourceName:           sourceName,
+			SourceNamespace:      sourceNamespace,
+			Destination:          destination,
+			DestinationType:      "Service",
+			DestinationName:      destinationName,
+			DestinationNamespace: destinationNamespace,
+			DestinationService:   fmt.Sprintf("%s.%s.svc.cluster.local", destinationName, destinationNamespace),
+		}
+		status[id] = topologyStatusMissing
+	}
+
+	return status
+}
+
+// edgeDependency returns the dependency for an edge from a workload to a
+// service. For edges to a workload (e.g. when service nodes are hidden), the
+// service is taken from the destination service of the edge. Edges from a
+// service to its workloads are not a dependency.
+func edgeDependency(edge models.Edge) (expectedDependency, bool) {
+	if edge.SourceType != "Workload" {
+		return expectedDependency{}, false
+	}
+
+	dependency := expectedDependency{Source: edge.SourceNamespace + "/" + edge.SourceName}
+
+	if edge.DestinationType == "Service" {
+		dependency.Destination = edge.DestinationNamespace + "/" + edge.DestinationName
+	} else if service, namespace, ok := strings.Cut(edge.DestinationService, "."); ok {
+		dependency.Destination, _, _ = strings.Cut(namespace, ".")
+		dependency.Destination += "/" + service
+	} else {
+		dependency.Destination = edge.DestinationNamespace + "/" + edge.DestinationName
+	}
+
+	return dependency, true
+}
+
+// findNode returns the id of the node with the given type, namespace and name.
+// If the graph contains no such node, the id for a node without a cluster is
+// returned. Nodes from multiple clusters can have the same name, in this case
+// the first found node is used.
+func findNode(nodes map[string]models.Node, nodeType, namespace, name string) (string, bool) {
+	id := nodeID(nodeType, namespace, name, "")
+	if _, ok := nodes[id]; ok {
+		return id, true
+	}
+
+	for _, node := range sortNodes(nodes, false) {
+		if node.Type == nodeType && node.Namespace == namespace && node.Name == name {
+			return node.ID, true
+		}
+	}
+	return id, false
+}
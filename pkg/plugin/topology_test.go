@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleTopologyResource(t *testing.T) {
+	topologies := newOrgStore[expectedTopology](t.TempDir(), "", "topologies")
+	d := &Datasource{expectedTopologies: topologies, logger: log.DefaultLogger, orgs: map[int64]*Datasource{2: {expectedTopologies: topologies, logger: log.DefaultLogger}}}
+
+	requestAs := func(role string, orgID int64, method, target, body string) *httptest.ResponseRecorder {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: orgID})
+		ctx = backend.WithUser(ctx, &backend.User{Login: "jane", Role: role})
+		w := httptest.NewRecorder()
+		d.handleTopologyResource(w, httptest.NewRequestWithContext(ctx, method, target, strings.NewReader(body)))
+		return w
+	}
+	request := func(orgID int64, method, target, body string) *httptest.ResponseRecorder {
+		return requestAs("Editor", orgID, method, target, body)
+	}
+
+	// Viewers can only read the stored data.
+	require.Equal(t, http.StatusForbidden, requestAs("Viewer", 1, http.MethodPut, "/topology?name=bookinfo", "dependencies: []").Code)
+	require.Equal(t, http.StatusForbidden, requestAs("Viewer", 1, http.MethodDelete, "/topology?name=bookinfo", "").Code)
+	require.Equal(t, http.StatusNotFound, requestAs("Viewer", 1, http.MethodGet, "/topology?name=bookinfo", "").Code)
+
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/topology", "dependencies: []").Code)
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/topology?name=bookinfo", "dependencies: [").Code)
+	require.Equal(t, http.StatusBadRequest, request(1, http.MethodPut, "/topology?name=bookinfo", `{"dependencies":[{"source":"productpage-v1","destination":"bookinfo/reviews"}]}`).Code)
+	require.Equal(t, http.StatusNoContent, request(1, http.MethodPut, "/topology?name=bookinfo", "dependencies:\n  - source: bookinfo/productpage-v1\n    destination: bookinfo/reviews\n").Code)
+
+	w := request(1, http.MethodGet, "/topology?name=bookinfo", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var topology expectedTopology
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&topology))
+	require.Equal(t, expectedTopology{Dependencies: []expectedDependency{{Source: "bookinfo/productpage-v1", Destination: "bookinfo/reviews"}}}, topology)
+
+	w = request(1, http.MethodGet, "/topology", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"names":["bookinfo"]}`, w.Body.String())
+
+	// The topologies are stored per organization, also for organizations
+	// without overrides.
+	require.Equal(t, http.StatusNotFound, request(2, http.MethodGet, "/topology?name=bookinfo", "").Code)
+	require.Equal(t, http.StatusNotFound, request(2, http.MethodDelete, "/topology?name=bookinfo", "").Code)
+	require.Equal(t, http.StatusNotFound, request(3, http.MethodGet, "/topology?name=bookinfo", "").Code)
+	require.JSONEq(t, `{"names":[]}`, request(3, http.MethodGet, "/topology", "").Body.String())
+
+	// The topologies are persisted, so that they are still available after the
+	// datasource was recreated.
+	restored, ok, err := newOrgStore[expectedTopology](topologies.directory, "", "topologies").get(1, "bookinfo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, topology, restored)
+
+	require.Equal(t, http.StatusNoContent, request(1, http.MethodDelete, "/topology?name=bookinfo", "").Code)
+	require.Equal(t, http.StatusNotFound, request(1, http.MethodGet, "/topology?name=bookinfo", "").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, request(1, http.MethodPatch, "/topology?name=bookinfo", "").Code)
+}
+
+func TestCompareTopology(t *testing.T) {
+	topology := expectedTopology{Dependencies: []expectedDependency{
+		{Source: "bookinfo/productpage-v1", Destination: "bookinfo/reviews"},
+		{Source: "bookinfo/productpage-v1", Destination: "bookinfo/details"},
+		{Source: "bookinfo/reviews-v1", Destination: "bookinfo/ratings"},
+		{Source: "shop/frontend", Destination: "shop/cart"},
+	}}
+
+	t.Run("workload graph", func(t *testing.T) {
+		edges := map[string]models.Edge{
+			"a": {ID: "a", Source: "workload/bookinfo/productpage-v1", SourceType: "Workload", SourceName: "productpage-v1", SourceNamespace: "bookinfo", Destination: "service/bookinfo/reviews", DestinationType: "Service", DestinationName: "reviews", DestinationNamespace: "bookinfo"},
+			"b": {ID: "b", Source: "service/bookinfo/reviews", SourceType: "Service", SourceName: "reviews", SourceNamespace: "bookinfo", Destination: "workload/bookinfo/reviews-v1", DestinationType: "Workload", DestinationName: "reviews-v1", DestinationNamespace: "bookinfo"},
+			"c": {ID: "c", Source: "workload/bookinfo/productpage-v1", SourceType: "Workload", SourceName: "productpage-v1", SourceNamespace: "bookinfo", Destination: "workload/bookinfo/mysql-v1", DestinationType: "Workload", DestinationName: "mysql-v1", DestinationNamespace: "bookinfo", DestinationService: "mysql.bookinfo.svc.cluster.local"},
+		}
+		nodes := map[string]models.Node{
+			"workload/bookinfo/productpage-v1": {ID: "workload/bookinfo/productpage-v1", Type: "Workload", Name: "productpage-v1", Namespace: "bookinfo"},
+			"service/bookinfo/reviews":         {ID: "service/bookinfo/reviews", Type: "Service", Name: "reviews", Namespace: "bookinfo"},
+			"workload/bookinfo/reviews-v1":     {ID: "workload/bookinfo/reviews-v1", Type: "Workload", Name: "reviews-v1", Namespace: "bookinfo"},
+			"workload/bookinfo/mysql-v1":       {ID: "workload/bookinfo/mysql-v1", Type: "Workload", Name: "mysql-v1", Namespace: "bookinfo"},
+		}
+
+		status := compareTopology(edges, nodes, topology, graphOptions{namespace: "bookinfo", workload: "productpage-v1"})
+
+		require.Equal(t, map[string]string{
+			"a": topologyStatusExpected,
+			"c": topologyStatusUnexpected,
+			"workload-productpage-v1-bookinfo-service-details-bookinfo": topologyStatusMissing,
+			"workload-reviews-v1-bookinfo-service-ratings-bookinfo":     topologyStatusMissing,
+		}, status)
+		require.Equal(t, "workload/bookinfo/productpage-v1", edges["workload-productpage-v1-bookinfo-service-details-bookinfo"].Source)
+		require.Equal(t, "service/bookinfo/details", edges["workload-productpage-v1-bookinfo-service-details-bookinfo"].Destination)
+		require.Contains(t, nodes, "service/bookinfo/details")
+		require.Contains(t, nodes, "service/bookinfo/ratings")
+		require.NotContains(t, nodes, "workload/shop/frontend")
+	})
+
+	t.Run("namespace graph", func(t *testing.T) {
+		edges := map[string]models.Edge{}
+		nodes := map[string]models.Node{}
+
+		status := compareTopology(edges, nodes, topology, graphOptions{namespace: "shop"})
+
+		require.Equal(t, map[string]string{"workload-frontend-shop-service-cart-shop": topologyStatusMissing}, status)
+		require.Len(t, nodes, 2)
+		require.Equal(t, "Workload", nodes["workload/shop/frontend"].Type)
+		require.Equal(t, "cart.shop.svc.cluster.local", nodes["service/shop/cart"].Service)
+	})
+}
@@ -0,0 +1,210 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// workloadTrafficRow is a single row of the workload traffic table, which
+// contains the traffic between the workload and one peer for one direction and
+// protocol.
+type workloadTrafficRow struct {
+	direction string
+	peer      string
+	protocol  string
+	requests  float64
+	errors    float64
+	duration  *float64
+	bytes     float64
+}
+
+// workloadTrafficQuery is a query of the workload traffic table. The namespace
+// and name labels are the labels, which identify the peer of the workload in
+// the returned metrics.
+type workloadTrafficQuery struct {
+	direction      string
+	metric         string
+	namespaceLabel string
+	nameLabel      string
+	query          prometheus.Expression
+}
+
+// handleWorkloadTrafficQueries handles the queries to get the inbound and
+// outbound traffic of a workload. It uses the concurrent package to handle
+// multiple queries in parallel.
+func (d *Datasource) handleWorkloadTrafficQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleWorkloadTrafficQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleWorkloadTraffic, 10)
+}
+
+// handleWorkloadTraffic returns a table with every peer of a workload, similar
+// to the traffic tab of Kiali. For each peer, direction and protocol the
+// request rate, the error rate, the P99 request duration and the TCP bytes are
+// returned. The inbound traffic is based on the metrics reported by the
+// workload as destination and the outbound traffic on the metrics reported by
+// the workload as source, so that each request is only counted once.
+func (d *Datasource) handleWorkloadTraffic(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleWorkloadTraffic")
+	defer span.End()
+
+	var qm models.QueryModelWorkloadTraffic
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	interval := int64(query.DataQuery.TimeRange.Duration().Seconds())
+
+	var queries []workloadTrafficQuery
+	for _, direction := range []struct {
+		name           string
+		selector       prometheus.Matchers
+		namespaceLabel string
+		nameLabel      string
+	}{
+		{name: "inbound", selector: prometheus.Matchers{prometheus.Equal("reporter", "destination"), prometheus.Equal("destination_workload_namespace", qm.Namespace), prometheus.Equal("destination_workload", qm.Workload)}, namespaceLabel: "source_workload_namespace", nameLabel: "source_workload"},
+		{name: "outbound", selector: prometheus.Matchers{prometheus.Equal("reporter", "source"), prometheus.Equal("source_workload_namespace", qm.Namespace), prometheus.Equal("source_workload", qm.Workload)}, namespaceLabel: "destination_service_namespace", nameLabel: "destination_service_name"},
+	} {
+		groupBy := []string{direction.namespaceLabel, direction.nameLabel, "request_protocol"}
+
+		queries = append(queries, workloadTrafficQuery{
+			direction:      direction.name,
+			metric:         "requests",
+			namespaceLabel: direction.namespaceLabel,
+			nameLabel:      direction.nameLabel,
+			query:          sumQuery("istio_requests_total", "increase", direction.selector, interval, append(slices.Clip(groupBy), "response_code", "grpc_response_status")...),
+		}, workloadTrafficQuery{
+			direction:      direction.name,
+			metric:         "duration",
+			namespaceLabel: direction.namespaceLabel,
+			nameLabel:      direction.nameLabel,
+			query:          d.durationQuantile(0.99, "increase", direction.selector, interval, groupBy...),
+		}, workloadTrafficQuery{
+			direction:      direction.name,
+			metric:         "bytes",
+			namespaceLabel: direction.namespaceLabel,
+			nameLabel:      direction.nameLabel,
+			query:          sumQuery("", "increase", append(prometheus.Matchers{prometheus.Regexp("__name__", "istio_tcp_sent_bytes_total|istio_tcp_received_bytes_total")}, direction.selector...), interval, direction.namespaceLabel, direction.nameLabel),
+		})
+	}
+
+	batch := make([]prometheus.MetricsQuery, 0, len(queries))
+	for _, q := range queries {
+		d.logger.Debug("Get metrics", "query", q.query.String(), "timeRangeFrom", query.DataQuery.TimeRange.From, "timeRangeTo", query.DataQuery.TimeRange.To)
+		batch = append(batch, prometheus.MetricsQuery{Metric: q.metric, Query: q.query, TimeRange: query.DataQuery.TimeRange})
+	}
+
+	results, err := d.prometheusClient.GetMetricsBatch(ctx, batch)
+	if err != nil {
+		d.logger.Error("Failed to get metrics", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	rows := getWorkloadTrafficRows(queries, results)
+
+	var directions, peers, protocols []string
+	var rps, errorRates, bytes []float64
+	var durations []*float64
+
+	for _, row := range rows {
+		directions = append(directions, row.direction)
+		peers = append(peers, row.peer)
+		protocols = append(protocols, row.protocol)
+		rps = append(rps, row.requests/float64(interval))
+		if row.requests > 0 {
+			errorRates = append(errorRates, row.errors/row.requests*100)
+		} else {
+			errorRates = append(errorRates, 0)
+		}
+		durations = append(durations, row.duration)
+		bytes = append(bytes, row.bytes)
+	}
+
+	frame := data.NewFrame(
+		"Workload Traffic",
+		data.NewField("direction", nil, directions).SetConfig(&data.FieldConfig{DisplayName: "Direction"}),
+		data.NewField("peer", nil, peers).SetConfig(&data.FieldConfig{DisplayName: "Peer"}),
+		data.NewField("protocol", nil, protocols).SetConfig(&data.FieldConfig{DisplayName: "Protocol"}),
+		data.NewField("rps", nil, rps).SetConfig(&data.FieldConfig{DisplayName: "Rate", Unit: "reqps"}),
+		data.NewField("err", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error", Unit: "percent"}),
+		data.NewField("p99", nil, durations).SetConfig(&data.FieldConfig{DisplayName: "P99", Unit: "ms"}),
+		data.NewField("bytes", nil, bytes).SetConfig(&data.FieldConfig{DisplayName: "Bytes", Unit: "decbytes"}),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// getWorkloadTrafficRows merges the results of the given queries into the rows
+// of the workload traffic table. The rows are identified by the direction, the
+// peer and the protocol, where the TCP bytes are always added to a row with the
+// "tcp" protocol. The rows are sorted by direction, peer and protocol.
+func getWorkloadTrafficRows(queries []workloadTrafficQuery, results [][]prometheus.Metric) []workloadTrafficRow {
+	rows := make(map[string]*workloadTrafficRow)
+
+	for i, q := range queries {
+		for _, m := range results[i] {
+			peer := fmt.Sprintf("%s/%s", m.Labels[q.namespaceLabel], m.Labels[q.nameLabel])
+			protocol := m.Labels["request_protocol"]
+			if q.metric == "bytes" {
+				protocol = "tcp"
+			}
+
+			key := strings.Join([]string{q.direction, peer, protocol}, "/")
+			if _, ok := rows[key]; !ok {
+				rows[key] = &workloadTrafficRow{direction: q.direction, peer: peer, protocol: protocol}
+			}
+
+			switch q.metric {
+			case "requests":
+				rows[key].requests += m.Value
+				if protocol == "grpc" && isGRPCError(m.Labels["grpc_response_status"]) {
+					rows[key].errors += m.Value
+				} else if protocol != "grpc" && isHTTPError(m.Labels["response_code"]) {
+					rows[key].errors += m.Value
+				}
+			case "duration":
+				if isValidDuration(m.Value) {
+					duration := m.Value
+					rows[key].duration = &duration
+				}
+			case "bytes":
+				rows[key].bytes += m.Value
+			}
+		}
+	}
+
+	var result []workloadTrafficRow
+	for _, key := range slices.Sorted(maps.Keys(rows)) {
+		result = append(result, *rows[key])
+	}
+
+	return result
+}
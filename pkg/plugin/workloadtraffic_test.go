@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetWorkloadTrafficRows(t *testing.T) {
+	queries := []workloadTrafficQuery{
+		{direction: "inbound", metric: "requests", namespaceLabel: "source_workload_namespace", nameLabel: "source_workload"},
+		{direction: "outbound", metric: "duration", namespaceLabel: "destination_service_namespace", nameLabel: "destination_service_name"},
+		{direction: "outbound", metric: "bytes", namespaceLabel: "destination_service_namespace", nameLabel: "destination_service_name"},
+	}
+
+	rows := getWorkloadTrafficRows(queries, [][]prometheus.Metric{
+		{
+			{Value: 90, Labels: map[string]string{"source_workload_namespace": "bookinfo", "source_workload": "productpage-v1", "request_protocol": "http", "response_code": "200"}},
+			{Value: 10, Labels: map[string]string{"source_workload_namespace": "bookinfo", "source_workload": "productpage-v1", "request_protocol": "http", "response_code": "500"}},
+		},
+		{
+			{Value: 25, Labels: map[string]string{"destination_service_namespace": "bookinfo", "destination_service_name": "ratings", "request_protocol": "grpc"}},
+			{Value: math.NaN(), Labels: map[string]string{"destination_service_namespace": "bookinfo", "destination_service_name": "details", "request_protocol": "http"}},
+		},
+		{
+			{Value: 1024, Labels: map[string]string{"destination_service_namespace": "bookinfo", "destination_service_name": "mysqldb"}},
+		},
+	})
+
+	duration := 25.0
+	require.Equal(t, []workloadTrafficRow{
+		{direction: "inbound", peer: "bookinfo/productpage-v1", protocol: "http", requests: 100, errors: 10},
+		{direction: "outbound", peer: "bookinfo/details", protocol: "http"},
+		{direction: "outbound", peer: "bookinfo/mysqldb", protocol: "tcp", bytes: 1024},
+		{direction: "outbound", peer: "bookinfo/ratings", protocol: "grpc", duration: &duration},
+	}, rows)
+}
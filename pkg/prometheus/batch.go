@@ -0,0 +1,249 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultBatchConcurrency is the number of queries of all batches of a client,
+// which are executed concurrently, when no concurrency is configured.
+const DefaultBatchConcurrency = 10
+
+const (
+	// batchRetries is the number of times a query of a batch is retried,
+	// when it failed with a transient error.
+	batchRetries = 2
+	// batchRetryBackoff is the duration to wait before the first retry. The
+	// duration is doubled for each further retry.
+	batchRetryBackoff = 100 * time.Millisecond
+)
+
+// MetricsQuery is a single query of a batch, which is executed via
+// GetMetricsBatch. The time ranges of the queries can differ, so that a batch
+// can contain multiple metrics as well as multiple windows of one metric.
+type MetricsQuery struct {
+	Metric    string
+	Query     Expression
+	TimeRange backend.TimeRange
+}
+
+// TimeSeriesQuery is a single query of a batch, which is executed via
+// GetTimeSeriesBatch. Like for the MetricsQuery the time ranges and steps of
+// the queries can differ.
+type TimeSeriesQuery struct {
+	Metric    string
+	Query     Expression
+	TimeRange backend.TimeRange
+	Step      time.Duration
+}
+
+// GetMetricsFunc is the signature of the GetMetrics method of a client, which
+// is used by the Batcher to execute a single query.
+type GetMetricsFunc func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error)
+
+// GetLabelValuesFunc is the signature of the GetLabelValues method of a client,
+// which is used by the Batcher to execute a single query.
+type GetLabelValuesFunc func(ctx context.Context, query LabelValuesQuery, timeRange backend.TimeRange) ([]string, error)
+
+// GetTimeSeriesFunc is the signature of the GetTimeSeries method of a client,
+// which is used by the Batcher to execute a single query.
+type GetTimeSeriesFunc func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange, step time.Duration) ([]TimeSeries, error)
+
+// Batcher executes batches of queries with a shared limit for the number of
+// concurrent queries. The Batcher is shared by all batches of a client, so that
+// the backpressure is the same for all queries, regardless of how many queries
+// are part of a single batch.
+type Batcher struct {
+	semaphore chan struct{}
+	backoff   time.Duration
+}
+
+// NewBatcher returns a new Batcher, which executes up to the given number of
+// queries concurrently. If the concurrency is not set, the
+// DefaultBatchConcurrency is used.
+func NewBatcher(concurrency int) *Batcher {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	return &Batcher{
+		semaphore: make(chan struct{}, concurrency),
+		backoff:   batchRetryBackoff,
+	}
+}
+
+// GetMetrics executes the given queries via the given function and returns the
+// metrics in the order of the queries. Queries which fail with a transient
+// error are retried. If a query fails, the remaining queries are canceled and
+// the error of the first failed query is returned.
+func (b *Batcher) GetMetrics(ctx context.Context, getMetrics GetMetricsFunc, queries []MetricsQuery) ([][]Metric, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetMetricsBatch", trace.WithAttributes(attribute.Int("batch.queries", len(queries))))
+	defer span.End()
+
+	results, err := runBatch(ctx, b, queries, func(ctx context.Context, query MetricsQuery) ([]Metric, error) {
+		return getMetrics(ctx, query.Metric, query.Query, query.TimeRange)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetTimeSeries executes the given queries via the given function and returns
+// the time series in the order of the queries. The queries share the
+// concurrency limit and the retries with the queries of GetMetrics.
+func (b *Batcher) GetTimeSeries(ctx context.Context, getTimeSeries GetTimeSeriesFunc, queries []TimeSeriesQuery) ([][]TimeSeries, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetTimeSeriesBatch", trace.WithAttributes(attribute.Int("batch.queries", len(queries))))
+	defer span.End()
+
+	results, err := runBatch(ctx, b, queries, func(ctx context.Context, query TimeSeriesQuery) ([]TimeSeries, error) {
+		return getTimeSeries(ctx, query.Metric, query.Query, query.TimeRange, query.Step)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// batchQuery is the constraint for the queries of a batch. The name of a query
+// is the metric or the label of the query, which is only used for logging.
+type batchQuery interface {
+	MetricsQuery | TimeSeriesQuery | LabelValuesQuery
+	name() string
+}
+
+func (q MetricsQuery) name() string     { return q.Metric }
+func (q TimeSeriesQuery) name() string  { return q.Metric }
+func (q LabelValuesQuery) name() string { return q.Label }
+
+// GetLabelValues executes the given queries via the given function and returns
+// the label values in the order of the queries. The queries share the
+// concurrency limit and the retries with the queries of GetMetrics.
+func (b *Batcher) GetLabelValues(ctx context.Context, getLabelValues GetLabelValuesFunc, queries []LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetLabelValuesBatch", trace.WithAttributes(attribute.Int("batch.queries", len(queries))))
+	defer span.End()
+
+	results, err := runBatch(ctx, b, queries, func(ctx context.Context, query LabelValuesQuery) ([]string, error) {
+		return getLabelValues(ctx, query, timeRange)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// runBatch executes the given queries concurrently via the given function and
+// returns the results in the order of the queries. If a query fails, the
+// remaining queries are canceled and the error of the first failed query is
+// returned.
+func runBatch[Q batchQuery, R any](ctx context.Context, b *Batcher, queries []Q, run func(ctx context.Context, query Q) (R, error)) ([]R, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]R, len(queries))
+
+	var firstErr error
+	var firstErrMutex sync.Mutex
+
+	var queriesWG sync.WaitGroup
+	for i, query := range queries {
+		queriesWG.Go(func() {
+			result, err := execute(ctx, b, query, run)
+			if err != nil {
+				firstErrMutex.Lock()
+				defer firstErrMutex.Unlock()
+
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			results[i] = result
+		})
+	}
+	queriesWG.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return results, nil
+}
+
+// execute executes a single query of a batch, when a slot of the semaphore is
+// available. The slot is released while waiting for a retry, so that other
+// queries are not blocked by the backoff.
+func execute[Q batchQuery, R any](ctx context.Context, b *Batcher, query Q, run func(ctx context.Context, query Q) (R, error)) (R, error) {
+	backoff := b.backoff
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case b.semaphore <- struct{}{}:
+		case <-ctx.Done():
+			var zero R
+			return zero, ctx.Err()
+		}
+
+		result, err := run(ctx, query)
+		<-b.semaphore
+
+		if err == nil || attempt >= batchRetries || !isTransientError(err) {
+			return result, err
+		}
+
+		backend.Logger.Debug("Retry query of batch", "query", query.name(), "attempt", attempt+1, "error", err.Error())
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			var zero R
+			return zero, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientError returns true if the given error is likely to go away when
+// the query is retried, e.g. a server error of a proxy in front of Prometheus
+// or a reset connection. Errors of the query itself, timeouts and rejected
+// credentials are not retried, because a retry would fail in the same way.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var unauthorizedErr *UnauthorizedError
+	if errors.As(err, &unauthorizedErr) {
+		return false
+	}
+
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Type == v1.ErrServer
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
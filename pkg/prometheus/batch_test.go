@@ -0,0 +1,110 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatcher(t *testing.T) {
+	queries := []MetricsQuery{
+		{Metric: "a", Query: Selector{Metric: "a"}},
+		{Metric: "b", Query: Selector{Metric: "b"}},
+		{Metric: "c", Query: Selector{Metric: "c"}},
+	}
+
+	t.Run("should return results in order of queries", func(t *testing.T) {
+		batcher := NewBatcher(2)
+
+		var running, maxRunning atomic.Int32
+		results, err := batcher.GetMetrics(context.Background(), func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error) {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				previous := maxRunning.Load()
+				if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			return []Metric{{Labels: map[string]string{"metric": metric}}}, nil
+		}, queries)
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		for i, query := range queries {
+			require.Equal(t, query.Metric, results[i][0].Labels["metric"])
+		}
+		require.LessOrEqual(t, maxRunning.Load(), int32(2))
+	})
+
+	t.Run("should retry transient errors", func(t *testing.T) {
+		batcher := NewBatcher(0)
+		batcher.backoff = time.Millisecond
+
+		var calls atomic.Int32
+		results, err := batcher.GetMetrics(context.Background(), func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error) {
+			if calls.Add(1) < 3 {
+				return nil, &v1.Error{Type: v1.ErrServer, Msg: "502 Bad Gateway"}
+			}
+			return []Metric{{Value: 1}}, nil
+		}, queries[:1])
+		require.NoError(t, err)
+		require.Equal(t, [][]Metric{{{Value: 1}}}, results)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("should not retry query errors", func(t *testing.T) {
+		batcher := NewBatcher(0)
+		batcher.backoff = time.Millisecond
+
+		var calls atomic.Int32
+		_, err := batcher.GetMetrics(context.Background(), func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error) {
+			calls.Add(1)
+			return nil, &v1.Error{Type: v1.ErrBadData, Msg: "parse error"}
+		}, queries[:1])
+		require.Error(t, err)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("should cancel remaining queries on error", func(t *testing.T) {
+		batcher := NewBatcher(0)
+
+		errQuery := errors.New("query failed")
+		_, err := batcher.GetMetrics(context.Background(), func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error) {
+			if metric == "b" {
+				return nil, errQuery
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}, queries)
+		require.ErrorIs(t, err, errQuery)
+	})
+
+	t.Run("should share the concurrency limit with time series queries", func(t *testing.T) {
+		batcher := NewBatcher(1)
+		batcher.semaphore <- struct{}{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := batcher.GetTimeSeries(ctx, func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange, step time.Duration) ([]TimeSeries, error) {
+			return nil, nil
+		}, []TimeSeriesQuery{{Metric: "a", Query: Selector{Metric: "a"}, Step: time.Minute}})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		<-batcher.semaphore
+		results, err := batcher.GetTimeSeries(context.Background(), func(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange, step time.Duration) ([]TimeSeries, error) {
+			return []TimeSeries{{Labels: map[string]string{"metric": metric}}}, nil
+		}, []TimeSeriesQuery{{Metric: "a", Query: Selector{Metric: "a"}, Step: time.Minute}, {Metric: "b", Query: Selector{Metric: "b"}, Step: time.Minute}})
+		require.NoError(t, err)
+		require.Equal(t, "a", results[0][0].Labels["metric"])
+		require.Equal(t, "b", results[1][0].Labels["metric"])
+	})
+}
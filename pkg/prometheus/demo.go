@@ -0,0 +1,644 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// demoWorkload is a workload of the synthetic topology, which is used in the
+// demo mode. If the service is empty, the workload can only be a source.
+type demoWorkload struct {
+	namespace string
+	name      string
+	app       string
+	version   string
+	service   string
+	locality  string
+}
+
+// demoEdge is an edge of the synthetic topology. For gRPC and HTTP edges the
+// rate is the number of requests per second, for TCP edges it is the number of
+// opened connections per second.
+type demoEdge struct {
+	source      demoWorkload
+	destination demoWorkload
+	protocol    string
+	rate        float64
+	errorRate   float64
+	latency     float64
+	bytes       float64
+	external    bool
+	plaintext   bool
+}
+
+// demoSeries is a single series of the synthetic metrics. The value of a
+// series is the per-second rate of the counter.
+type demoSeries struct {
+	name   string
+	labels map[string]string
+	rate   float64
+	phase  float64
+}
+
+// demoBuckets are the upper bounds of the request duration buckets, which are
+// a subset of the default buckets of Istio.
+var demoBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, math.Inf(1)}
+
+var (
+	demoSelectorRegexp = regexp.MustCompile(`((?:istio|kube)_[a-z_]+)(?:\{([^}]*)\})?|\{([^}]*)\}`)
+	demoMatcherRegexp  = regexp.MustCompile(`([a-z_]+)\s*(=~|!~|!=|=)\s*"((?:[^"\\]|\\.)*)"`)
+	demoWindowRegexp   = regexp.MustCompile(`\[(\d+)s\]`)
+	demoGroupByRegexp  = regexp.MustCompile(`by \(([^)]*)\)`)
+	demoQuantileRegexp = regexp.MustCompile(`histogram_quantile\(([0-9.]+),`)
+)
+
+// demoClient implements the Client interface with synthetic data for a
+// realistic multi-namespace topology, so that the plugin can be demoed and the
+// frontend can be developed without a Prometheus instance and an Istio cluster.
+//
+// The client doesn't implement PromQL. Instead it supports the subset of
+// PromQL, which is used by the plugin: sums of "rate" and "increase" grouped by
+// labels, "histogram_quantile", "timestamp", the division of two sums and the
+// "> 0" filter. The results are only good enough for a demo, they must not be
+// used to verify the queries of the plugin.
+type demoClient struct {
+	series  []demoSeries
+	batcher *Batcher
+}
+
+func newDemoClient() Client {
+	ingressgateway := demoWorkload{namespace: "istio-system", name: "istio-ingressgateway", app: "istio-ingressgateway", locality: "eu-central-1/eu-central-1a"}
+	productpage := demoWorkload{namespace: "bookinfo", name: "productpage-v1", app: "productpage", version: "v1", service: "productpage", locality: "eu-central-1/eu-central-1a"}
+	details := demoWorkload{namespace: "bookinfo", name: "details-v1", app: "details", version: "v1", service: "details", locality: "eu-central-1/eu-central-1a"}
+	reviewsV1 := demoWorkload{namespace: "bookinfo", name: "reviews-v1", app: "reviews", version: "v1", service: "reviews", locality: "eu-central-1/eu-central-1a"}
+	reviewsV2 := demoWorkload{namespace: "bookinfo", name: "reviews-v2", app: "reviews", version: "v2", service: "reviews", locality: "eu-central-1/eu-central-1b"}
+	reviewsV3 := demoWorkload{namespace: "bookinfo", name: "reviews-v3", app: "reviews", version: "v3", service: "reviews", locality: "eu-central-1/eu-central-1b"}
+	ratings := demoWorkload{namespace: "bookinfo", name: "ratings-v1", app: "ratings", version: "v1", service: "ratings", locality: "eu-central-1/eu-central-1a"}
+	frontend := demoWorkload{namespace: "shop", name: "frontend", app: "frontend", version: "v1", service: "frontend", locality: "eu-central-1/eu-central-1a"}
+	catalog := demoWorkload{namespace: "shop", name: "catalog", app: "catalog", version: "v1", service: "catalog", locality: "eu-central-1/eu-central-1a"}
+	cart := demoWorkload{namespace: "shop", name: "cart", app: "cart", version: "v1", service: "cart", locality: "eu-central-1/eu-central-1b"}
+	checkout := demoWorkload{namespace: "shop", name: "checkout", app: "checkout", version: "v1", service: "checkout", locality: "eu-central-1/eu-central-1b"}
+	payment := demoWorkload{namespace: "shop", name: "payment", app: "payment", version: "v1", service: "payment", locality: "eu-central-1/eu-central-1a"}
+	redis := demoWorkload{namespace: "data", name: "redis", app: "redis", version: "v1", service: "redis", locality: "eu-central-1/eu-central-1c"}
+	postgres := demoWorkload{namespace: "data", name: "postgres", app: "postgres", version: "v1", service: "postgres", locality: "eu-central-1/eu-central-1a"}
+	billing := demoWorkload{namespace: "legacy", name: "billing", app: "billing", version: "v1", service: "billing", locality: "eu-central-1/eu-central-1c"}
+	stripe := demoWorkload{namespace: "unknown", name: "unknown", app: "unknown", version: "unknown", service: "api.stripe.com"}
+
+	edges := []demoEdge{
+		{source: ingressgateway, destination: productpage, protocol: "http", rate: 40, errorRate: 0.005, latency: 120},
+		{source: ingressgateway, destination: frontend, protocol: "http", rate: 80, errorRate: 0.002, latency: 90},
+		{source: productpage, destination: details, protocol: "http", rate: 40, latency: 20},
+		{source: productpage, destination: reviewsV1, protocol: "http", rate: 14, latency: 40},
+		{source: productpage, destination: reviewsV2, protocol: "http", rate: 13, latency: 60},
+		{source: productpage, destination: reviewsV3, protocol: "http", rate: 13, errorRate: 0.04, latency: 350},
+		{source: reviewsV2, destination: ratings, protocol: "http", rate: 13, latency: 15},
+		{source: reviewsV3, destination: ratings, protocol: "http", rate: 13, errorRate: 0.001, latency: 15},
+		{source: frontend, destination: catalog, protocol: "grpc", rate: 60, latency: 30},
+		{source: frontend, destination: cart, protocol: "grpc", rate: 30, errorRate: 0.001, latency: 25},
+		{source: frontend, destination: checkout, protocol: "grpc", rate: 5, errorRate: 0.01, latency: 400},
+		{source: checkout, destination: payment, protocol: "grpc", rate: 5, errorRate: 0.02, latency: 300, plaintext: true},
+		{source: checkout, destination: billing, protocol: "http", rate: 5, latency: 80, plaintext: true},
+		{source: catalog, destination: ratings, protocol: "http", rate: 10, latency: 15},
+		{source: cart, destination: redis, protocol: "tcp", rate: 2, bytes: 50000},
+		{source: catalog, destination: postgres, protocol: "tcp", rate: 1, bytes: 200000},
+		{source: payment, destination: stripe, protocol: "http", rate: 5, errorRate: 0.005, latency: 250, external: true},
+	}
+
+	c := &demoClient{batcher: NewBatcher(0)}
+	for i, edge := range edges {
+		c.addEdge(edge, float64(i))
+	}
+
+	// Add the Kubernetes metrics for all services and workloads, including an
+	// idle workload and service per namespace, so that the "idle nodes" option
+	// can be used.
+	for _, w := range []demoWorkload{ingressgateway, productpage, details, reviewsV1, reviewsV2, reviewsV3, ratings, frontend, catalog, cart, checkout, payment, redis, postgres, billing} {
+		c.series = append(c.series, demoSeries{name: "kube_deployment_created", labels: map[string]string{"namespace": w.namespace, "deployment": w.name}})
+		if w.service != "" {
+			c.series = append(c.series, demoSeries{name: "kube_service_info", labels: map[string]string{"namespace": w.namespace, "service": w.service}})
+		}
+	}
+	for _, namespace := range []string{"bookinfo", "shop", "data", "legacy"} {
+		c.series = append(c.series, demoSeries{name: "kube_deployment_created", labels: map[string]string{"namespace": namespace, "deployment": "legacy"}})
+		c.series = append(c.series, demoSeries{name: "kube_service_info", labels: map[string]string{"namespace": namespace, "service": "legacy"}})
+	}
+
+	return c
+}
+
+// addEdge adds all series for the given edge. Like in Istio, requests of mesh
+// internal edges are reported by the source and the destination, requests to
+// external services are only reported by the source.
+func (c *demoClient) addEdge(edge demoEdge, phase float64) {
+	c.addEdgeSeries(edge, "source", phase)
+	if !edge.external {
+		c.addEdgeSeries(edge, "destination", phase)
+	}
+}
+
+// addEdgeSeries adds the series for the given edge and reporter.
+func (c *demoClient) addEdgeSeries(edge demoEdge, reporter string, phase float64) {
+	securityPolicy := "mutual_tls"
+	if edge.plaintext || edge.external {
+		securityPolicy = "none"
+	}
+
+	destinationService := edge.destination.service
+	destinationServiceNamespace := edge.destination.namespace
+	if !edge.external {
+		destinationService = edge.destination.service + "." + edge.destination.namespace + ".svc.cluster.local"
+	}
+
+	labels := func(extra map[string]string) map[string]string {
+		l := map[string]string{
+			"reporter":                       reporter,
+			"source_workload_namespace":      edge.source.namespace,
+			"source_workload":                edge.source.name,
+			"source_app":                     edge.source.app,
+			"source_version":                 edge.source.version,
+			"source_locality":                edge.source.locality,
+			"destination_service":            destinationService,
+			"destination_service_namespace":  destinationServiceNamespace,
+			"destination_service_name":       edge.destination.service,
+			"destination_workload_namespace": edge.destination.namespace,
+			"destination_workload":           edge.destination.name,
+			"destination_app":                edge.destination.app,
+			"destination_version":            edge.destination.version,
+			"destination_locality":           edge.destination.locality,
+			"connection_security_policy":     securityPolicy,
+		}
+		for key, value := range extra {
+			l[key] = value
+		}
+		return l
+	}
+
+	if edge.protocol == "tcp" {
+		c.series = append(c.series,
+			demoSeries{name: "istio_tcp_connections_opened_total", labels: labels(nil), rate: edge.rate, phase: phase},
+			demoSeries{name: "istio_tcp_sent_bytes_total", labels: labels(nil), rate: edge.bytes * 0.3, phase: phase},
+			demoSeries{name: "istio_tcp_received_bytes_total", labels: labels(nil), rate: edge.bytes * 0.7, phase: phase},
+		)
+		return
+	}
+
+	successLabels := map[string]string{"request_protocol": edge.protocol, "response_code": "200", "response_flags": "-"}
+	errorLabels := map[string]string{"request_protocol": edge.protocol, "response_code": "503", "response_flags": "UH"}
+	if edge.protocol == "grpc" {
+		successLabels["grpc_response_status"] = "0"
+		errorLabels["response_code"] = "200"
+		errorLabels["response_flags"] = "-"
+		errorLabels["grpc_response_status"] = "14"
+
+		c.series = append(c.series,
+			demoSeries{name: "istio_request_messages_total", labels: labels(map[string]string{"request_protocol": edge.protocol}), rate: edge.rate, phase: phase},
+			demoSeries{name: "istio_response_messages_total", labels: labels(map[string]string{"request_protocol": edge.protocol}), rate: edge.rate * 2, phase: phase},
+		)
+	}
+
+	c.series = append(c.series, demoSeries{name: "istio_requests_total", labels: labels(successLabels), rate: edge.rate * (1 - edge.errorRate), phase: phase})
+	if edge.errorRate > 0 {
+		c.series = append(c.series, demoSeries{name: "istio_requests_total", labels: labels(errorLabels), rate: edge.rate * edge.errorRate, phase: phase})
+	}
+
+	// The request durations are exponentially distributed, where the rate
+	// parameter is chosen so that the P99 is the configured latency of the
+	// edge.
+	lambda := math.Log(100) / edge.latency
+	for _, le := range demoBuckets {
+		share := 1.0
+		if !math.IsInf(le, 1) {
+			share = 1 - math.Exp(-lambda*le)
+		}
+
+		c.series = append(c.series, demoSeries{
+			name:   "istio_request_duration_milliseconds_bucket",
+			labels: labels(map[string]string{"request_protocol": edge.protocol, "le": formatBucket(le)}),
+			rate:   edge.rate * share,
+			phase:  phase,
+		})
+	}
+
+	// The count of the histogram is the same as the "+Inf" bucket.
+	c.series = append(c.series, demoSeries{name: "istio_request_duration_milliseconds_count", labels: labels(map[string]string{"request_protocol": edge.protocol}), rate: edge.rate, phase: phase})
+}
+
+func (c *demoClient) Close() {}
+
+func (c *demoClient) CheckHealth(ctx context.Context) error {
+	return nil
+}
+
+func (c *demoClient) GetRetention(ctx context.Context) (time.Duration, error) {
+	return 0, nil
+}
+
+func (c *demoClient) GetLabelValues(ctx context.Context, query LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	selectors := parseDemoSelectors(strings.Join(query.Matches(), " "))
+
+	var values []string
+	for _, s := range c.series {
+		value := s.labels[query.Label]
+		if query.Label == "__name__" {
+			value = s.name
+		}
+		if value != "" && (len(selectors) == 0 || matchesDemoSelectors(s, selectors)) {
+			values = append(values, value)
+		}
+	}
+
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+func (c *demoClient) GetLabelValuesBatch(ctx context.Context, queries []LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	return c.batcher.GetLabelValues(ctx, c.GetLabelValues, queries, timeRange)
+}
+
+func (c *demoClient) GetMetrics(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error) {
+	groups := c.evaluate(query.String(), timeRange.To)
+
+	var metrics []Metric
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[key]
+		labels := make(map[string]string, len(g.labels)+1)
+		for k, v := range g.labels {
+			labels[k] = v
+		}
+		labels["metric"] = metric
+
+		metrics = append(metrics, Metric{Value: g.value, Labels: labels, Timestamp: timeRange.To})
+	}
+
+	return metrics, nil
+}
+
+func (c *demoClient) GetMetricsBatch(ctx context.Context, queries []MetricsQuery) ([][]Metric, error) {
+	return c.batcher.GetMetrics(ctx, c.GetMetrics, queries)
+}
+
+func (c *demoClient) GetTimeSeries(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange, step time.Duration) ([]TimeSeries, error) {
+	// Like Prometheus we reject a step of zero, because the evaluation of the
+	// range would never end otherwise.
+	if step <= 0 {
+		return nil, errDemoStep
+	}
+
+	series := make(map[string]*TimeSeries)
+
+	for ts := timeRange.From; !ts.After(timeRange.To); ts = ts.Add(step) {
+		for key, g := range c.evaluate(query.String(), ts) {
+			s, ok := series[key]
+			if !ok {
+				labels := make(map[string]string, len(g.labels)+1)
+				for k, v := range g.labels {
+					labels[k] = v
+				}
+				labels["metric"] = metric
+
+				s = &TimeSeries{Labels: labels}
+				series[key] = s
+			}
+
+			s.Timestamps = append(s.Timestamps, ts)
+			s.Values = append(s.Values, g.value)
+		}
+	}
+
+	var timeSeries []TimeSeries
+	for _, key := range slices.Sorted(maps.Keys(series)) {
+		timeSeries = append(timeSeries, *series[key])
+	}
+
+	return timeSeries, nil
+}
+
+// errDemoStep is returned for range queries with a step of zero or less. The
+// message is the same as the one of the Prometheus API.
+var errDemoStep = errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer")
+
+func (c *demoClient) GetTimeSeriesBatch(ctx context.Context, queries []TimeSeriesQuery) ([][]TimeSeries, error) {
+	return c.batcher.GetTimeSeries(ctx, c.GetTimeSeries, queries)
+}
+
+// demoGroup is a single sample of the result of a query, which is identified
+// by the values of the grouping labels.
+type demoGroup struct {
+	labels map[string]string
+	value  float64
+}
+
+// evaluate evaluates the given query at the given time. If the query is the
+// division of two sums, both sides are evaluated on their own and the values
+// of the groups, which are present on both sides, are divided. Like the one to
+// one vector matching of Prometheus, groups which are only present on one side
+// are dropped. The numerator can fill up the missing groups with zeros via
+// "(<sum> or <sum> * 0)".
+func (c *demoClient) evaluate(query string, ts time.Time) map[string]demoGroup {
+	query = strings.TrimSpace(query)
+
+	nonZero := strings.HasSuffix(query, "> 0")
+	query = strings.TrimSpace(strings.TrimSuffix(query, "> 0"))
+
+	var groups map[string]demoGroup
+
+	if numerator, denominator, ok := strings.Cut(query, " / "); ok {
+		factor := 1.0
+		if d, found := strings.CutSuffix(denominator, " * 100"); found {
+			denominator = d
+			factor = 100
+		}
+
+		numerators := c.aggregateOr(numerator, ts)
+		denominators := c.aggregate(denominator, ts)
+
+		groups = make(map[string]demoGroup)
+		for key, d := range denominators {
+			n, ok := numerators[key]
+			if !ok || d.value == 0 {
+				continue
+			}
+			groups[key] = demoGroup{labels: d.labels, value: n.value / d.value * factor}
+		}
+	} else {
+		groups = c.aggregate(query, ts)
+	}
+
+	if nonZero {
+		for key, g := range groups {
+			if g.value <= 0 {
+				delete(groups, key)
+			}
+		}
+	}
+
+	return groups
+}
+
+// aggregateOr evaluates the expression "(<sum> or <sum> * 0)", which is used
+// to return a value of zero for the groups, which are only present in the
+// second sum, e.g. the namespaces without any mTLS traffic. All other
+// expressions are evaluated via "aggregate".
+func (c *demoClient) aggregateOr(expr string, ts time.Time) map[string]demoGroup {
+	inner, ok := strings.CutPrefix(expr, "(")
+	if !ok || !strings.HasSuffix(inner, " * 0)") {
+		return c.aggregate(expr, ts)
+	}
+	left, right, ok := strings.Cut(strings.TrimSuffix(inner, " * 0)"), " or ")
+	if !ok {
+		return c.aggregate(expr, ts)
+	}
+
+	groups := c.aggregate(left, ts)
+	for key, g := range c.aggregate(right, ts) {
+		if _, ok := groups[key]; !ok {
+			g.value = 0
+			groups[key] = g
+		}
+	}
+	return groups
+}
+
+// aggregate evaluates a single sum of all series matching one of the
+// selectors in the expression, grouped by the labels of the "by" clause.
+func (c *demoClient) aggregate(expr string, ts time.Time) map[string]demoGroup {
+	selectors := parseDemoSelectors(expr)
+
+	window := 0.0
+	if match := demoWindowRegexp.FindStringSubmatch(expr); match != nil {
+		window, _ = strconv.ParseFloat(match[1], 64)
+	}
+
+	var groupBy []string
+	if match := demoGroupByRegexp.FindStringSubmatch(expr); match != nil {
+		for label := range strings.SplitSeq(match[1], ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				groupBy = append(groupBy, label)
+			}
+		}
+	}
+
+	groups := make(map[string]demoGroup)
+
+	for _, s := range c.series {
+		if !matchesDemoSelectors(s, selectors) {
+			continue
+		}
+
+		// The traffic follows a daily pattern, which is shifted for each
+		// edge, so that the graphs and time series do not look static.
+		value := s.rate * (1 + 0.2*math.Sin(2*math.Pi*float64(ts.Unix())/86400+s.phase))
+		if strings.Contains(expr, "increase(") {
+			value = value * window
+		}
+
+		labels := make(map[string]string, len(groupBy))
+		for _, label := range groupBy {
+			labels[label] = s.labels[label]
+		}
+		key := demoGroupKey(labels, groupBy)
+
+		g := groups[key]
+		g.labels = labels
+		g.value = g.value + value
+		groups[key] = g
+	}
+
+	if strings.Contains(expr, "timestamp(") {
+		for key, g := range groups {
+			g.value = float64(ts.Unix())
+			groups[key] = g
+		}
+	}
+
+	if match := demoQuantileRegexp.FindStringSubmatch(expr); match != nil {
+		q, _ := strconv.ParseFloat(match[1], 64)
+		return demoHistogramQuantile(q, groups, groupBy)
+	}
+
+	return groups
+}
+
+// demoHistogramQuantile calculates the quantile for all groups, which only
+// differ in the "le" label, in the same way as the "histogram_quantile"
+// function of Prometheus: The bucket which contains the quantile is found and
+// the value is linearly interpolated within the bucket.
+func demoHistogramQuantile(q float64, buckets map[string]demoGroup, groupBy []string) map[string]demoGroup {
+	type bucket struct {
+		upperBound float64
+		count      float64
+	}
+
+	var labelNames []string
+	for _, label := range groupBy {
+		if label != "le" {
+			labelNames = append(labelNames, label)
+		}
+	}
+
+	histograms := make(map[string][]bucket)
+	histogramLabels := make(map[string]map[string]string)
+
+	for _, b := range buckets {
+		upperBound, err := strconv.ParseFloat(b.labels["le"], 64)
+		if err != nil {
+			continue
+		}
+
+		labels := make(map[string]string, len(labelNames))
+		for _, label := range labelNames {
+			labels[label] = b.labels[label]
+		}
+		key := demoGroupKey(labels, labelNames)
+
+		histograms[key] = append(histograms[key], bucket{upperBound: upperBound, count: b.value})
+		histogramLabels[key] = labels
+	}
+
+	groups := make(map[string]demoGroup)
+
+	for key, histogram := range histograms {
+		sort.Slice(histogram, func(i, j int) bool { return histogram[i].upperBound < histogram[j].upperBound })
+
+		total := histogram[len(histogram)-1].count
+		if total == 0 {
+			continue
+		}
+
+		rank := q * total
+		i := sort.Search(len(histogram), func(i int) bool { return histogram[i].count >= rank })
+		if i == len(histogram)-1 && math.IsInf(histogram[i].upperBound, 1) {
+			i = len(histogram) - 2
+			groups[key] = demoGroup{labels: histogramLabels[key], value: histogram[i].upperBound}
+			continue
+		}
+
+		lowerBound, lowerCount := 0.0, 0.0
+		if i > 0 {
+			lowerBound, lowerCount = histogram[i-1].upperBound, histogram[i-1].count
+		}
+
+		value := lowerBound
+		if histogram[i].count > lowerCount {
+			value = lowerBound + (histogram[i].upperBound-lowerBound)*(rank-lowerCount)/(histogram[i].count-lowerCount)
+		}
+		groups[key] = demoGroup{labels: histogramLabels[key], value: value}
+	}
+
+	return groups
+}
+
+// demoSelector is a parsed series selector, e.g.
+// `istio_requests_total{reporter="destination"}`.
+type demoSelector struct {
+	name     string
+	matchers []demoMatcher
+}
+
+type demoMatcher struct {
+	label    string
+	operator string
+	value    string
+	regexp   *regexp.Regexp
+}
+
+// parseDemoSelectors returns all series selectors of the given expression.
+// Invalid matchers are ignored.
+func parseDemoSelectors(expr string) []demoSelector {
+	var selectors []demoSelector
+
+	for _, match := range demoSelectorRegexp.FindAllStringSubmatch(expr, -1) {
+		selector := demoSelector{name: match[1]}
+
+		for _, m := range demoMatcherRegexp.FindAllStringSubmatch(match[2]+match[3], -1) {
+			value, err := strconv.Unquote(`"` + m[3] + `"`)
+			if err != nil {
+				continue
+			}
+
+			matcher := demoMatcher{label: m[1], operator: m[2], value: value}
+			if matcher.operator == "=~" || matcher.operator == "!~" {
+				matcher.regexp, err = regexp.Compile("^(?:" + value + ")$")
+				if err != nil {
+					continue
+				}
+			}
+			selector.matchers = append(selector.matchers, matcher)
+		}
+
+		selectors = append(selectors, selector)
+	}
+
+	return selectors
+}
+
+// matchesDemoSelectors returns true if the series matches at least one of the
+// given selectors. Selectors without a metric name must select the series via
+// the "__name__" label.
+func matchesDemoSelectors(s demoSeries, selectors []demoSelector) bool {
+	for _, selector := range selectors {
+		if selector.name != "" && selector.name != s.name {
+			continue
+		}
+
+		matches := true
+		for _, matcher := range selector.matchers {
+			value := s.labels[matcher.label]
+			if matcher.label == "__name__" {
+				value = s.name
+			}
+
+			switch matcher.operator {
+			case "=":
+				matches = value == matcher.value
+			case "!=":
+				matches = value != matcher.value
+			case "=~":
+				matches = matcher.regexp.MatchString(value)
+			case "!~":
+				matches = !matcher.regexp.MatchString(value)
+			}
+			if !matches {
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// demoGroupKey returns a unique key for the given label values. The names are
+// sorted, so that the groups of two sums can be matched, when they are grouped
+// by the same labels in a different order.
+func demoGroupKey(labels map[string]string, names []string) string {
+	var key strings.Builder
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		key.WriteString(name)
+		key.WriteString("=")
+		key.WriteString(labels[name])
+		key.WriteString(",")
+	}
+	return key.String()
+}
+
+// formatBucket formats the upper bound of a bucket in the same way as
+// Prometheus does it for the "le" label.
+func formatBucket(le float64) string {
+	if math.IsInf(le, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(le, 'g', -1, 64)
+}
@@ -0,0 +1,105 @@
+package prometheus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemoClient(t *testing.T) {
+	client := newDemoClient()
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: to.Add(-time.Hour), To: to}
+
+	requests := func(function string, window time.Duration, groupBy string, matchers ...Matcher) Query {
+		return Query{Aggregation: "sum", Function: function, Selector: Selector{Metric: "istio_requests_total", Matchers: matchers}, Window: window, GroupBy: []string{groupBy}}
+	}
+
+	t.Run("label values", func(t *testing.T) {
+		values, err := client.GetLabelValues(context.Background(), LabelValuesQuery{Label: "destination_workload", Selectors: []Selector{{Metric: "istio_requests_total", Matchers: Matchers{Equal("destination_workload_namespace", "bookinfo")}}}}, timeRange)
+		require.NoError(t, err)
+		require.Equal(t, []string{"details-v1", "productpage-v1", "ratings-v1", "reviews-v1", "reviews-v2", "reviews-v3"}, values)
+	})
+
+	t.Run("increase", func(t *testing.T) {
+		metrics, err := client.GetMetrics(context.Background(), "httpRequests", Binary{
+			LHS:      Query{Aggregation: "sum", Function: "increase", Selector: Selector{Metric: "istio_requests_total", Matchers: Matchers{Equal("reporter", "destination"), Equal("destination_workload_namespace", "bookinfo"), OneOf("destination_workload", "reviews-v1", "details-v1")}}, Window: time.Hour, GroupBy: []string{"destination_workload", "response_code"}},
+			Operator: ">",
+			RHS:      Number(0),
+		}, timeRange)
+		require.NoError(t, err)
+		require.Len(t, metrics, 2)
+		require.Equal(t, map[string]string{"destination_workload": "details-v1", "response_code": "200", "metric": "httpRequests"}, metrics[0].Labels)
+		require.InDelta(t, 40*3600, metrics[0].Value, 40*3600*0.2)
+	})
+
+	t.Run("ratio", func(t *testing.T) {
+		metrics, err := client.GetMetrics(context.Background(), "", Binary{
+			LHS:      Binary{LHS: requests("rate", time.Minute, "destination_workload", Equal("destination_workload", "reviews-v3"), Regexp("response_code", "5.*")), Operator: "/", RHS: requests("rate", time.Minute, "destination_workload", Equal("destination_workload", "reviews-v3"))},
+			Operator: "*",
+			RHS:      Number(100),
+		}, timeRange)
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		require.InDelta(t, 4, metrics[0].Value, 0.001)
+	})
+
+	t.Run("ratio without numerator", func(t *testing.T) {
+		metrics, err := client.GetMetrics(context.Background(), "", Binary{
+			LHS:      Binary{LHS: requests("rate", time.Minute, "destination_workload", Equal("destination_workload_namespace", "bookinfo"), Regexp("response_code", "5.*")), Operator: "/", RHS: requests("rate", time.Minute, "destination_workload", Equal("destination_workload_namespace", "bookinfo"))},
+			Operator: "*",
+			RHS:      Number(100),
+		}, timeRange)
+		require.NoError(t, err)
+		require.Len(t, metrics, 3)
+	})
+
+	t.Run("ratio with zero fallback", func(t *testing.T) {
+		metrics, err := client.GetMetrics(context.Background(), "", Binary{
+			LHS: Binary{
+				LHS:      Binary{LHS: requests("rate", time.Minute, "destination_workload_namespace", Equal("reporter", "destination"), Equal("connection_security_policy", "mutual_tls")), Operator: "or", RHS: Binary{LHS: requests("rate", time.Minute, "destination_workload_namespace", Equal("reporter", "destination")), Operator: "*", RHS: Number(0)}},
+				Operator: "/",
+				RHS:      requests("rate", time.Minute, "destination_workload_namespace", Equal("reporter", "destination")),
+			},
+			Operator: "*",
+			RHS:      Number(100),
+		}, timeRange)
+		require.NoError(t, err)
+		require.Len(t, metrics, 3)
+		require.Equal(t, "legacy", metrics[1].Labels["destination_workload_namespace"])
+		require.Equal(t, float64(0), metrics[1].Value)
+	})
+
+	t.Run("metric name regexp", func(t *testing.T) {
+		metrics, err := client.GetMetrics(context.Background(), "", Query{Aggregation: "sum", Function: "increase", Selector: Selector{Matchers: Matchers{Regexp("__name__", "istio_tcp_sent_bytes_total|istio_tcp_received_bytes_total"), Equal("reporter", "source"), Equal("source_workload", "cart")}}, Window: time.Hour, GroupBy: []string{"destination_service_name"}}, timeRange)
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		require.Equal(t, "redis", metrics[0].Labels["destination_service_name"])
+	})
+
+	t.Run("histogram quantile", func(t *testing.T) {
+		metrics, err := client.GetMetrics(context.Background(), "", Call{Function: "histogram_quantile", Args: []Expression{
+			Number(0.99),
+			Query{Aggregation: "sum", Function: "rate", Selector: Selector{Metric: "istio_request_duration_milliseconds_bucket", Matchers: Matchers{Equal("destination_workload", "reviews-v3")}}, Window: time.Minute, GroupBy: []string{"le", "destination_workload"}},
+		}}, timeRange)
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		require.Greater(t, metrics[0].Value, 250.0)
+		require.Less(t, metrics[0].Value, 500.0)
+	})
+
+	t.Run("time series", func(t *testing.T) {
+		timeSeries, err := client.GetTimeSeries(context.Background(), "", requests("rate", time.Minute, "destination_workload", Equal("destination_workload_namespace", "shop")), timeRange, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, timeSeries, 5)
+		require.Len(t, timeSeries[0].Values, 61)
+	})
+
+	t.Run("time series without step", func(t *testing.T) {
+		_, err := client.GetTimeSeries(context.Background(), "", requests("rate", time.Minute, "destination_workload", Equal("destination_workload_namespace", "shop")), timeRange, 0)
+		require.ErrorIs(t, err, errDemoStep)
+	})
+}
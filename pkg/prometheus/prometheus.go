@@ -2,6 +2,15 @@ package prometheus
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
 	"github.com/ricoberger/grafana-istio-plugin/pkg/roundtripper"
@@ -14,22 +23,137 @@ import (
 
 type Client interface {
 	CheckHealth(ctx context.Context) error
+	GetRetention(ctx context.Context) (time.Duration, error)
 	GetLabelValues(ctx context.Context, query LabelValuesQuery, timeRange backend.TimeRange) ([]string, error)
-	GetMetrics(ctx context.Context, metric, query string, timeRange backend.TimeRange) ([]Metric, error)
+	GetLabelValuesBatch(ctx context.Context, queries []LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error)
+	GetMetrics(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error)
+	GetMetricsBatch(ctx context.Context, queries []MetricsQuery) ([][]Metric, error)
+	GetTimeSeries(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange, step time.Duration) ([]TimeSeries, error)
+	GetTimeSeriesBatch(ctx context.Context, queries []TimeSeriesQuery) ([][]TimeSeries, error)
+	Close()
+}
+
+// UnauthorizedError is returned by all methods of the client, when Prometheus
+// responds with "401 Unauthorized" to a request with the credentials of the
+// datasource, e.g. because the token of the datasource expired after a
+// rotation of the secret. The error contains the configured
+// authentication method, so that the user gets a specific hint how the
+// credentials can be fixed instead of a generic query failure.
+type UnauthorizedError struct {
+	AuthMethod string
+	TokenFile  string
+}
+
+func (e *UnauthorizedError) Error() string {
+	switch e.AuthMethod {
+	case models.PrometheusAuthMethodBasic:
+		return "Prometheus rejected the username and password (401 Unauthorized): credentials invalid, re-save datasource with valid credentials"
+	case models.PrometheusAuthMethodToken:
+		if e.TokenFile != "" {
+			return fmt.Sprintf("Prometheus rejected the token from %s (401 Unauthorized): credentials invalid, check that the file contains a valid token", e.TokenFile)
+		}
+		return "Prometheus rejected the token (401 Unauthorized): credentials invalid, re-save datasource with a valid token"
+	default:
+		return "Prometheus rejected the credentials of the datasource (401 Unauthorized)"
+	}
 }
 
 type client struct {
-	api v1.API
+	api          v1.API
+	apiClient    api.Client
+	httpClient   *http.Client
+	transport    *roundtripper.Transport
+	certificate  *roundtripper.ClientCertificate
+	batcher      *Batcher
+	flavor       string
+	discovery    string
+	roundTo      time.Duration
+	queryTimeout time.Duration
+	queryLimit   int
 }
 
+// Close closes the idle connections of the client and stops watching the
+// client certificate. It must be called when the client isn't used anymore,
+// because otherwise the connections are kept open until the idle connection
+// timeout is reached.
+func (c *client) Close() {
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+	if c.certificate != nil {
+		c.certificate.Close()
+	}
+}
+
+// CheckHealth checks if the Prometheus API is reachable. VictoriaMetrics
+// doesn't implement the build information endpoint, so that we run a simple
+// query instead.
 func (c *client) CheckHealth(ctx context.Context) error {
+	if c.flavor == models.PrometheusFlavorVictoriaMetrics {
+		_, _, err := c.api.Query(ctx, "vector(1)", time.Now())
+		return err
+	}
+
 	_, err := c.api.Buildinfo(ctx)
 	return err
 }
 
+// GetRetention returns the time based retention of Prometheus. The retention
+// is read from the runtime information and if this isn't available from the
+// command-line flags. If the retention can not be determined, e.g. because
+// only a size based retention is configured or because the backend doesn't
+// implement these endpoints (VictoriaMetrics), zero is returned.
+func (c *client) GetRetention(ctx context.Context) (time.Duration, error) {
+	if c.flavor == models.PrometheusFlavorVictoriaMetrics {
+		return 0, nil
+	}
+
+	runtimeinfo, err := c.api.Runtimeinfo(ctx)
+	if err == nil && runtimeinfo.StorageRetention != "" {
+		return parseRetention(runtimeinfo.StorageRetention), nil
+	}
+
+	flags, err := c.api.Flags(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseRetention(flags["storage.tsdb.retention.time"]), nil
+}
+
+// parseRetention parses the retention returned by Prometheus, which can
+// contain a time and a size based retention, e.g. "15d or 512MiB". Only the
+// time based retention is returned.
+func parseRetention(retention string) time.Duration {
+	for _, field := range strings.Fields(retention) {
+		if d, err := model.ParseDuration(field); err == nil {
+			return time.Duration(d)
+		}
+	}
+	return 0
+}
+
 func (c *client) GetLabelValues(ctx context.Context, query LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
-	labelValues, _, err := c.api.LabelValues(ctx, query.Label, query.Matches, timeRange.From, timeRange.To)
+	// Some Prometheus compatible stores implement the series API, but not the
+	// "match[]" parameter for the label values API. For these stores the label
+	// values can be discovered via the series API instead. The series API
+	// requires at least one selector, so that queries without selectors always
+	// use the label values API.
+	if c.discovery == models.PrometheusDiscoveryModeSeries && len(query.Selectors) > 0 {
+		return c.getLabelValuesViaSeries(ctx, query, timeRange)
+	}
+
+	labelValues, _, err := c.api.LabelValues(ctx, query.Label, query.Matches(), c.round(timeRange.From), c.round(timeRange.To))
 	if err != nil {
+		// Some older or proxied Prometheus compatible backends do not support
+		// the label values API with the "match[]" parameter and respond with
+		// "404 Not Found" or "405 Method Not Allowed". In this case we fall
+		// back to get the label values via an instant query. All other errors,
+		// e.g. a bad selector, are returned, because the query would fail in
+		// the same way.
+		if len(query.Selectors) > 0 && isUnsupportedEndpointError(err) {
+			return c.getLabelValuesViaQuery(ctx, query, timeRange)
+		}
 		return nil, err
 	}
 
@@ -42,18 +166,99 @@ func (c *client) GetLabelValues(ctx context.Context, query LabelValuesQuery, tim
 	return values, nil
 }
 
-func (c *client) GetMetrics(ctx context.Context, metric, query string, timeRange backend.TimeRange) ([]Metric, error) {
-	result, _, err := c.api.Query(ctx, query, timeRange.To)
+// isUnsupportedEndpointError returns true if the given error was returned by
+// the Prometheus API client for a "404 Not Found" or "405 Method Not Allowed"
+// response. These responses are not valid API errors, so that the client
+// returns them as client error with the status code in the message.
+func isUnsupportedEndpointError(err error) bool {
+	var apiErr *v1.Error
+	if !errors.As(err, &apiErr) || apiErr.Type != v1.ErrClient {
+		return false
+	}
+	return apiErr.Msg == fmt.Sprintf("client error: %d", http.StatusNotFound) || apiErr.Msg == fmt.Sprintf("client error: %d", http.StatusMethodNotAllowed)
+}
+
+// GetLabelValuesBatch returns the label values for all given queries, in the
+// order of the queries. The queries share the concurrency limit with the
+// queries of GetMetricsBatch.
+func (c *client) GetLabelValuesBatch(ctx context.Context, queries []LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	return c.batcher.GetLabelValues(ctx, c.GetLabelValues, queries, timeRange)
+}
+
+// getLabelValuesViaSeries returns the label values for the given query via the
+// series API, which returns the label sets of all series matching one of the
+// selectors in the time range. The values are sorted and deduplicated, like the
+// values returned by the label values API.
+func (c *client) getLabelValuesViaSeries(ctx context.Context, query LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	labelSets, _, err := c.api.Series(ctx, query.Matches(), c.round(timeRange.From), c.round(timeRange.To))
 	if err != nil {
 		return nil, err
 	}
 
-	streams, ok := result.(model.Vector)
-	if !ok {
+	var values []string
+
+	for _, labelSet := range labelSets {
+		if value := string(labelSet[model.LabelName(query.Label)]); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+// getLabelValuesViaQuery returns the label values for the given query via an
+// instant query, which groups all series matching one of the selectors in the
+// time range by the label. The values are sorted and deduplicated, like the
+// values returned by the label values API. The range of the query is at least
+// one second, because Prometheus rejects a range of "0s".
+func (c *client) getLabelValuesViaQuery(ctx context.Context, query LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	var values []string
+
+	window := max(int64(timeRange.Duration().Seconds()), 1)
+
+	for _, match := range query.Matches() {
+		q := fmt.Sprintf("group by (%s) (count_over_time(%s[%ds]))", query.Label, match, window)
+
+		metrics, err := c.queryVector(ctx, "", q, c.round(timeRange.To))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range metrics {
+			if value := m.Labels[query.Label]; value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+func (c *client) GetMetrics(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange) ([]Metric, error) {
+	return c.queryVector(ctx, metric, query.String(), c.round(timeRange.To))
+}
+
+// GetMetricsBatch returns the metrics for all given queries, in the order of
+// the queries. The queries of all batches share the concurrency limit of the
+// client.
+func (c *client) GetMetricsBatch(ctx context.Context, queries []MetricsQuery) ([][]Metric, error) {
+	return c.batcher.GetMetrics(ctx, c.GetMetrics, queries)
+}
+
+func (c *client) GetTimeSeries(ctx context.Context, metric string, query Expression, timeRange backend.TimeRange, step time.Duration) ([]TimeSeries, error) {
+	result, _, err := c.api.QueryRange(ctx, query.String(), v1.Range{Start: c.round(timeRange.From), End: c.round(timeRange.To), Step: step})
+	if err != nil {
 		return nil, err
 	}
 
-	var metrics []Metric
+	streams, ok := result.(model.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type %q", result.Type())
+	}
+
+	var timeSeries []TimeSeries
 
 	for _, stream := range streams {
 		labels := make(map[string]string)
@@ -63,17 +268,131 @@ func (c *client) GetMetrics(ctx context.Context, metric, query string, timeRange
 			labels[string(key)] = string(value)
 		}
 
-		metrics = append(metrics, Metric{
-			Value:  float64(stream.Value),
-			Labels: labels,
+		var timestamps []time.Time
+		var values []float64
+
+		for _, sample := range stream.Values {
+			timestamps = append(timestamps, sample.Timestamp.Time())
+			values = append(values, float64(sample.Value))
+		}
+
+		timeSeries = append(timeSeries, TimeSeries{
+			Timestamps: timestamps,
+			Values:     values,
+			Labels:     labels,
 		})
 	}
 
-	return metrics, nil
+	return timeSeries, nil
+}
+
+// GetTimeSeriesBatch returns the time series for all given queries, in the
+// order of the queries. The queries share the concurrency limit with the
+// queries of GetMetricsBatch.
+func (c *client) GetTimeSeriesBatch(ctx context.Context, queries []TimeSeriesQuery) ([][]TimeSeries, error) {
+	return c.batcher.GetTimeSeries(ctx, c.GetTimeSeries, queries)
 }
 
-func NewClient(settings *models.PluginSettings) (Client, error) {
-	roundTripper := roundtripper.DefaultRoundTripper
+// tokenFileDirectoriesEnv is the environment variable with the comma separated
+// list of directories, from which a bearer token file can be read. Grafana sets
+// it from the "token_file_directories" option in the
+// "[plugin.ricoberger-istio-datasource]" section of its configuration, so that
+// only the administrator of the Grafana server can allow directories and not
+// the administrators of an organization, which can edit the datasource.
+const tokenFileDirectoriesEnv = "GF_PLUGIN_TOKEN_FILE_DIRECTORIES"
+
+// checkTokenFile returns an error if the token file isn't in one of the
+// directories of the "tokenFileDirectoriesEnv" environment variable. Otherwise
+// the token file could be used to send the content of any file, which can be
+// read by Grafana, to the Prometheus url of the datasource.
+func checkTokenFile(path string) error {
+	path = filepath.Clean(path)
+	for directory := range strings.SplitSeq(os.Getenv(tokenFileDirectoriesEnv), ",") {
+		directory = strings.TrimSpace(directory)
+		if directory == "" || !filepath.IsAbs(directory) {
+			continue
+		}
+		if strings.HasPrefix(path, filepath.Clean(directory)+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("token file %s is not in an allowed directory: set the \"token_file_directories\" option in the [plugin.ricoberger-istio-datasource] section of the Grafana configuration", path)
+}
+
+// round rounds the given time down to a multiple of the configured duration,
+// so that repeated queries use the same evaluation time and can be served from
+// the cache of a query frontend (e.g. Thanos or Mimir).
+func (c *client) round(t time.Time) time.Time {
+	if c.roundTo <= 0 {
+		return t
+	}
+	return t.Truncate(c.roundTo)
+}
+
+func NewClient(settings *models.PluginSettings) (_ Client, err error) {
+	// In the demo mode we do not connect to Prometheus, instead all queries
+	// are answered with synthetic data.
+	if settings.PrometheusDemoMode {
+		return newDemoClient(), nil
+	}
+
+	options, err := transportOptions(settings.PrometheusTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.PrometheusProxyUrl != "" {
+		proxyURL, err := url.Parse(settings.PrometheusProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		options.ProxyURL = proxyURL
+	}
+
+	// The client certificate is loaded from files, which are watched, so that
+	// a rotated certificate, e.g. by cert-manager, is used for all new
+	// connections without saving the datasource again.
+	if settings.PrometheusClientCertFile != "" && settings.PrometheusClientKeyFile != "" {
+		certificate, err := roundtripper.NewClientCertificate(settings.PrometheusClientCertFile, settings.PrometheusClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options.ClientCertificate = certificate
+
+		// Stop watching the files, when the client can not be created.
+		defer func() {
+			if err != nil {
+				certificate.Close()
+			}
+		}()
+	}
+
+	// The base transport is kept in the client, so that its idle connections
+	// can be closed when the datasource is disposed.
+	transport := roundtripper.New(options)
+	var roundTripper http.RoundTripper = transport
+
+	// The rate limit is applied to all requests of the datasource instance, so
+	// that a dashboard with many panels can not exceed the query budget of the
+	// Prometheus tenant. The client is shared by all organizations, so that
+	// they also share the limit.
+	if settings.PrometheusRateLimit > 0 {
+		roundTripper = roundtripper.NewRateLimitTransport(roundTripper, settings.PrometheusRateLimit)
+	}
+
+	// Additional query parameters can be used to set backend specific
+	// parameters for all requests, e.g. "latency_offset" for VictoriaMetrics.
+	if settings.PrometheusQueryParams != "" {
+		queryParameters, err := url.ParseQuery(settings.PrometheusQueryParams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query parameters: %w", err)
+		}
+
+		roundTripper = roundtripper.QueryParametersTransport{
+			Transport:       roundTripper,
+			QueryParameters: queryParameters,
+		}
+	}
 
 	if settings.PrometheusAuthMethod == models.PrometheusAuthMethodBasic {
 		roundTripper = roundtripper.BasicAuthTransport{
@@ -83,10 +402,51 @@ func NewClient(settings *models.PluginSettings) (Client, error) {
 		}
 	}
 
+	// If a token file is configured, the token is read from the file instead
+	// of the secure settings, so that a rotated token, e.g. the token of a
+	// Kubernetes service account, is used without saving the datasource again.
 	if settings.PrometheusAuthMethod == models.PrometheusAuthMethodToken {
-		roundTripper = roundtripper.TokenAuthTransporter{
+		if settings.PrometheusTokenFile != "" {
+			if err := checkTokenFile(settings.PrometheusTokenFile); err != nil {
+				return nil, err
+			}
+			roundTripper = roundtripper.NewTokenFileTransport(roundTripper, settings.PrometheusTokenFile)
+		} else {
+			roundTripper = roundtripper.TokenAuthTransporter{
+				Transport: roundTripper,
+				Token:     settings.Secrets.PrometheusToken,
+			}
+		}
+	}
+
+	// A "401 Unauthorized" response is returned as UnauthorizedError, so that
+	// invalid credentials can be reported with a specific message in the
+	// health check and the query responses. This is only done, when the
+	// credentials of the datasource are sent. The transport wraps the
+	// transports for the forwarded cookies and user headers, so that a 401
+	// for an expired session of the user isn't reported as invalid
+	// credentials of the datasource.
+	if settings.PrometheusAuthMethod == models.PrometheusAuthMethodBasic || settings.PrometheusAuthMethod == models.PrometheusAuthMethodToken {
+		roundTripper = roundtripper.UnauthorizedTransport{
+			Transport: roundTripper,
+			Err:       &UnauthorizedError{AuthMethod: settings.PrometheusAuthMethod, TokenFile: settings.PrometheusTokenFile},
+		}
+	}
+
+	// The allowed cookies are forwarded from the Grafana request, so that
+	// Prometheus can be protected by a proxy which uses session cookies.
+	if len(settings.KeepCookies) > 0 {
+		roundTripper = roundtripper.CookiesTransport{
+			Transport: roundTripper,
+			Cookies:   settings.KeepCookies,
+		}
+	}
+
+	// Forward the Grafana user of a query to Prometheus, so that a proxy in
+	// front of Prometheus can authorize the requests per user.
+	if settings.PrometheusForwardUserHeaders {
+		roundTripper = roundtripper.UserHeadersTransport{
 			Transport: roundTripper,
-			Token:     settings.Secrets.PrometheusToken,
 		}
 	}
 
@@ -98,7 +458,69 @@ func NewClient(settings *models.PluginSettings) (Client, error) {
 		return nil, err
 	}
 
+	var roundTo time.Duration
+	if settings.PrometheusRoundTo != "" {
+		d, err := model.ParseDuration(settings.PrometheusRoundTo)
+		if err != nil {
+			return nil, err
+		}
+		roundTo = time.Duration(d)
+	}
+
+	// The query timeout and limit are sent with all instant queries, so that
+	// heavy graph queries fail fast instead of waiting for the defaults of
+	// Prometheus.
+	var queryTimeout time.Duration
+	if settings.PrometheusQueryTimeout != "" {
+		d, err := model.ParseDuration(settings.PrometheusQueryTimeout)
+		if err != nil {
+			return nil, err
+		}
+		queryTimeout = time.Duration(d)
+	}
+
 	return &client{
-		api: v1.NewAPI(apiClient),
+		api:          v1.NewAPI(apiClient),
+		apiClient:    apiClient,
+		httpClient:   &http.Client{Transport: roundTripper},
+		transport:    transport,
+		certificate:  options.ClientCertificate,
+		batcher:      NewBatcher(settings.PrometheusBatchConcurrency),
+		flavor:       settings.PrometheusFlavor,
+		discovery:    settings.PrometheusDiscoveryMode,
+		roundTo:      roundTo,
+		queryTimeout: queryTimeout,
+		queryLimit:   settings.PrometheusQueryLimit,
 	}, nil
 }
+
+// transportOptions converts the transport settings into the options for the
+// RoundTripper. The durations in the settings can use the Prometheus duration
+// format, e.g. "90s" or "5m".
+func transportOptions(settings models.PrometheusTransport) (roundtripper.Options, error) {
+	options := roundtripper.Options{
+		MaxIdleConnsPerHost: settings.MaxIdleConnsPerHost,
+	}
+
+	for _, duration := range []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{name: "idle connection timeout", value: settings.IdleConnTimeout, field: &options.IdleConnTimeout},
+		{name: "response header timeout", value: settings.ResponseHeaderTimeout, field: &options.ResponseHeaderTimeout},
+		{name: "keep-alive", value: settings.KeepAlive, field: &options.KeepAlive},
+	} {
+		if duration.value == "" {
+			continue
+		}
+
+		d, err := model.ParseDuration(duration.value)
+		if err != nil {
+			return roundtripper.Options{}, fmt.Errorf("invalid %s: %w", duration.name, err)
+		}
+		*duration.field = time.Duration(d)
+	}
+
+	return options, nil
+}
@@ -0,0 +1,231 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLabelValuesFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/destination_workload_namespace/values":
+			w.WriteHeader(http.StatusNotFound)
+		case "/api/v1/query":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "group by (destination_workload_namespace) (count_over_time(istio_requests_total[3600s]))", r.Form.Get("query"))
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"destination_workload_namespace":"bookinfo"},"value":[1735689600,"1"]}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL})
+	require.NoError(t, err)
+
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	values, err := client.GetLabelValues(context.Background(), LabelValuesQuery{Label: "destination_workload_namespace", Selectors: []Selector{{Metric: "istio_requests_total"}}}, backend.TimeRange{From: to.Add(-time.Hour), To: to})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bookinfo"}, values)
+}
+
+func TestGetLabelValuesNoFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/destination_workload_namespace/values":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"invalid parameter \"match[]\": parse error"}`))
+		default:
+			require.Fail(t, "unexpected request", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL})
+	require.NoError(t, err)
+
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = client.GetLabelValues(context.Background(), LabelValuesQuery{Label: "destination_workload_namespace", Selectors: []Selector{{Metric: "istio_requests_total"}}}, backend.TimeRange{From: to.Add(-time.Hour), To: to})
+	require.ErrorContains(t, err, "parse error")
+}
+
+func TestGetLabelValuesViaQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/destination_workload/values":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "/api/v1/query":
+			require.NoError(t, r.ParseForm())
+			switch r.Form.Get("query") {
+			case "group by (destination_workload) (count_over_time(istio_requests_total[1s]))":
+				w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"destination_workload":"reviews-v2"},"value":[1735689600,"1"]},{"metric":{"destination_workload":"reviews-v1"},"value":[1735689600,"1"]}]}}`))
+			case "group by (destination_workload) (count_over_time(istio_tcp_sent_bytes_total[1s]))":
+				w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"destination_workload":"reviews-v1"},"value":[1735689600,"1"]}]}}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"unexpected query"}`))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL})
+	require.NoError(t, err)
+
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	values, err := client.GetLabelValues(context.Background(), LabelValuesQuery{Label: "destination_workload", Selectors: []Selector{{Metric: "istio_requests_total"}, {Metric: "istio_tcp_sent_bytes_total"}}}, backend.TimeRange{From: to.Add(-500 * time.Millisecond), To: to})
+	require.NoError(t, err)
+	require.Equal(t, []string{"reviews-v1", "reviews-v2"}, values)
+}
+
+func TestParseRetention(t *testing.T) {
+	require.Equal(t, 15*24*time.Hour, parseRetention("15d"))
+	require.Equal(t, 30*24*time.Hour, parseRetention("30d or 512MiB"))
+	require.Equal(t, time.Duration(0), parseRetention("512MiB"))
+	require.Equal(t, time.Duration(0), parseRetention(""))
+}
+
+func TestQueryTimeoutAndLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "30", r.Form.Get("timeout"))
+		require.Equal(t, "3", r.Form.Get("limit"))
+
+		switch r.Form.Get("query") {
+		case "slow":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"error","errorType":"timeout","error":"query timed out in expression evaluation"}`))
+		case "large":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"a":"1"},"value":[1735689600,"1"]},{"metric":{"a":"2"},"value":[1735689600,"1"]},{"metric":{"a":"3"},"value":[1735689600,"1"]}]}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"a":"1"},"value":[1735689600,"1"]},{"metric":{"a":"2"},"value":[1735689600,"1"]}]}}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL, PrometheusQueryTimeout: "30s", PrometheusQueryLimit: 2})
+	require.NoError(t, err)
+
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: to.Add(-time.Hour), To: to}
+
+	metrics, err := client.GetMetrics(context.Background(), "", Selector{Metric: "small"}, timeRange)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	_, err = client.GetMetrics(context.Background(), "", Selector{Metric: "large"}, timeRange)
+	require.ErrorContains(t, err, "query returned more than 2 series")
+
+	_, err = client.GetMetrics(context.Background(), "", Selector{Metric: "slow"}, timeRange)
+	require.EqualError(t, err, "query exceeded the timeout of 30s: timeout: query timed out in expression evaluation")
+}
+
+func TestGetLabelValuesViaSeries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/series":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, []string{`istio_requests_total{destination_workload_namespace="bookinfo"}`}, r.Form["match[]"])
+			w.Write([]byte(`{"status":"success","data":[{"__name__":"istio_requests_total","destination_workload":"reviews-v2"},{"__name__":"istio_requests_total","destination_workload":"reviews-v1"},{"__name__":"istio_requests_total","destination_workload":"reviews-v2"},{"__name__":"istio_requests_total"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL, PrometheusDiscoveryMode: models.PrometheusDiscoveryModeSeries})
+	require.NoError(t, err)
+
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	values, err := client.GetLabelValues(context.Background(), LabelValuesQuery{Label: "destination_workload", Selectors: []Selector{{Metric: "istio_requests_total", Matchers: Matchers{Equal("destination_workload_namespace", "bookinfo")}}}}, backend.TimeRange{From: to.Add(-time.Hour), To: to})
+	require.NoError(t, err)
+	require.Equal(t, []string{"reviews-v1", "reviews-v2"}, values)
+}
+
+func TestUnauthorizedError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer valid" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	t.Run("should return no error for valid token", func(t *testing.T) {
+		client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL, PrometheusAuthMethod: models.PrometheusAuthMethodToken, Secrets: &models.SecretPluginSettings{PrometheusToken: "valid"}})
+		require.NoError(t, err)
+
+		_, err = client.GetMetrics(context.Background(), "", Selector{Metric: "up"}, backend.TimeRange{To: time.Now()})
+		require.NoError(t, err)
+	})
+
+	t.Run("should return unauthorized error for expired token", func(t *testing.T) {
+		client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL, PrometheusAuthMethod: models.PrometheusAuthMethodToken, Secrets: &models.SecretPluginSettings{PrometheusToken: "expired"}})
+		require.NoError(t, err)
+
+		_, err = client.GetMetrics(context.Background(), "", Selector{Metric: "up"}, backend.TimeRange{To: time.Now()})
+		var unauthorizedErr *UnauthorizedError
+		require.ErrorAs(t, err, &unauthorizedErr)
+		require.Contains(t, unauthorizedErr.Error(), "credentials invalid, re-save datasource")
+	})
+
+	t.Run("should return response error without credentials of the datasource", func(t *testing.T) {
+		client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL})
+		require.NoError(t, err)
+
+		_, err = client.GetMetrics(context.Background(), "", Selector{Metric: "up"}, backend.TimeRange{To: time.Now()})
+		var unauthorizedErr *UnauthorizedError
+		require.Error(t, err)
+		require.False(t, errors.As(err, &unauthorizedErr))
+	})
+}
+
+func TestCheckTokenFile(t *testing.T) {
+	t.Setenv(tokenFileDirectoriesEnv, "")
+	require.ErrorContains(t, checkTokenFile("/var/run/secrets/kubernetes.io/serviceaccount/token"), "is not in an allowed directory")
+
+	t.Setenv(tokenFileDirectoriesEnv, "/etc/grafana/tokens, /var/run/secrets/kubernetes.io/serviceaccount/")
+	require.NoError(t, checkTokenFile("/var/run/secrets/kubernetes.io/serviceaccount/token"))
+	require.NoError(t, checkTokenFile("/etc/grafana/tokens/prometheus"))
+	require.Error(t, checkTokenFile("/etc/grafana/tokens/../grafana.ini"))
+	require.Error(t, checkTokenFile("/etc/grafana/tokens-other/prometheus"))
+
+	_, err := NewClient(&models.PluginSettings{PrometheusUrl: "http://localhost:9090", PrometheusAuthMethod: models.PrometheusAuthMethodToken, PrometheusTokenFile: "/etc/passwd"})
+	require.ErrorContains(t, err, "is not in an allowed directory")
+}
+
+func TestGetTimeSeriesUnexpectedResultType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL})
+	require.NoError(t, err)
+
+	_, err = client.GetTimeSeries(context.Background(), "", Selector{Metric: "up"}, backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()}, time.Minute)
+	require.EqualError(t, err, `unexpected result type "vector"`)
+}
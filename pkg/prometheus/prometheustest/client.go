@@ -0,0 +1,298 @@
+// Package prometheustest provides a scriptable mock of the Prometheus client
+// and helpers to compare data frames against golden files, so that query
+// handlers and their frame output can be tested without a Prometheus instance.
+package prometheustest
+
+import (
+	"context"
+	"maps"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Make sure that the mock implements the Prometheus client interface.
+var _ prometheus.Client = (*Client)(nil)
+
+// Client is a mock of the Prometheus client. The results of the queries are
+// scripted via the "Add*" methods, where each result is returned for all
+// queries matching the given regular expression. If multiple results match a
+// query, the results are concatenated. Queries without a matching result
+// return an empty result, like Prometheus does when there are no series.
+//
+// All queries are recorded and can be retrieved via the "Queries" method. The
+// queries without any matching result can be retrieved via the "Unmatched"
+// method, e.g. to detect fixtures which are out of date.
+type Client struct {
+	HealthError error
+	Retention   time.Duration
+
+	mutex       sync.Mutex
+	labelValues []labelValuesResult
+	metrics     []metricsResult
+	timeSeries  []timeSeriesResult
+	errors      []errorResult
+	queries     []string
+	unmatched   []string
+	closed      bool
+}
+
+type labelValuesResult struct {
+	label  string
+	values []string
+}
+
+type metricsResult struct {
+	query   *regexp.Regexp
+	metrics []prometheus.Metric
+}
+
+type timeSeriesResult struct {
+	query      *regexp.Regexp
+	timeSeries []prometheus.TimeSeries
+}
+
+type errorResult struct {
+	query *regexp.Regexp
+	err   error
+}
+
+// NewClient returns a new mock client without any results.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// AddLabelValues adds the values, which are returned for the given label,
+// independent of the matchers of the label values query.
+func (c *Client) AddLabelValues(label string, values ...string) *Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.labelValues = append(c.labelValues, labelValuesResult{label: label, values: values})
+	return c
+}
+
+// AddMetrics adds the metrics, which are returned by "GetMetrics" for all
+// queries matching the given regular expression.
+func (c *Client) AddMetrics(query string, metrics ...prometheus.Metric) *Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.metrics = append(c.metrics, metricsResult{query: regexp.MustCompile(query), metrics: metrics})
+	return c
+}
+
+// AddTimeSeries adds the time series, which are returned by "GetTimeSeries"
+// for all queries matching the given regular expression.
+func (c *Client) AddTimeSeries(query string, timeSeries ...prometheus.TimeSeries) *Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.timeSeries = append(c.timeSeries, timeSeriesResult{query: regexp.MustCompile(query), timeSeries: timeSeries})
+	return c
+}
+
+// AddError adds an error, which is returned for all queries matching the
+// given regular expression. For label values queries the regular expression
+// is matched against the label.
+func (c *Client) AddError(query string, err error) *Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.errors = append(c.errors, errorResult{query: regexp.MustCompile(query), err: err})
+	return c
+}
+
+// Queries returns all queries, which were run against the mock client in the
+// order they were run.
+func (c *Client) Queries() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]string(nil), c.queries...)
+}
+
+// Unmatched returns all metrics and time series queries, which didn't match
+// any of the scripted results, in the order they were run.
+func (c *Client) Unmatched() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]string(nil), c.unmatched...)
+}
+
+// Closed returns true if the "Close" method of the client was called.
+func (c *Client) Closed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+func (c *Client) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+}
+
+func (c *Client) CheckHealth(ctx context.Context) error {
+	return c.HealthError
+}
+
+func (c *Client) GetRetention(ctx context.Context) (time.Duration, error) {
+	return c.Retention, nil
+}
+
+func (c *Client) GetLabelValues(ctx context.Context, query prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.queries = append(c.queries, query.Label)
+	if err := c.errorFor(query.Label); err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, result := range c.labelValues {
+		if result.label == query.Label {
+			values = append(values, result.values...)
+		}
+	}
+
+	return values, nil
+}
+
+// GetLabelValuesBatch returns the results of the given queries. Like for
+// GetMetricsBatch, the queries are executed one after another.
+func (c *Client) GetLabelValuesBatch(ctx context.Context, queries []prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	results := make([][]string, len(queries))
+	for i, query := range queries {
+		values, err := c.GetLabelValues(ctx, query, timeRange)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = values
+	}
+
+	return results, nil
+}
+
+func (c *Client) GetMetrics(ctx context.Context, metric string, expression prometheus.Expression, timeRange backend.TimeRange) ([]prometheus.Metric, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	query := expression.String()
+	c.queries = append(c.queries, query)
+	if err := c.errorFor(query); err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	matched := false
+	for _, result := range c.metrics {
+		if !result.query.MatchString(query) {
+			continue
+		}
+		matched = true
+
+		// The labels are copied, because the real client sets the "metric"
+		// label and the handlers are allowed to modify the labels.
+		for _, m := range result.metrics {
+			labels := maps.Clone(m.Labels)
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels["metric"] = metric
+
+			metrics = append(metrics, prometheus.Metric{Value: m.Value, Labels: labels, Timestamp: m.Timestamp})
+		}
+	}
+	if !matched {
+		c.unmatched = append(c.unmatched, query)
+	}
+
+	return metrics, nil
+}
+
+// GetMetricsBatch returns the results of the given queries. The queries are
+// executed one after another, so that the recorded queries are in the order of
+// the batch. Like the real client, the error of the first failed query is
+// returned.
+func (c *Client) GetMetricsBatch(ctx context.Context, queries []prometheus.MetricsQuery) ([][]prometheus.Metric, error) {
+	results := make([][]prometheus.Metric, len(queries))
+	for i, query := range queries {
+		metrics, err := c.GetMetrics(ctx, query.Metric, query.Query, query.TimeRange)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = metrics
+	}
+
+	return results, nil
+}
+
+func (c *Client) GetTimeSeries(ctx context.Context, metric string, expression prometheus.Expression, timeRange backend.TimeRange, step time.Duration) ([]prometheus.TimeSeries, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	query := expression.String()
+	c.queries = append(c.queries, query)
+	if err := c.errorFor(query); err != nil {
+		return nil, err
+	}
+
+	var timeSeries []prometheus.TimeSeries
+	matched := false
+	for _, result := range c.timeSeries {
+		if !result.query.MatchString(query) {
+			continue
+		}
+		matched = true
+
+		for _, ts := range result.timeSeries {
+			labels := maps.Clone(ts.Labels)
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels["metric"] = metric
+
+			timeSeries = append(timeSeries, prometheus.TimeSeries{Timestamps: ts.Timestamps, Values: ts.Values, Labels: labels})
+		}
+	}
+	if !matched {
+		c.unmatched = append(c.unmatched, query)
+	}
+
+	return timeSeries, nil
+}
+
+// GetTimeSeriesBatch returns the results of the given queries. Like for
+// GetMetricsBatch, the queries are executed one after another.
+func (c *Client) GetTimeSeriesBatch(ctx context.Context, queries []prometheus.TimeSeriesQuery) ([][]prometheus.TimeSeries, error) {
+	results := make([][]prometheus.TimeSeries, len(queries))
+	for i, query := range queries {
+		timeSeries, err := c.GetTimeSeries(ctx, query.Metric, query.Query, query.TimeRange, query.Step)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = timeSeries
+	}
+
+	return results, nil
+}
+
+// errorFor returns the first scripted error, which matches the given query.
+// The caller must hold the mutex.
+func (c *Client) errorFor(query string) error {
+	for _, result := range c.errors {
+		if result.query.MatchString(query) {
+			return result.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package prometheustest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	client := NewClient().
+		AddLabelValues("destination_workload_namespace", "bookinfo").
+		AddMetrics(`istio_requests_total\{.*destination_workload_namespace="bookinfo"`, prometheus.Metric{Value: 10, Labels: map[string]string{"destination_workload": "reviews-v1"}}).
+		AddError(`istio_tcp_sent_bytes_total`, errors.New("query failed"))
+
+	values, err := client.GetLabelValues(context.Background(), prometheus.LabelValuesQuery{Label: "destination_workload_namespace"}, backend.TimeRange{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bookinfo"}, values)
+
+	query := func(metric, namespace string) prometheus.Query {
+		selector := prometheus.Selector{Metric: metric}
+		if namespace != "" {
+			selector.Matchers = prometheus.Matchers{prometheus.Equal("destination_workload_namespace", namespace)}
+		}
+		return prometheus.Query{Aggregation: "sum", Function: "increase", Selector: selector, Window: time.Minute}
+	}
+
+	metrics, err := client.GetMetrics(context.Background(), "httpRequests", query("istio_requests_total", "bookinfo"), backend.TimeRange{})
+	require.NoError(t, err)
+	require.Equal(t, []prometheus.Metric{{Value: 10, Labels: map[string]string{"destination_workload": "reviews-v1", "metric": "httpRequests"}}}, metrics)
+
+	metrics, err = client.GetMetrics(context.Background(), "httpRequests", query("istio_requests_total", "shop"), backend.TimeRange{})
+	require.NoError(t, err)
+	require.Empty(t, metrics)
+
+	_, err = client.GetMetrics(context.Background(), "tcpSentBytes", query("istio_tcp_sent_bytes_total", ""), backend.TimeRange{})
+	require.Error(t, err)
+
+	require.Len(t, client.Queries(), 4)
+	require.Equal(t, []string{`sum(increase(istio_requests_total{destination_workload_namespace="shop"}[60s]))`}, client.Unmatched())
+}
+
+func TestSortFrameRows(t *testing.T) {
+	frame := data.NewFrame("nodes", data.NewField("id", nil, []string{"b", "c", "a"}), data.NewField("value", nil, []float64{2, 3, 1}))
+
+	sorted := SortFrameRows(frame)
+	require.Equal(t, []string{"a", "b", "c"}, []string{sorted.Fields[0].At(0).(string), sorted.Fields[0].At(1).(string), sorted.Fields[0].At(2).(string)})
+	require.Equal(t, 1.0, sorted.Fields[1].At(0))
+}
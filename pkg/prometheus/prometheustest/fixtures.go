@@ -0,0 +1,246 @@
+package prometheustest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/prometheus"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Fixtures is a recorded set of Prometheus results, which can be stored in a
+// file and loaded into a mock client, so that tests always run against the
+// same data.
+//
+// The source is the url of the Prometheus instance, from which the results
+// were recorded, or "synthetic" for results, which were not returned by a real
+// Prometheus instance. Synthetic fixtures only test how the results are
+// converted into frames, but not if the queries return the expected results.
+type Fixtures struct {
+	Source      string               `json:"source"`
+	LabelValues []LabelValuesFixture `json:"labelValues,omitempty"`
+	Metrics     []MetricsFixture     `json:"metrics,omitempty"`
+	TimeSeries  []TimeSeriesFixture  `json:"timeSeries,omitempty"`
+}
+
+type LabelValuesFixture struct {
+	Label   string   `json:"label"`
+	Matches []string `json:"matches,omitempty"`
+	Values  []string `json:"values"`
+}
+
+type MetricsFixture struct {
+	Query   string              `json:"query"`
+	Metrics []prometheus.Metric `json:"metrics"`
+}
+
+type TimeSeriesFixture struct {
+	Query      string                  `json:"query"`
+	TimeSeries []prometheus.TimeSeries `json:"timeSeries"`
+}
+
+// LoadFixtures loads the fixtures from the given file and returns a mock
+// client, which returns the recorded results for exactly the recorded
+// queries.
+func LoadFixtures(path string) (*Client, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures Fixtures
+	if err := json.Unmarshal(raw, &fixtures); err != nil {
+		return nil, err
+	}
+
+	client := NewClient()
+	for _, fixture := range fixtures.LabelValues {
+		client.AddLabelValues(fixture.Label, fixture.Values...)
+	}
+	for _, fixture := range fixtures.Metrics {
+		client.AddMetrics("^"+regexp.QuoteMeta(fixture.Query)+"$", fixture.Metrics...)
+	}
+	for _, fixture := range fixtures.TimeSeries {
+		client.AddTimeSeries("^"+regexp.QuoteMeta(fixture.Query)+"$", fixture.TimeSeries...)
+	}
+
+	return client, nil
+}
+
+// SaveFixtures writes the given fixtures to a file. The fixtures are sorted,
+// so that the file doesn't change when the queries are run in a different
+// order.
+func SaveFixtures(path string, fixtures Fixtures) error {
+	slices.SortFunc(fixtures.LabelValues, func(a, b LabelValuesFixture) int {
+		return strings.Compare(a.Label+strings.Join(a.Matches, ","), b.Label+strings.Join(b.Matches, ","))
+	})
+	slices.SortFunc(fixtures.Metrics, func(a, b MetricsFixture) int { return strings.Compare(a.Query, b.Query) })
+	slices.SortFunc(fixtures.TimeSeries, func(a, b TimeSeriesFixture) int { return strings.Compare(a.Query, b.Query) })
+
+	raw, err := json.MarshalIndent(fixtures, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(raw, '\n'), 0o644)
+}
+
+// Recorder wraps a Prometheus client and records the results of all queries
+// as fixtures. Each query is only recorded once.
+type Recorder struct {
+	client prometheus.Client
+
+	mutex    sync.Mutex
+	fixtures Fixtures
+	recorded map[string]bool
+}
+
+// Make sure that the recorder implements the Prometheus client interface.
+var _ prometheus.Client = (*Recorder)(nil)
+
+// NewRecorder returns a new recorder for the given client.
+func NewRecorder(client prometheus.Client) *Recorder {
+	return &Recorder{
+		client:   client,
+		recorded: make(map[string]bool),
+	}
+}
+
+// Fixtures returns all recorded fixtures.
+func (r *Recorder) Fixtures() Fixtures {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.fixtures
+}
+
+func (r *Recorder) Close() {
+	r.client.Close()
+}
+
+func (r *Recorder) CheckHealth(ctx context.Context) error {
+	return r.client.CheckHealth(ctx)
+}
+
+func (r *Recorder) GetRetention(ctx context.Context) (time.Duration, error) {
+	return r.client.GetRetention(ctx)
+}
+
+func (r *Recorder) GetLabelValues(ctx context.Context, query prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([]string, error) {
+	values, err := r.client.GetLabelValues(ctx, query, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if key := "labelValues/" + query.Label + "/" + strings.Join(query.Matches(), ","); !r.recorded[key] {
+		r.recorded[key] = true
+		r.fixtures.LabelValues = append(r.fixtures.LabelValues, LabelValuesFixture{Label: query.Label, Matches: query.Matches(), Values: values})
+	}
+
+	return values, nil
+}
+
+func (r *Recorder) GetLabelValuesBatch(ctx context.Context, queries []prometheus.LabelValuesQuery, timeRange backend.TimeRange) ([][]string, error) {
+	results, err := r.client.GetLabelValuesBatch(ctx, queries, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, query := range queries {
+		if key := "labelValues/" + query.Label + "/" + strings.Join(query.Matches(), ","); !r.recorded[key] {
+			r.recorded[key] = true
+			r.fixtures.LabelValues = append(r.fixtures.LabelValues, LabelValuesFixture{Label: query.Label, Matches: query.Matches(), Values: results[i]})
+		}
+	}
+
+	return results, nil
+}
+
+func (r *Recorder) GetMetrics(ctx context.Context, metric string, query prometheus.Expression, timeRange backend.TimeRange) ([]prometheus.Metric, error) {
+	metrics, err := r.client.GetMetrics(ctx, metric, query, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if key := "metrics/" + query.String(); !r.recorded[key] {
+		r.recorded[key] = true
+		r.fixtures.Metrics = append(r.fixtures.Metrics, MetricsFixture{Query: query.String(), Metrics: metrics})
+	}
+
+	return metrics, nil
+}
+
+func (r *Recorder) GetMetricsBatch(ctx context.Context, queries []prometheus.MetricsQuery) ([][]prometheus.Metric, error) {
+	results, err := r.client.GetMetricsBatch(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, query := range queries {
+		if key := "metrics/" + query.Query.String(); !r.recorded[key] {
+			r.recorded[key] = true
+			r.fixtures.Metrics = append(r.fixtures.Metrics, MetricsFixture{Query: query.Query.String(), Metrics: results[i]})
+		}
+	}
+
+	return results, nil
+}
+
+func (r *Recorder) GetTimeSeries(ctx context.Context, metric string, query prometheus.Expression, timeRange backend.TimeRange, step time.Duration) ([]prometheus.TimeSeries, error) {
+	timeSeries, err := r.client.GetTimeSeries(ctx, metric, query, timeRange, step)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if key := "timeSeries/" + query.String(); !r.recorded[key] {
+		r.recorded[key] = true
+		r.fixtures.TimeSeries = append(r.fixtures.TimeSeries, TimeSeriesFixture{Query: query.String(), TimeSeries: timeSeries})
+	}
+
+	return timeSeries, nil
+}
+
+func (r *Recorder) GetTimeSeriesBatch(ctx context.Context, queries []prometheus.TimeSeriesQuery) ([][]prometheus.TimeSeries, error) {
+	results, err := r.client.GetTimeSeriesBatch(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, query := range queries {
+		if key := "timeSeries/" + query.Query.String(); !r.recorded[key] {
+			r.recorded[key] = true
+			r.fixtures.TimeSeries = append(r.fixtures.TimeSeries, TimeSeriesFixture{Query: query.Query.String(), TimeSeries: results[i]})
+		}
+	}
+
+	return results, nil
+}
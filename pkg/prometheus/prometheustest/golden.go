@@ -0,0 +1,86 @@
+package prometheustest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+// CheckGoldenResponse compares the given data response against the golden
+// file "<dir>/<name>.jsonc", using the golden response checks of the plugin
+// SDK. The rows of all frames are sorted before the comparison, because the
+// handlers generate the rows of the graph frames from maps, so that their
+// order is not stable.
+//
+// If update is true, the golden file is replaced with the given response.
+func CheckGoldenResponse(t *testing.T, dir, name string, response backend.DataResponse, update bool) {
+	t.Helper()
+
+	if update {
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		if err := os.Remove(filepath.Join(dir, name+".jsonc")); err != nil && !os.IsNotExist(err) {
+			require.NoError(t, err)
+		}
+	}
+
+	sorted := backend.DataResponse{
+		Error:       response.Error,
+		Status:      response.Status,
+		ErrorSource: response.ErrorSource,
+	}
+	for _, frame := range response.Frames {
+		sorted.Frames = append(sorted.Frames, SortFrameRows(frame))
+	}
+
+	experimental.CheckGoldenJSONResponse(t, dir, name, &sorted, update)
+}
+
+// SortFrameRows returns a copy of the given frame, where the rows are sorted
+// by the values of all fields, starting with the first field.
+func SortFrameRows(frame *data.Frame) *data.Frame {
+	rows, _ := frame.RowLen()
+
+	keys := make([]string, rows)
+	for i := range rows {
+		values := make([]string, len(frame.Fields))
+		for j, field := range frame.Fields {
+			if value, ok := field.ConcreteAt(i); ok {
+				values[j] = fmt.Sprint(value)
+			}
+		}
+		keys[i] = strings.Join(values, "\x00")
+	}
+
+	order := make([]int, rows)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+	fields := make([]*data.Field, len(frame.Fields))
+	for j, field := range frame.Fields {
+		sortedField := data.NewFieldFromFieldType(field.Type(), rows)
+		sortedField.Name = field.Name
+		sortedField.Labels = field.Labels
+		sortedField.Config = field.Config
+
+		for i, row := range order {
+			sortedField.Set(i, field.CopyAt(row))
+		}
+		fields[j] = sortedField
+	}
+
+	sorted := data.NewFrame(frame.Name, fields...)
+	sorted.RefID = frame.RefID
+	sorted.Meta = frame.Meta
+
+	return sorted
+}
@@ -0,0 +1,279 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatchType is the operator of a label matcher.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher is a label matcher of a selector, e.g. `namespace="bookinfo"`. The
+// value is escaped when the matcher is converted to PromQL, so that values from
+// a query model, like a namespace or a workload, can not change the selector.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+// Equal returns a matcher, which selects the series where the label is equal
+// to the value.
+func Equal(name, value string) Matcher {
+	return Matcher{Name: name, Type: MatchEqual, Value: value}
+}
+
+// NotEqual returns a matcher, which selects the series where the label is not
+// equal to the value.
+func NotEqual(name, value string) Matcher {
+	return Matcher{Name: name, Type: MatchNotEqual, Value: value}
+}
+
+// Regexp returns a matcher, which selects the series where the label matches
+// the regular expression.
+func Regexp(name, regex string) Matcher {
+	return Matcher{Name: name, Type: MatchRegexp, Value: regex}
+}
+
+// NotRegexp returns a matcher, which selects the series where the label does
+// not match the regular expression.
+func NotRegexp(name, regex string) Matcher {
+	return Matcher{Name: name, Type: MatchNotRegexp, Value: regex}
+}
+
+// OneOf returns a matcher, which selects the series where the label is equal to
+// one of the values. For a single value an equality matcher is returned,
+// otherwise the values are quoted and joined to a regular expression.
+func OneOf(name string, values ...string) Matcher {
+	if len(values) == 1 {
+		return Equal(name, values[0])
+	}
+
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, regexp.QuoteMeta(value))
+	}
+	return Regexp(name, strings.Join(quoted, "|"))
+}
+
+// String returns the matcher in PromQL, where the value is a double-quoted
+// string. The escape sequences of PromQL strings are the same as in Go, so that
+// "strconv.Quote" escapes quotes, backslashes and control characters.
+func (m Matcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// Matchers is a list of label matchers.
+type Matchers []Matcher
+
+// String returns the matchers in PromQL, separated by a comma, but without the
+// surrounding braces, so that they can be added to the matchers of another
+// selector.
+func (ms Matchers) String() string {
+	var b strings.Builder
+	for i, m := range ms {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(m.String())
+	}
+	return b.String()
+}
+
+// Selector is a series selector, which consists of an optional metric name and
+// a list of label matchers, e.g. `istio_requests_total{reporter="source"}`.
+type Selector struct {
+	Metric   string
+	Matchers Matchers
+}
+
+// String returns the selector in PromQL. If the selector doesn't have any
+// matchers, only the metric name is returned.
+func (s Selector) String() string {
+	if len(s.Matchers) == 0 {
+		return s.Metric
+	}
+	return s.Metric + "{" + s.Matchers.String() + "}"
+}
+
+// Expression is a PromQL expression, which can be run via the "GetMetrics" and
+// "GetTimeSeries" methods of the client. It can only be implemented by the
+// types of this package, so that all label values of an expression are part of
+// the matchers of a selector, which are escaped.
+type Expression interface {
+	String() string
+	expression()
+}
+
+func (Selector) expression()  {}
+func (Query) expression()     {}
+func (Number) expression()    {}
+func (Call) expression()      {}
+func (Aggregate) expression() {}
+func (Binary) expression()    {}
+
+// Query is a query, which aggregates a range function over a selector, e.g.
+// `sum(increase(istio_requests_total{reporter="source"}[60s])) by (source_app)`.
+// All parts except the selector are optional. It covers the most common query
+// of the handlers, more complex queries can be assembled from queries via the
+// "Call", "Aggregate" and "Binary" expressions.
+type Query struct {
+	Aggregation string
+	Function    string
+	Selector    Selector
+	Window      time.Duration
+	GroupBy     []string
+}
+
+// String returns the query in PromQL. The window is formatted in seconds, so
+// that a query for the same time range always results in the same string.
+func (q Query) String() string {
+	query := q.Selector.String()
+	if q.Window > 0 {
+		query = fmt.Sprintf("%s[%ds]", query, int64(q.Window.Seconds()))
+	}
+	if q.Function != "" {
+		query = q.Function + "(" + query + ")"
+	}
+	if q.Aggregation != "" {
+		query = q.Aggregation + "(" + query + ")"
+		if len(q.GroupBy) > 0 {
+			query += " by (" + strings.Join(q.GroupBy, ", ") + ")"
+		}
+	}
+	return query
+}
+
+// Number is a number literal, e.g. the quantile of a "histogram_quantile"
+// function or the value a query is compared with.
+type Number float64
+
+// String returns the number in PromQL, in the shortest representation which
+// doesn't lose any precision.
+func (n Number) String() string {
+	return strconv.FormatFloat(float64(n), 'g', -1, 64)
+}
+
+// Call is a function call, which can not be expressed as a Query, e.g.
+// `histogram_quantile(0.99, sum(...) by (le))` or `timestamp(a or b)`, because
+// the function has multiple arguments or the argument isn't a selector.
+type Call struct {
+	Function string
+	Args     []Expression
+}
+
+// String returns the function call in PromQL.
+func (c Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, arg.String())
+	}
+	return c.Function + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Aggregate is an aggregation over an expression, which can not be expressed
+// as a Query, e.g. `sum(increase(a[60s]) or increase(b[60s])) by (c)`.
+type Aggregate struct {
+	Operator string
+	Expr     Expression
+	GroupBy  []string
+}
+
+// String returns the aggregation in PromQL.
+func (a Aggregate) String() string {
+	query := a.Operator + "(" + a.Expr.String() + ")"
+	if len(a.GroupBy) > 0 {
+		query += " by (" + strings.Join(a.GroupBy, ", ") + ")"
+	}
+	return query
+}
+
+// Binary is a binary expression, e.g. `a / b`, `a * 1000`, `a > 0` or
+// `a or b`. It is the escape hatch for all queries, which combine multiple
+// expressions. The operator is never escaped, so that it must be one of the
+// PromQL operators and must not contain any user input.
+type Binary struct {
+	LHS      Expression
+	Operator string
+	RHS      Expression
+}
+
+// Or returns the union of the given expressions, which are joined with the
+// "or" operator, e.g. `a or b or c`. It must be called with at least one
+// expression.
+func Or(expressions ...Expression) Expression {
+	result := expressions[0]
+	for _, expression := range expressions[1:] {
+		result = Binary{LHS: result, Operator: "or", RHS: expression}
+	}
+	return result
+}
+
+// binaryPrecedence is the precedence of the binary operators of PromQL, from
+// the lowest to the highest precedence.
+var binaryPrecedence = map[string]int{
+	"or":     1,
+	"and":    2,
+	"unless": 2,
+	"==":     3,
+	"!=":     3,
+	"<=":     3,
+	"<":      3,
+	">=":     3,
+	">":      3,
+	"+":      4,
+	"-":      4,
+	"*":      5,
+	"/":      5,
+	"%":      5,
+	"atan2":  5,
+	"^":      6,
+}
+
+// String returns the binary expression in PromQL. An operand, which is a
+// binary expression with a lower precedence, is put into parentheses, so that
+// the expression is evaluated in the order of the tree. All binary operators
+// are treated as left-associative.
+func (b Binary) String() string {
+	precedence := binaryPrecedence[b.Operator]
+
+	lhs := b.LHS.String()
+	if operand, ok := b.LHS.(Binary); ok && binaryPrecedence[operand.Operator] < precedence {
+		lhs = "(" + lhs + ")"
+	}
+
+	rhs := b.RHS.String()
+	if operand, ok := b.RHS.(Binary); ok && binaryPrecedence[operand.Operator] <= precedence {
+		rhs = "(" + rhs + ")"
+	}
+
+	return lhs + " " + b.Operator + " " + rhs
+}
+
+// LabelValuesQuery is the query to get the values of a label. If selectors are
+// set, only the values of the series matching one of the selectors are
+// returned.
+type LabelValuesQuery struct {
+	Label     string
+	Selectors []Selector
+}
+
+// Matches returns the selectors of the query in PromQL, as they are used for
+// the "match[]" parameter of the Prometheus API.
+func (q LabelValuesQuery) Matches() []string {
+	matches := make([]string, 0, len(q.Selectors))
+	for _, selector := range q.Selectors {
+		matches = append(matches, selector.String())
+	}
+	return matches
+}
@@ -0,0 +1,74 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher(t *testing.T) {
+	require.Equal(t, `namespace="bookinfo"`, Equal("namespace", "bookinfo").String())
+	require.Equal(t, `namespace!="bookinfo"`, NotEqual("namespace", "bookinfo").String())
+	require.Equal(t, `response_code=~"5.."`, Regexp("response_code", "5..").String())
+	require.Equal(t, `destination_service!~".*:15020"`, NotRegexp("destination_service", ".*:15020").String())
+
+	t.Run("should escape values", func(t *testing.T) {
+		require.Equal(t, `namespace="bookinfo\"} or vector(1) or up{a=\"b"`, Equal("namespace", `bookinfo"} or vector(1) or up{a="b`).String())
+		require.Equal(t, `namespace="book\\info\n"`, Equal("namespace", "book\\info\n").String())
+	})
+
+	t.Run("should match one of the values", func(t *testing.T) {
+		require.Equal(t, `destination_workload="reviews-v1"`, OneOf("destination_workload", "reviews-v1").String())
+		require.Equal(t, `destination_workload=~"reviews-v1|reviews-v2"`, OneOf("destination_workload", "reviews-v1", "reviews-v2").String())
+		require.Equal(t, `destination_workload=~"my\\.workload|reviews-v1"`, OneOf("destination_workload", "my.workload", "reviews-v1").String())
+	})
+}
+
+func TestSelector(t *testing.T) {
+	require.Equal(t, `istio_requests_total`, Selector{Metric: "istio_requests_total"}.String())
+	require.Equal(t, `istio_requests_total{reporter="source", source_workload_namespace="bookinfo"}`, Selector{Metric: "istio_requests_total", Matchers: Matchers{Equal("reporter", "source"), Equal("source_workload_namespace", "bookinfo")}}.String())
+	require.Equal(t, `{__name__=~"istio_.*"}`, Selector{Matchers: Matchers{Regexp("__name__", "istio_.*")}}.String())
+}
+
+func TestQuery(t *testing.T) {
+	selector := Selector{Metric: "istio_requests_total", Matchers: Matchers{Equal("destination_workload_namespace", "bookinfo")}}
+
+	require.Equal(t, `sum(increase(istio_requests_total{destination_workload_namespace="bookinfo"}[3600s])) by (destination_app, destination_workload)`, Query{Aggregation: "sum", Function: "increase", Selector: selector, Window: time.Hour, GroupBy: []string{"destination_app", "destination_workload"}}.String())
+	require.Equal(t, `sum(increase(istio_requests_total[60s]))`, Query{Aggregation: "sum", Function: "increase", Selector: Selector{Metric: "istio_requests_total"}, Window: time.Minute}.String())
+	require.Equal(t, `rate(istio_requests_total{destination_workload_namespace="bookinfo"}[60s])`, Query{Function: "rate", Selector: selector, Window: time.Minute}.String())
+}
+
+func TestExpressions(t *testing.T) {
+	query := func(matchers ...Matcher) Query {
+		return Query{Aggregation: "sum", Function: "rate", Selector: Selector{Metric: "istio_requests_total", Matchers: matchers}, Window: time.Minute, GroupBy: []string{"destination_workload"}}
+	}
+
+	require.Equal(t, `histogram_quantile(0.99, sum(rate(istio_requests_total[60s])) by (destination_workload)) * 1000 > 0`, Binary{Binary{Call{"histogram_quantile", []Expression{Number(0.99), query()}}, "*", Number(1000)}, ">", Number(0)}.String())
+	require.Equal(t, `sum(increase(a[60s]) or increase(b[60s])) by (c)`, Aggregate{"sum", Binary{Query{Function: "increase", Selector: Selector{Metric: "a"}, Window: time.Minute}, "or", Query{Function: "increase", Selector: Selector{Metric: "b"}, Window: time.Minute}}, []string{"c"}}.String())
+	require.Equal(t, `max(timestamp(a or b))`, Aggregate{Operator: "max", Expr: Call{"timestamp", []Expression{Binary{Selector{Metric: "a"}, "or", Selector{Metric: "b"}}}}}.String())
+
+	t.Run("should add parentheses for operands with a lower precedence", func(t *testing.T) {
+		a, b, c := Selector{Metric: "a"}, Selector{Metric: "b"}, Selector{Metric: "c"}
+
+		require.Equal(t, `(a or b * 0) / c * 100`, Binary{Binary{Binary{a, "or", Binary{b, "*", Number(0)}}, "/", c}, "*", Number(100)}.String())
+		require.Equal(t, `a / (b * c)`, Binary{a, "/", Binary{b, "*", c}}.String())
+		require.Equal(t, `a - b - c`, Binary{Binary{a, "-", b}, "-", c}.String())
+	})
+
+	t.Run("should join expressions with or", func(t *testing.T) {
+		require.Equal(t, `a`, Or(Selector{Metric: "a"}).String())
+		require.Equal(t, `a or b or c`, Or(Selector{Metric: "a"}, Selector{Metric: "b"}, Selector{Metric: "c"}).String())
+	})
+}
+
+func TestLabelValuesQueryMatches(t *testing.T) {
+	require.Empty(t, LabelValuesQuery{Label: "destination_workload_namespace"}.Matches())
+	require.Equal(t, []string{`istio_requests_total{destination_workload_namespace="bookinfo"}`, `istio_tcp_sent_bytes_total`}, LabelValuesQuery{
+		Label: "destination_workload",
+		Selectors: []Selector{
+			{Metric: "istio_requests_total", Matchers: Matchers{Equal("destination_workload_namespace", "bookinfo")}},
+			{Metric: "istio_tcp_sent_bytes_total"},
+		},
+	}.Matches())
+}
@@ -0,0 +1,233 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// vectorSample is a single sample of an instant vector in the response of the
+// Prometheus query API.
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+// queryError is an error returned by the Prometheus query API, e.g. a bad
+// query or a timeout.
+type queryError struct {
+	errorType string
+	message   string
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.errorType, e.message)
+}
+
+// queryVector runs the given instant query and decodes the returned vector as
+// stream. This means that we never have to keep the raw response of Prometheus
+// in memory, which can be tens of megabytes for namespace graphs. Instead each
+// sample is decoded and converted on its own, so that the memory usage is
+// proportional to the returned metrics.
+//
+// If a query timeout is configured, it is sent as "timeout" parameter. If a
+// query limit is configured, one more series than the limit is requested via
+// the "limit" parameter, so that we can detect when the limit is exceeded and
+// return an error instead of an incomplete result.
+func (c *client) queryVector(ctx context.Context, metric, query string, ts time.Time) ([]Metric, error) {
+	u := c.apiClient.URL("/api/v1/query", nil)
+
+	args := url.Values{}
+	args.Set("query", query)
+	args.Set("time", strconv.FormatFloat(float64(ts.UnixNano())/1e9, 'f', -1, 64))
+	if c.queryTimeout > 0 {
+		args.Set("timeout", strconv.FormatFloat(c.queryTimeout.Seconds(), 'f', -1, 64))
+	}
+	if c.queryLimit > 0 {
+		args.Set("limit", strconv.Itoa(c.queryLimit+1))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(args.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Prometheus returns a JSON body with the error for all errors, e.g. bad
+	// queries or timeouts. If the content type isn't JSON, the error is most
+	// likely returned by a proxy in front of Prometheus.
+	if resp.StatusCode/100 != 2 && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("server returned HTTP status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	metrics, err := decodeVector(resp.Body, metric)
+	if err != nil {
+		var qErr *queryError
+		if c.queryTimeout > 0 && errors.As(err, &qErr) && qErr.errorType == "timeout" {
+			return nil, fmt.Errorf("query exceeded the timeout of %s: %w", model.Duration(c.queryTimeout), err)
+		}
+		return nil, err
+	}
+
+	if c.queryLimit > 0 && len(metrics) > c.queryLimit {
+		return nil, fmt.Errorf("query returned more than %d series, which is the limit of the datasource: narrow down the query, e.g. by selecting an application or workload", c.queryLimit)
+	}
+
+	return metrics, nil
+}
+
+// decodeVector decodes the response of the Prometheus query API from the given
+// reader. The "result" array is decoded sample by sample. The "metric" label is
+// set to the given metric for all returned metrics.
+func decodeVector(r io.Reader, metric string) ([]Metric, error) {
+	decoder := json.NewDecoder(r)
+
+	var status, errorType, errorMessage string
+	var metrics []Metric
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "status":
+			err = decoder.Decode(&status)
+		case "errorType":
+			err = decoder.Decode(&errorType)
+		case "error":
+			err = decoder.Decode(&errorMessage)
+		case "data":
+			metrics, err = decodeVectorData(decoder, metric)
+		default:
+			var skip json.RawMessage
+			err = decoder.Decode(&skip)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if status != "success" {
+		if errorMessage == "" {
+			errorMessage = "unknown error"
+		}
+		return nil, &queryError{errorType: errorType, message: errorMessage}
+	}
+
+	return metrics, nil
+}
+
+// decodeVectorData decodes the "data" object of the Prometheus query API
+// response.
+func decodeVectorData(decoder *json.Decoder, metric string) ([]Metric, error) {
+	var metrics []Metric
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "resultType":
+			var resultType string
+			if err := decoder.Decode(&resultType); err != nil {
+				return nil, err
+			}
+			if resultType != "vector" {
+				return nil, fmt.Errorf("unexpected result type %q", resultType)
+			}
+		case "result":
+			if err := expectDelim(decoder, '['); err != nil {
+				return nil, err
+			}
+
+			for decoder.More() {
+				var sample vectorSample
+				if err := decoder.Decode(&sample); err != nil {
+					return nil, err
+				}
+
+				valueString, ok := sample.Value[1].(string)
+				if !ok {
+					return nil, fmt.Errorf("unexpected sample value %v", sample.Value[1])
+				}
+				value, err := strconv.ParseFloat(valueString, 64)
+				if err != nil {
+					return nil, err
+				}
+
+				timestamp, ok := sample.Value[0].(float64)
+				if !ok {
+					return nil, fmt.Errorf("unexpected sample timestamp %v", sample.Value[0])
+				}
+
+				labels := sample.Metric
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels["metric"] = metric
+
+				metrics = append(metrics, Metric{
+					Value:     value,
+					Labels:    labels,
+					Timestamp: time.UnixMilli(int64(timestamp * 1000)).UTC(),
+				})
+			}
+
+			if err := expectDelim(decoder, ']'); err != nil {
+				return nil, err
+			}
+		default:
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// expectDelim reads the next token from the decoder and returns an error if it
+// isn't the given delimiter.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("unexpected token %v, expected %v", token, delim)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-istio-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeVector(t *testing.T) {
+	metrics, err := decodeVector(strings.NewReader(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"source_workload":"productpage"},"value":[1735689600,"42.5"]},{"metric":{},"value":[1735689600,"NaN"]}]},"warnings":["test"]}`), "httpRequests")
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	require.Equal(t, 42.5, metrics[0].Value)
+	require.Equal(t, map[string]string{"metric": "httpRequests", "source_workload": "productpage"}, metrics[0].Labels)
+	require.Equal(t, map[string]string{"metric": "httpRequests"}, metrics[1].Labels)
+	require.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), metrics[0].Timestamp)
+
+	_, err = decodeVector(strings.NewReader(`{"status":"error","errorType":"bad_data","error":"invalid parameter \"query\""}`), "")
+	require.EqualError(t, err, `bad_data: invalid parameter "query"`)
+
+	_, err = decodeVector(strings.NewReader(`{"status":"success","data":{"resultType":"matrix","result":[]}}`), "")
+	require.Error(t, err)
+}
+
+func TestGetMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/query", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "up", r.Form.Get("query"))
+		require.Equal(t, "1735689600", r.Form.Get("time"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"prometheus"},"value":[1735689600,"1"]}]}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&models.PluginSettings{PrometheusUrl: server.URL, PrometheusRoundTo: "1m"})
+	require.NoError(t, err)
+
+	to := time.Date(2025, 1, 1, 0, 0, 30, 0, time.UTC)
+	metrics, err := client.GetMetrics(context.Background(), "up", Selector{Metric: "up"}, backend.TimeRange{From: to.Add(-time.Hour), To: to})
+	require.NoError(t, err)
+	require.Equal(t, []Metric{{Value: 1, Labels: map[string]string{"metric": "up", "job": "prometheus"}, Timestamp: to.Truncate(time.Minute)}}, metrics)
+}
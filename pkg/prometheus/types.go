@@ -1,11 +1,20 @@
 package prometheus
 
-type LabelValuesQuery struct {
-	Label   string
-	Matches []string
-}
+import (
+	"time"
+)
 
 type Metric struct {
 	Value  float64
 	Labels map[string]string
+	// Timestamp is the evaluation time of the instant query, which can differ
+	// from the end of the requested time range, when the client rounds the
+	// time. It is zero if the client doesn't know the evaluation time.
+	Timestamp time.Time `json:",omitzero"`
+}
+
+type TimeSeries struct {
+	Timestamps []time.Time
+	Values     []float64
+	Labels     map[string]string
 }
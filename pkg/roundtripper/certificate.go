@@ -0,0 +1,119 @@
+package roundtripper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ClientCertificate is a client certificate for mTLS, which is loaded from a
+// certificate and a key file. The files are watched and the certificate is
+// loaded again when they are changed, so that certificates which are rotated,
+// e.g. by cert-manager, are used without recreating the RoundTripper.
+type ClientCertificate struct {
+	certFile string
+	keyFile  string
+	watcher  *fsnotify.Watcher
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// NewClientCertificate returns a new ClientCertificate for the given
+// certificate and key file. It returns an error if the files can not be loaded
+// or watched. The returned ClientCertificate must be closed, when it isn't
+// used anymore, to stop watching the files.
+func NewClientCertificate(certFile, keyFile string) (*ClientCertificate, error) {
+	certFile = filepath.Clean(certFile)
+	keyFile = filepath.Clean(keyFile)
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch client certificate: %w", err)
+	}
+
+	// The directories of the files are watched instead of the files, because
+	// the files are often replaced instead of modified. E.g. Kubernetes
+	// replaces the "..data" symlink in the directory of a mounted secret, so
+	// that a watch on the file itself would be lost after the first rotation.
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch client certificate: %w", err)
+		}
+	}
+
+	cc := &ClientCertificate{
+		certFile:    certFile,
+		keyFile:     keyFile,
+		watcher:     watcher,
+		certificate: &certificate,
+	}
+	go cc.watch()
+
+	return cc, nil
+}
+
+// GetClientCertificate returns the current client certificate. It can be used
+// as "GetClientCertificate" function in a TLS configuration, so that each new
+// connection uses the latest certificate. Existing connections are not
+// interrupted and keep the certificate of their handshake.
+func (cc *ClientCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+
+	return cc.certificate, nil
+}
+
+// Close stops watching the certificate and key file.
+func (cc *ClientCertificate) Close() error {
+	return cc.watcher.Close()
+}
+
+// watch loads the certificate again, when the certificate or key file or the
+// "..data" symlink of a mounted Kubernetes secret is changed. It returns when
+// the watcher is closed.
+func (cc *ClientCertificate) watch() {
+	for {
+		select {
+		case event, ok := <-cc.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Name == cc.certFile || event.Name == cc.keyFile || filepath.Base(event.Name) == "..data" {
+				cc.reload()
+			}
+		case err, ok := <-cc.watcher.Errors:
+			if !ok {
+				return
+			}
+			backend.Logger.Warn("Failed to watch client certificate", "error", err.Error())
+		}
+	}
+}
+
+// reload loads the certificate and key file again. If they can not be loaded,
+// the previous certificate is kept. This is expected when the certificate was
+// already written, but the key not yet, so that the files are loaded again
+// with the next event.
+func (cc *ClientCertificate) reload() {
+	certificate, err := tls.LoadX509KeyPair(cc.certFile, cc.keyFile)
+	if err != nil {
+		backend.Logger.Debug("Failed to reload client certificate", "error", err.Error())
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.certificate = &certificate
+}
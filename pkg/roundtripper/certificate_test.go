@@ -0,0 +1,84 @@
+package roundtripper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate with the given common
+// name and its key to the given files.
+func writeTestCertificate(t *testing.T, commonName, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600))
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600))
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	commonName := func(cc *ClientCertificate) string {
+		certificate, err := cc.GetClientCertificate(nil)
+		require.NoError(t, err)
+
+		cert, err := x509.ParseCertificate(certificate.Certificate[0])
+		require.NoError(t, err)
+		return cert.Subject.CommonName
+	}
+
+	t.Run("should return error for missing files", func(t *testing.T) {
+		_, err := NewClientCertificate(certFile, keyFile)
+		require.Error(t, err)
+	})
+
+	t.Run("should reload rotated certificate", func(t *testing.T) {
+		writeTestCertificate(t, "client-1", certFile, keyFile)
+
+		cc, err := NewClientCertificate(certFile, keyFile)
+		require.NoError(t, err)
+		defer cc.Close()
+		require.Equal(t, "client-1", commonName(cc))
+
+		writeTestCertificate(t, "client-2", certFile, keyFile)
+		require.Eventually(t, func() bool {
+			return commonName(cc) == "client-2"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should use client certificate for tls connections", func(t *testing.T) {
+		cc, err := NewClientCertificate(certFile, keyFile)
+		require.NoError(t, err)
+		defer cc.Close()
+
+		transport := New(Options{ClientCertificate: cc})
+		require.NotNil(t, transport.transport.TLSClientConfig.GetClientCertificate)
+		require.True(t, transport.transport.ForceAttemptHTTP2)
+	})
+}
@@ -1,22 +1,90 @@
 package roundtripper
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // DefaultRoundTripper is our default RoundTripper.
-var DefaultRoundTripper http.RoundTripper = otelhttp.NewTransport(&http.Transport{
-	Proxy: http.ProxyFromEnvironment,
-	DialContext: (&net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-	}).DialContext,
-	TLSHandshakeTimeout: 10 * time.Second,
-})
+var DefaultRoundTripper http.RoundTripper = New(Options{})
+
+// Options are the options to tune the transport of a RoundTripper. If an option
+// is not set, the default value of the "net/http" package is used, except for
+// the keep-alive period, which defaults to 30 seconds. If no proxy url is set,
+// the proxy is taken from the "HTTP_PROXY", "HTTPS_PROXY" and "NO_PROXY"
+// environment variables. If a client certificate is set, it is used for
+// all TLS connections of the RoundTripper.
+type Options struct {
+	ProxyURL              *url.URL
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	ResponseHeaderTimeout time.Duration
+	KeepAlive             time.Duration
+	ClientCertificate     *ClientCertificate
+}
+
+// Transport is the RoundTripper returned by New. It keeps a reference to the
+// underlying transport, so that its idle connections can be closed when the
+// RoundTripper isn't used anymore.
+type Transport struct {
+	http.RoundTripper
+	transport *http.Transport
+}
+
+// CloseIdleConnections closes all idle connections of the underlying transport.
+// Connections which are currently in use are not interrupted.
+func (t *Transport) CloseIdleConnections() {
+	t.transport.CloseIdleConnections()
+}
+
+// New returns a new RoundTripper with the given options.
+func New(options Options) *Transport {
+	keepAlive := options.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = 30 * time.Second
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if options.ProxyURL != nil {
+		proxy = http.ProxyURL(options.ProxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		IdleConnTimeout:       options.IdleConnTimeout,
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+	}
+
+	// HTTP/2 is only enabled by default, when no custom TLS configuration is
+	// set, so that it must be enabled explicitly for client certificates.
+	if options.ClientCertificate != nil {
+		transport.TLSClientConfig = &tls.Config{GetClientCertificate: options.ClientCertificate.GetClientCertificate}
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	return &Transport{RoundTripper: otelhttp.NewTransport(transport), transport: transport}
+}
 
 // BasicAuthTransport is the struct to add basic auth to a RoundTripper.
 type BasicAuthTransport struct {
@@ -42,3 +110,321 @@ func (tat TokenAuthTransporter) RoundTrip(req *http.Request) (*http.Response, er
 	req.Header.Set("Authorization", "Bearer "+tat.Token)
 	return tat.Transport.RoundTrip(req)
 }
+
+// QueryParametersTransport is the struct to add query parameters to all
+// requests of a RoundTripper.
+type QueryParametersTransport struct {
+	Transport       http.RoundTripper
+	QueryParameters url.Values
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// additional query parameters.
+func (qpt QueryParametersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	query := req.URL.Query()
+	for key, values := range qpt.QueryParameters {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	req.URL.RawQuery = query.Encode()
+
+	return qpt.Transport.RoundTrip(req)
+}
+
+// UserHeadersTransport is the struct to forward the Grafana user, which sent a
+// query, to all requests of a RoundTripper. The user is taken from the context
+// of the request, so that it can be used for per-user authorization in a proxy
+// in front of Prometheus (e.g. prom-label-proxy or kube-rbac-proxy).
+type UserHeadersTransport struct {
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// forwarding the Grafana user. The headers are only set, when the user is
+// known, so that requests without a user (e.g. the health check) are not
+// changed.
+func (uht UserHeadersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	user := backend.UserFromContext(req.Context())
+	pluginContext := backend.PluginConfigFromContext(req.Context())
+	if user == nil && pluginContext.OrgID == 0 {
+		return uht.Transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+
+	if user != nil {
+		req.Header.Set("X-Grafana-User", user.Login)
+		if user.Email != "" {
+			req.Header.Set("X-Grafana-Email", user.Email)
+		}
+		if user.Role != "" {
+			req.Header.Set("X-Grafana-Role", user.Role)
+		}
+	}
+	if pluginContext.OrgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(pluginContext.OrgID, 10))
+	}
+
+	return uht.Transport.RoundTrip(req)
+}
+
+type cookiesKey struct{}
+
+// WithCookies returns a copy of the context with the value of the "Cookie"
+// header of the Grafana request, so that the cookies can be forwarded by the
+// CookiesTransport.
+func WithCookies(ctx context.Context, cookies string) context.Context {
+	if cookies == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, cookiesKey{}, cookies)
+}
+
+// CookiesTransport is the struct to forward the allowed cookies of the Grafana
+// request to all requests of a RoundTripper. This can be used when Prometheus is
+// protected by a proxy which uses session cookies, e.g. oauth2-proxy.
+type CookiesTransport struct {
+	Transport http.RoundTripper
+	Cookies   []string
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// forwarding cookies. Only the cookies with a name from the list of allowed
+// cookies are forwarded.
+func (ct CookiesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, ok := req.Context().Value(cookiesKey{}).(string)
+	if !ok {
+		return ct.Transport.RoundTrip(req)
+	}
+
+	cookies, err := http.ParseCookie(header)
+	if err != nil {
+		return ct.Transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for _, cookie := range cookies {
+		if slices.Contains(ct.Cookies, cookie.Name) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	return ct.Transport.RoundTrip(req)
+}
+
+type authorizationKey struct{}
+
+// WithAuthorization returns a copy of the context with the value of the
+// "Authorization" header of the Grafana request, so that it can be forwarded
+// by the AuthorizationTransport.
+func WithAuthorization(ctx context.Context, authorization string) context.Context {
+	if authorization == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, authorizationKey{}, authorization)
+}
+
+// AuthorizationTransport is the struct to forward the "Authorization" header
+// of the Grafana request to all requests of a RoundTripper, so that the
+// requests are authorized as the user who sent the Grafana request.
+type AuthorizationTransport struct {
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// forwarding the "Authorization" header. The header is only set, when the
+// Grafana request contained it.
+func (at AuthorizationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorization, ok := req.Context().Value(authorizationKey{}).(string)
+	if !ok {
+		return at.Transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", authorization)
+
+	return at.Transport.RoundTrip(req)
+}
+
+// RateLimitTransport is the struct to limit the number of requests per second
+// of a RoundTripper via a token bucket. The RoundTripper must be shared by all
+// clients, which should use the same limit.
+type RateLimitTransport struct {
+	Transport http.RoundTripper
+
+	mu     sync.Mutex
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimitTransport returns a new RateLimitTransport, which allows qps
+// requests per second. The burst is the number of requests per second (at
+// least 1). The number of requests, which are sent at once, is limited by the
+// batch concurrency of the Prometheus client.
+func NewRateLimitTransport(transport http.RoundTripper, qps float64) *RateLimitTransport {
+	burst := max(1, int(math.Ceil(qps)))
+
+	return &RateLimitTransport{
+		Transport: transport,
+		qps:       qps,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+	}
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// rate limiting. If no token is available, the request waits until a token is
+// available or the context of the request is canceled.
+func (rlt *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if delay := rlt.reserve(time.Now()); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			rlt.release()
+			return nil, req.Context().Err()
+		}
+	}
+
+	return rlt.Transport.RoundTrip(req)
+}
+
+// reserve takes a token from the bucket and returns the duration, which must be
+// waited until the token is available. The number of tokens can become
+// negative, so that waiting requests are served in the order of their arrival.
+func (rlt *RateLimitTransport) reserve(now time.Time) time.Duration {
+	rlt.mu.Lock()
+	defer rlt.mu.Unlock()
+
+	if now.After(rlt.last) {
+		if !rlt.last.IsZero() {
+			rlt.tokens = min(rlt.burst, rlt.tokens+now.Sub(rlt.last).Seconds()*rlt.qps)
+		}
+		rlt.last = now
+	}
+
+	rlt.tokens--
+	if rlt.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rlt.tokens / rlt.qps * float64(time.Second))
+}
+
+// release returns a reserved token, which wasn't used, because the request was
+// canceled.
+func (rlt *RateLimitTransport) release() {
+	rlt.mu.Lock()
+	defer rlt.mu.Unlock()
+
+	rlt.tokens = min(rlt.burst, rlt.tokens+1)
+}
+
+// UnauthorizedTransport is the struct to return an error instead of the
+// response of a RoundTripper, when the server responds with "401
+// Unauthorized". This allows the callers to detect invalid credentials via
+// errors.Is or errors.As, instead of parsing the error messages of the
+// different clients.
+type UnauthorizedTransport struct {
+	Transport http.RoundTripper
+	Err       error
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// detecting invalid credentials. The body of a "401 Unauthorized" response is
+// discarded, so that the connection can be reused. If the request contains
+// forwarded credentials of the Grafana user (see CookiesTransport and
+// UserHeadersTransport), the response is returned unchanged, because the 401
+// can also be caused by these credentials, e.g. an expired session.
+func (ut UnauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := ut.Transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Header.Get("Cookie") != "" || req.Header.Get("X-Grafana-User") != "" {
+		return resp, nil
+	}
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+
+	return nil, ut.Err
+}
+
+// TokenFileTransport is the struct to add token auth to a RoundTripper, where
+// the token is read from a file, e.g. the token of a Kubernetes service
+// account. The file is read again when it was modified, so that rotated tokens
+// are used without recreating the RoundTripper.
+type TokenFileTransport struct {
+	Transport http.RoundTripper
+	Path      string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewTokenFileTransport returns a new TokenFileTransport, which reads the token
+// from the file with the given path.
+func NewTokenFileTransport(transport http.RoundTripper, path string) *TokenFileTransport {
+	return &TokenFileTransport{
+		Transport: transport,
+		Path:      path,
+	}
+}
+
+// RoundTrip implements the RoundTrip for our RoundTripper with support for
+// token auth, where the token is read from a file. If the file can not be
+// read, the request fails, because it would be rejected without a token.
+func (tft *TokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := tft.getToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return tft.Transport.RoundTrip(req)
+}
+
+// getToken returns the token from the file. The token is cached and the file
+// is only read again, when its modification time or size changed. Kubernetes
+// replaces the files of projected volumes via a symlink, which is followed by
+// os.Stat, so that a rotated token is also detected.
+func (tft *TokenFileTransport) getToken() (string, error) {
+	tft.mu.Lock()
+	defer tft.mu.Unlock()
+
+	info, err := os.Stat(tft.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	if tft.token != "" && info.ModTime().Equal(tft.modTime) && info.Size() == tft.size {
+		return tft.token, nil
+	}
+
+	content, err := os.ReadFile(tft.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("failed to read token file: %s is empty", tft.Path)
+	}
+
+	tft.token = token
+	tft.modTime = info.ModTime()
+	tft.size = info.Size()
+
+	return tft.token, nil
+}
@@ -2,10 +2,17 @@ package roundtripper
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/stretchr/testify/require"
 )
 
@@ -63,3 +70,211 @@ func TestTokenAuthTransporter(t *testing.T) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 	defer resp.Body.Close()
 }
+
+func TestProxyURL(t *testing.T) {
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	roundTripper := New(Options{ProxyURL: proxyURL})
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://prometheus.example.com:9090/api/v1/status/buildinfo", nil)
+	resp, err := roundTripper.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, "prometheus.example.com:9090", proxiedHost)
+}
+
+func TestQueryParametersTransport(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+	}))
+	defer server.Close()
+
+	roundTripper := QueryParametersTransport{
+		Transport:       DefaultRoundTripper,
+		QueryParameters: url.Values{"latency_offset": []string{"30s"}},
+	}
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/api/v1/query?query=up", nil)
+	resp, err := roundTripper.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, "up", query.Get("query"))
+	require.Equal(t, "30s", query.Get("latency_offset"))
+}
+
+func TestUserHeadersTransport(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	defer server.Close()
+
+	roundTripper := UserHeadersTransport{
+		Transport: DefaultRoundTripper,
+	}
+
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 2})
+	ctx = backend.WithUser(ctx, &backend.User{Login: "jane", Email: "jane@example.com", Role: "Viewer"})
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/query?query=up", nil)
+	resp, err := roundTripper.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, "jane", headers.Get("X-Grafana-User"))
+	require.Equal(t, "jane@example.com", headers.Get("X-Grafana-Email"))
+	require.Equal(t, "Viewer", headers.Get("X-Grafana-Role"))
+	require.Equal(t, "2", headers.Get("X-Grafana-Org-Id"))
+	require.Empty(t, req.Header.Get("X-Grafana-User"))
+
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/api/v1/query?query=up", nil)
+	resp, err = roundTripper.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Empty(t, headers.Get("X-Grafana-User"))
+	require.Empty(t, headers.Get("X-Grafana-Org-Id"))
+}
+
+func TestCookiesTransport(t *testing.T) {
+	var cookies []*http.Cookie
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies = r.Cookies()
+	}))
+	defer server.Close()
+
+	roundTripper := CookiesTransport{
+		Transport: DefaultRoundTripper,
+		Cookies:   []string{"_oauth2_proxy"},
+	}
+
+	ctx := WithCookies(context.Background(), "grafana_session=abc; _oauth2_proxy=def")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/query?query=up", nil)
+	resp, err := roundTripper.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Len(t, cookies, 1)
+	require.Equal(t, "_oauth2_proxy", cookies[0].Name)
+	require.Equal(t, "def", cookies[0].Value)
+	require.Empty(t, req.Cookies())
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	roundTripper := NewRateLimitTransport(DefaultRoundTripper, 2)
+
+	t.Run("should wait for a token", func(t *testing.T) {
+		now := time.Now()
+		require.Equal(t, time.Duration(0), roundTripper.reserve(now))
+		require.Equal(t, time.Duration(0), roundTripper.reserve(now))
+		require.Equal(t, 500*time.Millisecond, roundTripper.reserve(now))
+		require.Equal(t, time.Second, roundTripper.reserve(now))
+		require.Equal(t, 250*time.Millisecond, roundTripper.reserve(now.Add(1250*time.Millisecond)))
+	})
+
+	t.Run("should return an error when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/query?query=up", nil)
+		//nolint:bodyclose
+		_, err := roundTripper.RoundTrip(req)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("should set the burst to the requests per second", func(t *testing.T) {
+		require.Equal(t, float64(5), NewRateLimitTransport(DefaultRoundTripper, 4.5).burst)
+		require.Equal(t, float64(1), NewRateLimitTransport(DefaultRoundTripper, 0.1).burst)
+	})
+}
+
+func TestUnauthorizedTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(testTokenAuth))
+	defer server.Close()
+
+	errUnauthorized := errors.New("credentials invalid")
+
+	t.Run("should return response for valid credentials", func(t *testing.T) {
+		roundTripper := UnauthorizedTransport{Transport: TokenAuthTransporter{Transport: DefaultRoundTripper, Token: "admin"}, Err: errUnauthorized}
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+		resp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should return error for invalid credentials", func(t *testing.T) {
+		roundTripper := UnauthorizedTransport{Transport: TokenAuthTransporter{Transport: DefaultRoundTripper, Token: "expired"}, Err: errUnauthorized}
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+		//nolint:bodyclose
+		_, err := roundTripper.RoundTrip(req)
+		require.ErrorIs(t, err, errUnauthorized)
+	})
+
+	t.Run("should return response for forwarded user credentials", func(t *testing.T) {
+		roundTripper := CookiesTransport{Transport: UnauthorizedTransport{Transport: TokenAuthTransporter{Transport: DefaultRoundTripper, Token: "expired"}, Err: errUnauthorized}, Cookies: []string{"_oauth2_proxy"}}
+
+		req, _ := http.NewRequestWithContext(WithCookies(context.Background(), "_oauth2_proxy=expired"), http.MethodGet, server.URL, nil)
+		resp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestTokenFileTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	roundTripper := NewTokenFileTransport(DefaultRoundTripper, path)
+
+	request := func() (string, error) {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+		resp, err := roundTripper.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("should return error for missing file", func(t *testing.T) {
+		_, err := request()
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("should use token from file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("token-1\n"), 0o600))
+
+		authorization, err := request()
+		require.NoError(t, err)
+		require.Equal(t, "Bearer token-1", authorization)
+	})
+
+	t.Run("should reload rotated token", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("token-2\n"), 0o600))
+		require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+		authorization, err := request()
+		require.NoError(t, err)
+		require.Equal(t, "Bearer token-2", authorization)
+	})
+}
@@ -1,9 +1,11 @@
 import React, { ChangeEvent } from 'react';
 import {
   InlineField,
+  InlineSwitch,
   Input,
   RadioButtonGroup,
   SecretInput,
+  TagsInput,
   useStyles2,
 } from '@grafana/ui';
 import {
@@ -11,7 +13,15 @@ import {
   GrafanaTheme2,
 } from '@grafana/data';
 
-import { Options, OptionsPrometheusAuthMethod, OptionsSecure } from '../types';
+import {
+  Options,
+  OptionsPrometheusAuthMethod,
+  OptionsIstioNodeHealth,
+  OptionsIstioDurationUnit,
+  OptionsPrometheusFlavor,
+  OptionsPrometheusDiscoveryMode,
+  OptionsSecure,
+} from '../types';
 import { css } from '@emotion/css';
 
 interface Props
@@ -48,6 +58,59 @@ export function ConfigEditor({ options, onOptionsChange }: Props) {
         />
       </InlineField>
 
+      <InlineField label="Flavor" labelWidth={25}>
+        <RadioButtonGroup<OptionsPrometheusFlavor>
+          options={[
+            { label: 'Prometheus', value: 'prometheus' },
+            { label: 'VictoriaMetrics', value: 'victoriametrics' },
+          ]}
+          value={options.jsonData.prometheusFlavor || 'prometheus'}
+          onChange={(value: OptionsPrometheusFlavor) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...options.jsonData,
+                prometheusFlavor: value,
+              },
+            });
+          }}
+        />
+      </InlineField>
+
+      <InlineField label="Discovery Mode" labelWidth={25}>
+        <RadioButtonGroup<OptionsPrometheusDiscoveryMode>
+          options={[
+            { label: 'Label Values', value: 'labelValues' },
+            { label: 'Series', value: 'series' },
+          ]}
+          value={jsonData.prometheusDiscoveryMode || 'labelValues'}
+          onChange={(value: OptionsPrometheusDiscoveryMode) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusDiscoveryMode: value,
+              },
+            });
+          }}
+        />
+      </InlineField>
+
+      <InlineField label="Demo Mode" labelWidth={25} interactive>
+        <InlineSwitch
+          value={jsonData.prometheusDemoMode || false}
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusDemoMode: event.currentTarget.checked,
+              },
+            });
+          }}
+        />
+      </InlineField>
+
       <InlineField label="Authentication Method" labelWidth={25}>
         <RadioButtonGroup<OptionsPrometheusAuthMethod>
           options={[
@@ -151,6 +214,308 @@ export function ConfigEditor({ options, onOptionsChange }: Props) {
         </>
       )}
 
+      <InlineField label="Client Certificate File" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusClientCertFile: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusClientCertFile}
+          placeholder="/etc/prometheus/tls/tls.crt"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Client Key File" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusClientKeyFile: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusClientKeyFile}
+          placeholder="/etc/prometheus/tls/tls.key"
+          width={40}
+        />
+      </InlineField>
+
+      <InlineField label="Forward User Headers" labelWidth={25} interactive>
+        <InlineSwitch
+          value={jsonData.prometheusForwardUserHeaders || false}
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusForwardUserHeaders: event.currentTarget.checked,
+              },
+            });
+          }}
+        />
+      </InlineField>
+
+      <InlineField label="Allowed Cookies" labelWidth={25} interactive>
+        <TagsInput
+          tags={jsonData.keepCookies || []}
+          onChange={(cookies: string[]) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                keepCookies: cookies,
+              },
+            });
+          }}
+          placeholder="_oauth2_proxy"
+          width={40}
+        />
+      </InlineField>
+
+      <InlineField label="Query Parameters" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusQueryParams: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusQueryParams}
+          placeholder="latency_offset=30s"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Proxy Url" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusProxyUrl: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusProxyUrl}
+          placeholder="http://proxy.example.com:3128"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Maximum Window" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusMaxWindow: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusMaxWindow}
+          placeholder="4h"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Round Time Range" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusRoundTo: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusRoundTo}
+          placeholder="60s"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Query Timeout" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusQueryTimeout: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusQueryTimeout}
+          placeholder="30s"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Query Limit" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusQueryLimit: parseInt(event.target.value, 10),
+              },
+            });
+          }}
+          value={jsonData.prometheusQueryLimit}
+          placeholder="10000"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Default Range" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusDefaultRange: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusDefaultRange}
+          placeholder="7d"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="List Refresh" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusListRefresh: event.target.value,
+              },
+            });
+          }}
+          value={jsonData.prometheusListRefresh}
+          placeholder="1m"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Rate Limit" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusRateLimit: parseFloat(event.target.value),
+              },
+            });
+          }}
+          value={jsonData.prometheusRateLimit}
+          placeholder="10"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Batch Concurrency" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusBatchConcurrency: parseInt(event.target.value, 10),
+              },
+            });
+          }}
+          value={jsonData.prometheusBatchConcurrency}
+          placeholder="10"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Max Idle Conns Per Host" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusTransport: {
+                  ...jsonData.prometheusTransport,
+                  maxIdleConnsPerHost: parseInt(event.target.value, 10),
+                },
+              },
+            });
+          }}
+          value={jsonData.prometheusTransport?.maxIdleConnsPerHost}
+          placeholder="100"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Idle Conn Timeout" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusTransport: {
+                  ...jsonData.prometheusTransport,
+                  idleConnTimeout: event.target.value,
+                },
+              },
+            });
+          }}
+          value={jsonData.prometheusTransport?.idleConnTimeout}
+          placeholder="90s"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Response Header Timeout" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusTransport: {
+                  ...jsonData.prometheusTransport,
+                  responseHeaderTimeout: event.target.value,
+                },
+              },
+            });
+          }}
+          value={jsonData.prometheusTransport?.responseHeaderTimeout}
+          placeholder="30s"
+          width={40}
+        />
+      </InlineField>
+      <InlineField label="Keep Alive" labelWidth={25} interactive>
+        <Input
+          onChange={(event: ChangeEvent<HTMLInputElement>) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...jsonData,
+                prometheusTransport: {
+                  ...jsonData.prometheusTransport,
+                  keepAlive: event.target.value,
+                },
+              },
+            });
+          }}
+          value={jsonData.prometheusTransport?.keepAlive}
+          placeholder="30s"
+          width={40}
+        />
+      </InlineField>
+
       <div className={styles.container}>
         <h3>Istio</h3>
         <InlineField label="Warning Threshold" labelWidth={25} interactive>
@@ -183,6 +548,58 @@ export function ConfigEditor({ options, onOptionsChange }: Props) {
             width={40}
           />
         </InlineField>
+        <InlineField label="Highlight Throttled" labelWidth={25} interactive>
+          <InlineSwitch
+            value={jsonData.istioHighlightThrottled || false}
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioHighlightThrottled: event.currentTarget.checked,
+                },
+              });
+            }}
+          />
+        </InlineField>
+        <InlineField label="Node Health" labelWidth={25}>
+          <RadioButtonGroup<OptionsIstioNodeHealth>
+            options={[
+              { label: 'Server', value: 'server' },
+              { label: 'Client', value: 'client' },
+              { label: 'Worst', value: 'worst' },
+              { label: 'Weighted', value: 'weighted' },
+            ]}
+            value={jsonData.istioNodeHealth || 'server'}
+            onChange={(value: OptionsIstioNodeHealth) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioNodeHealth: value,
+                },
+              });
+            }}
+          />
+        </InlineField>
+        {jsonData.istioNodeHealth === 'weighted' && (
+          <InlineField label="Client Weight" labelWidth={25} interactive>
+            <Input
+              onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                onOptionsChange({
+                  ...options,
+                  jsonData: {
+                    ...jsonData,
+                    istioNodeHealthClientWeight: parseFloat(event.target.value),
+                  },
+                });
+              }}
+              value={jsonData.istioNodeHealthClientWeight}
+              placeholder="0.5"
+              width={40}
+            />
+          </InlineField>
+        )}
 
         <InlineField label="Workload Dashboard" labelWidth={25} interactive>
           <Input
@@ -214,6 +631,333 @@ export function ConfigEditor({ options, onOptionsChange }: Props) {
             width={40}
           />
         </InlineField>
+        <InlineField label="Gateway Dashboard" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioGatewayDashboard: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.istioGatewayDashboard}
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Edge Dashboard" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioEdgeDashboard: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.istioEdgeDashboard}
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Dashboard Params" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioDashboardParams: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.istioDashboardParams}
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Revision Label" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioRevisionLabel: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.istioRevisionLabel}
+            placeholder="istio_io_rev"
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Revision" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioRevision: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.istioRevision}
+            width={40}
+          />
+        </InlineField>
+        <InlineField
+          label="Excluded Destinations"
+          labelWidth={25}
+          interactive
+        >
+          <TagsInput
+            tags={jsonData.istioExcludedDestinations || []}
+            onChange={(destinations: string[]) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioExcludedDestinations: destinations,
+                },
+              });
+            }}
+            placeholder="*.istio-system"
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Duration Metric" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioDurationMetric: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.istioDurationMetric}
+            placeholder="istio_request_duration_milliseconds"
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Duration Unit" labelWidth={25}>
+          <RadioButtonGroup<OptionsIstioDurationUnit>
+            options={[
+              { label: 'Milliseconds', value: 'ms' },
+              { label: 'Seconds', value: 's' },
+            ]}
+            value={jsonData.istioDurationUnit}
+            onChange={(value: OptionsIstioDurationUnit) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioDurationUnit: value,
+                },
+              });
+            }}
+          />
+        </InlineField>
+        <InlineField label="Native Histograms" labelWidth={25} interactive>
+          <InlineSwitch
+            value={jsonData.istioNativeHistograms || false}
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioNativeHistograms: event.currentTarget.checked,
+                },
+              });
+            }}
+          />
+        </InlineField>
+        <InlineField label="Multi-Cluster" labelWidth={25} interactive>
+          <InlineSwitch
+            value={jsonData.istioMultiCluster || false}
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioMultiCluster: event.currentTarget.checked,
+                },
+              });
+            }}
+          />
+        </InlineField>
+        <InlineField label="Cardinality Limit" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  istioCardinalityLimit: parseInt(event.target.value, 10),
+                },
+              });
+            }}
+            value={jsonData.istioCardinalityLimit}
+            width={40}
+          />
+        </InlineField>
+      </div>
+
+      <div className={styles.container}>
+        <h3>Alertmanager</h3>
+        <InlineField label="Grafana Alerts" labelWidth={25} interactive>
+          <InlineSwitch
+            value={jsonData.grafanaAlerts || false}
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  grafanaAlerts: event.currentTarget.checked,
+                },
+              });
+            }}
+          />
+        </InlineField>
+        <InlineField label="Url" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  alertmanagerUrl: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.alertmanagerUrl}
+            width={40}
+          />
+        </InlineField>
+        <InlineField label="Authentication Method" labelWidth={25}>
+          <RadioButtonGroup<OptionsPrometheusAuthMethod>
+            options={[
+              { label: 'None', value: 'none' },
+              { label: 'Basic Auth', value: 'basic' },
+              { label: 'Bearer Token', value: 'token' },
+            ]}
+            value={jsonData.alertmanagerAuthMethod || 'none'}
+            onChange={(value: OptionsPrometheusAuthMethod) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  alertmanagerAuthMethod: value,
+                },
+              });
+            }}
+          />
+        </InlineField>
+
+        {jsonData.alertmanagerAuthMethod === 'basic' && (
+          <>
+            <InlineField label="Username" labelWidth={25} interactive>
+              <Input
+                onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                  onOptionsChange({
+                    ...options,
+                    jsonData: {
+                      ...jsonData,
+                      alertmanagerUsername: event.target.value,
+                    },
+                  });
+                }}
+                value={jsonData.alertmanagerUsername}
+                width={40}
+              />
+            </InlineField>
+            <InlineField label="Password" labelWidth={25} interactive>
+              <SecretInput
+                required
+                isConfigured={secureJsonFields.alertmanagerPassword}
+                value={secureJsonData?.alertmanagerPassword}
+                width={40}
+                onReset={() => {
+                  onOptionsChange({
+                    ...options,
+                    secureJsonFields: {
+                      ...options.secureJsonFields,
+                      alertmanagerPassword: false,
+                    },
+                    secureJsonData: {
+                      ...options.secureJsonData,
+                      alertmanagerPassword: '',
+                    },
+                  });
+                }}
+                onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                  onOptionsChange({
+                    ...options,
+                    secureJsonData: {
+                      ...options.secureJsonData,
+                      alertmanagerPassword: event.target.value,
+                    },
+                  });
+                }}
+              />
+            </InlineField>
+          </>
+        )}
+
+        {jsonData.alertmanagerAuthMethod === 'token' && (
+          <InlineField label="Bearer Token" labelWidth={25} interactive>
+            <SecretInput
+              required
+              isConfigured={secureJsonFields.alertmanagerToken}
+              value={secureJsonData?.alertmanagerToken}
+              width={40}
+              onReset={() => {
+                onOptionsChange({
+                  ...options,
+                  secureJsonFields: {
+                    ...options.secureJsonFields,
+                    alertmanagerToken: false,
+                  },
+                  secureJsonData: {
+                    ...options.secureJsonData,
+                    alertmanagerToken: '',
+                  },
+                });
+              }}
+              onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                onOptionsChange({
+                  ...options,
+                  secureJsonData: {
+                    ...options.secureJsonData,
+                    alertmanagerToken: event.target.value,
+                  },
+                });
+              }}
+            />
+          </InlineField>
+        )}
+      </div>
+
+      <div className={styles.container}>
+        <h3>Storage</h3>
+        <InlineField label="Directory" labelWidth={25} interactive>
+          <Input
+            onChange={(event: ChangeEvent<HTMLInputElement>) => {
+              onOptionsChange({
+                ...options,
+                jsonData: {
+                  ...jsonData,
+                  storageDirectory: event.target.value,
+                },
+              });
+            }}
+            value={jsonData.storageDirectory}
+            width={40}
+          />
+        </InlineField>
       </div>
     </>
   );
@@ -0,0 +1,45 @@
+import React from 'react';
+import { Alert } from '@grafana/ui';
+import { TimeRange } from '@grafana/data';
+import { useAsync } from 'react-use';
+
+import { DataSource } from '../datasource';
+import { Query } from '../types';
+
+interface Props {
+  datasource: DataSource;
+  range?: TimeRange;
+  query: Query;
+}
+
+// GraphCostWarning estimates the cost of the graph of the query and shows the
+// returned warning, so that users notice expensive selections before they run
+// the query. Errors of the estimation are ignored, because they should not
+// block the query editor.
+export function GraphCostWarning({ datasource, range, query }: Props) {
+  const state = useAsync(async (): Promise<string | undefined> => {
+    if (!range || !query.namespace || !query.metrics?.length) {
+      return undefined;
+    }
+
+    const cost = await datasource.estimateGraphCost(query, range);
+    return cost.warning;
+  }, [
+    datasource,
+    range,
+    query.namespace,
+    query.application,
+    query.workload,
+    query.metrics,
+  ]);
+
+  if (!state.value) {
+    return null;
+  }
+
+  return (
+    <Alert title="Expensive graph" severity="warning">
+      {state.value}
+    </Alert>
+  );
+}
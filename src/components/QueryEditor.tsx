@@ -6,6 +6,7 @@ import {
   InlineField,
   InlineFieldRow,
   InlineSwitch,
+  Input,
   MultiCombobox,
 } from '@grafana/ui';
 import { QueryEditorProps } from '@grafana/data';
@@ -16,6 +17,7 @@ import { NamespaceField } from './NamespaceField';
 import { ApplicationField } from './ApplicationField';
 import { WorkloadField } from './WorkloadField';
 import { FiltersField } from './FiltersField';
+import { GraphCostWarning } from './GraphCostWarning';
 
 type Props = QueryEditorProps<DataSource, Query, Options>;
 
@@ -27,17 +29,19 @@ export function QueryEditor({
   onRunQuery,
 }: Props) {
   const [graphOptionsIsOpen, setGraphOptionsIsOpen] = useState(false);
+  const isGraph = query.queryType !== 'canary';
 
   return (
     <>
       <InlineFieldRow>
-        <InlineField label="Graph Type" labelWidth={25}>
+        <InlineField label="Query Type" labelWidth={25}>
           <Combobox<QueryType>
             value={query.queryType}
             options={[
               { label: 'Application Graph', value: 'applicationgraph' },
               { label: 'Workload Graph', value: 'workloadgraph' },
               { label: 'Namespace Graph', value: 'namespacegraph' },
+              { label: 'Canary', value: 'canary' },
             ]}
             onChange={(option: ComboboxOption<QueryType>) => {
               onChange({
@@ -70,7 +74,8 @@ export function QueryEditor({
           }}
         />
 
-        {query.queryType === 'applicationgraph' && (
+        {(query.queryType === 'applicationgraph' ||
+          query.queryType === 'canary') && (
           <ApplicationField
             datasource={datasource}
             range={range}
@@ -97,90 +102,131 @@ export function QueryEditor({
         )}
       </InlineFieldRow>
 
-      <Collapse
-        label="Graph Options"
-        isOpen={graphOptionsIsOpen}
-        onToggle={() => setGraphOptionsIsOpen(!graphOptionsIsOpen)}
-      >
+      {query.queryType === 'canary' && (
         <InlineFieldRow>
-          <InlineField label="Metrics" labelWidth={25}>
-            <MultiCombobox
-              data-testid="metrics-combobox"
-              width="auto"
-              minWidth={32}
-              maxWidth={32}
-              isClearable={true}
-              value={query.metrics}
-              options={[
-                { label: 'gRPC Requests', value: 'grpcRequests' },
-                {
-                  label: 'gRPC Request Duration',
-                  value: 'grpcRequestDuration',
-                },
-                { label: 'gRPC Sent Messages', value: 'grpcSentMessages' },
-                {
-                  label: 'gRPC Received Messages',
-                  value: 'grpcReceivedMessages',
-                },
-                { label: 'HTTP Requests', value: 'httpRequests' },
-                {
-                  label: 'HTTP RequestDuration',
-                  value: 'httpRequestDuration',
-                },
-                { label: 'TCP Sent Bytes', value: 'tcpSentBytes' },
-                { label: 'TCP Received Bytes', value: 'tcpReceivedBytes' },
-              ]}
-              onChange={(option: Array<ComboboxOption<string>>) => {
-                onChange({
-                  ...query,
-                  metrics: Array.from(option.values()).map(
-                    (value) => value.value,
-                  ),
-                });
+          <InlineField label="Baseline Version" labelWidth={25} interactive>
+            <Input
+              value={query.baselineVersion}
+              placeholder="v1"
+              width={25}
+              onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                onChange({ ...query, baselineVersion: event.target.value });
               }}
+              onBlur={onRunQuery}
             />
           </InlineField>
-        </InlineFieldRow>
-
-        <InlineFieldRow>
-          <InlineField label="Idle Edges" labelWidth={25}>
-            <InlineSwitch
-              value={query.idleEdges || false}
+          <InlineField label="Canary Version" labelWidth={25} interactive>
+            <Input
+              value={query.canaryVersion}
+              placeholder="v2"
+              width={25}
               onChange={(event: ChangeEvent<HTMLInputElement>) => {
-                onChange({ ...query, idleEdges: event.target.checked });
+                onChange({ ...query, canaryVersion: event.target.value });
               }}
+              onBlur={onRunQuery}
             />
           </InlineField>
         </InlineFieldRow>
+      )}
 
-        <InlineFieldRow>
-          <FiltersField
-            datasource={datasource}
-            range={range}
-            filterType="source"
-            namespace={query.namespace}
-            application={query.application}
-            workload={query.workload}
-            filters={query.sourceFilters}
-            onFiltersChange={(filters) => {
-              onChange({ ...query, sourceFilters: filters });
-            }}
-          />
+      {isGraph && (
+        <Collapse
+          label="Graph Options"
+          isOpen={graphOptionsIsOpen}
+          onToggle={() => setGraphOptionsIsOpen(!graphOptionsIsOpen)}
+        >
+          <InlineFieldRow>
+            <InlineField label="Metrics" labelWidth={25}>
+              <MultiCombobox
+                data-testid="metrics-combobox"
+                width="auto"
+                minWidth={32}
+                maxWidth={32}
+                isClearable={true}
+                value={query.metrics}
+                options={[
+                  { label: 'gRPC Requests', value: 'grpcRequests' },
+                  {
+                    label: 'gRPC Request Duration',
+                    value: 'grpcRequestDuration',
+                  },
+                  { label: 'gRPC Sent Messages', value: 'grpcSentMessages' },
+                  {
+                    label: 'gRPC Received Messages',
+                    value: 'grpcReceivedMessages',
+                  },
+                  { label: 'HTTP Requests', value: 'httpRequests' },
+                  {
+                    label: 'HTTP RequestDuration',
+                    value: 'httpRequestDuration',
+                  },
+                  { label: 'TCP Sent Bytes', value: 'tcpSentBytes' },
+                  { label: 'TCP Received Bytes', value: 'tcpReceivedBytes' },
+                ]}
+                onChange={(option: Array<ComboboxOption<string>>) => {
+                  onChange({
+                    ...query,
+                    metrics: Array.from(option.values()).map(
+                      (value) => value.value,
+                    ),
+                  });
+                }}
+              />
+            </InlineField>
+          </InlineFieldRow>
 
-          <FiltersField
-            datasource={datasource}
-            range={range}
-            filterType="destination"
-            namespace={query.namespace}
-            application={query.application}
-            workload={query.workload}
-            filters={query.destinationFilters}
-            onFiltersChange={(filters) => {
-              onChange({ ...query, destinationFilters: filters });
-            }}
-          />
-        </InlineFieldRow>
-      </Collapse>
+          <InlineFieldRow>
+            <InlineField label="Idle Edges" labelWidth={25}>
+              <InlineSwitch
+                value={query.idleEdges || false}
+                onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                  onChange({ ...query, idleEdges: event.target.checked });
+                }}
+              />
+            </InlineField>
+            <InlineField label="Idle Nodes" labelWidth={25}>
+              <InlineSwitch
+                value={query.idleNodes || false}
+                onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                  onChange({ ...query, idleNodes: event.target.checked });
+                }}
+              />
+            </InlineField>
+          </InlineFieldRow>
+
+          <InlineFieldRow>
+            <FiltersField
+              datasource={datasource}
+              range={range}
+              filterType="source"
+              namespace={query.namespace}
+              application={query.application}
+              workload={query.workload}
+              filters={query.sourceFilters}
+              onFiltersChange={(filters) => {
+                onChange({ ...query, sourceFilters: filters });
+              }}
+            />
+
+            <FiltersField
+              datasource={datasource}
+              range={range}
+              filterType="destination"
+              namespace={query.namespace}
+              application={query.application}
+              workload={query.workload}
+              filters={query.destinationFilters}
+              onFiltersChange={(filters) => {
+                onChange({ ...query, destinationFilters: filters });
+              }}
+            />
+          </InlineFieldRow>
+        </Collapse>
+      )}
+
+      {isGraph && (
+        <GraphCostWarning datasource={datasource} range={range} query={query} />
+      )}
     </>
   );
 }
@@ -7,6 +7,7 @@ import {
   ComboboxOption,
   RadioButtonGroup,
   Input,
+  InlineSwitch,
 } from '@grafana/ui';
 
 import { DataSource } from '../datasource';
@@ -160,6 +161,26 @@ export function VariableQueryEditor({
             )}
           </>
         )}
+
+      {(query.queryType === 'namespaces' ||
+        query.queryType === 'applications' ||
+        query.queryType === 'workloads') && (
+          <InlineFieldRow>
+            <InlineField
+              label="Sort by Traffic"
+              labelWidth={25}
+              tooltip="Sort the values by their request rate in the selected time range, instead of alphabetically"
+            >
+              <InlineSwitch
+                value={query.sortByTraffic || false}
+                onChange={(event: ChangeEvent<HTMLInputElement>) => {
+                  onChange({ ...query, sortByTraffic: event.target.checked });
+                  onRunQuery();
+                }}
+              />
+            </InlineField>
+          </InlineFieldRow>
+        )}
     </>
   );
 }
@@ -7,17 +7,25 @@ import {
   MetricFindValue,
   DataQueryRequest,
   DataQueryResponse,
+  TimeRange,
 } from '@grafana/data';
 import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
 import { lastValueFrom, Observable } from 'rxjs';
 
-import { Query, Options, DEFAULT_QUERY } from './types';
+import {
+  Query,
+  Options,
+  LinkContext,
+  GraphCost,
+  DEFAULT_QUERY,
+} from './types';
 import { VariableSupport } from './variablesupport';
 
 export class DataSource extends DataSourceWithBackend<Query, Options> {
   constructor(instanceSettings: DataSourceInstanceSettings<Options>) {
     super(instanceSettings);
     this.variables = new VariableSupport(this);
+    this.annotations = {};
   }
 
   getDefaultQuery(_: CoreApp): Partial<Query> {
@@ -57,7 +65,41 @@ export class DataSource extends DataSourceWithBackend<Query, Options> {
   }
 
   query(request: DataQueryRequest<Query>): Observable<DataQueryResponse> {
-    return super.query(request);
+    // The relative time range, the time zone and the "datasource" variable of
+    // the dashboard are added to all queries, so that the links of the graphs
+    // to other dashboards show the same context as the panel.
+    const raw = request.range?.raw;
+    const linkContext: LinkContext = {
+      rawFrom: typeof raw?.from === 'string' ? raw.from : undefined,
+      rawTo: typeof raw?.to === 'string' ? raw.to : undefined,
+      timezone: request.timezone,
+      dashboardDatasource: getTemplateSrv()
+        .getVariables()
+        .some((variable) => variable.name === 'datasource')
+        ? getTemplateSrv().replace('${datasource}', request.scopedVars)
+        : undefined,
+    };
+
+    return super.query({
+      ...request,
+      targets: request.targets.map((target) => ({ ...target, ...linkContext })),
+    });
+  }
+
+  // estimateGraphCost returns the estimated number of series and samples of
+  // the graph for the given query, so that the query editor can warn about
+  // expensive selections before the graph is generated.
+  estimateGraphCost(query: Query, range: TimeRange): Promise<GraphCost> {
+    return this.postResource<GraphCost>(
+      'graph/cost',
+      this.applyTemplateVariables(query, {}),
+      {
+        params: {
+          from: range.from.valueOf(),
+          to: range.to.valueOf(),
+        },
+      },
+    );
   }
 
   async metricFindQuery(
@@ -126,6 +168,73 @@ export class DataSource extends DataSourceWithBackend<Query, Options> {
       return false;
     }
 
+    if (
+      query.queryType === 'servicegraph' &&
+      (!query.namespace || !query.service)
+    ) {
+      return false;
+    }
+
+    if (
+      query.queryType === 'canary' &&
+      (!query.namespace ||
+        !query.application ||
+        !query.baselineVersion ||
+        !query.canaryVersion)
+    ) {
+      return false;
+    }
+
+    if (
+      query.queryType === 'upstreams' &&
+      (!query.namespace || (!query.workload && !query.service))
+    ) {
+      return false;
+    }
+
+    if (
+      query.queryType === 'downstreams' &&
+      (!query.namespace || (!query.workload && !query.service))
+    ) {
+      return false;
+    }
+
+    if (
+      query.queryType === 'latencyheatmap' &&
+      (!query.namespace || !query.service)
+    ) {
+      return false;
+    }
+
+    if (query.queryType === 'rollouts' && !query.namespace) {
+      return false;
+    }
+
+    if (query.queryType === 'errorbudget' && !query.namespace) {
+      return false;
+    }
+
+    if (query.queryType === 'latencyslo' && !query.namespace) {
+      return false;
+    }
+
+    if (
+      query.queryType === 'workloadtraffic' &&
+      (!query.namespace || !query.workload)
+    ) {
+      return false;
+    }
+
+    if (
+      query.queryType === 'path' &&
+      (!query.sourceNamespace ||
+        !query.sourceWorkload ||
+        !query.destinationNamespace ||
+        !query.destinationWorkload)
+    ) {
+      return false;
+    }
+
     return true;
   }
 }
@@ -54,6 +54,90 @@ export const DEFAULT_QUERIES: Record<QueryType, Partial<Query>> = {
     sourceFilters: [],
     destinationFilters: [],
   },
+  servicegraph: {
+    namespace: '',
+    service: '',
+    metrics: [
+      'grpcRequests',
+      'httpRequests',
+      'tcpSentBytes',
+      'tcpReceivedBytes',
+    ],
+    sourceFilters: [],
+    destinationFilters: [],
+  },
+  canary: {
+    namespace: '',
+    application: '',
+    baselineVersion: '',
+    canaryVersion: '',
+  },
+  namespacematrix: {},
+  upstreams: {
+    namespace: '',
+    workload: '',
+    service: '',
+  },
+  downstreams: {
+    namespace: '',
+    workload: '',
+    service: '',
+  },
+  path: {
+    sourceNamespace: '',
+    sourceWorkload: '',
+    destinationNamespace: '',
+    destinationWorkload: '',
+    metrics: [
+      'grpcRequests',
+      'httpRequests',
+      'tcpSentBytes',
+      'tcpReceivedBytes',
+    ],
+  },
+  egress: {
+    namespace: '',
+  },
+  ingress: {
+    namespace: '',
+    gateway: '',
+  },
+  healthscore: {
+    namespace: '',
+  },
+  mtlscoverage: {
+    namespace: '',
+  },
+  latencyheatmap: {
+    namespace: '',
+    service: '',
+    sourceNamespace: '',
+    sourceWorkload: '',
+  },
+  rollouts: {
+    namespace: '',
+    application: '',
+  },
+  errorbudget: {
+    namespace: '',
+    service: '',
+    target: 99.9,
+    window: '30d',
+  },
+  latencyslo: {
+    namespace: '',
+    service: '',
+    sourceNamespace: '',
+    sourceWorkload: '',
+    latencyThreshold: 500,
+  },
+  namespacehealth: {
+    namespace: '',
+  },
+  workloadtraffic: {
+    namespace: '',
+    workload: '',
+  },
 };
 
 export const DEFAULT_QUERY: Partial<Query> = {
@@ -70,76 +154,413 @@ export type QueryType =
   | 'filters'
   | 'applicationgraph'
   | 'workloadgraph'
-  | 'namespacegraph';
+  | 'namespacegraph'
+  | 'servicegraph'
+  | 'canary'
+  | 'namespacematrix'
+  | 'upstreams'
+  | 'downstreams'
+  | 'path'
+  | 'egress'
+  | 'ingress'
+  | 'healthscore'
+  | 'mtlscoverage'
+  | 'latencyheatmap'
+  | 'rollouts'
+  | 'errorbudget'
+  | 'latencyslo'
+  | 'namespacehealth'
+  | 'workloadtraffic';
 
 export interface Query
   extends DataQuery,
+  QueryModelNamespaces,
   QueryModelApplications,
   QueryModelWorkloads,
   QueryModelFilters,
   QueryModelApplicationGraph,
   QueryModelWorkloadGraph,
-  QueryModelNamespaceGraph {
+  QueryModelNamespaceGraph,
+  QueryModelServiceGraph,
+  QueryModelCanary,
+  QueryModelDependencies,
+  QueryModelPath,
+  QueryModelEgress,
+  QueryModelIngress,
+  QueryModelHealthScore,
+  QueryModelMTLSCoverage,
+  QueryModelLatencyHeatmap,
+  QueryModelRollouts,
+  QueryModelErrorBudget,
+  QueryModelLatencySLO,
+  QueryModelNamespaceHealth,
+  QueryModelWorkloadTraffic {
   queryType: QueryType;
 }
 
-interface QueryModelApplications {
+export interface LinkContext {
+  rawFrom?: string;
+  rawTo?: string;
+  timezone?: string;
+  dashboardDatasource?: string;
+}
+
+export interface GraphCost {
+  namespace: string;
+  series: number;
+  samples: number;
+  metrics: GraphMetricCost[];
+  warning?: string;
+}
+
+export interface GraphMetricCost {
+  metric: string;
+  series: number;
+  samples: number;
+}
+
+interface Pagination {
+  search?: string;
+  limit?: number;
+  offset?: number;
+}
+
+interface QueryModelNamespaces extends Pagination {
+  sortByTraffic?: boolean;
+}
+
+interface QueryModelApplications extends Pagination {
   namespace?: string;
+  sortByTraffic?: boolean;
 }
 
-interface QueryModelWorkloads {
+interface QueryModelWorkloads extends Pagination {
   namespace?: string;
+  sortByTraffic?: boolean;
 }
 
 export type QueryModelFiltersFilterType = 'source' | 'destination';
 
-interface QueryModelFilters {
+interface QueryModelFilters extends Pagination {
   filterType?: QueryModelFiltersFilterType;
   namespace?: string;
   application?: string;
   workload?: string;
 }
 
-interface QueryModelApplicationGraph {
+interface QueryModelApplicationGraph extends LinkContext {
   namespace?: string;
   application?: string;
   metrics?: string[];
   idleEdges?: boolean;
+  hideServiceNodes?: boolean;
+  detectIssues?: boolean;
+  excludeMirrors?: boolean;
+  locality?: boolean;
+  groupingLabels?: string[];
+  includeResponseClasses?: string[];
+  aggregateByApp?: boolean;
+  workloadDurations?: boolean;
+  sortByTraffic?: boolean;
+  revision?: string;
+  expectedTopology?: string;
+  compareEvaluationTime?: string;
+  job?: string;
+  edgeTable?: boolean;
+  sparklineBuckets?: number;
+  timeSeries?: boolean;
+  mergeWithRefIds?: string[];
+  evaluationTime?: string;
+  window?: string;
+  depth?: number;
   sourceFilters?: string[];
   destinationFilters?: string[];
+  preset?: string;
 }
 
-interface QueryModelWorkloadGraph {
+interface QueryModelWorkloadGraph extends LinkContext {
   namespace?: string;
   workload?: string;
+  matchServiceNamespace?: boolean;
   metrics?: string[];
   idleEdges?: boolean;
+  hideServiceNodes?: boolean;
+  detectIssues?: boolean;
+  excludeMirrors?: boolean;
+  locality?: boolean;
+  groupingLabels?: string[];
+  includeResponseClasses?: string[];
+  aggregateByApp?: boolean;
+  workloadDurations?: boolean;
+  sortByTraffic?: boolean;
+  revision?: string;
+  expectedTopology?: string;
+  compareEvaluationTime?: string;
+  job?: string;
+  edgeTable?: boolean;
+  sparklineBuckets?: number;
+  timeSeries?: boolean;
+  mergeWithRefIds?: string[];
+  evaluationTime?: string;
+  window?: string;
+  depth?: number;
   sourceFilters?: string[];
   destinationFilters?: string[];
+  preset?: string;
 }
 
-interface QueryModelNamespaceGraph {
+interface QueryModelNamespaceGraph extends LinkContext {
   namespace?: string;
   workload?: string;
   metrics?: string[];
   idleEdges?: boolean;
+  hideServiceNodes?: boolean;
+  detectIssues?: boolean;
+  excludeMirrors?: boolean;
+  locality?: boolean;
+  groupingLabels?: string[];
+  includeResponseClasses?: string[];
+  aggregateByApp?: boolean;
+  workloadDurations?: boolean;
+  sortByTraffic?: boolean;
+  revision?: string;
+  expectedTopology?: string;
+  compareEvaluationTime?: string;
+  job?: string;
+  edgeTable?: boolean;
+  sparklineBuckets?: number;
+  timeSeries?: boolean;
+  mergeWithRefIds?: string[];
+  evaluationTime?: string;
+  window?: string;
+  idleNodes?: boolean;
+  sourceFilters?: string[];
+  destinationFilters?: string[];
+  preset?: string;
+}
+
+interface QueryModelServiceGraph extends LinkContext {
+  namespace?: string;
+  service?: string;
+  metrics?: string[];
+  idleEdges?: boolean;
+  hideServiceNodes?: boolean;
+  detectIssues?: boolean;
+  excludeMirrors?: boolean;
+  locality?: boolean;
+  groupingLabels?: string[];
+  includeResponseClasses?: string[];
+  aggregateByApp?: boolean;
+  workloadDurations?: boolean;
+  sortByTraffic?: boolean;
+  revision?: string;
+  expectedTopology?: string;
+  compareEvaluationTime?: string;
+  job?: string;
+  edgeTable?: boolean;
+  sparklineBuckets?: number;
+  timeSeries?: boolean;
+  mergeWithRefIds?: string[];
+  evaluationTime?: string;
+  window?: string;
   sourceFilters?: string[];
   destinationFilters?: string[];
+  preset?: string;
+}
+
+interface QueryModelCanary {
+  namespace?: string;
+  application?: string;
+  baselineVersion?: string;
+  canaryVersion?: string;
+}
+
+interface QueryModelDependencies {
+  namespace?: string;
+  workload?: string;
+  service?: string;
+}
+
+interface QueryModelPath extends LinkContext {
+  sourceNamespace?: string;
+  sourceWorkload?: string;
+  destinationNamespace?: string;
+  destinationWorkload?: string;
+  metrics?: string[];
+  idleEdges?: boolean;
+  hideServiceNodes?: boolean;
+  detectIssues?: boolean;
+  excludeMirrors?: boolean;
+  locality?: boolean;
+  groupingLabels?: string[];
+  includeResponseClasses?: string[];
+  aggregateByApp?: boolean;
+  workloadDurations?: boolean;
+  sortByTraffic?: boolean;
+  revision?: string;
+  expectedTopology?: string;
+  compareEvaluationTime?: string;
+  job?: string;
+  edgeTable?: boolean;
+  sparklineBuckets?: number;
+  timeSeries?: boolean;
+  mergeWithRefIds?: string[];
+  evaluationTime?: string;
+  window?: string;
+  depth?: number;
+}
+
+interface QueryModelEgress {
+  namespace?: string;
+}
+
+interface QueryModelIngress {
+  namespace?: string;
+  gateway?: string;
+}
+
+interface QueryModelHealthScore {
+  namespace?: string;
+  latencyThreshold?: number;
+  errorWeight?: number;
+  latencyWeight?: number;
+  mtlsWeight?: number;
+}
+
+interface QueryModelMTLSCoverage {
+  namespace?: string;
+}
+
+interface QueryModelLatencyHeatmap {
+  namespace?: string;
+  service?: string;
+  sourceNamespace?: string;
+  sourceWorkload?: string;
+}
+
+interface QueryModelRollouts {
+  namespace?: string;
+  application?: string;
+  minShare?: number;
+}
+
+interface QueryModelErrorBudget {
+  namespace?: string;
+  service?: string;
+  target?: number;
+  window?: string;
+}
+
+interface QueryModelLatencySLO {
+  namespace?: string;
+  service?: string;
+  sourceNamespace?: string;
+  sourceWorkload?: string;
+  latencyThreshold?: number;
+  edges?: boolean;
+}
+
+interface QueryModelNamespaceHealth {
+  namespace?: string;
+}
+
+interface QueryModelWorkloadTraffic {
+  namespace?: string;
+  workload?: string;
 }
 
 export type OptionsPrometheusAuthMethod = 'none' | 'basic' | 'token';
 
+export type OptionsPrometheusFlavor = 'prometheus' | 'victoriametrics';
+export type OptionsPrometheusDiscoveryMode = 'labelValues' | 'series';
+
+export type OptionsIstioNodeHealth = 'server' | 'client' | 'worst' | 'weighted';
+
+export type OptionsIstioDurationUnit = 'ms' | 's';
+
 export interface Options extends DataSourceJsonData {
   prometheusUrl?: string;
   prometheusAuthMethod?: OptionsPrometheusAuthMethod;
   prometheusUsername?: string;
+  prometheusTokenFile?: string;
+  prometheusClientCertFile?: string;
+  prometheusClientKeyFile?: string;
+  prometheusFlavor?: OptionsPrometheusFlavor;
+  prometheusDiscoveryMode?: OptionsPrometheusDiscoveryMode;
+  prometheusQueryParams?: string;
+  prometheusProxyUrl?: string;
+  prometheusMaxWindow?: string;
+  prometheusRoundTo?: string;
+  prometheusQueryTimeout?: string;
+  prometheusQueryLimit?: number;
+  prometheusDefaultRange?: string;
+  prometheusListRefresh?: string;
+  prometheusRateLimit?: number;
+  prometheusBatchConcurrency?: number;
+  prometheusForwardUserHeaders?: boolean;
+  keepCookies?: string[];
+  prometheusDemoMode?: boolean;
+  prometheusTransport?: OptionsPrometheusTransport;
   istioWarningThreshold?: number;
   istioErrorThreshold?: number;
+  istioHighlightThrottled?: boolean;
+  istioNodeHealth?: OptionsIstioNodeHealth;
+  istioNodeHealthClientWeight?: number;
   istioWorkloadDashboard?: string;
   istioServiceDashboard?: string;
+  istioGatewayDashboard?: string;
+  istioEdgeDashboard?: string;
+  istioDashboardParams?: string;
+  istioLinks?: OptionsIstioLink[];
+  istioAnnotations?: OptionsIstioAnnotation[];
+  istioRevisionLabel?: string;
+  istioRevision?: string;
+  istioExcludedDestinations?: string[];
+  istioDurationMetric?: string;
+  istioDurationUnit?: OptionsIstioDurationUnit;
+  istioNativeHistograms?: boolean;
+  istioMultiCluster?: boolean;
+  istioCardinalityLimit?: number;
+  alertmanagerUrl?: string;
+  alertmanagerAuthMethod?: OptionsPrometheusAuthMethod;
+  alertmanagerUsername?: string;
+  grafanaAlerts?: boolean;
+  storageDirectory?: string;
+  overrides?: Record<string, OptionsOrgOverrides>;
+}
+
+export interface OptionsIstioLink {
+  title: string;
+  url: string;
+  target: 'node' | 'edge';
+}
+
+export interface OptionsIstioAnnotation {
+  name: string;
+  title?: string;
+  link?: boolean;
+}
+
+export interface OptionsOrgOverrides {
+  istioWarningThreshold?: number;
+  istioErrorThreshold?: number;
+  istioWorkloadDashboard?: string;
+  istioServiceDashboard?: string;
+  istioGatewayDashboard?: string;
+  istioEdgeDashboard?: string;
+  istioNamespaces?: string[];
+}
+
+export interface OptionsPrometheusTransport {
+  maxIdleConnsPerHost?: number;
+  idleConnTimeout?: string;
+  responseHeaderTimeout?: string;
+  keepAlive?: string;
 }
 
 export interface OptionsSecure {
   prometheusPassword?: string;
   prometheusToken?: string;
+  alertmanagerPassword?: string;
+  alertmanagerToken?: string;
 }